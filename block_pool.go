@@ -217,6 +217,9 @@ func (self *BlockPool) DistributeHashes() {
 					self.hashPool = monkutil.DeleteFromByteSlice(self.hashPool, hash)
 					delete(self.pool, string(hash))
 				}
+			} else if item.peer == nil && item.from != nil {
+				// First ask the peer that gave us the hash
+				peer = item.from
 			} else if lastFetchFailed || item.peer == nil {
 				// Find a suitable, available peer
 				eachPeer(self.eth.peers, func(p *Peer, v *list.Element) {
@@ -255,41 +258,43 @@ func (self *BlockPool) Stop() {
 	close(self.quit)
 }
 
+// Timers run on the monkutil clock, so a simulation
+// decides when the pool does its work
 func (self *BlockPool) downloadThread() {
-	serviceTimer := time.NewTicker(100 * time.Millisecond)
-	flushedTimer := time.NewTicker(5 * time.Second)
-out:
-	for {
-		select {
-		case <-self.quit:
-			break out
-		case <-serviceTimer.C:
-			// If we're not catching up.
-			if !self.areWeFetchingHashes() {
-				// If we're waiting for a checkpoint, request it from
-				// all peers
-				cman := self.eth.ChainManager()
-				if cman.WaitingForCheckpoint() {
-					eachPeer(self.eth.peers, func(p *Peer, v *list.Element) {
-						p.FetchBlocks([][]byte{cman.LatestCheckPointHash()})
-					})
-				} else {
-					// distribute the hashes to peers
-					// and download the blockchain
-					self.DistributeHashes()
-				}
-			}
+	monkutil.Every(100*time.Millisecond, self.quit, self.service)
+	monkutil.Every(5*time.Second, self.quit, self.flushed)
+}
 
-			self.setChainLength()
-		case <-flushedTimer.C:
-			// if pool is empty, get hashes
-			if self.Len() == 0 {
-				eachPeer(self.eth.peers, func(p *Peer, v *list.Element) {
-					//p.FetchHashes()
-				})
-			}
+func (self *BlockPool) service() {
+	// If we're not catching up.
+	if !self.areWeFetchingHashes() {
+		// If we're waiting for a checkpoint, request it from
+		// all peers
+		cman := self.eth.ChainManager()
+		if cman.WaitingForCertificate() {
+			// the certificate is requested when we get a peer's status
+			self.eth.checkCheckpointSync()
+		} else if cman.WaitingForCheckpoint() {
+			eachPeer(self.eth.peers, func(p *Peer, v *list.Element) {
+				p.FetchBlocks([][]byte{cman.LatestCheckPointHash()})
+			})
+		} else {
+			// distribute the hashes to peers
+			// and download the blockchain
+			self.DistributeHashes()
 		}
 	}
+
+	self.setChainLength()
+}
+
+func (self *BlockPool) flushed() {
+	// if pool is empty, get hashes
+	if self.Len() == 0 {
+		eachPeer(self.eth.peers, func(p *Peer, v *list.Element) {
+			//p.FetchHashes()
+		})
+	}
 }
 
 func (self *BlockPool) setChainLength() {
@@ -326,80 +331,96 @@ func (self *BlockPool) chainThread() {
 	if self.eth.ChainManager().WaitingForCheckpoint() {
 		<-self.start
 	}
-	procTimer := time.NewTicker(500 * time.Millisecond)
-out:
-	for {
-		select {
-		case <-self.quit:
-			break out
-		case <-procTimer.C:
-			// We'd need to make sure that the pools are properly protected by a mutex
-			blocks := self.Blocks()
-			monkchain.BlockBy(monkchain.Number).Sort(blocks)
-
-			// Find first block with prevhash in canonical
-			for i, block := range blocks {
-				if self.eth.ChainManager().HasBlock(block.PrevHash) {
-					blocks = blocks[i:]
+	monkutil.Every(500*time.Millisecond, self.quit, self.processChain)
+}
+
+func (self *BlockPool) processChain() {
+	// We'd need to make sure that the pools are properly protected by a mutex
+	blocks := self.Blocks()
+	monkchain.BlockBy(monkchain.Number).Sort(blocks)
+
+	// Find first block with prevhash in canonical
+	for i, block := range blocks {
+		if self.eth.ChainManager().HasBlock(block.PrevHash) {
+			blocks = blocks[i:]
+			break
+		}
+	}
+
+	// Find first conescutive chain
+	if len(blocks) > 0 {
+		// Find chain of blocks
+		if self.eth.ChainManager().HasBlock(blocks[0].PrevHash) {
+			for i, block := range blocks[1:] {
+				// NOTE: The Ith element in this loop refers to the previous block in
+				// outer "blocks"
+				if bytes.Compare(block.PrevHash, blocks[i].Hash()) != 0 {
+					blocks = blocks[:i]
 					break
 				}
 			}
+		} else {
+			blocks = nil
+		}
+	}
 
-			// Find first conescutive chain
-			if len(blocks) > 0 {
-				// Find chain of blocks
-				if self.eth.ChainManager().HasBlock(blocks[0].PrevHash) {
-					for i, block := range blocks[1:] {
-						// NOTE: The Ith element in this loop refers to the previous block in
-						// outer "blocks"
-						if bytes.Compare(block.PrevHash, blocks[i].Hash()) != 0 {
-							blocks = blocks[:i]
-							break
-						}
-					}
-				} else {
-					blocks = nil
-				}
+	// Hold blocks from the near future (and their children)
+	// until their time comes. Any further ahead fail below
+	if len(blocks) > 0 {
+		chainManager := self.eth.ChainManager()
+		rules := chainManager.TimeRules()
+		now := chainManager.NetworkTime()
+		for i, block := range blocks {
+			if ahead := block.Time - now; ahead > rules.MaxDrift && ahead <= rules.Hold {
+				poollogger.Debugf("Holding block #%v (%x...) for %ds\n", block.Number, block.Hash()[0:4], ahead-rules.MaxDrift)
+				blocks = blocks[:i]
+				break
 			}
+		}
+	}
 
-			// TODO figure out whether we were catching up
-			// If caught up and just a new block has been propagated:
-			// sm.eth.EventMux().Post(NewBlockEvent{block})
-			// otherwise process and don't emit anything
-			if len(blocks) > 0 {
-				chainManager := self.eth.ChainManager()
-
-				// sling blocks into a list
-				bchain := monkchain.NewChain(blocks)
-				// validate the chain
-				_, err := chainManager.TestChain(bchain)
-
-				// If validation failed, we flush the pool
-				// and punish the peer
-				if err != nil && !monkchain.IsTDError(err) {
-					poollogger.Debugln(err)
-
-					self.Reset()
-					//self.punishPeer()
-				} else {
-					// Validation was successful
-					// Sum-difficulties, insert chain
-					// Possibly re-org
-					chainManager.InsertChain(bchain)
-					// Remove all blocks from pool
-					for _, block := range blocks {
-						self.Remove(block.Hash())
-					}
-				}
+	// TODO figure out whether we were catching up
+	// If caught up and just a new block has been propagated:
+	// sm.eth.EventMux().Post(NewBlockEvent{block})
+	// otherwise process and don't emit anything
+	if len(blocks) > 0 {
+		chainManager := self.eth.ChainManager()
+
+		// sling blocks into a list
+		bchain := monkchain.NewChain(blocks)
+		// validate the chain
+		_, err := chainManager.TestChain(bchain)
+
+		// If validation failed, we flush the pool
+		// and punish the peer. Future blocks stay for later
+		if monkchain.IsFutureBlockErr(err) {
+			poollogger.Debugln(err)
+		} else if err != nil && !monkchain.IsTDError(err) {
+			poollogger.Debugln(err)
+
+			self.Reset()
+			//self.punishPeer()
+		} else {
+			// Validation was successful
+			// Sum-difficulties, insert chain
+			// Possibly re-org
+			if err := chainManager.InsertChain(bchain); err != nil {
+				poollogger.Infoln(err)
 			}
-
-			/* Do not propagate to the network on catchups
-			if amount == 1 {
-				block := self.eth.ChainManager().CurrentBlock
-				self.eth.Broadcast(monkwire.MsgBlockTy, []interface{}{block.Value().Val})
-			}*/
+			// Remove all blocks from pool
+			for _, block := range blocks {
+				self.Remove(block.Hash())
+			}
+			// the blocks are canonical now (or on a fork)
+			self.eth.Reactor().Post("chainHead", chainManager.CurrentBlock())
 		}
 	}
+
+	/* Do not propagate to the network on catchups
+	if amount == 1 {
+		block := self.eth.ChainManager().CurrentBlock
+		self.eth.Broadcast(monkwire.MsgBlockTy, []interface{}{block.Value().Val})
+	}*/
 }
 
 func (self *BlockPool) punishPeer() {
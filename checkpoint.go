@@ -0,0 +1,96 @@
+package thelonious
+
+import (
+	"time"
+
+	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkreact"
+	"github.com/eris-ltd/thelonious/monkwire"
+)
+
+// Sign checkpoints as they come up, if we have the permission
+func (s *Thelonious) checkpointLoop() {
+	blockChan := make(chan monkreact.Event, 5)
+	reactor := s.Reactor()
+	reactor.Subscribe("newBlock", blockChan)
+out:
+	for {
+		select {
+		case <-s.quit:
+			break out
+		case ev := <-blockChan:
+			if block, ok := ev.Resource.(*monkchain.Block); ok {
+				s.signCheckpoint(block)
+			}
+		}
+	}
+	reactor.Unsubscribe("newBlock", blockChan)
+}
+
+func (s *Thelonious) signCheckpoint(head *monkchain.Block) {
+	cman := s.ChainManager()
+	checkpoint := cman.CheckpointFor(head)
+	if checkpoint == nil || !cman.CanCertify(s.KeyManager().Address()) {
+		return
+	}
+
+	cert := monkchain.NewCheckpointCertificate(checkpoint.Number.Uint64(), checkpoint.Hash())
+	cert.Sign(s.KeyManager().PrivateKey())
+	monklogger.Infof("Signing checkpoint (#%d) %x\n", cert.Number, cert.BlockHash)
+	s.receiveCheckpoint(cert)
+}
+
+// Add a checkpoint certificate (or votes for one) and pass it on
+// if it's new to us. If we were waiting for a certificate to sync
+// from, the chain is ready once we have the checkpoint block
+func (s *Thelonious) receiveCheckpoint(cert *monkchain.CheckpointCertificate) {
+	cman := s.ChainManager()
+	waiting := cman.WaitingForCertificate()
+
+	merged, err := cman.AddCheckpointCertificate(cert)
+	if err != nil {
+		monklogger.Debugln("Ignoring checkpoint certificate:", err)
+		return
+	}
+	if merged != nil {
+		s.Broadcast(monkwire.MsgCheckpointTy, []interface{}{merged.RlpData()})
+	}
+
+	if waiting && !cman.WaitingForCheckpoint() {
+		s.Reactor().Post("chainReady", "Chain is ready!")
+	}
+}
+
+// Ask a peer for its latest checkpoint certificate
+func (s *Thelonious) requestCheckpoint(p *Peer) {
+	s.checkpointMut.Lock()
+	if s.checkpointRequestedAt.IsZero() {
+		s.checkpointRequestedAt = time.Now()
+	}
+	s.checkpointMut.Unlock()
+
+	p.QueueMessage(monkwire.NewMessage(monkwire.MsgGetCheckpointTy, []interface{}{}))
+}
+
+// Give up on syncing from a checkpoint if no peer
+// has given us a certificate in time
+func (s *Thelonious) checkCheckpointSync() {
+	cman := s.ChainManager()
+	if !cman.WaitingForCertificate() {
+		return
+	}
+
+	s.checkpointMut.Lock()
+	requested := s.checkpointRequestedAt
+	s.checkpointMut.Unlock()
+
+	if requested.IsZero() || time.Since(requested) < monkchain.CheckpointSyncTimeout {
+		return
+	}
+
+	monklogger.Infoln("No checkpoint certificate from peers. Syncing from genesis")
+	cman.CancelCheckpointSync()
+	if !cman.WaitingForCheckpoint() {
+		s.Reactor().Post("chainReady", "Chain is ready!")
+	}
+}
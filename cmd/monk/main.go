@@ -2,7 +2,9 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"github.com/eris-ltd/thelonious/monk"
+	"os"
 	"path"
 )
 
@@ -32,6 +34,7 @@ var (
 	adversary        = flag.Int("adversary", 0, "Set node to be adversarial")
 	useCheckpoint    = flag.Bool("use-checkpoint", false, "Use a blockchain checkpoint")
 	latestCheckpoint = flag.String("latest-checkpoint", "", "Set the latest checkpoint")
+	checkpointSync   = flag.Bool("checkpoint-sync", false, "Sync from the latest certified checkpoint")
 
 	configFile    = flag.String("config-file", "config", "What is this even?")
 	rootDir       = flag.String("root-dir", "", "Set the root database directory")
@@ -49,6 +52,10 @@ var (
 	logLevel  = flag.Int("log-level", 5, "Set the logger level")
 
 	test = flag.String("test", "", "Run a test")
+
+	ceremony          = flag.String("ceremony", "", "Run a genesis ceremony step (contribute, sign, verify)")
+	ceremonyAccounts  = flag.String("ceremony-accounts", "", "Json file of accounts to contribute to the genesis")
+	ceremonyThreshold = flag.Int("ceremony-threshold", 0, "Set the number of founder signatures required")
 )
 
 func main() {
@@ -81,6 +88,7 @@ func main() {
 	m.Config.Adversary = *adversary
 	m.Config.UseCheckpoint = *useCheckpoint
 	m.Config.LatestCheckpoint = *latestCheckpoint
+	m.Config.CheckpointSync = *checkpointSync
 
 	m.Config.ConfigFile = *configFile
 	m.Config.RootDir = *rootDir
@@ -101,6 +109,14 @@ func main() {
 		RunTest(m, *test)
 	}
 
+	if *ceremony != "" {
+		if err := monk.RunCeremony(*ceremony, *genesisConfig, *ceremonyAccounts, *keyFile, *keyCursor, *ceremonyThreshold); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	m.Init()
 	m.Start()
 	m.WaitForShutdown()
@@ -0,0 +1,135 @@
+package monk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkdb"
+	"github.com/eris-ltd/thelonious/monkdoug"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+/*
+   Genesis ceremony for multi-party chains.
+   Every founder, in turn:
+       monk -ceremony contribute -ceremony-accounts accounts.json -key-file founder.prv
+   Then pass the genesis.json around again and every founder:
+       monk -ceremony sign -key-file founder.prv
+   Anyone can then check the signatures and get the chain id:
+       monk -ceremony verify
+*/
+
+func RunCeremony(step, genesisFile, accountsFile, keyFile string, keyCursor, threshold int) error {
+	switch step {
+	case "contribute":
+		return CeremonyContribute(genesisFile, accountsFile, keyFile, keyCursor, threshold)
+	case "sign":
+		return CeremonySign(genesisFile, keyFile, keyCursor)
+	case "verify":
+		chainId, err := CeremonyVerify(genesisFile)
+		if err != nil {
+			return err
+		}
+		fmt.Println("ChainID:", chainId)
+		return nil
+	}
+	return fmt.Errorf("Unknown ceremony step %s. Use contribute, sign, or verify", step)
+}
+
+// Add the founder's accounts to the genesis.json
+func CeremonyContribute(genesisFile, accountsFile, keyFile string, keyCursor, threshold int) error {
+	keys, err := ceremonyKeys(keyFile, keyCursor)
+	if err != nil {
+		return err
+	}
+
+	var accounts []*monkdoug.Account
+	if accountsFile != "" {
+		b, err := ioutil.ReadFile(accountsFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &accounts); err != nil {
+			return err
+		}
+	}
+
+	g := monkdoug.LoadGenesis(genesisFile)
+	if err := g.Contribute(keys.Address(), accounts); err != nil {
+		return err
+	}
+	if threshold > 0 {
+		g.Ceremony.Threshold = threshold
+	}
+	logger.Infof("Founder %x contributed %d accounts\n", keys.Address(), len(accounts))
+	return g.Save(genesisFile)
+}
+
+// Sign the genesis block deployed from the genesis.json
+func CeremonySign(genesisFile, keyFile string, keyCursor int) error {
+	keys, err := ceremonyKeys(keyFile, keyCursor)
+	if err != nil {
+		return err
+	}
+
+	g := monkdoug.LoadGenesis(genesisFile)
+	if g.Ceremony == nil {
+		return fmt.Errorf("No ceremony in %s. Contribute first", genesisFile)
+	}
+	hash, err := ceremonyGenesisHash(g)
+	if err != nil {
+		return err
+	}
+	if err := g.Ceremony.Sign(hash, keys); err != nil {
+		return err
+	}
+	logger.Infof("Founder %x signed genesis %x\n", keys.Address(), hash)
+	return g.Save(genesisFile)
+}
+
+// Check the founders' signatures and return the chain id
+func CeremonyVerify(genesisFile string) (string, error) {
+	g := monkdoug.LoadGenesis(genesisFile)
+	if g.Ceremony == nil {
+		return "", fmt.Errorf("No ceremony in %s", genesisFile)
+	}
+	hash, err := ceremonyGenesisHash(g)
+	if err != nil {
+		return "", err
+	}
+	chainId := g.Ceremony.ChainID(hash)
+	if err := g.Ceremony.Validate(chainId, hash); err != nil {
+		return "", err
+	}
+	return monkutil.Bytes2Hex(chainId), nil
+}
+
+// Deploy the genesis in a throw away db
+func ceremonyGenesisHash(g *monkdoug.GenesisConfig) ([]byte, error) {
+	db, err := monkdb.NewMemDatabase()
+	if err != nil {
+		return nil, err
+	}
+	monkutil.Config.Db = db
+	block, err := g.GenesisBlock()
+	if err != nil {
+		return nil, err
+	}
+	return block.Hash(), nil
+}
+
+func ceremonyKeys(keyFile string, keyCursor int) (*monkcrypto.KeyPair, error) {
+	if keyFile == "" {
+		return nil, fmt.Errorf("The ceremony requires a key file")
+	}
+	keyRing, err := monkcrypto.NewKeyRingFromFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if keyCursor >= keyRing.Len() {
+		return nil, fmt.Errorf("No key %d in %s", keyCursor, keyFile)
+	}
+	return keyRing.GetKeyPair(keyCursor), nil
+}
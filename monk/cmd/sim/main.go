@@ -1,150 +1,99 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"github.com/eris-ltd/thelonious/monk"
+	"os"
+	"time"
+
 	"github.com/eris-ltd/thelonious/monkcrypto"
 	"github.com/eris-ltd/thelonious/monkdoug"
+	"github.com/eris-ltd/thelonious/monklog"
+	"github.com/eris-ltd/thelonious/monksim"
 	"github.com/eris-ltd/thelonious/monkutil"
-	"io/ioutil"
-	"os"
-	"os/exec"
-	"os/user"
-	"path"
-	"strconv"
-	"time"
 )
 
 var (
-	N       = 10
-	usr, _  = user.Current()
-	rootDir = path.Join(usr.HomeDir, "monksim")
-
-	index  = flag.Int("index", -1, "port")
-	client = flag.Bool("client", false, "client")
-	env    = flag.Bool("env", false, "env..")
+	n        = flag.Int("n", 4, "number of nodes")
+	seed     = flag.Int64("seed", 1, "seed for keys and network losses")
+	genesis  = flag.String("genesis", "", "genesis.json (default: no gendoug)")
+	miners   = flag.Int("miners", 1, "number of nodes mining")
+	duration = flag.Duration("duration", 5*time.Minute, "virtual time to run for")
+	latency  = flag.Duration("latency", 50*time.Millisecond, "network latency")
+	jitter   = flag.Duration("jitter", 50*time.Millisecond, "network jitter")
+	loss     = flag.Float64("loss", 0, "fraction of messages lost")
+	logLevel = flag.Int("log", 0, "log level")
 )
 
-func runMaster() {
-	mm := monk.NewMonk(nil)
-	mm.Config.RootDir = path.Join(rootDir, strconv.Itoa(*index))
-	mm.Config.Port = 30304 + *index
-	mm.Config.GenesisConfig = path.Join(rootDir, "genesis.json")
-	mm.Config.Mining = true
-	mm.Config.LogLevel = 3
-	mm.Config.KeySession = "keys"
-	//mm.Config.Adversary = 1
-
-	if *index < 0 {
-		mm.Config.RootDir = path.Join(rootDir, "seed")
-		mm.Config.LogLevel = 0
-		mm.Config.Mining = true
-		mm.Config.Adversary = 0
-
-		go func() {
-			for i := 0; i < 1; i++ {
-				cmd := exec.Command("sim", "-client", "-index", strconv.Itoa(i))
-				cmd.Stdout = os.Stdout
-				go cmd.Run()
-			}
-		}()
-	}
-
-	mm.Init()
-	mm.Start()
-
-	time.Sleep(10 * time.Second)
-}
-
-func runClient() {
-	mm := monk.NewMonk(nil)
-	mm.Config.RootDir = path.Join(rootDir, strconv.Itoa(*index))
-	mm.Config.Port = 30303 + *index
-	mm.Config.GenesisConfig = path.Join(rootDir, "genesis.json")
-	mm.Config.Mining = true
-	if *index >= 0 {
-		mm.Config.Adversary = 1
-	}
-	mm.Config.LogLevel = 3
-	mm.Config.KeySession = "keys"
-	//mm.Config.Adversary = 1
-
-	mm.Init()
-	mm.Start()
-
-	time.Sleep(60 * time.Second)
-
-	// tally chain
-	latest := mm.LatestBlock()
-	for i := 0; i < 100; i++ {
-		b := mm.Block(latest)
-		if b == nil {
-			break
+// Every node gets its own copy of the genesis,
+// with an account and mining permissions for each node
+func genesisFunc(keys []*monkcrypto.KeyPair) *monkdoug.GenesisConfig {
+	var g *monkdoug.GenesisConfig
+	if *genesis == "" {
+		g = &monkdoug.GenesisConfig{
+			Address:    "0000000000THISISDOUG",
+			NoGenDoug:  true,
+			Difficulty: 10,
 		}
-		fmt.Println(b.Coinbase, b.Hash)
-		latest = b.PrevHash
+	} else {
+		g = monkdoug.LoadGenesis(*genesis)
 	}
 
+	for i, k := range keys {
+		g.AddAccount(&monkdoug.Account{
+			Address:     monkutil.Bytes2Hex(k.Address()),
+			Name:        fmt.Sprintf("node%d", i),
+			Balance:     "12345678900000",
+			Permissions: map[string]int{"mine": 1, "transact": 1, "create": 1},
+		})
+	}
+	g.Init()
+	return g
 }
 
 func main() {
 	flag.Parse()
 
-	if *env {
-		setupSimEnv(N)
-		return
-	}
-
-	if !*client {
-		runMaster()
-	} else {
-		runClient()
+	if *logLevel > 0 {
+		monklog.AddLogSystem(monklog.NewStdLogSystem(os.Stdout, 0, monklog.LogLevel(*logLevel)))
 	}
 
-}
-
-func setupSimEnv(n int) {
-	_, err := os.Stat(rootDir)
+	sim, err := monksim.NewSimulator(*n, *seed, genesisFunc)
 	if err != nil {
-		os.Mkdir(rootDir, 0777)
+		fmt.Println("Could not create simulation:", err)
+		os.Exit(1)
 	}
+	defer sim.Stop()
 
-	keyPairs := []*monkcrypto.KeyPair{}
-
-	// load genesis json, append new keys. allocations.
-	g := monkdoug.LoadGenesis(path.Join(monk.ErisLtd, "thelonious", "monk", "genesis-std.json"))
-
-	g.Difficulty = 17
-	g.Consensus = ""
-
-	// generate N keys and N dirs,
-	// put a key in each dir
-	// create account object and append to genesis config
-	for i := 0; i < n; i++ {
-		kP := monkcrypto.GenerateNewKeyPair()
-		keyPairs = append(keyPairs, kP)
-
-		d := path.Join(rootDir, strconv.Itoa(i))
-		os.Mkdir(d, 0777)
-		ioutil.WriteFile(path.Join(d, "keys.prv"), []byte(monkutil.Bytes2Hex(kP.PrivateKey)), 0600)
+	sim.Net.SetLatency(*latency, *jitter)
+	sim.Net.SetLoss(*loss)
+	if err := sim.Start(); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for i := 0; i < *miners && i < *n; i++ {
+		sim.StartMining(i)
+	}
 
-		acc := monkdoug.Account{
-			Address:     monkutil.Bytes2Hex(kP.Address()),
-			Name:        strconv.Itoa(i),
-			Balance:     "12345678900000",
-			Permissions: map[string]int{"mine": 1, "transact": 1, "create": 0},
-		}
-		g.Accounts = append(g.Accounts, &acc)
+	if err := sim.Run(*duration); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for i := 0; i < *miners && i < *n; i++ {
+		sim.StopMining(i)
+	}
+	// let the last blocks propagate
+	if err := sim.Run(30 * time.Second); err != nil {
+		fmt.Println(err)
+		return
 	}
 
-	b, err := json.Marshal(g)
-	if err != nil {
-		fmt.Println("err on marshal!", err)
+	for hash, nodes := range sim.Heads() {
+		fmt.Printf("%v: %s\n", nodes, hash)
+	}
+	if err := sim.Converged(); err != nil {
+		fmt.Println(err)
+		return
 	}
-	var out bytes.Buffer
-	json.Indent(&out, b, "", "\t")
-	ioutil.WriteFile(path.Join(rootDir, "genesis.json"), out.Bytes(), 0600)
+	fmt.Printf("Converged at #%d\n", sim.CommonHeight())
 }
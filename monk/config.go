@@ -46,6 +46,7 @@ type ChainConfig struct {
 
 	// Local Node
 	Mining           bool   `json:"mining"`
+	MiningThreads    int    `json:"mining_threads"`
 	MaxPeers         int    `json:"max_peers"`
 	ClientIdentifier string `json:"client"`
 	Version          string `json:"version"`
@@ -57,6 +58,10 @@ type ChainConfig struct {
 	Adversary        int    `json:"adversary"`
 	UseCheckpoint    bool   `json:"use_checkpoint"`
 	LatestCheckpoint string `json:"latest_checkpoint"`
+	CheckpointSync   bool   `json:"checkpoint_sync"`
+	AddressIndex     bool   `json:"address_index"`
+	TxJournal        bool   `json:"tx_journal"`
+	TxJournalAll     bool   `json:"tx_journal_all"`
 
 	// Paths
 	ConfigFile    string `json:"config_file"`
@@ -91,6 +96,7 @@ var DefaultConfig = &ChainConfig{
 
 	// Local Node
 	Mining:           false,
+	MiningThreads:    1,
 	MaxPeers:         10,
 	ClientIdentifier: "Thelonious(decerver)",
 	Version:          "0.7.0",
@@ -102,6 +108,10 @@ var DefaultConfig = &ChainConfig{
 	Adversary:        0,
 	UseCheckpoint:    false,
 	LatestCheckpoint: "",
+	CheckpointSync:   false,
+	AddressIndex:     false,
+	TxJournal:        true,
+	TxJournalAll:     false,
 
 	// Paths
 	ConfigFile:    "config", // TODO: deprecate this2
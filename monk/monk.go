@@ -253,6 +253,10 @@ func (mod *MonkModule) Block(hash string) *types.Block {
 	return mod.monk.Block(hash)
 }
 
+func (mod *MonkModule) AddressTxs(addr string, offset, limit int) (*monkpipe.JSAddressTxs, error) {
+	return mod.monk.AddressTxs(addr, offset, limit)
+}
+
 func (mod *MonkModule) IsScript(target string) bool {
 	return mod.monk.IsScript(target)
 }
@@ -462,6 +466,16 @@ func (monk *Monk) Block(hash string) *types.Block {
 	return convertBlock(block)
 }
 
+// A page of the txs sent from or to an address (including
+// by contracts), newest first. Needs address_index in the config
+func (monk *Monk) AddressTxs(addr string, offset, limit int) (*monkpipe.JSAddressTxs, error) {
+	txs := monkpipe.NewJSPipe(monk.thelonious).AddressTxs(addr, offset, limit)
+	if txs == nil {
+		return nil, fmt.Errorf("The address index is off (set address_index in the config)")
+	}
+	return txs, nil
+}
+
 func (monk *Monk) IsScript(target string) bool {
 	// is contract if storage is empty and no bytecode
 	obj := monk.Account(target)
@@ -728,6 +742,14 @@ func (m *Monk) newThelonious() {
 
 	logger.Infoln("Created thelonious node")
 
+	th.ChainManager().SetAddressIndex(m.config.AddressIndex)
+	th.TxPool().SetJournal(m.config.TxJournal, m.config.TxJournalAll)
+	MiningThreads = m.config.MiningThreads
+
+	if m.config.CheckpointSync {
+		th.ChainManager().SyncFromCheckpoint()
+	}
+
 	th.Port = strconv.Itoa(m.config.ListenPort)
 	th.MaxPeers = m.config.MaxPeers
 
@@ -174,6 +174,9 @@ func StartRpc(ethereum *eth.Thelonious, RpcHost string, RpcPort int) {
 
 var miner *monkminer.Miner
 
+// Search workers for the miner (one per cpu if < 1)
+var MiningThreads = 1
+
 func GetMiner() *monkminer.Miner {
 	return miner
 }
@@ -188,6 +191,8 @@ func StartMining(ethereum *eth.Thelonious) bool {
 			logger.Infoln("Start mining")
 			if miner == nil {
 				miner = monkminer.NewDefaultMiner(addr, ethereum)
+				miner.SetWorkers(MiningThreads)
+				ethereum.SetMiner(miner)
 			}
 			// Give it some time to connect with peers
 			time.Sleep(3 * time.Second)
@@ -222,6 +227,7 @@ func StopMining(ethereum *eth.Thelonious) bool {
 		miner.Stop()
 		logger.Infoln("Stopped mining")
 		ethereum.Mining = false
+		ethereum.SetMiner(nil)
 		miner = nil
 		return true
 	}
@@ -410,7 +416,9 @@ func DeployChain(root, genesis, config string) (string, error) {
 
 	if strings.HasSuffix(genesis, ".pdx") || strings.HasSuffix(genesis, ".gdx") {
 		m.GenesisConfig = &monkdoug.GenesisConfig{Address: "0000000000THISISDOUG", NoGenDoug: false, Pdx: genesis}
-		m.GenesisConfig.Init()
+		if err := m.GenesisConfig.Init(); err != nil {
+			return "", err
+		}
 	} else {
 		m.Config.GenesisConfig = genesis
 	}
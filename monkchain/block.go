@@ -6,7 +6,6 @@ import (
 	"math/big"
 	"sort"
 	_ "strconv"
-	"time"
 
 	"github.com/eris-ltd/thelonious/monkcrypto"
 	"github.com/eris-ltd/thelonious/monkstate"
@@ -133,7 +132,7 @@ func CreateBlock(root interface{},
 		Coinbase:    base,
 		Difficulty:  Difficulty,
 		Nonce:       Nonce,
-		Time:        time.Now().Unix(),
+		Time:        monkutil.Now().Unix(),
 		Extra:       extra,
 		UncleSha:    EmptyShaList,
 		GasUsed:     new(big.Int),
@@ -168,36 +167,6 @@ func (block *Block) Transactions() []*Transaction {
 	return block.transactions
 }
 
-// TODO: GenDoug?
-func (block *Block) CalcGasLimit(parent *Block) *big.Int {
-	return monkutil.Big("100000000000000000000000")
-
-	if block.Number.Cmp(big.NewInt(0)) == 0 {
-		return monkutil.BigPow(10, 6)
-	}
-
-	// ((1024-1) * parent.gasLimit + (gasUsed * 6 / 5)) / 1024
-
-	previous := new(big.Int).Mul(big.NewInt(1024-1), parent.GasLimit)
-	current := new(big.Rat).Mul(new(big.Rat).SetInt(parent.GasUsed), big.NewRat(6, 5))
-	curInt := new(big.Int).Div(current.Num(), current.Denom())
-
-	result := new(big.Int).Add(previous, curInt)
-	result.Div(result, big.NewInt(1024))
-
-	min := big.NewInt(125000)
-
-	return monkutil.BigMax(min, result)
-}
-
-func (block *Block) BlockInfo() BlockInfo {
-	bi := BlockInfo{}
-	data, _ := monkutil.Config.Db.Get(append(block.Hash(), []byte("Info")...))
-	bi.RlpDecode(data)
-
-	return bi
-}
-
 func (self *Block) GetTransaction(hash []byte) *Transaction {
 	for _, receipt := range self.receipts {
 		if bytes.Compare(receipt.Tx.Hash(), hash) == 0 {
@@ -371,6 +340,20 @@ func (self *Block) Receipts() []*Receipt {
 	return self.receipts
 }
 
+// Logs of all the block's receipts
+func (self *Block) Logs() monkstate.Logs {
+	var logs monkstate.Logs
+	for _, receipt := range self.receipts {
+		logs = append(logs, receipt.Logs...)
+	}
+	return logs
+}
+
+// Bloom of the addresses and topics of the block's logs
+func (self *Block) Bloom() *BloomFilter {
+	return LogsBloom(self.Logs())
+}
+
 // blocks need signatures that should match the coinbase
 func (self *Block) Signature(key []byte) []byte {
 	hash := self.Hash()
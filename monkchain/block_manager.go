@@ -80,13 +80,12 @@ type Consensus interface {
 	CheckPoint(proposed []byte, bc *ChainManager) bool
 }
 
-// Private global genDoug variable for checking permissions on arbitrary
-// chain related actions. Set by setLastBlock when we boot up the blockchain
-var genDoug Protocol
-
-// Public function so we can validate permissions using the genDoug from outside this package
-func DougValidatePerm(addr []byte, role string, state *monkstate.State) error {
-	return genDoug.ValidatePerm(addr, role, state)
+// Optional interface for protocols that run system
+// contracts at the beginning and end of every block.
+// State changes are part of the block's state root
+type BlockCallProtocol interface {
+	PreCall(block, parent *Block, state *monkstate.State) error
+	PostCall(block, parent *Block, state *monkstate.State) error
 }
 
 type BlockManager struct {
@@ -121,7 +120,7 @@ type BlockManager struct {
 func NewBlockManager(thelonious NodeManager) *BlockManager {
 	sm := &BlockManager{
 		mem: make(map[string]*big.Int),
-		Pow: &EasyPow{},
+		Pow: thelonious.ChainManager().NewPoW(),
 		th:  thelonious,
 		bc:  thelonious.ChainManager(),
 	}
@@ -169,14 +168,21 @@ func (self *BlockManager) ProcessTransactions(coinbase *monkstate.StateObject, s
 		totalUsedGas       = big.NewInt(0)
 		err                error
 	)
+	minPrice := self.bc.GasRules(block.Number, state).MinPrice()
 
 done:
 	for i, tx := range txs {
+		if tx.GasPrice.Cmp(minPrice) < 0 {
+			self.th.Reactor().Post("newTx:post:fail", &TxFail{tx, GasPriceError(tx.GasPrice, minPrice)})
+			continue
+		}
 		txGas := new(big.Int).Set(tx.Gas)
+		nMessages := len(state.Manifest().Messages)
+		state.EmptyLogs()
 
 		cb := state.GetStateObject(coinbase.Address())
 		// TODO: deal with this
-		st := NewStateTransitionEris(cb, tx, state, block, self.bc.Genesis()) // ERIS
+		st := NewStateTransitionEris(cb, tx, state, block, self.bc.Genesis(), self.bc.protocol) // ERIS
 		err = st.TransitionState()
 		if err != nil {
 			statelogger.Infoln(err)
@@ -212,12 +218,18 @@ done:
 		// Notify all subscribers
 		self.th.Reactor().Post("newTx:post", tx)
 
+		// tag the tx's messages (for the address index)
+		txHash := tx.Hash()
+		for _, msg := range state.Manifest().Messages[nMessages:] {
+			msg.Tx = txHash
+		}
+
 		// Update the state with pending changes
 		state.Update()
 
 		txGas.Sub(txGas, st.gas)
 		accumelative := new(big.Int).Set(totalUsedGas.Add(totalUsedGas, txGas))
-		receipt := &Receipt{tx, monkutil.CopyBytes(state.Root().([]byte)), accumelative}
+		receipt := &Receipt{tx, monkutil.CopyBytes(state.Root().([]byte)), accumelative, state.Logs()}
 
 		if i < len(block.Receipts()) {
 			original := block.Receipts()[i]
@@ -240,8 +252,6 @@ done:
 		}
 	}
 
-	parent.GasUsed = totalUsedGas
-
 	return receipts, handled, unhandled, err
 }
 
@@ -274,29 +284,59 @@ func (sm *BlockManager) ProcessWithParent(block, parent *Block) (td *big.Int, er
 		fmt.Printf("## %x %x ##\n", block.Hash(), block.Number)
 	}
 
-	var receipts Receipts
-	receipts, err = sm.ApplyDiff(state, parent, block)
-	if err != nil {
+	// Block times are judged the same way whatever the protocol.
+	// Blocks from the near future come back as a FutureBlockErr
+	if err = sm.bc.CheckBlockTime(parent, block); err != nil {
 		return
 	}
 
-	txSha := CreateTxSha(receipts)
-	if bytes.Compare(txSha, block.TxSha) != 0 {
-		err = fmt.Errorf("Error validating tx sha. Received %x, got %x", block.TxSha, txSha)
-		return
+	// System contracts run at the beginning and end of the block.
+	// If they fail the block is invalid, which ValidateBlock reports
+	calls := sm.PreCall(block, parent, state)
+	if calls == nil {
+		// only checked on chains with gas limit rules
+		if rules := sm.bc.GasRules(block.Number, parent.State()); rules.Limited() {
+			if limit := rules.GasLimit(parent); block.GasLimit.Cmp(limit) != 0 {
+				err = ValidationError("Block gas limit is %v, expected %v", block.GasLimit, limit)
+				return
+			}
+		}
+
+		var receipts Receipts
+		receipts, err = sm.ApplyDiff(state, parent, block)
+		if err != nil {
+			return
+		}
+
+		txSha := CreateTxSha(receipts)
+		if bytes.Compare(txSha, block.TxSha) != 0 {
+			err = fmt.Errorf("Error validating tx sha. Received %x, got %x", block.TxSha, txSha)
+			return
+		}
+
+		gasUsed := new(big.Int)
+		if len(receipts) > 0 {
+			gasUsed = receipts[len(receipts)-1].CumulativeGasUsed
+		}
+		if block.GasUsed.Cmp(gasUsed) != 0 {
+			err = ValidationError("Block gas used is %v, expected %v", block.GasUsed, gasUsed)
+			return
+		}
+
+		if err = sm.AccumelateRewards(state, block, parent, receipts); err != nil {
+			statelogger.Errorln("Error accumulating reward", err)
+			return
+		}
+
+		calls = sm.PostCall(block, parent, state)
 	}
 
 	// Block validation
-	if err = sm.ValidateBlock(block); err != nil {
+	if err = sm.ValidateBlock(block, calls); err != nil {
 		statelogger.Errorln("Error validating block:", err)
 		return
 	}
 
-	if err = sm.AccumelateRewards(state, block, parent); err != nil {
-		statelogger.Errorln("Error accumulating reward", err)
-		return
-	}
-
 	state.Update()
 
 	if !block.State().Cmp(state) {
@@ -304,6 +344,15 @@ func (sm *BlockManager) ProcessWithParent(block, parent *Block) (td *big.Int, er
 		return
 	}
 
+	// save who the txs touched, for when the block is canonical
+	if sm.bc.AddressIndex() {
+		sm.bc.saveAddressRefs(block, state.Manifest().Messages)
+	}
+
+	// and the block's bloom, for filters
+	filter := sm.createBloomFilter(block, state)
+	sm.th.Db().Put(bloomKey(block.Hash()), filter.Bin())
+
 	// Calculate the new total difficulty and sync back to the db
 	var ok bool
 	if td, ok = sm.CalculateTD(block); ok {
@@ -312,24 +361,28 @@ func (sm *BlockManager) ProcessWithParent(block, parent *Block) (td *big.Int, er
 
 		//if dontReact == false {
 		sm.th.Reactor().Post("newBlock", block)
+		if logs := block.Logs(); len(logs) > 0 {
+			sm.th.Reactor().Post("logs", logs)
+		}
 		state.Manifest().Reset()
 		//}
 
 		statelogger.Infof("Processed block #%d (%x...)\n", block.Number, block.Hash()[0:4])
 		sm.transState = nil
 		sm.state = state.Copy()
-		/*
-			// Create a bloom bin for this block
-			filter := sm.createBloomFilter(state)
-			// Persist the data
-			fk := append([]byte("bloom"), block.Hash()...)
-			sm.Thelonious.Db().Put(fk, filter.Bin())
-		*/
 		//sm.Thelonious.TxPool().RemoveInvalid(state)
 		sm.th.TxPool().RemoveSet(block.Transactions())
+		sm.th.TxPool().Reset(state)
+		return
+	} else if td == nil {
+		// the parent is missing or has no total difficulty
+		err = fmt.Errorf("Unable to calculate total difficulty of block %x", block.Hash()[0:4])
 		return
 	} else {
 		sm.transState = state
+		// valid, but not enough difficulty to be canonical.
+		// miners may include it as an uncle
+		sm.th.Reactor().Post("newUncle", block)
 		return
 	}
 
@@ -337,8 +390,16 @@ func (sm *BlockManager) ProcessWithParent(block, parent *Block) (td *big.Int, er
 }
 
 func (sm *BlockManager) ApplyDiff(state *monkstate.State, parent, block *Block) (receipts Receipts, err error) {
+	// the pool skips underpriced txs, but a block can't have them
+	minPrice := sm.bc.GasRules(block.Number, state).MinPrice()
+	for _, tx := range block.Transactions() {
+		if tx.GasPrice.Cmp(minPrice) < 0 {
+			return nil, ValidationError("Tx %x gas price %v is below the min %v", tx.Hash()[:4], tx.GasPrice, minPrice)
+		}
+	}
+
 	coinbase := state.GetOrNewStateObject(block.Coinbase)
-	coinbase.SetGasPool(block.CalcGasLimit(parent))
+	coinbase.SetGasPool(sm.bc.GasPool(parent))
 
 	// Process the transactions on to current block
 	receipts, _, _, err = sm.ProcessTransactions(coinbase, state, block, parent, block.Transactions())
@@ -371,15 +432,27 @@ func (sm *BlockManager) CalculateTD(block *Block) (*big.Int, bool) {
 // Validates the current block. Returns an error if the block was invalid,
 // an uncle or anything that isn't on the current block chain.
 // Validation validates easy over difficult (dagger takes longer time = difficult)
-func (sm *BlockManager) ValidateBlock(block *Block) error {
+// calls is the error from the block's system contracts, if any
+func (sm *BlockManager) ValidateBlock(block *Block, calls error) error {
 	// all validation is done through the genDoug
-	return sm.bc.protocol.ValidateBlock(block, sm.bc)
+	// active at the block's height
+	if err := sm.bc.ProtocolAt(block.Number).ValidateBlock(block, sm.bc); err != nil {
+		return err
+	}
+	return calls
 }
 
-func (sm *BlockManager) AccumelateRewards(state *monkstate.State, block, parent *Block) error {
-	reward := new(big.Int).Set(BlockReward)
+func (sm *BlockManager) AccumelateRewards(state *monkstate.State, block, parent *Block, receipts Receipts) error {
+	rewards := sm.bc.RewardRules(block.Number, parent.State())
+	base := rewards.BlockReward(block.Number)
+	reward := new(big.Int).Set(base)
 
-	knownUncles := monkutil.Set(parent.Uncles)
+	rules := sm.bc.UncleRules()
+	if len(block.Uncles) > rules.Max {
+		return UncleError(fmt.Sprintf("Too many uncles (%d > %d)", len(block.Uncles), rules.Max))
+	}
+
+	ancestors, knownUncles := sm.bc.UncleAncestry(parent, rules.Depth)
 	nonces := monkutil.NewSet(block.Nonce)
 	for _, uncle := range block.Uncles {
 		if nonces.Include(uncle.Nonce) {
@@ -387,52 +460,110 @@ func (sm *BlockManager) AccumelateRewards(state *monkstate.State, block, parent
 			return UncleError("Uncle not unique")
 		}
 
-		uncleParent := sm.bc.GetBlock(uncle.PrevHash)
-		if uncleParent == nil {
-			return UncleError("Uncle's parent unknown")
-		}
-
-		if uncleParent.Number.Cmp(new(big.Int).Sub(parent.Number, big.NewInt(6))) < 0 {
-			return UncleError("Uncle too old")
-		}
-
-		if knownUncles.Include(uncle.Hash()) {
-			return UncleError("Uncle in chain")
+		if err := ValidUncle(uncle, parent, ancestors, knownUncles); err != nil {
+			return err
 		}
 
 		nonces.Insert(uncle.Nonce)
-
-		r := new(big.Int)
-		r.Mul(BlockReward, big.NewInt(15)).Div(r, big.NewInt(16))
+		knownUncles[string(uncle.Hash())] = true
 
 		uncleAccount := state.GetAccount(uncle.Coinbase)
-		uncleAccount.AddAmount(r)
+		uncleAccount.AddAmount(rules.UncleReward(base))
 
-		reward.Add(reward, new(big.Int).Div(BlockReward, big.NewInt(32)))
+		reward.Add(reward, rules.InclusionReward(base))
 	}
 	// Get the account associated with the coinbase
 	account := state.GetAccount(block.Coinbase)
 	// Reward amount of junk to the coinbase address
 	account.AddAmount(reward)
 
+	// The coinbase already has the fees. Pass on the treasury's share
+	if share := rewards.TreasuryShare(reward, ReceiptFees(receipts)); share.Sign() > 0 {
+		account.SubAmount(share)
+		state.GetAccount(rewards.TreasuryAddress()).AddAmount(share)
+	}
+
+	return nil
+}
+
+// Run the protocol's block start system contracts (if any)
+// Must be run by miners and validators alike
+func (sm *BlockManager) PreCall(block, parent *Block, state *monkstate.State) error {
+	if p, ok := sm.bc.ProtocolAt(block.Number).(BlockCallProtocol); ok {
+		if err := p.PreCall(block, parent, state); err != nil {
+			return ValidationError("Block pre-call failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// Run the protocol's block end system contracts (if any)
+func (sm *BlockManager) PostCall(block, parent *Block, state *monkstate.State) error {
+	if p, ok := sm.bc.ProtocolAt(block.Number).(BlockCallProtocol); ok {
+		if err := p.PostCall(block, parent, state); err != nil {
+			return ValidationError("Block post-call failed: %v", err)
+		}
+	}
 	return nil
 }
 
+// Posted as "chainReorg" when the canonical chain switches branches
+type ChainReorg struct {
+	OldHead, NewHead []byte
+	// Hashes of the txs only in the old chain, and of those in the new one
+	Dropped, Added [][]byte
+}
+
+// Put the txs only in the old chain back in the pool (if they're
+// still valid on the new one), take out the txs now mined,
+// and let everyone know
+func (sm *BlockManager) Reorg(oldHead, newHead *Block, dropped, added Transactions) {
+	pool := sm.th.TxPool()
+	state := newHead.State()
+	pool.RemoveSet(added)
+	pool.Reset(state)
+
+	for _, tx := range dropped {
+		// already mined (or replaced) in the new chain
+		if tx.Nonce < state.GetNonce(tx.Sender()) {
+			continue
+		}
+		pool.queueTransaction(tx)
+	}
+	statelogger.Infof("Reorg from %x to %x dropped %d txs and mined %d\n", oldHead.Hash()[:4], newHead.Hash()[:4], len(dropped), len(added))
+
+	reorg := &ChainReorg{OldHead: oldHead.Hash(), NewHead: newHead.Hash()}
+	for _, tx := range dropped {
+		reorg.Dropped = append(reorg.Dropped, tx.Hash())
+	}
+	for _, tx := range added {
+		reorg.Added = append(reorg.Added, tx.Hash())
+	}
+	sm.th.Reactor().Post("chainReorg", reorg)
+}
+
 func (sm *BlockManager) Stop() {
 	sm.bc.Stop()
 }
 
-// Manifest will handle both creating notifications and generating bloom bin data
-func (sm *BlockManager) createBloomFilter(state *monkstate.State) *BloomFilter {
-	bloomf := NewBloomFilter(nil)
+func bloomKey(blockHash []byte) []byte {
+	return append([]byte("bloom"), blockHash...)
+}
+
+// Bloom of the addresses messaged processing the block (from the
+// manifest) and of the addresses and topics of the block's logs
+func (sm *BlockManager) createBloomFilter(block *Block, state *monkstate.State) *BloomFilter {
+	bloomf := block.Bloom()
 
 	for _, msg := range state.Manifest().Messages {
-		bloomf.Set(msg.To)
-		bloomf.Set(msg.From)
+		if len(msg.To) > 0 {
+			bloomf.Set(msg.To)
+		}
+		if len(msg.From) > 0 {
+			bloomf.Set(msg.From)
+		}
 	}
 
-	sm.th.Reactor().Post("messages", state.Manifest().Messages)
-
 	return bloomf
 }
 
@@ -450,9 +581,9 @@ func (sm *BlockManager) GetMessages(block *Block) (messages []*monkstate.Message
 
 	defer state.Reset()
 
-	sm.ApplyDiff(state, parent, block)
+	receipts, _ := sm.ApplyDiff(state, parent, block)
 
-	sm.AccumelateRewards(state, block, parent)
+	sm.AccumelateRewards(state, block, parent, receipts)
 
 	return state.Manifest().Messages, nil
 }
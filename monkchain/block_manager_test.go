@@ -1,5 +1,14 @@
 package monkchain
 
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkstate"
+)
+
 /*
 import (
 	_ "fmt"
@@ -34,3 +43,71 @@ func TestVm(t *testing.T) {
 	bm.ApplyTransactions(block, []*Transaction{tx2})
 }
 */
+
+// A protocol whose system contracts fail as asked
+type callProtocol struct {
+	Protocol
+	pre, post error
+}
+
+func (self *callProtocol) ValidateBlock(block *Block, bc *ChainManager) error { return nil }
+
+func (self *callProtocol) PreCall(block, parent *Block, state *monkstate.State) error {
+	return self.pre
+}
+
+func (self *callProtocol) PostCall(block, parent *Block, state *monkstate.State) error {
+	return self.post
+}
+
+func TestBlockCalls(t *testing.T) {
+	for call, p := range map[string]*callProtocol{
+		"pre-call":  {pre: fmt.Errorf("no")},
+		"post-call": {post: fmt.Errorf("no")},
+	} {
+		bc, blocks := canonicalChain(2)
+		bc.protocol = p
+		sm := &BlockManager{bc: bc}
+
+		parent := blocks[1]
+		block := CreateBlock(parent.State().Trie.Root, parent.Hash(), nil, big.NewInt(1), nil, "")
+		block.Number = big.NewInt(2)
+		block.GasLimit = bc.GasLimit(parent)
+		block.SetReceipts(nil, nil)
+
+		_, err := sm.ProcessWithParent(block, parent)
+		if !IsValidationErr(err) || !strings.Contains(err.Error(), call) {
+			t.Errorf("Expected a failed %s to invalidate the block, got %v", call, err)
+		}
+	}
+}
+
+// Times are checked for every protocol, even one that takes anything
+func TestBlockTimes(t *testing.T) {
+	bc, blocks := canonicalChain(2)
+	bc.protocol = &callProtocol{}
+	sm := &BlockManager{bc: bc}
+
+	parent := blocks[1]
+	for name, c := range map[string]struct {
+		dt     int64
+		future bool
+	}{
+		"before the parent":  {dt: -3600},
+		"far in the future":  {dt: 3600},
+		"a little too early": {dt: DefaultTimeRules.MaxDrift + 5, future: true},
+	} {
+		block := CreateBlock(parent.State().Trie.Root, parent.Hash(), nil, big.NewInt(1), nil, "")
+		block.Number = big.NewInt(2)
+		block.Time = parent.Time + c.dt
+		block.GasLimit = bc.GasLimit(parent)
+		block.SetReceipts(nil, nil)
+
+		_, err := sm.ProcessWithParent(block, parent)
+		if c.future && !IsFutureBlockErr(err) {
+			t.Errorf("%s: expected the block to be held, got %v", name, err)
+		} else if !c.future && !IsValidationErr(err) {
+			t.Errorf("%s: expected a validation error, got %v", name, err)
+		}
+	}
+}
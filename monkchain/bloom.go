@@ -1,5 +1,10 @@
 package monkchain
 
+import (
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkstate"
+)
+
 type BloomFilter struct {
 	bin []byte
 }
@@ -45,3 +50,44 @@ func (self *BloomFilter) Search(addr []byte) bool {
 func (self *BloomFilter) Bin() []byte {
 	return self.bin
 }
+
+// Set data of any length (eg. a log topic) by its hash,
+// so short or padded values don't all land on the same bits
+func (self *BloomFilter) Add(data []byte) {
+	self.Set(monkcrypto.Sha3Bin(data))
+}
+
+func (self *BloomFilter) Has(data []byte) bool {
+	return self.Search(monkcrypto.Sha3Bin(data))
+}
+
+// Has any of the items been added
+func (self *BloomFilter) HasAny(items [][]byte) bool {
+	for _, data := range items {
+		if self.Has(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set all the bits set in other
+func (self *BloomFilter) Merge(other *BloomFilter) {
+	for i, b := range other.bin {
+		if b != 0 {
+			self.bin[i] = 1
+		}
+	}
+}
+
+// Bloom of the addresses and topics of logs
+func LogsBloom(logs monkstate.Logs) *BloomFilter {
+	bloom := NewBloomFilter(nil)
+	for _, log := range logs {
+		bloom.Add(log.Address)
+		for _, topic := range log.Topics {
+			bloom.Add(topic)
+		}
+	}
+	return bloom
+}
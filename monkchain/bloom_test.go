@@ -1,6 +1,11 @@
 package monkchain
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkstate"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
 
 func TestBloomFilter(t *testing.T) {
 	bf := NewBloomFilter(nil)
@@ -18,3 +23,22 @@ func TestBloomFilter(t *testing.T) {
 		t.Error("Expected 'b' not to field trie using a bloom filter")
 	}
 }
+
+func TestLogsBloom(t *testing.T) {
+	addr := []byte("01234567890123456789")
+	topic := monkutil.LeftPadBytes([]byte{1}, 32)
+	bloom := LogsBloom(monkstate.Logs{&monkstate.Log{Address: addr, Topics: [][]byte{topic}}})
+
+	if !bloom.Has(addr) || !bloom.Has(topic) {
+		t.Error("Expected the log's address and topic in the bloom")
+	}
+	if bloom.Has(monkutil.LeftPadBytes([]byte{2}, 32)) {
+		t.Error("Expected another topic not to be in the bloom")
+	}
+
+	merged := NewBloomFilter(nil)
+	merged.Merge(bloom)
+	if !merged.HasAny([][]byte{[]byte("nothing"), topic}) {
+		t.Error("Expected the merged bloom to have the topic")
+	}
+}
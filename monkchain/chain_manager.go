@@ -46,6 +46,9 @@ type ChainManager struct {
 	//Thelonious NodeManager
 	processor BlockProcessor
 	protocol  Protocol
+	// Blocks and chain info. State lives in monkutil.Config.Db,
+	// which may be shared with other nodes in the same process
+	db monkutil.Database
 	// Genesis Block and Chain ID
 	genesisBlock *Block
 	chainID      []byte
@@ -68,6 +71,23 @@ type ChainManager struct {
 	latestCheckPointNumber uint64
 	waitingForCheckPoint   bool
 
+	// Our latest checkpoint certificate, and
+	// votes for those without a quorum yet
+	latestCertificate     *CheckpointCertificate
+	pendingCertificates   map[string]*CheckpointCertificate
+	waitingForCertificate bool
+
+	// Our latest commit (for models with finality).
+	// We never reorg below the committed height
+	committedHash   []byte
+	committedHeight uint64
+
+	// Clock offsets of our peers, for network time
+	timeOffsets *TimeOffsets
+
+	// Index txs by the addresses they touch
+	addressIndex bool
+
 	// sync access to current state (block, hash, num)
 	mut sync.Mutex
 	// sync access to TestChain/InsertChain
@@ -75,15 +95,25 @@ type ChainManager struct {
 }
 
 func NewChainManager(protocol Protocol) *ChainManager {
-	bc := &ChainManager{}
+	return NewChainManagerWithDb(protocol, monkutil.Config.Db)
+}
+
+// A chain manager keeping its blocks in db
+func NewChainManagerWithDb(protocol Protocol, db monkutil.Database) *ChainManager {
+	bc := &ChainManager{db: db}
 	bc.genesisBlock = NewBlockFromBytes(monkutil.Encode(Genesis))
 	bc.workingTree = make(map[string]*link)
+	bc.pendingCertificates = make(map[string]*CheckpointCertificate)
+	bc.timeOffsets = NewTimeOffsets()
 	bc.protocol = protocol
 
 	// set last block we know of or deploy genesis
 	bc.setLastBlock()
 	// load the latest checkpoint
 	bc.loadCheckpoint()
+	bc.loadCheckpointCertificate()
+	// load the latest commit
+	bc.loadCommit()
 
 	return bc
 }
@@ -120,7 +150,7 @@ func (bc *ChainManager) IsCheckpoint(hash []byte) bool {
 
 // Receive the checkpointed block from peers
 func (bc *ChainManager) ReceiveCheckPointBlock(block *Block) bool {
-	if block == nil {
+	if block == nil || bc.WaitingForCertificate() {
 		return false
 	}
 
@@ -141,7 +171,7 @@ func (bc *ChainManager) updateCheckpoint(checkPoint []byte) {
 		bc.setWaitingForCheckpoint(false)
 		bc.latestCheckPointBlock = b
 		bc.latestCheckPointNumber = b.Number.Uint64()
-		monkutil.Config.Db.Put([]byte("LatestCheckPoint"), b.Hash())
+		bc.db.Put([]byte("LatestCheckPoint"), b.Hash())
 		chainlogger.Infof("Updating checkpoint block: (#%d) %x\n", bc.latestCheckPointNumber, checkPoint)
 	} else {
 		// we have accepted the checkpoint but don't have the block
@@ -151,10 +181,12 @@ func (bc *ChainManager) updateCheckpoint(checkPoint []byte) {
 	}
 }
 
+// Waiting for a checkpoint block, or
+// for a certificate to sync from
 func (bc *ChainManager) WaitingForCheckpoint() bool {
 	bc.mut.Lock()
 	defer bc.mut.Unlock()
-	return bc.waitingForCheckPoint
+	return bc.waitingForCheckPoint || bc.waitingForCertificate
 }
 
 func (bc *ChainManager) setWaitingForCheckpoint(s bool) {
@@ -165,7 +197,7 @@ func (bc *ChainManager) setWaitingForCheckpoint(s bool) {
 
 // load checkpoint from db or set to genesis
 func (bc *ChainManager) loadCheckpoint() {
-	data, _ := monkutil.Config.Db.Get([]byte("LatestCheckPoint"))
+	data, _ := bc.db.Get([]byte("LatestCheckPoint"))
 	if len(data) != 0 {
 		bc.updateCheckpoint(data)
 	} else {
@@ -173,6 +205,125 @@ func (bc *ChainManager) loadCheckpoint() {
 	}
 }
 
+// Store a commit certificate for a block, reorging to it
+// if it's on a fork. The block must end up canonical.
+// The certificate should already have been verified by the protocol
+func (bc *ChainManager) Commit(cert *CommitCertificate) error {
+	block := bc.GetBlock(cert.BlockHash)
+	if block == nil {
+		return fmt.Errorf("Commit for unknown block %x", cert.BlockHash)
+	}
+	if block.Number.Uint64() != cert.Height {
+		return fmt.Errorf("Commit height %d does not match block #%d", cert.Height, block.Number)
+	}
+	if cert.Height < bc.CommittedHeight() {
+		return fmt.Errorf("Commit #%d is below committed height %d", cert.Height, bc.CommittedHeight())
+	}
+
+	// the commit may come from the protocol's own goroutine,
+	// so the reorg is serialized with TestChain and InsertChain
+	bc.chainMut.Lock()
+	defer bc.chainMut.Unlock()
+
+	// committed blocks win, whatever their difficulty
+	if l, ok := bc.workingTree[string(cert.BlockHash)]; ok {
+		chainlogger.Infof("Reorging to committed block (#%d) %x\n", cert.Height, cert.BlockHash)
+		chain := &BlockChain{list.New()}
+		chain.PushBack(l)
+		if err := bc.reOrg(chain); err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(bc.GetBlockHashByNumber(cert.Height), cert.BlockHash) {
+		return fmt.Errorf("Committed block (#%d) %x is not canonical", cert.Height, cert.BlockHash)
+	}
+
+	bc.db.Put(append(block.Hash(), []byte("Commit")...), cert.RlpEncode())
+	bc.db.Put([]byte("LatestCommit"), block.Hash())
+
+	bc.mut.Lock()
+	bc.committedHash = block.Hash()
+	bc.committedHeight = cert.Height
+	bc.mut.Unlock()
+
+	chainlogger.Infof("Committed block (#%d) %x\n", cert.Height, cert.BlockHash)
+	return nil
+}
+
+// Return the commit certificate for a block, if we have one
+func (bc *ChainManager) GetCommit(hash []byte) *CommitCertificate {
+	data, _ := bc.db.Get(append(hash, []byte("Commit")...))
+	if len(data) == 0 {
+		return nil
+	}
+	return NewCommitCertificateFromBytes(data)
+}
+
+func (bc *ChainManager) CommittedHash() []byte {
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+	return bc.committedHash
+}
+
+func (bc *ChainManager) CommittedHeight() uint64 {
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+	return bc.committedHeight
+}
+
+// load latest commit from db, if any
+func (bc *ChainManager) loadCommit() {
+	data, _ := bc.db.Get([]byte("LatestCommit"))
+	if len(data) == 0 {
+		return
+	}
+	if cert := bc.GetCommit(data); cert != nil {
+		bc.committedHash = cert.BlockHash
+		bc.committedHeight = cert.Height
+	}
+}
+
+// Find the most recent ancestor of block that is on canonical
+func (bc *ChainManager) forkPoint(block *Block) *Block {
+	for ; block != nil; block = bc.GetBlock(block.PrevHash) {
+		if bc.GetBlockCanonical(block.Hash()) != nil {
+			break
+		}
+	}
+	return block
+}
+
+// Optional interface for processors told about re-orgs,
+// with the txs only in the old chain and those in the new one
+type ReorgProcessor interface {
+	Reorg(oldHead, newHead *Block, dropped, added Transactions)
+}
+
+// Txs in the blocks back from oldHead and newHead to their
+// common ancestor: those only in the old chain, and the new ones
+func (self *ChainManager) reorgTxs(oldHead, newHead *Block, ancestorHash []byte) (dropped, added Transactions) {
+	mined := make(map[string]bool)
+	for b := newHead; b != nil && bytes.Compare(b.Hash(), ancestorHash) != 0; b = self.GetBlockCanonical(b.PrevHash) {
+		for _, tx := range b.Transactions() {
+			mined[string(tx.Hash())] = true
+			added = append(added, tx)
+		}
+	}
+	// oldest first, so they go back in nonce order
+	var old Blocks
+	for b := oldHead; b != nil && bytes.Compare(b.Hash(), ancestorHash) != 0; b = self.GetBlockCanonical(b.PrevHash) {
+		old = append(Blocks{b}, old...)
+	}
+	for _, b := range old {
+		for _, tx := range b.Transactions() {
+			if !mined[string(tx.Hash())] {
+				dropped = append(dropped, tx)
+			}
+		}
+	}
+	return
+}
+
 func (bc *ChainManager) SetProcessor(proc BlockProcessor) {
 	bc.processor = proc
 }
@@ -199,14 +350,16 @@ func (bc *ChainManager) NewBlock(coinbase []byte) *Block {
 		nil,
 		"")
 
-	// TODO: How do we feel about this
-	block.MinGasPrice = big.NewInt(10000000000000)
-
 	parent := bc.CurrentBlock()
 	if parent != nil {
-		block.Difficulty = genDoug.Difficulty(block, parent)
 		block.Number = new(big.Int).Add(parent.Number, monkutil.Big1)
-		block.GasLimit = monkutil.BigPow(10, 50) //block.CalcGasLimit(bc.CurrentBlock)
+		// don't mine a block the network will reject for being too old
+		if median := bc.MedianTimePast(parent, bc.TimeRules().MedianSpan); block.Time < median {
+			block.Time = median
+		}
+		block.Difficulty = bc.protocol.Difficulty(block, parent)
+		block.GasLimit = bc.GasLimit(parent)
+		block.MinGasPrice = new(big.Int).Set(bc.GasRules(block.Number, parent.State()).MinPrice())
 
 	}
 
@@ -215,7 +368,7 @@ func (bc *ChainManager) NewBlock(coinbase []byte) *Block {
 
 // XXX: Only checks canonical!
 func (bc *ChainManager) HasBlock(hash []byte) bool {
-	data, _ := monkutil.Config.Db.Get(hash)
+	data, _ := bc.db.Get(hash)
 	return len(data) != 0
 }
 
@@ -268,11 +421,11 @@ func (self *ChainManager) GetChainHashesFromHash(hash []byte, max uint64) (chain
 
 func (bc *ChainManager) setLastBlock() {
 	// check for a genesis block
-	data, _ := monkutil.Config.Db.Get([]byte("GenesisBlock"))
+	data, _ := bc.db.Get([]byte("GenesisBlock"))
 	if len(data) != 0 {
 		chainlogger.Infoln("Found genesis block")
 		bc.genesisBlock = NewBlockFromBytes(data)
-		data, _ = monkutil.Config.Db.Get([]byte("ChainID"))
+		data, _ = bc.db.Get([]byte("ChainID"))
 		if len(data) == 0 {
 			log.Fatal("No chainID found for genesis block.")
 		}
@@ -291,28 +444,29 @@ func (bc *ChainManager) setLastBlock() {
 		if err != nil {
 			log.Fatal("Genesis deploy failed:", err)
 		}
-		monkutil.Config.Db.Put([]byte("GenesisBlock"), bc.genesisBlock.RlpEncode())
-		monkutil.Config.Db.Put([]byte("ChainID"), chainId[:])
+		if err := bc.protocol.ValidateChainID(chainId, bc.genesisBlock); err != nil {
+			log.Fatal(err)
+		}
+		bc.db.Put([]byte("GenesisBlock"), bc.genesisBlock.RlpEncode())
+		bc.db.Put([]byte("ChainID"), chainId[:])
 		bc.chainID = chainId
 	}
 
 	// check for last block.
-	data, _ = monkutil.Config.Db.Get([]byte("LastBlock"))
+	data, _ = bc.db.Get([]byte("LastBlock"))
 	if len(data) != 0 {
 		block := NewBlockFromBytes(data)
 		bc.currentBlock = block
 		bc.currentBlockHash = block.Hash()
 		bc.currentBlockNumber = block.Number.Uint64()
+		bc.repairIndex(block)
 	} else {
 		bc.Reset()
 	}
-	// set the genDoug model (global var) for determining chain permissions
-	genDoug = bc.protocol
-
 	//bc.SetTotalDifficulty(monkutil.Big("0"))
 
 	// Set the last know difficulty (might be 0x0 as initial value, Genesis)
-	bc.TD = monkutil.BigD(monkutil.Config.Db.LastKnownTD())
+	bc.TD = monkutil.BigD(bc.db.LastKnownTD())
 
 	chainlogger.Infof("Last block (#%d) %x\n", bc.currentBlockNumber, bc.currentBlock.Hash())
 	chainlogger.Infof("ChainID (%x) \n", bc.chainID)
@@ -329,7 +483,7 @@ func (bc *ChainManager) Reset() {
 }
 
 func (bc *ChainManager) SetTotalDifficulty(td *big.Int) {
-	monkutil.Config.Db.Put([]byte("LTD"), td.Bytes())
+	bc.db.Put([]byte("LTD"), td.Bytes())
 	bc.TD = td
 }
 
@@ -339,12 +493,17 @@ func (bc *ChainManager) add(block *Block) {
 	defer bc.mut.Unlock()
 
 	bc.writeBlockInfo(block)
+	bc.writeNumberIndex(block)
+	bc.writeTxIndex(block)
+	if bc.addressIndex {
+		bc.indexAddresses(block)
+	}
 	bc.currentBlock = block
 	bc.currentBlockHash = block.Hash()
 
 	encodedBlock := block.RlpEncode()
-	monkutil.Config.Db.Put(block.Hash(), encodedBlock)
-	monkutil.Config.Db.Put([]byte("LastBlock"), encodedBlock)
+	bc.db.Put(block.Hash(), encodedBlock)
+	bc.db.Put([]byte("LastBlock"), encodedBlock)
 }
 
 func (bc *ChainManager) ChainID() []byte {
@@ -446,41 +605,16 @@ func (self *ChainManager) GetBlockWorking(hash []byte) *Block {
 
 // Strictly returns canonical blocks
 func (self *ChainManager) GetBlockCanonical(hash []byte) *Block {
-	data, _ := monkutil.Config.Db.Get(hash)
+	data, _ := self.db.Get(hash)
 	if len(data) == 0 {
 		return nil
 	}
 	return NewBlockFromBytes(data)
 }
 
-func (self *ChainManager) GetBlockByNumber(num uint64) *Block {
-	block := self.CurrentBlock()
-	for ; block != nil; block = self.GetBlock(block.PrevHash) {
-		if block.Number.Uint64() == num {
-			break
-		}
-	}
-
-	if block != nil && block.Number.Uint64() == 0 && num != 0 {
-		return nil
-	}
-
-	return block
-}
-
-func (self *ChainManager) GetBlockBack(num uint64) *Block {
-	block := self.CurrentBlock()
-
-	for ; num != 0 && block != nil; num-- {
-		block = self.GetBlock(block.PrevHash)
-	}
-
-	return block
-}
-
 func (bc *ChainManager) BlockInfoByHash(hash []byte) BlockInfo {
 	bi := BlockInfo{}
-	data, _ := monkutil.Config.Db.Get(append(hash, []byte("Info")...))
+	data, _ := bc.db.Get(append(hash, []byte("Info")...))
 	bi.RlpDecode(data)
 
 	return bi
@@ -488,7 +622,7 @@ func (bc *ChainManager) BlockInfoByHash(hash []byte) BlockInfo {
 
 func (bc *ChainManager) BlockInfo(block *Block) BlockInfo {
 	bi := BlockInfo{}
-	data, _ := monkutil.Config.Db.Get(append(block.Hash(), []byte("Info")...))
+	data, _ := bc.db.Get(append(block.Hash(), []byte("Info")...))
 	if len(data) == 0 {
 		if l, ok := bc.workingTree[string(block.Hash())]; ok {
 			b := l.block
@@ -514,13 +648,11 @@ func (bc *ChainManager) BlockInfo(block *Block) BlockInfo {
 // Unexported method for writing extra non-essential block info to the db
 // not thread safe (caller should lock)
 func (bc *ChainManager) writeBlockInfo(block *Block) {
-	if block.Number.Cmp(big.NewInt(0)) != 0 {
-		bc.currentBlockNumber++
-	}
+	bc.currentBlockNumber = block.Number.Uint64()
 	bi := BlockInfo{Number: bc.currentBlockNumber, Hash: block.Hash(), Parent: block.PrevHash, TD: bc.TD}
 
 	// For now we use the block hash with the words "info" appended as key
-	monkutil.Config.Db.Put(append(block.Hash(), []byte("Info")...), bi.RlpEncode())
+	bc.db.Put(append(block.Hash(), []byte("Info")...), bi.RlpEncode())
 }
 
 func (bc *ChainManager) Stop() {
@@ -567,7 +699,11 @@ func NewChain(blocks Blocks) *BlockChain {
 func (self *ChainManager) TestChain(chain *BlockChain) (td *big.Int, err error) {
 	self.chainMut.Lock()
 	defer self.chainMut.Unlock()
+	return self.testChain(chain)
+}
 
+// TestChain for callers holding chainMut
+func (self *ChainManager) testChain(chain *BlockChain) (td *big.Int, err error) {
 	self.workingChain = chain
 	defer func(cm *ChainManager) { cm.workingChain = nil }(self)
 
@@ -577,6 +713,11 @@ func (self *ChainManager) TestChain(chain *BlockChain) (td *big.Int, err error)
 	parent, fork = self.detectFork(chain)
 	if fork {
 		fmt.Println("Fork!")
+		// never fork off below a committed block
+		if point := self.forkPoint(parent); point == nil || point.Number.Uint64() < self.CommittedHeight() {
+			err = ValidationError("Fork off parent %x is below committed height %d", parent.Hash(), self.CommittedHeight())
+			return
+		}
 		if _, ok := self.workingTree[string(parent.Hash())]; !ok {
 			chainlogger.Infof("New fork detected off parent %x at height %d. Head %x at %d", parent.Hash(), parent.Number, self.CurrentBlockHash(), self.CurrentBlockNumber())
 		} else {
@@ -603,7 +744,10 @@ func (self *ChainManager) TestChain(chain *BlockChain) (td *big.Int, err error)
 			chainlogger.Infoln(err)
 			chainlogger.Debugf("Block #%v failed (%x...)\n", block.Number, block.Hash()[0:4])
 			chainlogger.Debugln(block)
-			err = fmt.Errorf("incoming chain failed %v\n", err)
+			// blocks from the near future are held, not dropped
+			if !IsFutureBlockErr(err) {
+				err = fmt.Errorf("incoming chain failed %v\n", err)
+			}
 			return
 		} else {
 			chainlogger.Debugf("Block #%v passed (%x...)\n", block.Number, block.Hash()[0:4])
@@ -626,10 +770,8 @@ func (self *ChainManager) TestChain(chain *BlockChain) (td *big.Int, err error)
 	return
 }
 
-func (self *ChainManager) insertChain(chain *BlockChain) {
-	self.chainMut.Lock()
-	defer self.chainMut.Unlock()
-
+// Not thread safe (caller should hold chainMut)
+func (self *ChainManager) extendChain(chain *BlockChain) {
 	// We are lengthening canonical!
 	// for each block, set the new difficulty, add to chain
 	for e := chain.Front(); e != nil; e = e.Next() {
@@ -654,9 +796,16 @@ func (self *ChainManager) insertChain(chain *BlockChain) {
 }
 
 // This function assumes you've done your checking. No validity checking is done at this stage anymore
-// This will either extend canonical or cause a reorg.
-func (self *ChainManager) InsertChain(chain *BlockChain) {
+// This will either extend canonical or cause a reorg, which fails
+// if it would revert a committed block
+func (self *ChainManager) InsertChain(chain *BlockChain) error {
+	self.chainMut.Lock()
+	defer self.chainMut.Unlock()
+	return self.insertChain(chain)
+}
 
+// InsertChain for callers holding chainMut
+func (self *ChainManager) insertChain(chain *BlockChain) error {
 	var (
 		oldest       = chain.Front().Value.(*link).block
 		branchParent = self.GetBlock(oldest.PrevHash)
@@ -666,8 +815,8 @@ func (self *ChainManager) InsertChain(chain *BlockChain) {
 	// Check if parent is top block on canonical
 	// if so, extend canonical
 	if bytes.Compare(head.Hash(), branchParent.Hash()) == 0 {
-		self.insertChain(chain)
-		return
+		self.extendChain(chain)
+		return nil
 	}
 
 	// Looks like it's time for a re-org!
@@ -680,11 +829,13 @@ func (self *ChainManager) InsertChain(chain *BlockChain) {
 
 	if td.Cmp(self.TD) > 0 {
 		chainlogger.Infoln("A fork has overtaken canonical. Time for a reorg!")
-		self.reOrg(chain)
+		return self.reOrg(chain)
 	}
+	return nil
 }
 
-func (self *ChainManager) reOrg(chain *BlockChain) {
+// Not thread safe (caller should hold chainMut)
+func (self *ChainManager) reOrg(chain *BlockChain) error {
 	// Find branch point
 	// Pop them off the top of canonical into a chain
 	//  add the chain to working tree
@@ -692,6 +843,21 @@ func (self *ChainManager) reOrg(chain *BlockChain) {
 	// Create array of blocks from new head back to branch point
 	// Deletes them from workingTree
 	// Uses memory links. Maybe we should use prev hashes?
+	oldest := chain.Back().Value.(*link)
+	for oldest.parent != nil {
+		oldest = oldest.parent
+	}
+	ancestorHash := oldest.block.PrevHash
+	ancestor := self.GetBlockCanonical(ancestorHash)
+	if ancestor == nil {
+		return fmt.Errorf("Refusing reorg to block %x without a canonical ancestor", chain.Back().Value.(*link).block.Hash())
+	}
+
+	// committed blocks are final, and the fork stays in the working tree
+	if ancestor.Number.Uint64() < self.CommittedHeight() {
+		return ValidationError("Refusing reorg to block %x at height %d below committed height %d", ancestorHash, ancestor.Number, self.CommittedHeight())
+	}
+
 	chainlogger.Debugln("Popping blocks off working tree")
 	bchain := &BlockChain{list.New()}
 	for l := chain.Back().Value.(*link); l != nil; l = l.parent {
@@ -702,9 +868,6 @@ func (self *ChainManager) reOrg(chain *BlockChain) {
 		delete(self.workingTree, string(l.block.Hash()))
 	}
 
-	ancestorHash := bchain.Front().Value.(*link).block.PrevHash
-	ancestor := self.GetBlockCanonical(ancestorHash)
-
 	oldHeadHash := self.CurrentBlockHash()
 	oldHead := self.GetBlockCanonical(oldHeadHash)
 
@@ -714,23 +877,53 @@ func (self *ChainManager) reOrg(chain *BlockChain) {
 	self.mut.Lock()
 	self.currentBlock = ancestor
 	self.currentBlockHash = ancestorHash
+	self.currentBlockNumber = ancestor.Number.Uint64()
 	self.mut.Unlock()
 
 	// process the new chain on top
 	// we've already done this
 	// but we're also paranoid
 	chainlogger.Infof("Testing new chain (redundant, we know...)")
-	_, err := self.TestChain(bchain)
-	if err != nil {
+	// a committed chain may be lighter than the old one
+	_, err := self.testChain(bchain)
+	if err != nil && !IsTDError(err) {
 		chainlogger.Infoln("Reorg failed as new chain failed processing. This shouldn't have happened and may mean trouble")
 		self.mut.Lock()
 		self.currentBlock = oldHead
 		self.currentBlockHash = oldHeadHash
+		self.currentBlockNumber = oldHead.Number.Uint64()
 		self.mut.Unlock()
-		return
+		return err
 	}
+	// pop the old chain's txs off the address index
+	// (newest first) before the new ones go on
+	if self.AddressIndex() {
+		for b := oldHead; b != nil && bytes.Compare(b.Hash(), ancestorHash) != 0; b = self.GetBlockCanonical(b.PrevHash) {
+			self.unindexAddresses(b)
+		}
+	}
+
 	chainlogger.Infof("Inserting chain")
-	self.InsertChain(bchain)
+	if err = self.insertChain(bchain); err != nil {
+		return err
+	}
+
+	// if the new chain is shorter, the old one's
+	// numbers above the new head are no longer canonical
+	if head := self.CurrentBlockNumber(); head < oldHead.Number.Uint64() {
+		self.deleteNumberIndex(head+1, oldHead.Number.Uint64())
+	}
+	// nor are txs only in the old chain
+	for b := oldHead; b != nil && bytes.Compare(b.Hash(), ancestorHash) != 0; b = self.GetBlockCanonical(b.PrevHash) {
+		self.deleteTxIndex(b)
+	}
+
+	// txs only in the old chain go back in the pool
+	if p, ok := self.processor.(ReorgProcessor); ok {
+		newHead := self.CurrentBlock()
+		dropped, added := self.reorgTxs(oldHead, newHead, ancestorHash)
+		p.Reorg(oldHead, newHead, dropped, added)
+	}
 
 	// move old canonical into workingTree chain
 	bchain = &BlockChain{list.New()}
@@ -741,11 +934,11 @@ func (self *ChainManager) reOrg(chain *BlockChain) {
 
 	// again, we have already processed, since its fucking canonical
 	// but this is easy for now, gives an extra check
-	_, err = self.TestChain(bchain)
+	_, err = self.testChain(bchain)
 	if err != nil && !IsTDError(err) {
 		chainlogger.Infoln("Adding the old canonical chain to the workingTree failed. This shouldn't happen, and may imply that Jesus has returned")
-		return
 	}
+	return nil
 }
 
 // Add chain to working tree by connecting link blocks
@@ -784,8 +977,8 @@ func (self *ChainManager) addChainToWorkingTree(chain *BlockChain) {
 					}
 					// use nil as marker for branch off canonical
 					l.parent = nil
-					parentDiff := b.BlockInfo().TD
-					l.td = base.Add(parentDiff, b.Difficulty)
+					parentDiff := self.BlockInfo(b).TD
+					l.td = base.Add(parentDiff, block.Difficulty)
 				}
 			}
 			// add child
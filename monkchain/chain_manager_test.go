@@ -1,6 +1,7 @@
 package monkchain
 
 import (
+	"bytes"
 	"container/list"
 	"fmt"
 	"io"
@@ -61,14 +62,20 @@ func (e *fakeEth) IsListening() bool                                      { retu
 func (e *fakeEth) Peers() *list.List                                      { return nil }
 func (e *fakeEth) KeyManager() *monkcrypto.KeyManager                     { return nil }
 func (e *fakeEth) ClientIdentity() monkwire.ClientIdentity                { return nil }
-func (e *fakeEth) Db() monkutil.Database                                  { return nil }
+func (e *fakeEth) Db() monkutil.Database                                  { return monkutil.Config.Db }
 func (e *fakeEth) Protocol() Protocol                                     { return nil }
 
 type fakeDoug struct{}
 
 func (d *fakeDoug) Doug() []byte { return nil }
-func (d *fakeDoug) Deploy(block *Block) []byte {
-	return nil
+func (d *fakeDoug) Deploy(block *Block) ([]byte, error) {
+	// bankroll the key the blocks' txs come from
+	account := block.State().GetAccount(FakeKeys.Address())
+	account.Balance = monkutil.BigPow(2, 200)
+	block.State().UpdateStateObject(account)
+	block.State().Update()
+	block.State().Sync()
+	return nil, nil
 }
 func (d *fakeDoug) ValidateChainID(chainId []byte, genBlock *Block) error {
 	return nil
@@ -83,6 +90,7 @@ func (d *fakeDoug) CheckPoint(proposed []byte, bc *ChainManager) bool
 var (
 	FakeEth  = &fakeEth{}
 	FakeDoug = &fakeDoug{}
+	FakeKeys = monkcrypto.GenerateNewKeyPair()
 )
 
 func newBlockFromParent(addr []byte, parent *Block) *Block {
@@ -96,7 +104,7 @@ func newBlockFromParent(addr []byte, parent *Block) *Block {
 	block.MinGasPrice = big.NewInt(10000000000000)
 	block.Difficulty = CalcDifficulty(block, parent)
 	block.Number = new(big.Int).Add(parent.Number, monkutil.Big1)
-	block.GasLimit = block.CalcGasLimit(parent)
+	block.GasLimit = DefaultGasRules.GasLimit(parent)
 	block.Time = parent.Time + 1
 	return block
 }
@@ -106,12 +114,20 @@ func makeBlock(bman *BlockManager, parent *Block, i int) *Block {
 	addr := monkutil.LeftPadBytes([]byte{byte(i)}, 20)
 	block := newBlockFromParent(addr, parent)
 	cbase := block.State().GetOrNewStateObject(addr)
-	cbase.SetGasPool(block.CalcGasLimit(parent))
-	receipts, txs, _, _ := bman.ProcessTransactions(cbase, block.State(), block, block, Transactions{})
+	cbase.SetGasPool(DefaultGasRules.GasPool(parent))
+	// a tx paying the coinbase, so the indexes have something to find
+	nonce := block.State().GetNonce(FakeKeys.Address())
+	tx := NewTransactionMessage(addr, big.NewInt(1), big.NewInt(1000), block.MinGasPrice, nil)
+	tx.Nonce = nonce
+	tx.Sign(FakeKeys.PrivateKey)
+	receipts, txs, _, _ := bman.ProcessTransactions(cbase, block.State(), block, block, Transactions{tx})
 	//block.SetTransactions(txs)
 	block.SetTxHash(receipts)
+	if len(receipts) > 0 {
+		block.GasUsed = receipts[len(receipts)-1].CumulativeGasUsed
+	}
 	block.SetReceipts(receipts, txs)
-	bman.AccumelateRewards(block.State(), block, parent)
+	bman.AccumelateRewards(block.State(), block, parent, receipts)
 	block.State().Update()
 	return block
 }
@@ -164,11 +180,12 @@ func newCanonical(n int) (*BlockManager, error) {
 // Create a new chain manager starting from given block
 // Effectively a fork factory
 func newChainManager(block *Block, protocol Protocol) *ChainManager {
-	bc := &ChainManager{}
+	bc := &ChainManager{db: monkutil.Config.Db}
 	bc.protocol = protocol
+	bc.timeOffsets = NewTimeOffsets()
+	bc.pendingCertificates = make(map[string]*CheckpointCertificate)
 	bc.genesisBlock = NewBlockFromBytes(monkutil.Encode(Genesis))
 	bc.workingTree = make(map[string]*link)
-	genDoug = bc.protocol
 	if block == nil {
 		bc.protocol.Deploy(bc.genesisBlock)
 		bc.Reset()
@@ -176,7 +193,7 @@ func newChainManager(block *Block, protocol Protocol) *ChainManager {
 	} else {
 		bc.currentBlock = block
 		bc.SetTotalDifficulty(monkutil.Big("0"))
-		bc.TD = block.BlockInfo().TD
+		bc.TD = bc.BlockInfo(block).TD
 	}
 	return bc
 }
@@ -193,7 +210,8 @@ func flushChain(chain *BlockChain) *BlockChain {
 }
 
 // Test fork of length N starting from block i
-func testFork(t *testing.T, bman *BlockManager, i, N int, f func(td1, td2 *big.Int)) {
+// Returns the fork, tested against the first chain
+func testFork(t *testing.T, bman *BlockManager, i, N int, f func(td1, td2 *big.Int)) *BlockChain {
 	var b *Block = nil
 	if i > 0 {
 		b = bman.bc.GetBlockByNumber(uint64(i))
@@ -226,6 +244,7 @@ func testFork(t *testing.T, bman *BlockManager, i, N int, f func(td1, td2 *big.I
 	}
 	// Compare difficulties
 	f(bman.bc.TD, td2)
+	return chainB
 }
 
 func TestExtendCanonical(t *testing.T) {
@@ -321,6 +340,61 @@ func TestEqualFork(t *testing.T) {
 	testFork(t, bman, 9, 1, f)
 }
 
+// Blocks of a chain from front to back
+func chainBlocks(chain *BlockChain) Blocks {
+	var blocks Blocks
+	for e := chain.Front(); e != nil; e = e.Next() {
+		blocks = append(blocks, e.Value.(*link).block)
+	}
+	return blocks
+}
+
+// Check the number and tx indexes give canonical's blocks,
+// and none of the txs in the blocks off canonical
+func checkIndex(t *testing.T, bc *ChainManager, canonical, off Blocks) {
+	checkBlocks(t, bc.BlocksInRange(0, bc.CurrentBlockNumber()), canonical...)
+	for _, block := range canonical {
+		for _, tx := range block.Transactions() {
+			if b, _ := bc.GetTransactionBlock(tx.Hash()); b == nil || !bytes.Equal(b.Hash(), block.Hash()) {
+				t.Errorf("Tx %x not found in block #%v", tx.Hash()[:4], block.Number)
+			}
+		}
+	}
+	for _, block := range off {
+		for _, tx := range block.Transactions() {
+			if bc.GetTransaction(tx.Hash()) != nil {
+				t.Errorf("Found tx %x from block #%v off canonical", tx.Hash()[:4], block.Number)
+			}
+		}
+	}
+}
+
+func TestForkIndex(t *testing.T) {
+	initDB()
+	bman, err := newCanonical(10)
+	if err != nil {
+		t.Fatal("Could not make new canonical chain:", err)
+	}
+	canonical := bman.bc.BlocksInRange(0, 10)
+	checkIndex(t, bman.bc, canonical, nil)
+
+	// a shorter fork leaves canonical alone
+	shorter := testFork(t, bman, 6, 3, func(td1, td2 *big.Int) {})
+	bman.bc.InsertChain(shorter)
+	if n := bman.bc.CurrentBlockNumber(); n != 10 {
+		t.Fatal("Expected the head to stay at #10, got", n)
+	}
+	checkIndex(t, bman.bc, canonical, chainBlocks(shorter))
+
+	// a longer one replaces it above the fork point
+	longer := testFork(t, bman, 5, 8, func(td1, td2 *big.Int) {})
+	bman.bc.InsertChain(longer)
+	if n := bman.bc.CurrentBlockNumber(); n != 13 {
+		t.Fatal("Expected the head to move to #13, got", n)
+	}
+	checkIndex(t, bman.bc, append(canonical[:6:6], chainBlocks(longer)...), canonical[6:])
+}
+
 func TestBrokenChain(t *testing.T) {
 	initDB()
 	bman, err := newCanonical(10)
@@ -341,6 +415,85 @@ func TestBrokenChain(t *testing.T) {
 	}
 }
 
+// Just enough of a protocol for the gas rules
+type gasProtocol struct {
+	Protocol
+	rules *GasRules
+}
+
+func (self *gasProtocol) GasRules(state *monkstate.State) *GasRules {
+	return self.rules
+}
+
+func TestBlockGas(t *testing.T) {
+	initDB()
+	bman, err := newCanonical(2)
+	if err != nil {
+		t.Fatal("Could not make new canonical chain:", err)
+	}
+	parent := bman.bc.CurrentBlock()
+	process := func(block *Block) error {
+		bman.bc.workingChain = NewChain(Blocks{parent})
+		bman.bc.workingChain.Back().Value.(*link).td = bman.bc.TD
+		_, err := bman.ProcessWithParent(block, parent)
+		return err
+	}
+
+	// the gas used must be that of the receipts
+	block := makeBlock(bman, parent, 0)
+	block.GasUsed = new(big.Int).Add(block.GasUsed, big.NewInt(1))
+	if err := process(block); !IsValidationErr(err) {
+		t.Errorf("Expected a validation error for the wrong gas used, got %v", err)
+	}
+
+	// and a received block can't skip underpriced txs
+	block = makeBlock(bman, parent, 0)
+	rules := *DefaultGasRules
+	rules.MinGasPrice = new(big.Int).Add(block.MinGasPrice, big.NewInt(1))
+	bman.bc.protocol = &gasProtocol{FakeDoug, &rules}
+	if err := process(block); !IsValidationErr(err) {
+		t.Errorf("Expected a validation error for an underpriced tx, got %v", err)
+	}
+}
+
+// Blocks of a chain without gas rules replay as before: their
+// header limit isn't checked and they spend the legacy pool
+func TestLegacyGas(t *testing.T) {
+	initDB()
+	bman, err := newCanonical(2)
+	if err != nil {
+		t.Fatal("Could not make new canonical chain:", err)
+	}
+	parent := bman.bc.CurrentBlock()
+	process := func(block *Block) error {
+		bman.bc.workingChain = NewChain(Blocks{parent})
+		bman.bc.workingChain.Back().Value.(*link).td = bman.bc.TD
+		_, err := bman.ProcessWithParent(block, parent)
+		return err
+	}
+
+	block := makeBlock(bman, parent, 0)
+	block.GasLimit = big.NewInt(0)
+	if err := process(block); err != nil {
+		t.Error("Expected a block from before the gas rules to pass, got", err)
+	}
+	if pool := bman.bc.GasPool(parent); pool.Cmp(LegacyGasPool) != 0 {
+		t.Errorf("Expected the legacy gas pool, got %v", pool)
+	}
+
+	// with an initial limit, the header must have it
+	rules := *DefaultGasRules
+	rules.InitialLimit = big.NewInt(1000000)
+	bman.bc.protocol = &gasProtocol{FakeDoug, &rules}
+	if err := process(block); !IsValidationErr(err) {
+		t.Errorf("Expected a validation error for the wrong gas limit, got %v", err)
+	}
+	block.GasLimit = big.NewInt(1000000)
+	if err := process(block); err != nil {
+		t.Error("Expected the block with the limit to pass, got", err)
+	}
+}
+
 func BenchmarkChainTesting(b *testing.B) {
 	initDB()
 	const chainlen = 1000
@@ -0,0 +1,362 @@
+package monkchain
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/eris-ltd/thelonious/monkstate"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+/*
+   Checkpoint certificates.
+   Every CheckpointInterval blocks, accounts with the "checkpoint"
+   permission sign the block at that height (once it is CheckpointDepth
+   blocks deep) and gossip their signatures. A certificate with a
+   quorum of signatures lets a new node start syncing from the
+   certified block instead of from genesis.
+   Signers are checked against the permissions in our current state,
+   so a new node trusts the set from genesis (weak subjectivity).
+*/
+
+// Blocks on top of a checkpoint before it is signed
+var CheckpointDepth uint64 = 6
+
+// How long a new node waits for a certificate
+// before falling back to syncing from genesis
+var CheckpointSyncTimeout = 30 * time.Second
+
+// Most checkpoints we collect votes for at once
+var MaxPendingCertificates = 32
+
+// Optional interface for protocols that certify checkpoints
+type CheckpointProtocol interface {
+	// Blocks between checkpoints (0 for none)
+	CheckpointInterval(state *monkstate.State) uint64
+	// Signatures needed to certify a checkpoint (0 for none)
+	CheckpointQuorum(state *monkstate.State) int
+}
+
+type CheckpointCertificate struct {
+	Number    uint64
+	BlockHash []byte
+	Votes     []*Vote
+}
+
+func NewCheckpointCertificate(number uint64, hash []byte) *CheckpointCertificate {
+	return &CheckpointCertificate{Number: number, BlockHash: hash}
+}
+
+func NewCheckpointCertificateFromBytes(data []byte) *CheckpointCertificate {
+	cert := &CheckpointCertificate{}
+	cert.RlpValueDecode(monkutil.NewValueFromBytes(data))
+	return cert
+}
+
+func NewCheckpointCertificateFromValue(val *monkutil.Value) *CheckpointCertificate {
+	cert := &CheckpointCertificate{}
+	cert.RlpValueDecode(val)
+	return cert
+}
+
+// Add our signature to the certificate
+func (self *CheckpointCertificate) Sign(privk []byte) {
+	vote := NewVote(VoteCheckpoint, self.Number, 0, self.BlockHash)
+	vote.Sign(privk)
+	self.Votes = append(self.Votes, vote)
+}
+
+// Addresses of the distinct accounts whose votes
+// match the certificate's number and block hash
+func (self *CheckpointCertificate) Signers() [][]byte {
+	seen := make(map[string]bool)
+	signers := [][]byte{}
+	for _, vote := range self.Votes {
+		if vote.Type != VoteCheckpoint || vote.Height != self.Number || !bytes.Equal(vote.BlockHash, self.BlockHash) {
+			continue
+		}
+		signer := vote.Signer()
+		if signer == nil || seen[string(signer)] {
+			continue
+		}
+		seen[string(signer)] = true
+		signers = append(signers, signer)
+	}
+	return signers
+}
+
+// Add the votes from other that we don't have.
+// Returns the number of votes added
+func (self *CheckpointCertificate) Merge(other *CheckpointCertificate) int {
+	if self.Number != other.Number || !bytes.Equal(self.BlockHash, other.BlockHash) {
+		return 0
+	}
+	have := make(map[string]bool)
+	for _, signer := range self.Signers() {
+		have[string(signer)] = true
+	}
+	var n int
+	for _, vote := range other.Votes {
+		if vote.Type != VoteCheckpoint || vote.Height != self.Number || !bytes.Equal(vote.BlockHash, self.BlockHash) {
+			continue
+		}
+		signer := vote.Signer()
+		if signer == nil || have[string(signer)] {
+			continue
+		}
+		have[string(signer)] = true
+		self.Votes = append(self.Votes, vote)
+		n += 1
+	}
+	return n
+}
+
+func (self *CheckpointCertificate) RlpData() interface{} {
+	votes := make([]interface{}, len(self.Votes))
+	for i, vote := range self.Votes {
+		votes[i] = vote.RlpData()
+	}
+	return []interface{}{self.Number, self.BlockHash, votes}
+}
+
+func (self *CheckpointCertificate) RlpEncode() []byte {
+	return monkutil.Encode(self.RlpData())
+}
+
+func (self *CheckpointCertificate) RlpValueDecode(decoder *monkutil.Value) {
+	self.Number = decoder.Get(0).Uint()
+	self.BlockHash = decoder.Get(1).Bytes()
+	votes := decoder.Get(2)
+	self.Votes = make([]*Vote, votes.Len())
+	for i := 0; i < votes.Len(); i++ {
+		self.Votes[i] = NewVoteFromValue(votes.Get(i))
+	}
+}
+
+func (self *CheckpointCertificate) String() string {
+	return fmt.Sprintf("CHECKPOINT #%d %x (%d votes)", self.Number, self.BlockHash, len(self.Votes))
+}
+
+// Checkpoint interval and quorum, if the protocol certifies checkpoints
+func (bc *ChainManager) checkpointRules(state *monkstate.State) (uint64, int) {
+	p, ok := bc.ProtocolAt(new(big.Int).SetUint64(bc.CurrentBlockNumber() + 1)).(CheckpointProtocol)
+	if !ok {
+		return 0, 0
+	}
+	return p.CheckpointInterval(state), p.CheckpointQuorum(state)
+}
+
+// The block to sign as a checkpoint once head is the latest block, if any
+func (bc *ChainManager) CheckpointFor(head *Block) *Block {
+	interval, quorum := bc.checkpointRules(head.State())
+	if interval == 0 || quorum == 0 {
+		return nil
+	}
+	number := head.Number.Uint64()
+	if number <= CheckpointDepth || (number-CheckpointDepth)%interval != 0 {
+		return nil
+	}
+	block := head
+	for i := uint64(0); i < CheckpointDepth && block != nil; i++ {
+		block = bc.GetBlock(block.PrevHash)
+	}
+	return block
+}
+
+// Whether addr may sign checkpoints
+func (bc *ChainManager) CanCertify(addr []byte) bool {
+	state := bc.CurrentBlock().State()
+	if _, quorum := bc.checkpointRules(state); quorum == 0 {
+		return false
+	}
+	return bc.mayCertify(addr, state)
+}
+
+// Whether addr may sign checkpoints, by the protocol for the next block
+func (bc *ChainManager) mayCertify(addr []byte, state *monkstate.State) bool {
+	number := new(big.Int).SetUint64(bc.CurrentBlockNumber() + 1)
+	return bc.ProtocolAt(number).ValidatePerm(addr, "checkpoint", state) == nil
+}
+
+// The certificate with only the votes from accounts that may sign checkpoints
+func (bc *ChainManager) permittedVotes(cert *CheckpointCertificate, state *monkstate.State) *CheckpointCertificate {
+	permitted := NewCheckpointCertificate(cert.Number, cert.BlockHash)
+	for _, vote := range cert.Votes {
+		signer := vote.Signer()
+		if signer != nil && bc.mayCertify(signer, state) {
+			permitted.Votes = append(permitted.Votes, vote)
+		}
+	}
+	return permitted
+}
+
+// Add a certificate (or some votes for one) from a peer or ourselves.
+// Votes are collected until there is a quorum. Returns the
+// certificate with the votes merged in if we learned anything
+// new (so it can be passed on), or nil otherwise
+func (bc *ChainManager) AddCheckpointCertificate(cert *CheckpointCertificate) (*CheckpointCertificate, error) {
+	state := bc.CurrentBlock().State()
+	_, quorum := bc.checkpointRules(state)
+	if quorum == 0 {
+		return nil, fmt.Errorf("Protocol does not certify checkpoints")
+	}
+	cert = bc.permittedVotes(cert, state)
+	if len(cert.Signers()) == 0 {
+		return nil, fmt.Errorf("Checkpoint #%d has no valid votes", cert.Number)
+	}
+
+	merged, certified, err := bc.mergeCertificate(cert, quorum)
+	if certified {
+		bc.adoptCertificate(merged)
+	}
+	return merged, err
+}
+
+func (bc *ChainManager) mergeCertificate(cert *CheckpointCertificate, quorum int) (*CheckpointCertificate, bool, error) {
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+
+	latest := bc.latestCertificate
+	if latest != nil && cert.Number < latest.Number {
+		return nil, false, nil
+	}
+	if latest != nil && cert.Number == latest.Number {
+		if !bytes.Equal(cert.BlockHash, latest.BlockHash) {
+			// a certified checkpoint is never replaced
+			equivocators := signedBoth(latest, cert)
+			chainlogger.Errorf("Equivocation at checkpoint #%d: votes for %x after %x was certified (signed both: %x)\n", cert.Number, cert.BlockHash, latest.BlockHash, equivocators)
+			return nil, false, fmt.Errorf("Checkpoint #%d is already certified for %x", cert.Number, latest.BlockHash)
+		}
+		// more votes for a certified checkpoint
+		if latest.Merge(cert) == 0 {
+			return nil, false, nil
+		}
+		bc.writeCheckpointCertificate(latest)
+		return latest, false, nil
+	}
+
+	pending := bc.pendingCertificates[string(cert.BlockHash)]
+	if pending == nil {
+		if !bc.makeRoomForCertificate(len(cert.Signers())) {
+			return nil, false, fmt.Errorf("Too many pending checkpoints for #%d", cert.Number)
+		}
+		pending = NewCheckpointCertificate(cert.Number, cert.BlockHash)
+		bc.pendingCertificates[string(cert.BlockHash)] = pending
+	}
+	if pending.Merge(cert) == 0 {
+		return nil, false, nil
+	}
+	if len(pending.Signers()) < quorum {
+		return pending, false, nil
+	}
+
+	bc.latestCertificate = pending
+	bc.writeCheckpointCertificate(pending)
+	for hash, c := range bc.pendingCertificates {
+		if c.Number <= pending.Number {
+			delete(bc.pendingCertificates, hash)
+		}
+	}
+	return pending, true, nil
+}
+
+// Make room for a new pending certificate with the given number of
+// signers, dropping the one with the fewest (the latest if tied).
+// A signer voting for many blocks can't push out a checkpoint that
+// has more votes. False if there's no room
+func (bc *ChainManager) makeRoomForCertificate(signers int) bool {
+	if len(bc.pendingCertificates) < MaxPendingCertificates {
+		return true
+	}
+	var weakest string
+	var fewest int
+	var number uint64
+	for hash, c := range bc.pendingCertificates {
+		n := len(c.Signers())
+		if weakest == "" || n < fewest || (n == fewest && c.Number > number) {
+			weakest, fewest, number = hash, n, c.Number
+		}
+	}
+	if signers < fewest {
+		return false
+	}
+	delete(bc.pendingCertificates, weakest)
+	return true
+}
+
+// Accounts that signed both certificates
+func signedBoth(a, b *CheckpointCertificate) [][]byte {
+	signed := make(map[string]bool)
+	for _, signer := range a.Signers() {
+		signed[string(signer)] = true
+	}
+	both := [][]byte{}
+	for _, signer := range b.Signers() {
+		if signed[string(signer)] {
+			both = append(both, signer)
+		}
+	}
+	return both
+}
+
+// Use a newly certified checkpoint. If we're waiting to sync
+// and are behind it, fetch the checkpoint block and its state
+// from peers. Otherwise, we don't accept blocks below it
+func (bc *ChainManager) adoptCertificate(cert *CheckpointCertificate) {
+	chainlogger.Infof("Certified checkpoint (#%d) %x\n", cert.Number, cert.BlockHash)
+
+	if bc.WaitingForCertificate() {
+		if cert.Number > bc.CurrentBlockNumber() {
+			bc.updateCheckpoint(cert.BlockHash)
+		}
+		bc.CancelCheckpointSync()
+	} else if cert.Number > bc.LatestCheckPointNumber() && bc.GetBlockCanonical(cert.BlockHash) != nil {
+		bc.updateCheckpoint(cert.BlockHash)
+	}
+}
+
+func (bc *ChainManager) writeCheckpointCertificate(cert *CheckpointCertificate) {
+	bc.db.Put([]byte("CheckpointCertificate"), cert.RlpEncode())
+}
+
+// The latest certificate with a quorum, if any
+func (bc *ChainManager) LatestCheckpointCertificate() *CheckpointCertificate {
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+	return bc.latestCertificate
+}
+
+// load the latest certificate from db, if any
+func (bc *ChainManager) loadCheckpointCertificate() {
+	data, _ := bc.db.Get([]byte("CheckpointCertificate"))
+	if len(data) != 0 {
+		bc.latestCertificate = NewCheckpointCertificateFromBytes(data)
+	}
+}
+
+// Wait for a certificate from peers and sync from the
+// certified checkpoint rather than from genesis
+func (bc *ChainManager) SyncFromCheckpoint() {
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+	if bc.waitingForCheckPoint {
+		// we already have a checkpoint to sync from
+		return
+	}
+	bc.waitingForCertificate = true
+}
+
+func (bc *ChainManager) WaitingForCertificate() bool {
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+	return bc.waitingForCertificate
+}
+
+// Stop waiting for a certificate (ie. none came in time)
+func (bc *ChainManager) CancelCheckpointSync() {
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+	bc.waitingForCertificate = false
+}
@@ -0,0 +1,96 @@
+package monkchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+func TestCheckpointCertificateMerge(t *testing.T) {
+	hash := monkcrypto.Sha3Bin([]byte("checkpoint"))
+	keys := []*monkcrypto.KeyPair{monkcrypto.GenerateNewKeyPair(), monkcrypto.GenerateNewKeyPair()}
+
+	cert := NewCheckpointCertificate(100, hash)
+	cert.Sign(keys[0].PrivateKey)
+
+	other := NewCheckpointCertificate(100, hash)
+	other.Sign(keys[0].PrivateKey)
+	other.Sign(keys[1].PrivateKey)
+	other = NewCheckpointCertificateFromBytes(other.RlpEncode())
+
+	if other.Number != 100 || !bytes.Equal(other.BlockHash, hash) {
+		t.Error("Checkpoint certificate did not survive rlp", other)
+	}
+	if n := cert.Merge(other); n != 1 {
+		t.Errorf("Expected to merge 1 vote, merged %d", n)
+	}
+	if n := cert.Merge(other); n != 0 {
+		t.Errorf("Merged %d votes we already had", n)
+	}
+	if n := len(cert.Signers()); n != 2 {
+		t.Errorf("Expected 2 signers, got %d", n)
+	}
+
+	// votes for another checkpoint don't count
+	wrong := NewCheckpointCertificate(101, hash)
+	wrong.Sign(monkcrypto.GenerateNewKeyPair().PrivateKey)
+	if n := cert.Merge(wrong); n != 0 {
+		t.Errorf("Merged %d votes for another checkpoint", n)
+	}
+}
+
+func certify(number uint64, hash []byte, keys ...*monkcrypto.KeyPair) *CheckpointCertificate {
+	cert := NewCheckpointCertificate(number, hash)
+	for _, k := range keys {
+		cert.Sign(k.PrivateKey)
+	}
+	return cert
+}
+
+func TestCheckpointEquivocation(t *testing.T) {
+	initDB()
+	bc := &ChainManager{db: monkutil.Config.Db, pendingCertificates: make(map[string]*CheckpointCertificate)}
+	keys := []*monkcrypto.KeyPair{monkcrypto.GenerateNewKeyPair(), monkcrypto.GenerateNewKeyPair(), monkcrypto.GenerateNewKeyPair()}
+	hash := monkcrypto.Sha3Bin([]byte("checkpoint"))
+	other := monkcrypto.Sha3Bin([]byte("other"))
+
+	if _, certified, err := bc.mergeCertificate(certify(100, hash, keys[0], keys[1]), 2); !certified || err != nil {
+		t.Fatal("Expected a quorum to certify the checkpoint, got", err)
+	}
+	// a second quorum for another block at the same height
+	if _, certified, err := bc.mergeCertificate(certify(100, other, keys[1], keys[2]), 2); certified || err == nil {
+		t.Error("Expected a conflicting checkpoint to be rejected")
+	}
+	if latest := bc.latestCertificate; !bytes.Equal(latest.BlockHash, hash) {
+		t.Errorf("Certified checkpoint was replaced by %x", latest.BlockHash)
+	}
+	if both := signedBoth(bc.latestCertificate, certify(100, other, keys[1], keys[2])); len(both) != 1 || !bytes.Equal(both[0], keys[1].Address()) {
+		t.Errorf("Expected the equivocator to be the second signer, got %x", both)
+	}
+	// more votes for the certified one are still taken
+	if merged, _, err := bc.mergeCertificate(certify(100, hash, keys[2]), 2); merged == nil || err != nil {
+		t.Error("Expected to merge a vote for the certified checkpoint, got", err)
+	}
+}
+
+func TestPendingCertificatesBound(t *testing.T) {
+	initDB()
+	bc := &ChainManager{db: monkutil.Config.Db, pendingCertificates: make(map[string]*CheckpointCertificate)}
+	spammer := monkcrypto.GenerateNewKeyPair()
+	keys := []*monkcrypto.KeyPair{monkcrypto.GenerateNewKeyPair(), monkcrypto.GenerateNewKeyPair()}
+	hash := monkcrypto.Sha3Bin([]byte("checkpoint"))
+	bc.mergeCertificate(certify(100, hash, keys...), 3)
+
+	// one signer voting for many blocks
+	for i := 0; i < 2*MaxPendingCertificates; i++ {
+		bc.mergeCertificate(certify(100, monkcrypto.Sha3Bin([]byte{byte(i)}), spammer), 3)
+	}
+	if n := len(bc.pendingCertificates); n > MaxPendingCertificates {
+		t.Errorf("%d pending certificates, expected at most %d", n, MaxPendingCertificates)
+	}
+	if _, certified, _ := bc.mergeCertificate(certify(100, hash, spammer), 3); !certified {
+		t.Error("Expected the checkpoint with the most votes to survive the spam")
+	}
+}
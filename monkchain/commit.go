@@ -0,0 +1,182 @@
+package monkchain
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkutil"
+	"github.com/obscuren/secp256k1-go"
+)
+
+/*
+   Votes and commit certificates for models with finality.
+   A proposer signs a proposal vote for the block it made.
+   A validator signs a prevote for the block it saw proposed
+   at a given height and round, and a precommit once it has seen
+   more than two thirds of the validators prevote for that block.
+   More than two thirds of precommits make a commit certificate,
+   which is stored alongside the block. The ChainManager refuses
+   to reorg below the latest committed height.
+*/
+
+const (
+	VoteProposal  = 0x00
+	VotePrevote   = 0x01
+	VotePrecommit = 0x02
+	// Signature of a checkpoint certificate
+	VoteCheckpoint = 0x03
+)
+
+type Vote struct {
+	Type      byte
+	Height    uint64
+	Round     uint64
+	BlockHash []byte
+	v         byte
+	r, s      []byte
+}
+
+func NewVote(typ byte, height, round uint64, hash []byte) *Vote {
+	return &Vote{Type: typ, Height: height, Round: round, BlockHash: hash}
+}
+
+func NewVoteFromValue(val *monkutil.Value) *Vote {
+	vote := &Vote{}
+	vote.RlpValueDecode(val)
+	return vote
+}
+
+func (self *Vote) Hash() []byte {
+	data := []interface{}{self.Type, self.Height, self.Round, self.BlockHash}
+	return monkcrypto.Sha3Bin(monkutil.NewValue(data).Encode())
+}
+
+func (self *Vote) Sign(privk []byte) {
+	sig, _ := secp256k1.Sign(self.Hash(), privk)
+	self.r = sig[:32]
+	self.s = sig[32:64]
+	self.v = sig[64] + 27
+}
+
+func (self *Vote) PublicKey() []byte {
+	r := monkutil.LeftPadBytes(self.r, 32)
+	s := monkutil.LeftPadBytes(self.s, 32)
+	sig := append(r, s...)
+	sig = append(sig, self.v-27)
+
+	pubkey, _ := secp256k1.RecoverPubkey(self.Hash(), sig)
+	return pubkey
+}
+
+// Address of the validator that signed the vote
+func (self *Vote) Signer() []byte {
+	if len(self.r) == 0 || len(self.s) == 0 {
+		return nil
+	}
+
+	pubkey := self.PublicKey()
+	if len(pubkey) == 0 || pubkey[0] != 4 {
+		return nil
+	}
+	return monkcrypto.Sha3Bin(pubkey[1:])[12:]
+}
+
+func (self *Vote) RlpData() interface{} {
+	return []interface{}{self.Type, self.Height, self.Round, self.BlockHash, self.v, self.r, self.s}
+}
+
+func (self *Vote) RlpValueDecode(decoder *monkutil.Value) {
+	self.Type = byte(decoder.Get(0).Uint())
+	self.Height = decoder.Get(1).Uint()
+	self.Round = decoder.Get(2).Uint()
+	self.BlockHash = decoder.Get(3).Bytes()
+	self.v = byte(decoder.Get(4).Uint())
+	self.r = decoder.Get(5).Bytes()
+	self.s = decoder.Get(6).Bytes()
+}
+
+func (self *Vote) String() string {
+	return fmt.Sprintf("VOTE(%d) #%d/%d %x by %x", self.Type, self.Height, self.Round, self.BlockHash, self.Signer())
+}
+
+// Proof that a block at a height was committed by the validators
+type CommitCertificate struct {
+	Height     uint64
+	Round      uint64
+	BlockHash  []byte
+	Precommits []*Vote
+}
+
+func NewCommitCertificate(height, round uint64, hash []byte, precommits []*Vote) *CommitCertificate {
+	return &CommitCertificate{Height: height, Round: round, BlockHash: hash, Precommits: precommits}
+}
+
+func NewCommitCertificateFromBytes(data []byte) *CommitCertificate {
+	cert := &CommitCertificate{}
+	cert.RlpValueDecode(monkutil.NewValueFromBytes(data))
+	return cert
+}
+
+func NewCommitCertificateFromValue(val *monkutil.Value) *CommitCertificate {
+	cert := &CommitCertificate{}
+	cert.RlpValueDecode(val)
+	return cert
+}
+
+// Addresses of the distinct validators whose precommits
+// match the certificate's height, round, and block hash
+func (self *CommitCertificate) Signers() [][]byte {
+	seen := make(map[string]bool)
+	signers := [][]byte{}
+	for _, vote := range self.Precommits {
+		if vote.Type != VotePrecommit || vote.Height != self.Height || vote.Round != self.Round {
+			continue
+		}
+		if !bytes.Equal(vote.BlockHash, self.BlockHash) {
+			continue
+		}
+		signer := vote.Signer()
+		if signer == nil || seen[string(signer)] {
+			continue
+		}
+		seen[string(signer)] = true
+		signers = append(signers, signer)
+	}
+	return signers
+}
+
+func (self *CommitCertificate) RlpData() interface{} {
+	votes := make([]interface{}, len(self.Precommits))
+	for i, vote := range self.Precommits {
+		votes[i] = vote.RlpData()
+	}
+	return []interface{}{self.Height, self.Round, self.BlockHash, votes}
+}
+
+func (self *CommitCertificate) RlpEncode() []byte {
+	return monkutil.Encode(self.RlpData())
+}
+
+func (self *CommitCertificate) RlpValueDecode(decoder *monkutil.Value) {
+	self.Height = decoder.Get(0).Uint()
+	self.Round = decoder.Get(1).Uint()
+	self.BlockHash = decoder.Get(2).Bytes()
+	votes := decoder.Get(3)
+	self.Precommits = make([]*Vote, votes.Len())
+	for i := 0; i < votes.Len(); i++ {
+		self.Precommits[i] = NewVoteFromValue(votes.Get(i))
+	}
+}
+
+func (self *CommitCertificate) String() string {
+	return fmt.Sprintf("COMMIT #%d/%d %x (%d precommits)", self.Height, self.Round, self.BlockHash, len(self.Precommits))
+}
+
+// Optional interface for consensus models that run their own
+// processes alongside the node (ie. to exchange votes).
+// Started and stopped with the node
+type ConsensusEngine interface {
+	Start(th NodeManager)
+	Stop()
+}
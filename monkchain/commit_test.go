@@ -0,0 +1,39 @@
+package monkchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+func TestCommitCertificateSigners(t *testing.T) {
+	hash := monkcrypto.Sha3Bin([]byte("block"))
+	keys := []*monkcrypto.KeyPair{monkcrypto.GenerateNewKeyPair(), monkcrypto.GenerateNewKeyPair()}
+
+	votes := []*Vote{}
+	for _, k := range keys {
+		vote := NewVote(VotePrecommit, 5, 1, hash)
+		vote.Sign(k.PrivateKey)
+		if !bytes.Equal(vote.Signer(), k.Address()) {
+			t.Errorf("Vote signer %x, expected %x", vote.Signer(), k.Address())
+		}
+		votes = append(votes, vote)
+	}
+	// duplicates and votes for other blocks don't count
+	votes = append(votes, votes[0])
+	other := NewVote(VotePrecommit, 5, 1, monkcrypto.Sha3Bin([]byte("other")))
+	other.Sign(monkcrypto.GenerateNewKeyPair().PrivateKey)
+	votes = append(votes, other)
+
+	cert := NewCommitCertificate(5, 1, hash, votes)
+	cert = NewCommitCertificateFromValue(monkutil.NewValueFromBytes(cert.RlpEncode()))
+
+	if cert.Height != 5 || cert.Round != 1 || !bytes.Equal(cert.BlockHash, hash) {
+		t.Error("Commit certificate did not survive rlp", cert)
+	}
+	if n := len(cert.Signers()); n != 2 {
+		t.Errorf("Expected 2 signers, got %d", n)
+	}
+}
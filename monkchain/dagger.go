@@ -4,6 +4,7 @@ import (
 	"hash"
 	"math/big"
 	"math/rand"
+	"sync/atomic"
 	"time"
 
 	"github.com/eris-ltd/thelonious/monkcrypto"
@@ -25,7 +26,8 @@ type PoW interface {
 type EasyPow struct {
 	hash     *big.Int
 	HashRate int64
-	turbo    bool
+	// set while workers are searching, so atomic
+	turbo int32
 }
 
 func (pow *EasyPow) GetHashrate() int64 {
@@ -33,7 +35,11 @@ func (pow *EasyPow) GetHashrate() int64 {
 }
 
 func (pow *EasyPow) Turbo(on bool) {
-	pow.turbo = on
+	var turbo int32
+	if on {
+		turbo = 1
+	}
+	atomic.StoreInt32(&pow.turbo, turbo)
 }
 
 func (pow *EasyPow) Search(block *Block, reactChan chan monkreact.Event) []byte {
@@ -67,7 +73,7 @@ func (pow *EasyPow) Search(block *Block, reactChan chan monkreact.Event) []byte
 			}
 		}
 
-		if !pow.turbo {
+		if atomic.LoadInt32(&pow.turbo) == 0 {
 			time.Sleep(20 * time.Microsecond)
 		}
 	}
@@ -96,6 +102,17 @@ func (pow *EasyPow) SetHash(hash *big.Int) {
 type Dagger struct {
 	hash *big.Int
 	xn   *big.Int
+	// depth of the dag, and parents of a node at the top
+	// (defaults 9 and 16)
+	levels uint64
+	width  int
+}
+
+func (dag *Dagger) depth() uint64 {
+	if dag.levels == 0 {
+		return 9
+	}
+	return dag.levels
 }
 
 var Found bool
@@ -177,8 +194,11 @@ func (dag *Dagger) Node(L uint64, i uint64) *big.Int {
 	}
 
 	var m *big.Int
-	if L == 9 {
+	if L == dag.depth() {
 		m = big.NewInt(16)
+		if dag.width > 0 {
+			m = big.NewInt(int64(dag.width))
+		}
 	} else {
 		m = big.NewInt(3)
 	}
@@ -231,9 +251,9 @@ func (dag *Dagger) Eval(N *big.Int) *big.Int {
 		d.Write(big.NewInt(int64(k)).Bytes())
 
 		b.SetBytes(Sum(d))
-		pk := (b.Uint64() & 0x1ffffff)
+		pk := b.Uint64() & ((1 << (dag.depth()*3 - 2)) - 1)
 
-		sha.Write(dag.Node(9, pk).Bytes())
+		sha.Write(dag.Node(dag.depth(), pk).Bytes())
 	}
 
 	return ret.SetBytes(Sum(sha))
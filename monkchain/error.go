@@ -97,6 +97,23 @@ func GasLimitTxError(is, max *big.Int) *GasLimitTxErr {
 	return &GasLimitTxErr{Message: fmt.Sprintf("GasLimitTx error. Max %s, transaction would take %s", max, is), Is: is, Max: max}
 }
 
+type GasPriceErr struct {
+	Message string
+	Is, Min *big.Int
+}
+
+func IsGasPriceErr(err error) bool {
+	_, ok := err.(*GasPriceErr)
+
+	return ok
+}
+func (err *GasPriceErr) Error() string {
+	return err.Message
+}
+func GasPriceError(is, min *big.Int) *GasPriceErr {
+	return &GasPriceErr{Message: fmt.Sprintf("Gas price too low. Min %s, transaction pays %s", min, is), Is: is, Min: min}
+}
+
 type NonceErr struct {
 	Message string
 	Is, Exp uint64
@@ -133,6 +150,34 @@ func IsOutOfGasErr(err error) bool {
 	return ok
 }
 
+// Why the tx pool turned a tx away, or dropped one it had
+type TxPoolErr struct {
+	Message string
+	Reason  string
+}
+
+const (
+	TxUnderpriced = "underpriced"
+	TxReplaced    = "replaced"
+	TxAccountFull = "account full"
+	TxPoolFull    = "pool full"
+	TxEvicted     = "evicted"
+)
+
+func (err *TxPoolErr) Error() string {
+	return err.Message
+}
+
+func TxPoolError(reason, format string, v ...interface{}) *TxPoolErr {
+	return &TxPoolErr{Message: fmt.Sprintf(format, v...), Reason: reason}
+}
+
+func IsTxPoolErr(err error) bool {
+	_, ok := err.(*TxPoolErr)
+
+	return ok
+}
+
 type TxFail struct {
 	Tx  *Transaction
 	Err error
@@ -193,3 +238,22 @@ func IsTDError(e error) bool {
 	_, ok := e.(*TDError)
 	return ok
 }
+
+// A block that's only a little in the future.
+// It should be held and tried again once Wait seconds pass
+type FutureBlockErr struct {
+	Wait int64
+}
+
+func (err *FutureBlockErr) Error() string {
+	return fmt.Sprintf("Block is in the future. Valid in %ds", err.Wait)
+}
+
+func FutureBlockError(wait int64) *FutureBlockErr {
+	return &FutureBlockErr{wait}
+}
+
+func IsFutureBlockErr(err error) bool {
+	_, ok := err.(*FutureBlockErr)
+	return ok
+}
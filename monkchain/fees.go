@@ -1,7 +1,119 @@
 package monkchain
 
 import (
+	"fmt"
 	"math/big"
+
+	"github.com/eris-ltd/thelonious/monkstate"
+	"github.com/eris-ltd/thelonious/monkutil"
 )
 
+// The default block reward
 var BlockReward *big.Int = big.NewInt(1.5e+18)
+
+/*
+   How miners are paid. The coinbase gets the block reward for
+   the block's height, a share of it for each uncle it includes
+   (see UncleRules), and the fees its txs paid. Part of the reward
+   and of the fees may go to a treasury instead. Miners and
+   validators both pay out through AccumelateRewards
+*/
+
+type RewardRules struct {
+	// Block reward (wei) before the first step of the
+	// schedule. BlockReward if not set
+	Reward *big.Int `json:"reward"`
+	// The block reward from a height on
+	Schedule []*RewardStep `json:"schedule,omitempty"`
+	// Address (hex) of the treasury (none if empty)
+	Treasury string `json:"treasury"`
+	// The treasury's share of the coinbase's reward
+	RewardNum int64 `json:"reward-num"`
+	RewardDen int64 `json:"reward-den"`
+	// and of the fees
+	FeeNum int64 `json:"fee-num"`
+	FeeDen int64 `json:"fee-den"`
+}
+
+type RewardStep struct {
+	Height uint64   `json:"height"`
+	Reward *big.Int `json:"reward"`
+}
+
+var DefaultRewardRules = &RewardRules{Reward: BlockReward}
+
+// Optional interface for protocols with their own rewards
+type RewardProtocol interface {
+	// Rewards for blocks on the state (nil for defaults)
+	RewardRules(state *monkstate.State) *RewardRules
+}
+
+// Rewards of the protocol at the block number, given the
+// parent's state, or the defaults
+func (bc *ChainManager) RewardRules(number *big.Int, state *monkstate.State) *RewardRules {
+	if p, ok := bc.ProtocolAt(number).(RewardProtocol); ok {
+		if rules := p.RewardRules(state); rules != nil {
+			return rules
+		}
+	}
+	return DefaultRewardRules
+}
+
+// The block reward at the block number: that of the
+// latest step of the schedule reached
+func (self *RewardRules) BlockReward(number *big.Int) *big.Int {
+	reward, height := self.Reward, uint64(0)
+	if reward == nil {
+		reward = BlockReward
+	}
+	for _, step := range self.Schedule {
+		if step.Height <= number.Uint64() && step.Height >= height {
+			reward, height = step.Reward, step.Height
+		}
+	}
+	if reward == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(reward)
+}
+
+// The treasury's address, or nil if there's none
+func (self *RewardRules) TreasuryAddress() []byte {
+	return monkutil.UserHex2Bytes(self.Treasury)
+}
+
+// The treasury's shares must be fractions between 0 and 1
+func (self *RewardRules) Check() error {
+	if self.RewardNum < 0 || self.RewardDen < 0 || self.RewardNum > self.RewardDen {
+		return fmt.Errorf("Treasury reward share %d/%d is not between 0 and 1", self.RewardNum, self.RewardDen)
+	}
+	if self.FeeNum < 0 || self.FeeDen < 0 || self.FeeNum > self.FeeDen {
+		return fmt.Errorf("Treasury fee share %d/%d is not between 0 and 1", self.FeeNum, self.FeeDen)
+	}
+	return nil
+}
+
+// The treasury's share of the coinbase's reward and fees,
+// which is never more than all of them
+func (self *RewardRules) TreasuryShare(reward, fees *big.Int) *big.Int {
+	if self.TreasuryAddress() == nil {
+		return new(big.Int)
+	}
+	share := fraction(reward, self.RewardNum, self.RewardDen)
+	share.Add(share, fraction(fees, self.FeeNum, self.FeeDen))
+	if total := new(big.Int).Add(reward, fees); share.Cmp(total) > 0 {
+		share = total
+	}
+	return share
+}
+
+// The fees the txs paid the coinbase (gas used times gas price)
+func ReceiptFees(receipts Receipts) *big.Int {
+	fees, used := new(big.Int), new(big.Int)
+	for _, receipt := range receipts {
+		gas := new(big.Int).Sub(receipt.CumulativeGasUsed, used)
+		fees.Add(fees, gas.Mul(gas, receipt.Tx.GasPrice))
+		used = receipt.CumulativeGasUsed
+	}
+	return fees
+}
@@ -0,0 +1,136 @@
+package monkchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkstate"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+// Just enough of a protocol for the rewards
+type rewardProtocol struct {
+	Protocol
+	rules *RewardRules
+}
+
+func (self *rewardProtocol) RewardRules(state *monkstate.State) *RewardRules {
+	return self.rules
+}
+
+func TestRewardSchedule(t *testing.T) {
+	rules := &RewardRules{
+		Reward: big.NewInt(1000),
+		// out of order
+		Schedule: []*RewardStep{{Height: 20, Reward: big.NewInt(0)}, {Height: 10, Reward: big.NewInt(500)}},
+	}
+	for number, reward := range map[int64]int64{0: 1000, 9: 1000, 10: 500, 19: 500, 20: 0, 100: 0} {
+		if r := rules.BlockReward(big.NewInt(number)); r.Int64() != reward {
+			t.Errorf("Reward at #%d is %v, expected %d", number, r, reward)
+		}
+	}
+	if r := (&RewardRules{}).BlockReward(big.NewInt(1)); r.Cmp(BlockReward) != 0 {
+		t.Errorf("Expected the default reward, got %v", r)
+	}
+	if share := rules.TreasuryShare(big.NewInt(1000), big.NewInt(1000)); share.Sign() != 0 {
+		t.Errorf("Expected no share without a treasury, got %v", share)
+	}
+}
+
+func TestTreasuryShare(t *testing.T) {
+	rules := &RewardRules{Treasury: "01", RewardNum: 1, RewardDen: 2, FeeNum: 1, FeeDen: 1}
+	if err := rules.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if share := rules.TreasuryShare(big.NewInt(1000), big.NewInt(200)); share.Int64() != 700 {
+		t.Errorf("Expected a share of 700, got %v", share)
+	}
+
+	// a share above 1 is never more than the reward and fees
+	rules.RewardNum = 3
+	if err := rules.Check(); err == nil {
+		t.Error("Expected a reward share of 3/2 to fail")
+	}
+	if share := rules.TreasuryShare(big.NewInt(1000), big.NewInt(200)); share.Int64() != 1200 {
+		t.Errorf("Expected the share capped at 1200, got %v", share)
+	}
+	rules.RewardNum, rules.FeeNum = 1, 2
+	if err := rules.Check(); err == nil {
+		t.Error("Expected a fee share of 2/1 to fail")
+	}
+	rules.FeeNum, rules.FeeDen = -1, 1
+	if err := rules.Check(); err == nil {
+		t.Error("Expected a negative fee share to fail")
+	}
+}
+
+func TestAccumelateRewards(t *testing.T) {
+	key := monkcrypto.GenerateNewKeyPair()
+	bc, blocks := canonicalChain(2, poolTx(key, 0, 2))
+	treasury, coinbase := monkutil.LeftPadBytes([]byte{1}, 20), monkutil.LeftPadBytes([]byte{2}, 20)
+	bc.protocol = &rewardProtocol{rules: &RewardRules{
+		Reward:    big.NewInt(1000),
+		Schedule:  []*RewardStep{{Height: 1, Reward: big.NewInt(600)}},
+		Treasury:  monkutil.Bytes2Hex(treasury),
+		RewardNum: 1,
+		RewardDen: 2,
+		FeeNum:    1,
+		FeeDen:    4,
+	}}
+	sm := &BlockManager{bc: bc}
+
+	block, parent := blocks[1], blocks[0]
+	block.Coinbase = coinbase
+	if fees := ReceiptFees(block.Receipts()); fees.Int64() != 200 {
+		t.Fatalf("Expected 200 in fees, got %v", fees)
+	}
+	state := block.State()
+	if err := sm.AccumelateRewards(state, block, parent, block.Receipts()); err != nil {
+		t.Fatal(err)
+	}
+
+	// half of the 600 reward and a quarter of the 200 fees
+	if balance := state.GetAccount(treasury).Balance; balance.Int64() != 350 {
+		t.Errorf("Treasury got %v, expected 350", balance)
+	}
+	if balance := state.GetAccount(coinbase).Balance; balance.Int64() != 600-350 {
+		t.Errorf("Coinbase got %v, expected %d", balance, 600-350)
+	}
+}
+
+func TestUncleRewards(t *testing.T) {
+	bc, blocks := canonicalChain(3)
+	bc.protocol = &rewardProtocol{rules: &RewardRules{Reward: big.NewInt(3200)}}
+	sm := &BlockManager{bc: bc}
+	coinbase, uncleCoinbase := monkutil.LeftPadBytes([]byte{2}, 20), monkutil.LeftPadBytes([]byte{3}, 20)
+
+	// a sibling of blocks[1]
+	uncle := CreateBlock(nil, blocks[0].Hash(), uncleCoinbase, big.NewInt(1), nil, "")
+	uncle.Number = big.NewInt(1)
+	uncle.Nonce = []byte{1}
+	block, parent := blocks[2], blocks[1]
+	block.Coinbase = coinbase
+	block.Nonce = []byte{2}
+	block.SetUncles([]*Block{uncle})
+
+	state := block.State()
+	if err := sm.AccumelateRewards(state, block, parent, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 15/16 of the reward to the uncle, and 1/32 more for including it
+	if balance := state.GetAccount(uncleCoinbase).Balance; balance.Int64() != 3000 {
+		t.Errorf("Uncle's coinbase got %v, expected 3000", balance)
+	}
+	if balance := state.GetAccount(coinbase).Balance; balance.Int64() != 3300 {
+		t.Errorf("Coinbase got %v, expected 3300", balance)
+	}
+
+	// an ancestor isn't an uncle, and neither is the same uncle twice
+	for _, uncles := range [][]*Block{{blocks[1]}, {uncle, uncle}} {
+		block.SetUncles(uncles)
+		if err := sm.AccumelateRewards(block.State(), block, parent, nil); !IsUncleErr(err) {
+			t.Errorf("Expected an uncle error, got %v", err)
+		}
+	}
+}
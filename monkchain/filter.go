@@ -25,8 +25,14 @@ type Filter struct {
 
 	altered []data
 
+	// for logs: the contracts that made them, and the
+	// alternatives for each topic (none for any topic)
+	address [][]byte
+	topics  [][][]byte
+
 	BlockCallback   func(*Block)
 	MessageCallback func(monkstate.Messages)
+	LogsCallback    func(monkstate.Logs)
 }
 
 // Create a new filter which uses a bloom filter on blocks to figure out whether a particular block
@@ -72,6 +78,20 @@ func NewFilterFromMap(object map[string]interface{}, eth NodeManager) *Filter {
 		filter.altered = makeAltered(object["altered"])
 	}
 
+	if object["address"] != nil {
+		filter.SetAddress(makeBytesList(object["address"]))
+	}
+
+	if topics, ok := object["topics"].([]interface{}); ok {
+		for _, topic := range topics {
+			if topic == nil {
+				filter.AddTopic()
+			} else {
+				filter.AddTopic(makeBytesList(topic)...)
+			}
+		}
+	}
+
 	return filter
 }
 
@@ -106,6 +126,31 @@ func (self *Filter) AddTo(addr []byte) {
 	self.to = append(self.to, addr)
 }
 
+func (self *Filter) SetAddress(addr [][]byte) {
+	self.address = addr
+}
+
+func (self *Filter) AddAddress(addr []byte) {
+	self.address = append(self.address, addr)
+}
+
+// Set the alternatives for each topic in turn
+func (self *Filter) SetTopics(topics [][][]byte) {
+	self.topics = nil
+	for _, alternatives := range topics {
+		self.AddTopic(alternatives...)
+	}
+}
+
+// Add the alternatives for the next topic (none for any)
+func (self *Filter) AddTopic(alternatives ...[]byte) {
+	padded := make([][]byte, len(alternatives))
+	for i, topic := range alternatives {
+		padded[i] = monkutil.LeftPadBytes(topic, 32)
+	}
+	self.topics = append(self.topics, padded)
+}
+
 func (self *Filter) SetMax(max int) {
 	self.max = max
 }
@@ -161,6 +206,39 @@ func (self *Filter) Find() []*monkstate.Message {
 	return messages[skip:]
 }
 
+// Find logs with the current parameters, newest first
+func (self *Filter) FindLogs() monkstate.Logs {
+	var earliestBlockNo uint64 = uint64(self.earliest)
+	if self.earliest == -1 {
+		earliestBlockNo = self.eth.ChainManager().CurrentBlock().Number.Uint64()
+	}
+	var latestBlockNo uint64 = uint64(self.latest)
+	if self.latest == -1 {
+		latestBlockNo = self.eth.ChainManager().CurrentBlock().Number.Uint64()
+	}
+
+	var logs monkstate.Logs
+	self.eth.ChainManager().IterateBlocks(latestBlockNo, earliestBlockNo, func(block *Block) bool {
+		if !self.bloomLogs(block.Bloom()) {
+			return true
+		}
+		for _, receipt := range block.Receipts() {
+			if self.bloomLogs(receipt.Bloom()) {
+				logs = append(logs, self.FilterLogs(receipt.Logs)...)
+			}
+		}
+		return self.max <= 0 || len(logs) < self.skip+self.max
+	})
+
+	skip := int(math.Min(float64(len(logs)), float64(self.skip)))
+	logs = logs[skip:]
+	if self.max > 0 && len(logs) > self.max {
+		logs = logs[:self.max]
+	}
+
+	return logs
+}
+
 func includes(addresses [][]byte, a []byte) (found bool) {
 	for _, addr := range addresses {
 		if bytes.Compare(addr, a) == 0 {
@@ -212,11 +290,56 @@ func (self *Filter) FilterMessages(msgs []*monkstate.Message) []*monkstate.Messa
 	return messages
 }
 
+func (self *Filter) FilterLogs(logs monkstate.Logs) monkstate.Logs {
+	var filtered monkstate.Logs
+
+	for _, log := range logs {
+		if len(self.address) > 0 && !includes(self.address, log.Address) {
+			continue
+		}
+
+		if !self.matchTopics(log.Topics) {
+			continue
+		}
+
+		filtered = append(filtered, log)
+	}
+
+	return filtered
+}
+
+func (self *Filter) matchTopics(topics [][]byte) bool {
+	for i, alternatives := range self.topics {
+		if len(alternatives) == 0 {
+			continue
+		}
+		if i >= len(topics) || !includes(alternatives, topics[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Could the logs in the bloom match
+func (self *Filter) bloomLogs(bloom *BloomFilter) bool {
+	if len(self.address) > 0 && !bloom.HasAny(self.address) {
+		return false
+	}
+
+	for _, alternatives := range self.topics {
+		if len(alternatives) > 0 && !bloom.HasAny(alternatives) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (self *Filter) bloomFilter(block *Block) bool {
-	fk := append([]byte("bloom"), block.Hash()...)
-	bin, err := self.eth.Db().Get(fk)
-	if err != nil {
-		panic(err)
+	bin, _ := self.eth.Db().Get(bloomKey(block.Hash()))
+	// blocks we didn't process (eg. from before a checkpoint) have no bloom
+	if len(bin) == 0 {
+		return true
 	}
 
 	bloom := NewBloomFilter(bin)
@@ -260,6 +383,21 @@ func mapToData(m map[string]interface{}) (d data) {
 	return
 }
 
+// a hex string or a list of them
+func makeBytesList(v interface{}) (list [][]byte) {
+	if str, ok := v.(string); ok {
+		list = append(list, monkutil.Hex2Bytes(str))
+	} else if slice, ok := v.([]interface{}); ok {
+		for _, item := range slice {
+			list = append(list, makeBytesList(item)...)
+		}
+	} else {
+		panic(fmt.Sprintf("makeBytesList err (unknown conversion): %T\n", v))
+	}
+
+	return
+}
+
 // data can come in in the following formats:
 // ["aabbccdd", {id: "ccddee", at: "11223344"}], "aabbcc", {id: "ccddee", at: "1122"}
 func makeAltered(v interface{}) (d []data) {
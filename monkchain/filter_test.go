@@ -1,7 +1,68 @@
 package monkchain
 
-import "testing"
+import (
+	"math/big"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkstate"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
 
 func TestFilter(t *testing.T) {
 	//filter := NewFilter(nil)
 }
+
+func TestFilterLogs(t *testing.T) {
+	contract, other := []byte("contract"), []byte("other")
+	transfer, owner := []byte("transfer"), []byte("owner")
+	logs := monkstate.Logs{
+		&monkstate.Log{Address: contract, Topics: [][]byte{monkutil.LeftPadBytes(transfer, 32), monkutil.LeftPadBytes(owner, 32)}},
+		&monkstate.Log{Address: contract, Topics: [][]byte{monkutil.LeftPadBytes(transfer, 32)}},
+		&monkstate.Log{Address: other, Topics: [][]byte{monkutil.LeftPadBytes(owner, 32)}},
+	}
+
+	check := func(filter *Filter, want ...int) {
+		got := filter.FilterLogs(logs)
+		if len(got) != len(want) {
+			t.Errorf("Got %d logs, expected %d", len(got), len(want))
+			return
+		}
+		for i, j := range want {
+			if got[i] != logs[j] {
+				t.Errorf("Log %d was %v, expected %v", i, got[i], logs[j])
+			}
+		}
+	}
+
+	filter := NewFilter(nil)
+	check(filter, 0, 1, 2)
+
+	filter.AddAddress(contract)
+	check(filter, 0, 1)
+
+	// any first topic, then the owner
+	filter.AddTopic()
+	filter.AddTopic(owner)
+	check(filter, 0)
+
+	filter = NewFilter(nil)
+	filter.SetTopics([][][]byte{{transfer, owner}})
+	check(filter, 0, 1, 2)
+	if !filter.bloomLogs(LogsBloom(logs[2:])) || filter.bloomLogs(NewBloomFilter(nil)) {
+		t.Error("Expected the bloom to match only with the topic")
+	}
+}
+
+func TestReceiptLogsRlp(t *testing.T) {
+	tx := NewTransactionMessage([]byte("to"), big.NewInt(1), big.NewInt(100), big.NewInt(1), nil)
+	receipt := &Receipt{tx, []byte("root"), big.NewInt(100), nil}
+	if r := NewRecieptFromValue(monkutil.NewValueFromBytes(monkutil.Encode(receipt.RlpData()))); len(r.Logs) != 0 {
+		t.Error("Expected no logs")
+	}
+
+	receipt.Logs = monkstate.Logs{&monkstate.Log{Address: []byte("contract"), Topics: [][]byte{monkutil.LeftPadBytes([]byte{1}, 32)}, Data: []byte("data")}}
+	r := NewRecieptFromValue(monkutil.NewValueFromBytes(monkutil.Encode(receipt.RlpData())))
+	if len(r.Logs) != 1 || string(r.Logs[0].Address) != "contract" || len(r.Logs[0].Topics) != 1 || string(r.Logs[0].Data) != "data" {
+		t.Errorf("Logs didn't survive the rlp: %v", r.Logs)
+	}
+}
@@ -0,0 +1,51 @@
+package monkchain
+
+import (
+	"math/big"
+
+	"github.com/eris-ltd/thelonious/monkvm"
+)
+
+/*
+   A protocol may schedule upgrades at given heights
+   (new consensus model, gas costs, vm opcodes).
+   Anything validating a block or running its txs
+   should use the protocol active at the block's height.
+*/
+
+// Optional interface for protocols with scheduled upgrades
+type ForkProtocol interface {
+	// The protocol active at a block number
+	ProtocolAt(number *big.Int) Protocol
+}
+
+// Optional interface for protocols with their own vm rules
+type VmProtocol interface {
+	VmRules() *monkvm.Rules
+}
+
+func protocolAt(protocol Protocol, number *big.Int) Protocol {
+	if p, ok := protocol.(ForkProtocol); ok && number != nil {
+		return p.ProtocolAt(number)
+	}
+	return protocol
+}
+
+func vmRulesAt(protocol Protocol, number *big.Int) *monkvm.Rules {
+	if p, ok := protocolAt(protocol, number).(VmProtocol); ok {
+		if rules := p.VmRules(); rules != nil {
+			return rules
+		}
+	}
+	return monkvm.DefaultRules()
+}
+
+// The protocol active at a block number
+func (bc *ChainManager) ProtocolAt(number *big.Int) Protocol {
+	return protocolAt(bc.protocol, number)
+}
+
+// The vm rules active at a block number
+func (bc *ChainManager) VmRulesAt(number *big.Int) *monkvm.Rules {
+	return vmRulesAt(bc.protocol, number)
+}
@@ -0,0 +1,114 @@
+package monkchain
+
+import (
+	"math/big"
+
+	"github.com/eris-ltd/thelonious/monkstate"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+/*
+   A chain's gas economics (not the vm's gas costs). Txs must
+   pay at least the min gas price (0 for free gas). Blocks have
+   a fixed gas limit unless a Divisor is set, in which case each
+   block's limit moves 1/Divisor of the way from its parent's
+   toward Factor times the gas its parent used, but never below
+   Min (ethereum uses 1024, 6/5 and 125000). The first block
+   after genesis always gets the initial limit.
+
+   Without an initial limit a chain keeps the limits it had
+   before gas rules: every header says DefaultGasLimit, which
+   isn't checked, and blocks have LegacyGasPool to spend
+*/
+
+type GasRules struct {
+	// Min gas price for txs
+	MinGasPrice *big.Int `json:"min-gas-price"`
+	// Gas limit of the first block, or of every block if
+	// fixed. If not set (or 0) the limits aren't enforced
+	InitialLimit *big.Int `json:"initial-limit"`
+	// Adjustment of the limit (fixed if Divisor is 0)
+	Divisor   int64    `json:"divisor"`
+	FactorNum int64    `json:"factor-num"`
+	FactorDen int64    `json:"factor-den"`
+	Min       *big.Int `json:"min"`
+}
+
+// The gas limit in headers without an initial limit
+var DefaultGasLimit = monkutil.BigPow(10, 50)
+
+// The gas blocks may use without an initial limit
+var LegacyGasPool = monkutil.BigPow(10, 23)
+
+var DefaultGasRules = &GasRules{
+	MinGasPrice: new(big.Int),
+}
+
+// Optional interface for protocols with their own gas economics
+type GasProtocol interface {
+	// Gas rules for blocks on the state (nil for defaults)
+	GasRules(state *monkstate.State) *GasRules
+}
+
+// Gas rules of the protocol at the block number,
+// given the state it starts from, or the defaults
+func (bc *ChainManager) GasRules(number *big.Int, state *monkstate.State) *GasRules {
+	if p, ok := bc.ProtocolAt(number).(GasProtocol); ok {
+		if rules := p.GasRules(state); rules != nil {
+			return rules
+		}
+	}
+	return DefaultGasRules
+}
+
+// The gas limit of a block on the parent
+func (bc *ChainManager) GasLimit(parent *Block) *big.Int {
+	number := new(big.Int).Add(parent.Number, monkutil.Big1)
+	return bc.GasRules(number, parent.State()).GasLimit(parent)
+}
+
+// The gas a block on the parent may use
+func (bc *ChainManager) GasPool(parent *Block) *big.Int {
+	number := new(big.Int).Add(parent.Number, monkutil.Big1)
+	return bc.GasRules(number, parent.State()).GasPool(parent)
+}
+
+func (self *GasRules) MinPrice() *big.Int {
+	if self.MinGasPrice == nil {
+		return new(big.Int)
+	}
+	return self.MinGasPrice
+}
+
+// Whether blocks must have the gas limit of the rules
+func (self *GasRules) Limited() bool {
+	return self.InitialLimit != nil && self.InitialLimit.Sign() > 0
+}
+
+// The gas limit of a block on the parent
+func (self *GasRules) GasLimit(parent *Block) *big.Int {
+	if !self.Limited() {
+		return new(big.Int).Set(DefaultGasLimit)
+	}
+	if self.Divisor <= 0 || parent.Number.Sign() == 0 {
+		return new(big.Int).Set(self.InitialLimit)
+	}
+
+	// ((Divisor-1) * parent.GasLimit + parent.GasUsed * Factor) / Divisor
+	limit := new(big.Int).Mul(big.NewInt(self.Divisor-1), parent.GasLimit)
+	limit.Add(limit, fraction(parent.GasUsed, self.FactorNum, self.FactorDen))
+	limit.Div(limit, big.NewInt(self.Divisor))
+
+	if self.Min != nil {
+		return monkutil.BigMax(self.Min, limit)
+	}
+	return limit
+}
+
+// The gas a block on the parent may use
+func (self *GasRules) GasPool(parent *Block) *big.Int {
+	if !self.Limited() {
+		return new(big.Int).Set(LegacyGasPool)
+	}
+	return self.GasLimit(parent)
+}
@@ -0,0 +1,37 @@
+package monkchain
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestGasLimit(t *testing.T) {
+	parent := CreateBlock(nil, ZeroHash256, nil, big.NewInt(1), nil, "")
+	parent.Number = big.NewInt(0)
+	if limit := DefaultGasRules.GasLimit(parent); limit.Cmp(DefaultGasLimit) != 0 {
+		t.Errorf("Expected the default limit, got %v", limit)
+	}
+	// chains from before the gas rules spend what CalcGasLimit gave
+	if pool := DefaultGasRules.GasPool(parent); pool.Cmp(LegacyGasPool) != 0 {
+		t.Errorf("Expected the legacy gas pool, got %v", pool)
+	}
+
+	rules := &GasRules{InitialLimit: big.NewInt(1000000), Divisor: 1024, FactorNum: 6, FactorDen: 5, Min: big.NewInt(125000)}
+	if limit := rules.GasLimit(parent); limit.Int64() != 1000000 {
+		t.Errorf("Expected the initial limit after genesis, got %v", limit)
+	}
+	if pool := rules.GasPool(parent); pool.Int64() != 1000000 {
+		t.Errorf("Expected the pool to be the limit, got %v", pool)
+	}
+
+	parent.Number = big.NewInt(1)
+	parent.GasLimit, parent.GasUsed = big.NewInt(1000000), big.NewInt(1000000)
+	// (1023*1000000 + 1200000) / 1024
+	if limit := rules.GasLimit(parent); limit.Int64() != 1000195 {
+		t.Errorf("Expected the limit to grow to 1000195, got %v", limit)
+	}
+	parent.GasLimit, parent.GasUsed = big.NewInt(125000), big.NewInt(0)
+	if limit := rules.GasLimit(parent); limit.Int64() != 125000 {
+		t.Errorf("Expected the limit to stop at the min, got %v", limit)
+	}
+}
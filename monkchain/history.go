@@ -0,0 +1,181 @@
+package monkchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+
+	"github.com/eris-ltd/thelonious/monkstate"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+/*
+   An optional index from addresses to the transactions that touch
+   them, as the sender or recipient of the transaction itself or of
+   any message it makes along the way (ie. contract calls, from the
+   state manifest). A block's references are saved when it's processed
+   and added to the index when it becomes canonical. On re-orgs, the
+   old chain's references are popped off the end of each address's list.
+   Only blocks processed while the index is on are indexed
+*/
+
+// A transaction touching an address
+type AddressTx struct {
+	TxHash    []byte
+	BlockHash []byte
+	Number    uint64
+	// of the tx in its block
+	Index uint64
+	// the address only got (or sent) an internal message
+	Internal bool
+}
+
+func (self *AddressTx) RlpData() interface{} {
+	var internal uint64
+	if self.Internal {
+		internal = 1
+	}
+	return []interface{}{self.TxHash, self.BlockHash, self.Number, self.Index, internal}
+}
+
+func NewAddressTxFromValue(val *monkutil.Value) *AddressTx {
+	return &AddressTx{
+		TxHash:    val.Get(0).Bytes(),
+		BlockHash: val.Get(1).Bytes(),
+		Number:    val.Get(2).Uint(),
+		Index:     val.Get(3).Uint(),
+		Internal:  val.Get(4).Uint() != 0,
+	}
+}
+
+// Turn the address index on or off
+func (bc *ChainManager) SetAddressIndex(on bool) {
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+	bc.addressIndex = on
+}
+
+func (bc *ChainManager) AddressIndex() bool {
+	bc.mut.Lock()
+	defer bc.mut.Unlock()
+	return bc.addressIndex
+}
+
+func addressRefsKey(blockHash []byte) []byte {
+	return append(monkutil.CopyBytes(blockHash), []byte("AddrRefs")...)
+}
+
+func addressCountKey(addr []byte) []byte {
+	return append(monkutil.CopyBytes(addr), []byte("TxCount")...)
+}
+
+func addressTxKey(addr []byte, n uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n)
+	return append(append(monkutil.CopyBytes(addr), []byte("Tx")...), key...)
+}
+
+// Save the addresses touched by each of block's txs, from the
+// messages made processing it. Messages are tagged with their
+// tx, so those from other blocks or system calls are skipped
+func (bc *ChainManager) saveAddressRefs(block *Block, messages monkstate.Messages) {
+	index := make(map[string]uint64)
+	for i, tx := range block.Transactions() {
+		index[string(tx.Hash())] = uint64(i)
+	}
+
+	var refs []interface{}
+	seen := make(map[string]bool)
+	first := make(map[string]bool)
+	for _, msg := range messages {
+		i, ok := index[string(msg.Tx)]
+		if !ok {
+			continue
+		}
+		// the first message of a tx is the tx itself
+		var internal uint64
+		if first[string(msg.Tx)] {
+			internal = 1
+		}
+		first[string(msg.Tx)] = true
+
+		for _, addr := range [][]byte{msg.From, msg.To} {
+			if len(addr) == 0 || seen[string(msg.Tx)+string(addr)] {
+				continue
+			}
+			seen[string(msg.Tx)+string(addr)] = true
+			refs = append(refs, []interface{}{addr, msg.Tx, i, internal})
+		}
+	}
+	bc.db.Put(addressRefsKey(block.Hash()), monkutil.Encode(refs))
+}
+
+func (bc *ChainManager) addressRefs(block *Block) (addrs [][]byte, txs []*AddressTx) {
+	data, _ := bc.db.Get(addressRefsKey(block.Hash()))
+	if len(data) == 0 {
+		return
+	}
+	val := monkutil.NewValueFromBytes(data)
+	for i := 0; i < val.Len(); i++ {
+		ref := val.Get(i)
+		addrs = append(addrs, ref.Get(0).Bytes())
+		txs = append(txs, &AddressTx{
+			TxHash:    ref.Get(1).Bytes(),
+			BlockHash: block.Hash(),
+			Number:    block.Number.Uint64(),
+			Index:     ref.Get(2).Uint(),
+			Internal:  ref.Get(3).Uint() != 0,
+		})
+	}
+	return
+}
+
+func (bc *ChainManager) addressTxCount(addr []byte) uint64 {
+	data, _ := bc.db.Get(addressCountKey(addr))
+	return monkutil.BigD(data).Uint64()
+}
+
+// Append a canonical block's txs to the lists of the addresses they touch
+// not thread safe (caller should lock)
+func (bc *ChainManager) indexAddresses(block *Block) {
+	addrs, txs := bc.addressRefs(block)
+	for i, addr := range addrs {
+		n := bc.addressTxCount(addr)
+		bc.db.Put(addressTxKey(addr, n), monkutil.Encode(txs[i].RlpData()))
+		bc.db.Put(addressCountKey(addr), new(big.Int).SetUint64(n+1).Bytes())
+	}
+}
+
+// Pop a block's txs off the end of the address lists,
+// in the reverse of the order they were added
+func (bc *ChainManager) unindexAddresses(block *Block) {
+	addrs, _ := bc.addressRefs(block)
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr := addrs[i]
+		n := bc.addressTxCount(addr)
+		if n == 0 {
+			continue
+		}
+		data, _ := bc.db.Get(addressTxKey(addr, n-1))
+		if !bytes.Equal(NewAddressTxFromValue(monkutil.NewValueFromBytes(data)).BlockHash, block.Hash()) {
+			continue
+		}
+		bc.db.Delete(addressTxKey(addr, n-1))
+		bc.db.Put(addressCountKey(addr), new(big.Int).SetUint64(n-1).Bytes())
+	}
+}
+
+// A page of the txs touching an address, newest first,
+// and the total number of them
+func (bc *ChainManager) AddressTxs(addr []byte, offset, limit uint64) ([]*AddressTx, uint64) {
+	total := bc.addressTxCount(addr)
+	var txs []*AddressTx
+	for i := offset; i < total && uint64(len(txs)) < limit; i++ {
+		data, _ := bc.db.Get(addressTxKey(addr, total-1-i))
+		if len(data) == 0 {
+			break
+		}
+		txs = append(txs, NewAddressTxFromValue(monkutil.NewValueFromBytes(data)))
+	}
+	return txs, total
+}
@@ -0,0 +1,69 @@
+package monkchain
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkstate"
+)
+
+func TestAddressIndex(t *testing.T) {
+	key := monkcrypto.GenerateNewKeyPair()
+	to, contract := []byte("to"), []byte("contract")
+	tx := NewTransactionMessage(to, big.NewInt(1), big.NewInt(100), big.NewInt(1), nil)
+	tx.Sign(key.PrivateKey)
+
+	bc, blocks := canonicalChain(0)
+	bc.SetAddressIndex(true)
+	for i := 0; i < 3; i++ {
+		prevHash := ZeroHash256
+		if i > 0 {
+			prevHash = blocks[i-1].Hash()
+		}
+		block := CreateBlock(nil, prevHash, nil, big.NewInt(1), nil, "")
+		block.Number = big.NewInt(int64(i))
+		block.SetReceipts(Receipts{&Receipt{tx, nil, big.NewInt(100), nil}}, Transactions{tx})
+
+		// the tx, a call it makes, and a message from another block
+		bc.saveAddressRefs(block, monkstate.Messages{
+			&monkstate.Message{From: key.Address(), To: to, Tx: tx.Hash()},
+			&monkstate.Message{From: to, To: contract, Tx: tx.Hash()},
+			&monkstate.Message{From: contract, To: key.Address(), Tx: []byte("other")},
+		})
+		bc.add(block)
+		blocks = append(blocks, block)
+	}
+
+	txs, total := bc.AddressTxs(to, 0, 10)
+	if total != 3 || len(txs) != 3 {
+		t.Fatalf("Expected 3 txs to the recipient, got %d (%d total)", len(txs), total)
+	}
+	for i, atx := range txs {
+		if !bytes.Equal(atx.BlockHash, blocks[2-i].Hash()) || atx.Internal {
+			t.Errorf("Tx %d was in #%d (internal %v), expected #%d", i, atx.Number, atx.Internal, 2-i)
+		}
+	}
+	if txs, _ := bc.AddressTxs(contract, 0, 10); len(txs) != 3 || !txs[0].Internal {
+		t.Error("Expected internal txs to the contract")
+	}
+
+	txs, total = bc.AddressTxs(key.Address(), 1, 1)
+	if total != 3 || len(txs) != 1 || !bytes.Equal(txs[0].BlockHash, blocks[1].Hash()) {
+		t.Errorf("Expected the second page of the sender's txs to be #1, got %v (%d total)", txs, total)
+	}
+
+	// re-orged off canonical
+	bc.unindexAddresses(blocks[2])
+	bc.unindexAddresses(blocks[1])
+	txs, total = bc.AddressTxs(key.Address(), 0, 10)
+	if total != 1 || len(txs) != 1 || !bytes.Equal(txs[0].BlockHash, blocks[0].Hash()) {
+		t.Errorf("Expected only #0 left, got %v (%d total)", txs, total)
+	}
+	// already gone
+	bc.unindexAddresses(blocks[2])
+	if _, total := bc.AddressTxs(to, 0, 10); total != 1 {
+		t.Errorf("Expected 1 tx left to the recipient, got %d", total)
+	}
+}
@@ -0,0 +1,189 @@
+package monkchain
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+/*
+   The canonical chain is indexed by number, so heights resolve
+   without walking parents from the head, and by transaction hash,
+   so transactions and their receipts can be found without scanning
+   blocks. Entries are written as blocks are added to canonical,
+   rewritten on re-orgs, and repaired from the head on startup
+   (eg. for databases from before the index)
+*/
+
+func numberKey(num uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, num)
+	return append([]byte("Number"), key...)
+}
+
+// Point the index at block for its number
+// not thread safe (caller should lock)
+func (bc *ChainManager) writeNumberIndex(block *Block) {
+	bc.db.Put(numberKey(block.Number.Uint64()), block.Hash())
+}
+
+// Drop index entries from (inclusive) to to (inclusive),
+// eg. above the new head after a re-org onto a shorter chain
+func (bc *ChainManager) deleteNumberIndex(from, to uint64) {
+	for num := from; num <= to && num >= from; num++ {
+		bc.db.Delete(numberKey(num))
+	}
+}
+
+func txKey(hash []byte) []byte {
+	return append(monkutil.CopyBytes(hash), []byte("TxLookup")...)
+}
+
+// Point the index at block for each of its transactions
+func (bc *ChainManager) writeTxIndex(block *Block) {
+	for i, tx := range block.Transactions() {
+		bc.db.Put(txKey(tx.Hash()), monkutil.Encode([]interface{}{block.Hash(), uint64(i)}))
+	}
+}
+
+// Drop the entries for block's transactions, unless
+// they've since been indexed in another block
+func (bc *ChainManager) deleteTxIndex(block *Block) {
+	for _, tx := range block.Transactions() {
+		if blockHash, _ := bc.txLookup(tx.Hash()); bytes.Equal(blockHash, block.Hash()) {
+			bc.db.Delete(txKey(tx.Hash()))
+		}
+	}
+}
+
+func (bc *ChainManager) txLookup(hash []byte) (blockHash []byte, index uint64) {
+	data, _ := bc.db.Get(txKey(hash))
+	if len(data) == 0 {
+		return nil, 0
+	}
+	val := monkutil.NewValueFromBytes(data)
+	return val.Get(0).Bytes(), val.Get(1).Uint()
+}
+
+// Walk back from block, indexing canonical blocks until
+// we meet the index or run out of blocks (eg. at a checkpoint)
+func (bc *ChainManager) repairIndex(block *Block) {
+	n := 0
+	for ; block != nil; block = bc.GetBlockCanonical(block.PrevHash) {
+		hash, _ := bc.db.Get(numberKey(block.Number.Uint64()))
+		if bytes.Equal(hash, block.Hash()) {
+			break
+		}
+		bc.writeNumberIndex(block)
+		bc.writeTxIndex(block)
+		n++
+		if block.Number.Sign() == 0 {
+			break
+		}
+	}
+	if n > 0 {
+		chainlogger.Infof("Indexed %d canonical blocks\n", n)
+	}
+}
+
+// Hash of the canonical block with the given number (nil if none)
+func (bc *ChainManager) GetBlockHashByNumber(num uint64) []byte {
+	if num > bc.CurrentBlockNumber() {
+		return nil
+	}
+	hash, _ := bc.db.Get(numberKey(num))
+	if len(hash) == 0 {
+		return nil
+	}
+	return hash
+}
+
+func (bc *ChainManager) GetBlockByNumber(num uint64) *Block {
+	hash := bc.GetBlockHashByNumber(num)
+	if hash == nil {
+		return nil
+	}
+	return bc.GetBlockCanonical(hash)
+}
+
+// The canonical block num blocks behind the head
+func (bc *ChainManager) GetBlockBack(num uint64) *Block {
+	head := bc.CurrentBlockNumber()
+	if num > head {
+		return nil
+	}
+	return bc.GetBlockByNumber(head - num)
+}
+
+// Call fn on each canonical block from from to to (inclusive),
+// until it returns false. If from is above to, we iterate in
+// reverse. The range is cut off at the head, and we stop at
+// the first block we don't have (eg. before a checkpoint)
+func (bc *ChainManager) IterateBlocks(from, to uint64, fn func(block *Block) bool) {
+	head := bc.CurrentBlockNumber()
+	if from > head {
+		from = head
+	}
+	if to > head {
+		to = head
+	}
+
+	step := uint64(1)
+	if from > to {
+		step = ^uint64(0) // -1
+	}
+	for num := from; ; num += step {
+		block := bc.GetBlockByNumber(num)
+		if block == nil || !fn(block) || num == to {
+			return
+		}
+	}
+}
+
+// Canonical blocks from from to to (inclusive).
+// Newest first if from is above to
+func (bc *ChainManager) BlocksInRange(from, to uint64) Blocks {
+	var blocks Blocks
+	bc.IterateBlocks(from, to, func(block *Block) bool {
+		blocks = append(blocks, block)
+		return true
+	})
+	return blocks
+}
+
+// The canonical block containing a transaction,
+// and the transaction's index in it (nil if none)
+func (bc *ChainManager) GetTransactionBlock(hash []byte) (*Block, uint64) {
+	blockHash, index := bc.txLookup(hash)
+	if blockHash == nil {
+		return nil, 0
+	}
+	block := bc.GetBlockCanonical(blockHash)
+	// blocks re-orged off canonical stay in the db
+	if block == nil || !bytes.Equal(bc.GetBlockHashByNumber(block.Number.Uint64()), blockHash) {
+		return nil, 0
+	}
+	if index >= uint64(len(block.Receipts())) {
+		return nil, 0
+	}
+	return block, index
+}
+
+// A transaction on the canonical chain (nil if none)
+func (bc *ChainManager) GetTransaction(hash []byte) *Transaction {
+	block, index := bc.GetTransactionBlock(hash)
+	if block == nil {
+		return nil
+	}
+	return block.Receipts()[index].Tx
+}
+
+// The receipt of a transaction on the canonical chain (nil if none)
+func (bc *ChainManager) GetReceipt(hash []byte) *Receipt {
+	block, index := bc.GetTransactionBlock(hash)
+	if block == nil {
+		return nil
+	}
+	return block.Receipts()[index]
+}
@@ -0,0 +1,153 @@
+package monkchain
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkdb"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+// A bare chain manager on a fresh db, which
+// is also the config's db
+func newTestChain() *ChainManager {
+	db, _ := monkdb.NewMemDatabase()
+	if monkutil.Config == nil {
+		monkutil.Config = &monkutil.ConfigManager{}
+	}
+	monkutil.Config.Db = db
+	return &ChainManager{db: db, workingTree: make(map[string]*link), TD: new(big.Int), timeOffsets: NewTimeOffsets()}
+}
+
+// The block after parent (or the first, if it's nil) with the txs
+func nextBlock(parent *Block, txs ...*Transaction) *Block {
+	prevHash, number := ZeroHash256, new(big.Int)
+	if parent != nil {
+		prevHash, number = parent.Hash(), new(big.Int).Add(parent.Number, big.NewInt(1))
+	}
+	block := CreateBlock(nil, prevHash, nil, big.NewInt(1), nil, "")
+	block.Number = number
+	var receipts Receipts
+	for j, tx := range txs {
+		receipts = append(receipts, &Receipt{tx, nil, big.NewInt(int64(j+1) * 100), nil})
+	}
+	block.SetReceipts(receipts, txs)
+	return block
+}
+
+// A chain manager with n blocks on canonical,
+// each with the given txs
+func canonicalChain(n int, txs ...*Transaction) (*ChainManager, Blocks) {
+	bc := newTestChain()
+	var blocks Blocks
+	var parent *Block
+	for i := 0; i < n; i++ {
+		parent = nextBlock(parent, txs...)
+		bc.add(parent)
+		blocks = append(blocks, parent)
+	}
+	return bc, blocks
+}
+
+func checkBlocks(t *testing.T, got Blocks, want ...*Block) {
+	if len(got) != len(want) {
+		t.Errorf("Got %d blocks, expected %d", len(got), len(want))
+		return
+	}
+	for i := range got {
+		if !bytes.Equal(got[i].Hash(), want[i].Hash()) {
+			t.Errorf("Block %d was #%v, expected #%v", i, got[i].Number, want[i].Number)
+		}
+	}
+}
+
+func TestNumberIndex(t *testing.T) {
+	bc, blocks := canonicalChain(5)
+
+	for i, block := range blocks {
+		if b := bc.GetBlockByNumber(uint64(i)); b == nil || !bytes.Equal(b.Hash(), block.Hash()) {
+			t.Errorf("Block #%d not found by number", i)
+		}
+	}
+	if b := bc.GetBlockByNumber(5); b != nil {
+		t.Error("Found a block above the head")
+	}
+	if b := bc.GetBlockBack(1); b == nil || !bytes.Equal(b.Hash(), blocks[3].Hash()) {
+		t.Error("Expected the block behind the head to be #3")
+	}
+	if b := bc.GetBlockBack(5); b != nil {
+		t.Error("Found a block behind genesis")
+	}
+
+	checkBlocks(t, bc.BlocksInRange(1, 3), blocks[1], blocks[2], blocks[3])
+	checkBlocks(t, bc.BlocksInRange(3, 1), blocks[3], blocks[2], blocks[1])
+	checkBlocks(t, bc.BlocksInRange(3, 100), blocks[3], blocks[4])
+	checkBlocks(t, bc.BlocksInRange(100, 3), blocks[4], blocks[3])
+	checkBlocks(t, bc.BlocksInRange(2, 0), blocks[2], blocks[1], blocks[0])
+
+	var n int
+	bc.IterateBlocks(0, 4, func(block *Block) bool {
+		n++
+		return block.Number.Uint64() < 1
+	})
+	if n != 2 {
+		t.Errorf("Iteration went on for %d blocks after being stopped at #1", n)
+	}
+}
+
+func TestNumberIndexRepair(t *testing.T) {
+	bc, blocks := canonicalChain(5)
+
+	// as if from a database before the index
+	bc.deleteNumberIndex(0, 4)
+	if b := bc.GetBlockByNumber(2); b != nil {
+		t.Fatal("Index entries were not deleted")
+	}
+	bc.repairIndex(bc.CurrentBlock())
+	checkBlocks(t, bc.BlocksInRange(0, 4), blocks...)
+}
+
+func TestTxIndex(t *testing.T) {
+	key := monkcrypto.GenerateNewKeyPair()
+	var txs []*Transaction
+	for i := 0; i < 2; i++ {
+		tx := NewTransactionMessage(key.Address(), big.NewInt(int64(i)), big.NewInt(100), big.NewInt(1), nil)
+		tx.Sign(key.PrivateKey)
+		txs = append(txs, tx)
+	}
+	bc, blocks := canonicalChain(2, txs...)
+
+	// the same txs are in both blocks, so the last one wins
+	block, index := bc.GetTransactionBlock(txs[1].Hash())
+	if block == nil || !bytes.Equal(block.Hash(), blocks[1].Hash()) || index != 1 {
+		t.Fatalf("Tx not found at index 1 of block #1 (got %v, %d)", block, index)
+	}
+	if tx := bc.GetTransaction(txs[0].Hash()); tx == nil || !bytes.Equal(tx.Hash(), txs[0].Hash()) {
+		t.Error("Tx not found by hash")
+	}
+	if r := bc.GetReceipt(txs[1].Hash()); r == nil || r.CumulativeGasUsed.Int64() != 200 {
+		t.Error("Receipt not found by hash", r)
+	}
+	if tx := bc.GetTransaction([]byte("nothing")); tx != nil {
+		t.Error("Found a tx that doesn't exist")
+	}
+
+	// off canonical, the txs are gone
+	bc.deleteNumberIndex(1, 1)
+	if tx := bc.GetTransaction(txs[0].Hash()); tx != nil {
+		t.Error("Found a tx in a block off canonical")
+	}
+	bc.writeNumberIndex(blocks[1])
+
+	// only entries pointing at the block are dropped
+	bc.deleteTxIndex(blocks[0])
+	if tx := bc.GetTransaction(txs[0].Hash()); tx == nil {
+		t.Error("Tx indexed in another block was dropped")
+	}
+	bc.deleteTxIndex(blocks[1])
+	if tx := bc.GetTransaction(txs[0].Hash()); tx != nil {
+		t.Error("Tx index was not dropped")
+	}
+}
@@ -0,0 +1,262 @@
+package monkchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkreact"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+/*
+   Registry of block proofs of work by name. The genesis.json
+   picks one ("pow") and may give it options ("pow-options"),
+   which the constructor unmarshals. Blocks are mined and verified
+   with the same algorithm. Builtins are "easy" (the default),
+   "dagger", and "none" for chains that rely on authority alone
+*/
+
+// Construct a proof of work from its json options (may be empty)
+type PoWConstructor func(options json.RawMessage) (PoW, error)
+
+var (
+	powsMut sync.Mutex
+	pows    = make(map[string]PoWConstructor)
+)
+
+// Register a named proof of work.
+// Names must be unique
+func RegisterPoW(name string, constructor PoWConstructor) error {
+	powsMut.Lock()
+	defer powsMut.Unlock()
+	if constructor == nil {
+		return fmt.Errorf("Nil constructor for pow %s", name)
+	}
+	if _, ok := pows[name]; ok {
+		return fmt.Errorf("PoW %s already registered", name)
+	}
+	pows[name] = constructor
+	return nil
+}
+
+// A new instance of a named proof of work
+func NewPoW(name string, options json.RawMessage) (PoW, error) {
+	powsMut.Lock()
+	constructor, ok := pows[name]
+	powsMut.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown pow %s", name)
+	}
+	return constructor(options)
+}
+
+// Names of all registered proofs of work, sorted
+func RegisteredPoWs() []string {
+	powsMut.Lock()
+	defer powsMut.Unlock()
+	names := []string{}
+	for name := range pows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Optional interface for protocols picking their proof of work
+type PoWProtocol interface {
+	// A new instance of the chain's proof of work
+	NewPoW() PoW
+}
+
+// A new instance of the chain's proof of work (EasyPow by default).
+// Miners and validators should each have their own
+func (bc *ChainManager) NewPoW() PoW {
+	if p, ok := bc.protocol.(PoWProtocol); ok {
+		return p.NewPoW()
+	}
+	return &EasyPow{}
+}
+
+// A worker's share of the nonces to search:
+// Start+Worker, Start+Worker+Workers, ...
+type NonceRange struct {
+	Start           uint64
+	Worker, Workers uint64
+}
+
+// Optional interface for proofs of work that can split a search
+// across workers. A worker tries its range until it finds a nonce
+// or stop is closed, adding one to hashes for every try
+type ParallelPoW interface {
+	SearchRange(block *Block, r NonceRange, stop chan struct{}, hashes *int64) []byte
+}
+
+func (pow *EasyPow) SearchRange(block *Block, r NonceRange, stop chan struct{}, hashes *int64) []byte {
+	hash := block.HashNoNonce()
+	for n := r.Start + r.Worker; ; n += r.Workers {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		atomic.AddInt64(hashes, 1)
+
+		sha := monkcrypto.Sha3Bin(new(big.Int).SetUint64(n).Bytes())
+		if pow.Verify(hash, block.Difficulty, sha) {
+			return sha
+		}
+
+		if atomic.LoadInt32(&pow.turbo) == 0 {
+			time.Sleep(20 * time.Microsecond)
+		}
+	}
+}
+
+// No work at all. Any nonce is valid
+type NoPow struct{}
+
+func (pow *NoPow) Search(block *Block, reactChan chan monkreact.Event) []byte {
+	return make([]byte, 32)
+}
+
+func (pow *NoPow) Verify(hash []byte, diff *big.Int, nonce []byte) bool {
+	return true
+}
+
+func (pow *NoPow) SearchRange(block *Block, r NonceRange, stop chan struct{}, hashes *int64) []byte {
+	return make([]byte, 32)
+}
+
+func (pow *NoPow) GetHashrate() int64 {
+	return 0
+}
+
+func (pow *NoPow) Turbo(on bool) {
+}
+
+// Dagger as a block proof of work. The nonce
+// is the big endian bytes of the dagger's nonce
+type DaggerPow struct {
+	// Depth of the dag, and parents of a node at the top
+	Levels uint64 `json:"levels"`
+	Width  int    `json:"width"`
+
+	HashRate int64 `json:"-"`
+	// set while workers are searching, so atomic
+	turbo int32
+}
+
+func (pow *DaggerPow) dagger(hash []byte) *Dagger {
+	return &Dagger{hash: new(big.Int).SetBytes(hash), levels: pow.Levels, width: pow.Width}
+}
+
+func (pow *DaggerPow) GetHashrate() int64 {
+	return pow.HashRate
+}
+
+func (pow *DaggerPow) Turbo(on bool) {
+	var turbo int32
+	if on {
+		turbo = 1
+	}
+	atomic.StoreInt32(&pow.turbo, turbo)
+}
+
+func (pow *DaggerPow) Search(block *Block, reactChan chan monkreact.Event) []byte {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	dag := pow.dagger(block.HashNoNonce())
+	obj := new(big.Int).Div(monkutil.BigPow(2, 256), block.Difficulty)
+	i := int64(0)
+	start := time.Now()
+	t := time.Now()
+
+	for {
+		select {
+		case <-reactChan:
+			powlogger.Infoln("Breaking from mining")
+			return nil
+		default:
+			i++
+
+			if time.Since(t) > (1 * time.Second) {
+				pow.HashRate = int64(float64(i) / time.Since(start).Seconds())
+				powlogger.Infoln("Hashing @", pow.HashRate, "hash")
+
+				t = time.Now()
+			}
+
+			nonce := big.NewInt(r.Int63())
+			if dag.Eval(nonce).Cmp(obj) < 0 {
+				return nonce.Bytes()
+			}
+		}
+
+		if atomic.LoadInt32(&pow.turbo) == 0 {
+			time.Sleep(20 * time.Microsecond)
+		}
+	}
+}
+
+func (pow *DaggerPow) SearchRange(block *Block, r NonceRange, stop chan struct{}, hashes *int64) []byte {
+	dag := pow.dagger(block.HashNoNonce())
+	obj := new(big.Int).Div(monkutil.BigPow(2, 256), block.Difficulty)
+	for n := r.Start + r.Worker; ; n += r.Workers {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		atomic.AddInt64(hashes, 1)
+
+		// dagger nonces are positive int64s
+		nonce := new(big.Int).SetUint64(n & math.MaxInt64)
+		if dag.Eval(nonce).Cmp(obj) < 0 {
+			return nonce.Bytes()
+		}
+
+		if atomic.LoadInt32(&pow.turbo) == 0 {
+			time.Sleep(20 * time.Microsecond)
+		}
+	}
+}
+
+func (pow *DaggerPow) Verify(hash []byte, diff *big.Int, nonce []byte) bool {
+	obj := new(big.Int).Div(monkutil.BigPow(2, 256), diff)
+	return pow.dagger(hash).Eval(new(big.Int).SetBytes(nonce)).Cmp(obj) < 0
+}
+
+func mustRegisterPoW(name string, constructor PoWConstructor) {
+	if err := RegisterPoW(name, constructor); err != nil {
+		panic(err)
+	}
+}
+
+// The builtin proofs of work
+func init() {
+	mustRegisterPoW("easy", func(options json.RawMessage) (PoW, error) {
+		return &EasyPow{}, nil
+	})
+	mustRegisterPoW("dagger", func(options json.RawMessage) (PoW, error) {
+		pow := &DaggerPow{}
+		if len(options) > 0 {
+			if err := json.Unmarshal(options, pow); err != nil {
+				return nil, err
+			}
+		}
+		if pow.Levels > 21 {
+			return nil, fmt.Errorf("Dagger levels must be at most 21, got %d", pow.Levels)
+		}
+		return pow, nil
+	})
+	mustRegisterPoW("none", func(options json.RawMessage) (PoW, error) {
+		return &NoPow{}, nil
+	})
+}
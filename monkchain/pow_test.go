@@ -0,0 +1,47 @@
+package monkchain
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkreact"
+)
+
+func TestPoWRegistry(t *testing.T) {
+	for _, name := range []string{"easy", "dagger", "none"} {
+		if _, err := NewPoW(name, nil); err != nil {
+			t.Errorf("Builtin pow %s: %v", name, err)
+		}
+	}
+	if _, err := NewPoW("nothing", nil); err == nil {
+		t.Error("Expected an error for an unknown pow")
+	}
+	if err := RegisterPoW("easy", func(json.RawMessage) (PoW, error) { return &NoPow{}, nil }); err == nil {
+		t.Error("Expected an error registering a pow twice")
+	}
+	if _, err := NewPoW("dagger", json.RawMessage(`{"levels":40}`)); err == nil {
+		t.Error("Expected an error for a dagger too deep")
+	}
+}
+
+func TestDaggerPow(t *testing.T) {
+	_, blocks := canonicalChain(1)
+	block := blocks[0]
+	block.Difficulty = big.NewInt(4)
+
+	pow, err := NewPoW("dagger", json.RawMessage(`{"levels":2,"width":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pow.Turbo(true)
+	nonce := pow.Search(block, make(chan monkreact.Event))
+	if !pow.Verify(block.HashNoNonce(), block.Difficulty, nonce) {
+		t.Fatalf("Found nonce %x doesn't verify", nonce)
+	}
+
+	// the same nonce is no good for a much harder block
+	if pow.Verify(block.HashNoNonce(), new(big.Int).Lsh(big.NewInt(1), 255), nonce) {
+		t.Error("Nonce verified at an impossible difficulty")
+	}
+}
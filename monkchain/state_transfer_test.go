@@ -12,7 +12,7 @@ import (
 type fDoug struct{}
 
 // Populate the state
-func (d *fDoug) Deploy(block *Block) []byte {
+func (d *fDoug) Deploy(block *Block) ([]byte, error) {
 	for _, acct := range [][]string{
 		[]string{"abc123", "9876"},
 		[]string{"321cba", "1234"},
@@ -23,7 +23,7 @@ func (d *fDoug) Deploy(block *Block) []byte {
 	}
 	block.State().Update()
 	block.State().Sync()
-	return nil
+	return nil, nil
 }
 
 func (d *fDoug) Doug() []byte { return nil }
@@ -36,6 +36,8 @@ type StateTransition struct {
 	state              *monkstate.State
 	block              *Block
 	genesis            *Block
+	// permissions and vm rules (nil for none)
+	protocol Protocol
 
 	msg *monkstate.Message
 
@@ -43,11 +45,19 @@ type StateTransition struct {
 }
 
 func NewStateTransition(coinbase *monkstate.StateObject, tx *Transaction, state *monkstate.State, block *Block) *StateTransition {
-	return &StateTransition{coinbase.Address(), tx.Recipient, tx, new(big.Int), new(big.Int).Set(tx.GasPrice), tx.Value, tx.Data, state, block, nil, nil, coinbase, nil, nil}
+	return &StateTransition{coinbase.Address(), tx.Recipient, tx, new(big.Int), new(big.Int).Set(tx.GasPrice), tx.Value, tx.Data, state, block, nil, nil, nil, coinbase, nil, nil}
 }
 
-func NewStateTransitionEris(coinbase *monkstate.StateObject, tx *Transaction, state *monkstate.State, block *Block, gen *Block) *StateTransition {
-	return &StateTransition{coinbase.Address(), tx.Recipient, tx, new(big.Int), new(big.Int).Set(tx.GasPrice), tx.Value, tx.Data, state, block, gen, nil, coinbase, nil, nil}
+func NewStateTransitionEris(coinbase *monkstate.StateObject, tx *Transaction, state *monkstate.State, block *Block, gen *Block, protocol Protocol) *StateTransition {
+	return &StateTransition{coinbase.Address(), tx.Recipient, tx, new(big.Int), new(big.Int).Set(tx.GasPrice), tx.Value, tx.Data, state, block, gen, protocol, nil, coinbase, nil, nil}
+}
+
+// Number of the block the tx is in (nil if not in a block)
+func (self *StateTransition) blockNumber() *big.Int {
+	if self.block == nil {
+		return nil
+	}
+	return self.block.Number
 }
 
 func (self *StateTransition) Coinbase() *monkstate.StateObject {
@@ -132,7 +142,7 @@ func (self *StateTransition) RefundGas() {
 func (self *StateTransition) preCheck() (err error) {
 	// preCheck() should be a proxy for calling a doug permissions model
 	// the permissions model will check all the things
-	if err := genDoug.ValidateTx(self.tx, self.state); err != nil {
+	if err := protocolAt(self.protocol, self.blockNumber()).ValidateTx(self.tx, self.state); err != nil {
 		return err
 	}
 	// Pre-pay gas / Buy gas off the coinbase account
@@ -174,14 +184,16 @@ func (self *StateTransition) TransitionState() (err error) {
 	// Increment the nonce for the next transaction
 	sender.Nonce += 1
 
+	gasRules := vmRulesAt(self.protocol, self.blockNumber()).Gas
+
 	// Transaction gas
-	if err = self.UseGas(monkvm.GasTx); err != nil {
+	if err = self.UseGas(gasRules.Tx); err != nil {
 		return
 	}
 
 	// Pay data gas
 	dataPrice := big.NewInt(int64(len(self.data)))
-	dataPrice.Mul(dataPrice, monkvm.GasData)
+	dataPrice.Mul(dataPrice, gasRules.Data)
 	if err = self.UseGas(dataPrice); err != nil {
 		return
 	}
@@ -279,11 +291,12 @@ func (self *StateTransition) Eval(msg *monkstate.Message, script []byte, context
 	var (
 		transactor    = self.Sender()
 		state         = self.state
-		env           = NewEnv(state, self.tx, self.block)
+		env           = NewEnv(state, self.tx, self.block, self.protocol)
 		callerClosure = monkvm.NewClosure(msg, transactor, context, script, self.gas, self.gasPrice)
 	)
 
 	vm := monkvm.New(env)
+	vm.Rules = vmRulesAt(self.protocol, self.blockNumber())
 	vm.Verbose = true
 	vm.Fn = typ
 
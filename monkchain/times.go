@@ -0,0 +1,155 @@
+package monkchain
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+/*
+   Block timestamps are judged against network time: our own
+   clock adjusted by the median offset reported by our peers.
+   A block may be a little ahead of network time, and blocks
+   a little further ahead are held until their time comes.
+   Blocks may not be older than the median time of the blocks
+   before them, so no single miner can drag the chain's time
+   back (or forward) to game difficulty or turn taking.
+*/
+
+// Rules for block timestamps. Times are in seconds
+type TimeRules struct {
+	// How far ahead of network time a block may be
+	MaxDrift int64 `json:"max-drift"`
+	// How far ahead of network time a block may be and
+	// still be held (rather than rejected) until it's valid
+	Hold int64 `json:"hold"`
+	// Number of blocks (ending at the parent) whose median time
+	// a block may not be older than (1 for just the parent)
+	MedianSpan int `json:"median-span"`
+	// Largest peer offset we'll adjust network time by
+	MaxOffset int64 `json:"max-offset"`
+}
+
+var DefaultTimeRules = &TimeRules{
+	MaxDrift:   15,
+	Hold:       60,
+	MedianSpan: 11,
+	MaxOffset:  70 * 60,
+}
+
+// Optional interface for protocols with their own time rules
+type TimeProtocol interface {
+	TimeRules() *TimeRules
+}
+
+// Time rules of the protocol, or the defaults
+func (bc *ChainManager) TimeRules() *TimeRules {
+	if p, ok := bc.protocol.(TimeProtocol); ok {
+		if rules := p.TimeRules(); rules != nil {
+			return rules
+		}
+	}
+	return DefaultTimeRules
+}
+
+// Median time of the span blocks ending at block
+func (bc *ChainManager) MedianTimePast(block *Block, span int) int64 {
+	times := make([]int64, 0, span)
+	for i := 0; i < span && block != nil; i++ {
+		times = append(times, block.Time)
+		if block.Number.Sign() == 0 {
+			break
+		}
+		block = bc.GetBlock(block.PrevHash)
+	}
+	if len(times) == 0 {
+		return 0
+	}
+	sort.Sort(int64s(times))
+	return times[len(times)/2]
+}
+
+// Our clock, adjusted by the median offset of our peers
+func (bc *ChainManager) NetworkTime() int64 {
+	return monkutil.Now().Unix() + bc.timeOffsets.Median(bc.TimeRules().MaxOffset)
+}
+
+// Record the offset of a peer's clock from ours
+func (bc *ChainManager) AddTimeOffset(peer string, offset int64) {
+	bc.timeOffsets.Add(peer, offset)
+}
+
+// Forget a peer's clock offset (eg. when it disconnects)
+func (bc *ChainManager) RemoveTimeOffset(peer string) {
+	bc.timeOffsets.Remove(peer)
+}
+
+// Check a block's time against the time rules.
+// Returns a FutureBlockErr if the block should be held
+func (bc *ChainManager) CheckBlockTime(prevBlock, block *Block) error {
+	rules := bc.TimeRules()
+
+	span := rules.MedianSpan
+	if span < 1 {
+		span = 1
+	}
+	if median := bc.MedianTimePast(prevBlock, span); block.Time < median {
+		return ValidationError("Block timestamp %v is before the median of the last %d blocks (%v)", block.Time, span, median)
+	}
+
+	now := bc.NetworkTime()
+	if ahead := block.Time - now; ahead > rules.MaxDrift {
+		if ahead > rules.Hold {
+			return ValidationError("Block timestamp %v is too far in the future (%ds > %ds)", block.Time, ahead, rules.Hold)
+		}
+		return FutureBlockError(block.Time - rules.MaxDrift - now)
+	}
+	return nil
+}
+
+// Clock offsets of our peers
+type TimeOffsets struct {
+	mut     sync.Mutex
+	offsets map[string]int64
+}
+
+func NewTimeOffsets() *TimeOffsets {
+	return &TimeOffsets{offsets: make(map[string]int64)}
+}
+
+func (self *TimeOffsets) Add(peer string, offset int64) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+	self.offsets[peer] = offset
+}
+
+func (self *TimeOffsets) Remove(peer string) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+	delete(self.offsets, peer)
+}
+
+// Median of the offsets (and our own, of 0).
+// If it's larger than max, our clock is either right
+// or too far off to fix, so we don't adjust at all
+func (self *TimeOffsets) Median(max int64) int64 {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+	offsets := int64s{0}
+	for _, o := range self.offsets {
+		offsets = append(offsets, o)
+	}
+	sort.Sort(offsets)
+	median := offsets[len(offsets)/2]
+	if median > max || median < -max {
+		return 0
+	}
+	return median
+}
+
+type int64s []int64
+
+func (s int64s) Len() int           { return len(s) }
+func (s int64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s int64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
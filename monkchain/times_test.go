@@ -0,0 +1,110 @@
+package monkchain
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time                         { return time.Time(c) }
+func (c fixedClock) Every(time.Duration, chan bool, func()) {}
+
+// A chain manager with a chain of blocks at the given times
+// (in the working tree), and the last of them
+func timedChain(times ...int64) (*ChainManager, *Block) {
+	bc := newTestChain()
+	var parent *Block
+	for _, t := range times {
+		block := nextBlock(parent)
+		block.Time = t
+		bc.workingTree[string(block.Hash())] = &link{block: block}
+		parent = block
+	}
+	return bc, parent
+}
+
+func TestMedianTimePast(t *testing.T) {
+	bc, parent := timedChain(100, 300, 200, 250, 150)
+	if m := bc.MedianTimePast(parent, 5); m != 200 {
+		t.Errorf("Median of 5 was %d, expected 200", m)
+	}
+	if m := bc.MedianTimePast(parent, 1); m != 150 {
+		t.Errorf("Median of 1 was %d, expected the parent's time 150", m)
+	}
+	// past genesis, we take what we have
+	if m := bc.MedianTimePast(parent, 11); m != 200 {
+		t.Errorf("Median of 11 was %d, expected 200", m)
+	}
+}
+
+func TestCheckBlockTime(t *testing.T) {
+	now := int64(1000000)
+	monkutil.SetClock(fixedClock(time.Unix(now, 0)))
+	defer monkutil.SetClock(nil)
+
+	bc, parent := timedChain(now-50, now-40, now-30)
+	rules := DefaultTimeRules
+	block := CreateBlock(nil, parent.Hash(), nil, big.NewInt(1), nil, "")
+	block.Number = big.NewInt(3)
+
+	check := func(blockTime int64) error {
+		block.Time = blockTime
+		return bc.CheckBlockTime(parent, block)
+	}
+
+	if err := check(now); err != nil {
+		t.Error(err)
+	}
+	// before the parent but not the median is fine
+	if err := check(now - 35); err != nil {
+		t.Error(err)
+	}
+	if err := check(now - 45); !IsValidationErr(err) {
+		t.Errorf("Expected a block before the median to fail, got %v", err)
+	}
+	if err := check(now + rules.MaxDrift); err != nil {
+		t.Error(err)
+	}
+	err := check(now + rules.MaxDrift + 5)
+	if !IsFutureBlockErr(err) || err.(*FutureBlockErr).Wait != 5 {
+		t.Errorf("Expected a slightly future block to be held for 5s, got %v", err)
+	}
+	if err := check(now + rules.Hold + 1); !IsValidationErr(err) {
+		t.Errorf("Expected a far future block to fail, got %v", err)
+	}
+
+	// network time follows the median peer
+	bc.AddTimeOffset("a", 20)
+	bc.AddTimeOffset("b", 30)
+	if err := check(now + rules.MaxDrift + 5); err != nil {
+		t.Errorf("Expected network time to catch up with the block, got %v", err)
+	}
+	bc.RemoveTimeOffset("a")
+	bc.RemoveTimeOffset("b")
+	if nt := bc.NetworkTime(); nt != now {
+		t.Errorf("Network time was %d, expected %d", nt, now)
+	}
+}
+
+func TestTimeOffsetsMedian(t *testing.T) {
+	offsets := NewTimeOffsets()
+	if m := offsets.Median(60); m != 0 {
+		t.Errorf("Expected no offset without peers, got %d", m)
+	}
+	offsets.Add("a", 10)
+	offsets.Add("b", 20)
+	offsets.Add("c", -5)
+	if m := offsets.Median(60); m != 10 {
+		t.Errorf("Median offset was %d, expected 10", m)
+	}
+	// too far off to trust
+	offsets.Add("a", 100)
+	offsets.Add("c", 100)
+	if m := offsets.Median(60); m != 0 {
+		t.Errorf("Expected a capped offset of 0, got %d", m)
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"math/big"
+	"math/rand"
+	"time"
 
 	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkstate"
 	"github.com/eris-ltd/thelonious/monkutil"
 	"github.com/obscuren/secp256k1-go"
 )
@@ -27,6 +30,10 @@ type Transaction struct {
 	v         byte
 	r, s      []byte
 
+	// Proof of work nonce for chains requiring
+	// work on transactions (not part of the hash)
+	PowNonce []byte
+
 	// Indicates whether this tx is a contract creation transaction
 	contractCreation bool
 }
@@ -62,8 +69,18 @@ func (self *Transaction) TotalValue() *big.Int {
 	return v.Add(v, self.Value)
 }
 
+// The tx's identity. It covers the signature, so txs with the
+// same fields from different senders don't collide
 func (tx *Transaction) Hash() []byte {
 	data := []interface{}{tx.Nonce, tx.GasPrice, tx.Gas, tx.Recipient, tx.Value, tx.Data}
+	data = append(data, tx.v, new(big.Int).SetBytes(tx.r).Bytes(), new(big.Int).SetBytes(tx.s).Bytes())
+
+	return monkcrypto.Sha3Bin(monkutil.NewValue(data).Encode())
+}
+
+// The hash that's signed (and worked on), without the signature
+func (tx *Transaction) SigHash() []byte {
+	data := []interface{}{tx.Nonce, tx.GasPrice, tx.Gas, tx.Recipient, tx.Value, tx.Data}
 
 	return monkcrypto.Sha3Bin(monkutil.NewValue(data).Encode())
 }
@@ -82,7 +99,7 @@ func (tx *Transaction) CreationAddress() []byte {
 }
 
 func (tx *Transaction) Signature(key []byte) []byte {
-	hash := tx.Hash()
+	hash := tx.SigHash()
 
 	sig, _ := secp256k1.Sign(hash, key)
 
@@ -90,7 +107,7 @@ func (tx *Transaction) Signature(key []byte) []byte {
 }
 
 func (tx *Transaction) PublicKey() []byte {
-	hash := tx.Hash()
+	hash := tx.SigHash()
 
 	// TODO
 	r := monkutil.LeftPadBytes(tx.r, 32)
@@ -127,6 +144,30 @@ func (tx *Transaction) Sign(privk []byte) error {
 	return nil
 }
 
+// Find a nonce such that the tx hash and nonce
+// satisfy the difficulty. Gives up after limit
+func (tx *Transaction) SolvePow(diff *big.Int, limit time.Duration) error {
+	pow := &EasyPow{}
+	hash := tx.SigHash()
+	start := time.Now()
+	r := rand.New(rand.NewSource(start.UnixNano()))
+	for time.Since(start) < limit {
+		nonce := monkcrypto.Sha3Bin(big.NewInt(r.Int63()).Bytes())
+		if pow.Verify(hash, diff, nonce) {
+			tx.PowNonce = nonce
+			return nil
+		}
+	}
+	return fmt.Errorf("No tx proof of work found in %v (difficulty = %v)", limit, diff)
+}
+
+func (tx *Transaction) VerifyPow(diff *big.Int) bool {
+	if len(tx.PowNonce) == 0 {
+		return false
+	}
+	return (&EasyPow{}).Verify(tx.SigHash(), diff, tx.PowNonce)
+}
+
 func (tx *Transaction) GetSig() []byte {
 	if tx.r != nil && tx.s != nil {
 		return append(tx.r, append(tx.s, tx.v)...)
@@ -139,7 +180,11 @@ func (tx *Transaction) RlpData() interface{} {
 
 	// TODO Remove prefixing zero's
 
-	return append(data, tx.v, new(big.Int).SetBytes(tx.r).Bytes(), new(big.Int).SetBytes(tx.s).Bytes())
+	data = append(data, tx.v, new(big.Int).SetBytes(tx.r).Bytes(), new(big.Int).SetBytes(tx.s).Bytes())
+	if len(tx.PowNonce) > 0 {
+		data = append(data, tx.PowNonce)
+	}
+	return data
 }
 
 func (tx *Transaction) RlpValue() *monkutil.Value {
@@ -165,6 +210,7 @@ func (tx *Transaction) RlpValueDecode(decoder *monkutil.Value) {
 
 	tx.r = decoder.Get(7).Bytes()
 	tx.s = decoder.Get(8).Bytes()
+	tx.PowNonce = decoder.Get(9).Bytes()
 
 	if IsContractAddr(tx.Recipient) {
 		tx.contractCreation = true
@@ -204,6 +250,8 @@ type Receipt struct {
 	Tx                *Transaction
 	PostState         []byte
 	CumulativeGasUsed *big.Int
+	// made by contracts running the tx
+	Logs monkstate.Logs
 }
 type Receipts []*Receipt
 
@@ -218,10 +266,24 @@ func (self *Receipt) RlpValueDecode(decoder *monkutil.Value) {
 	self.Tx = NewTransactionFromValue(decoder.Get(0))
 	self.PostState = decoder.Get(1).Bytes()
 	self.CumulativeGasUsed = decoder.Get(2).BigInt()
+	if decoder.Len() > 3 {
+		self.Logs = monkstate.NewLogsFromValue(decoder.Get(3))
+	}
 }
 
+// Receipts without logs keep the old encoding,
+// so the tx sha of existing blocks doesn't change
 func (self *Receipt) RlpData() interface{} {
-	return []interface{}{self.Tx.RlpData(), self.PostState, self.CumulativeGasUsed}
+	if len(self.Logs) == 0 {
+		return []interface{}{self.Tx.RlpData(), self.PostState, self.CumulativeGasUsed}
+	}
+	return []interface{}{self.Tx.RlpData(), self.PostState, self.CumulativeGasUsed, self.Logs.RlpData()}
+}
+
+// Bloom of the addresses and topics of the receipt's logs.
+// It's derived from the logs, which are part of the tx sha
+func (self *Receipt) Bloom() *BloomFilter {
+	return LogsBloom(self.Logs)
 }
 
 func (self *Receipt) String() string {
@@ -230,10 +292,12 @@ func (self *Receipt) String() string {
 	Tx:[                 %v]
 	PostState:           0x%x
 	CumulativeGasUsed:   %v
+	Logs:                %v
 	`,
 		self.Tx,
 		self.PostState,
-		self.CumulativeGasUsed)
+		self.CumulativeGasUsed,
+		self.Logs)
 }
 
 func (self *Receipt) Cmp(other *Receipt) bool {
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"math/big"
 	"sync"
+	"sync/atomic"
 
 	"github.com/eris-ltd/thelonious/monklog"
 	"github.com/eris-ltd/thelonious/monkstate"
+	"github.com/eris-ltd/thelonious/monkutil"
 	"github.com/eris-ltd/thelonious/monkwire"
 )
 
@@ -16,6 +18,9 @@ var txplogger = monklog.NewLogger("TXP")
 
 const (
 	txPoolQueueSize = 50
+	// default limits on the number of txs in the pool
+	txPoolMaxTxs        = 4096
+	txPoolMaxAccountTxs = 64
 )
 
 type TxPoolHook chan *Transaction
@@ -24,10 +29,23 @@ type TxMsgTy byte
 const (
 	TxPre = iota
 	TxPost
-	minGasPrice = 0 //1000000
 )
 
-var MinGasPrice = big.NewInt(0)
+// Optional interface for protocols requiring
+// proof of work on transactions (anti-spam)
+type TxPowProtocol interface {
+	// nil if no work is required
+	TxDifficulty(state *monkstate.State) *big.Int
+}
+
+// Difficulty of the work required on transactions
+// in the block with the given number, or nil
+func (bc *ChainManager) TxDifficulty(number *big.Int, state *monkstate.State) *big.Int {
+	if p, ok := bc.ProtocolAt(number).(TxPowProtocol); ok {
+		return p.TxDifficulty(state)
+	}
+	return nil
+}
 
 type TxMsg struct {
 	Tx   *Transaction
@@ -51,6 +69,12 @@ func FindTx(pool *list.List, finder func(*Transaction, *list.Element) bool) *Tra
 // independently read without needing access to the actual pool. If the
 // pool is being drained or synced for whatever reason the transactions
 // will simple queue up and handled when the mutex is freed.
+//
+// Txs are kept per sender, sorted by nonce. Those following on from the
+// sender's nonce are pending (ready to be mined), those after a gap are
+// queued until it's filled. A tx with the nonce of one in the pool
+// replaces it if it pays a higher gas price. When the pool or a sender's
+// share of it is full, the least useful txs are evicted
 type TxPool struct {
 	Thelonious NodeManager
 	// The mutex for accessing the Tx pool.
@@ -58,32 +82,242 @@ type TxPool struct {
 	// Queueing channel for reading and writing incoming
 	// transactions to
 	queueChan chan *Transaction
+	// Txs queued but not yet handled
+	queued int32
 	// Quiting channel
 	quit chan bool
-	// The actual pool
-	pool *list.List
+	// Executable txs by sender
+	pending map[string]txList
+	// Txs waiting on lower nonces, by sender
+	queue map[string]txList
+	// Every tx in the pool by hash
+	all map[string]*Transaction
+	// When each tx arrived, by hash (a counter)
+	arrivals map[string]uint64
+	arrived  uint64
+
+	// Max txs in the pool, and from any one sender
+	maxTxs, maxAccountTxs int
+
+	// Txs kept across restarts, by hash, and the
+	// number of journal slots used in the db
+	journal      map[string]*journalEntry
+	journalSlots uint64
+	// Hashes of txs submitted through this node, until they're queued
+	locals                   map[string]bool
+	journalLocal, journalAll bool
 
 	subscribers []chan TxMsg
 }
 
 func NewTxPool(thelonious NodeManager) *TxPool {
 	return &TxPool{
-		pool:       list.New(),
-		queueChan:  make(chan *Transaction, txPoolQueueSize),
-		quit:       make(chan bool),
-		Thelonious: thelonious,
+		pending:       make(map[string]txList),
+		queue:         make(map[string]txList),
+		all:           make(map[string]*Transaction),
+		arrivals:      make(map[string]uint64),
+		maxTxs:        txPoolMaxTxs,
+		maxAccountTxs: txPoolMaxAccountTxs,
+		journal:       make(map[string]*journalEntry),
+		locals:        make(map[string]bool),
+		journalLocal:  true,
+		queueChan:     make(chan *Transaction, txPoolQueueSize),
+		quit:          make(chan bool),
+		Thelonious:    thelonious,
+	}
+}
+
+// Set the max number of txs in the pool, and from any one sender
+func (pool *TxPool) SetLimits(maxTxs, maxAccountTxs int) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.maxTxs, pool.maxAccountTxs = maxTxs, maxAccountTxs
+}
+
+// A sender's txs, sorted by nonce
+type txList []*Transaction
+
+// Index of the tx with the nonce, or -1
+func (l txList) find(nonce uint64) int {
+	for i, tx := range l {
+		if tx.Nonce == nonce {
+			return i
+		}
 	}
+	return -1
+}
+
+func (l txList) insert(tx *Transaction) txList {
+	i := len(l)
+	for i > 0 && l[i-1].Nonce > tx.Nonce {
+		i--
+	}
+	l = append(l, nil)
+	copy(l[i+1:], l[i:])
+	l[i] = tx
+	return l
+}
+
+func (l txList) remove(i int) txList {
+	return append(l[:i:i], l[i+1:]...)
 }
 
 // Blocking function. Don't use directly. Use QueueTransaction instead
 // Caller should hold the lock!
 func (pool *TxPool) addTransaction(tx *Transaction) {
-	pool.pool.PushBack(tx)
-
 	// Broadcast the transaction to the rest of the peers
 	pool.Thelonious.Broadcast(monkwire.MsgTxTy, []interface{}{tx.RlpData()})
 }
 
+// Add a tx to its sender's lists, given the sender's nonce in the
+// current state. Returns the txs replaced or evicted to make room.
+// Caller should hold the lock!
+func (pool *TxPool) add(tx *Transaction, nonce uint64) ([]*TxFail, error) {
+	if tx.Nonce < nonce {
+		return nil, NonceError(tx.Nonce, nonce)
+	}
+	sender := string(tx.Sender())
+	pending, queued := pool.pending[sender], pool.queue[sender]
+
+	// replace a tx with the same nonce if we're paying more
+	for _, lists := range []map[string]txList{pool.pending, pool.queue} {
+		l := lists[sender]
+		if i := l.find(tx.Nonce); i >= 0 {
+			old := l[i]
+			if tx.GasPrice.Cmp(old.GasPrice) <= 0 {
+				return nil, TxPoolError(TxUnderpriced, "Replacement tx gas price %v must be higher than %v", tx.GasPrice, old.GasPrice)
+			}
+			l[i] = tx
+			pool.untrack(old)
+			pool.track(tx)
+			return []*TxFail{{old, TxPoolError(TxReplaced, "Replaced by tx %x", tx.Hash())}}, nil
+		}
+	}
+
+	var dropped []*TxFail
+	executable := tx.Nonce == nonce+uint64(len(pending))
+
+	// make room in the sender's share for lower nonces only
+	if len(pending)+len(queued) >= pool.maxAccountTxs {
+		var last *Transaction
+		if len(queued) > 0 {
+			last = queued[len(queued)-1]
+		} else if len(pending) > 0 {
+			last = pending[len(pending)-1]
+		}
+		if last == nil || last.Nonce < tx.Nonce {
+			return nil, TxPoolError(TxAccountFull, "Sender %x has %d txs in the pool", tx.Sender(), pool.maxAccountTxs)
+		}
+		pool.removeTx(last)
+		dropped = append(dropped, &TxFail{last, TxPoolError(TxEvicted, "Evicted for tx %x with a lower nonce", tx.Hash())})
+	}
+
+	// make room in the pool
+	if len(pool.all) >= pool.maxTxs {
+		victim, victimExecutable := pool.cheapest(sender)
+		if victim == nil || !lessUseful(victim, victimExecutable, tx, executable) {
+			return nil, TxPoolError(TxPoolFull, "Pool is full with %d txs", pool.maxTxs)
+		}
+		pool.removeTx(victim)
+		dropped = append(dropped, &TxFail{victim, TxPoolError(TxEvicted, "Evicted for tx %x", tx.Hash())})
+	}
+
+	pool.track(tx)
+	if executable {
+		pool.pending[sender] = append(pool.pending[sender], tx)
+		pool.promote(sender)
+	} else {
+		pool.queue[sender] = pool.queue[sender].insert(tx)
+	}
+	return dropped, nil
+}
+
+func (pool *TxPool) track(tx *Transaction) {
+	pool.arrived++
+	pool.all[string(tx.Hash())] = tx
+	pool.arrivals[string(tx.Hash())] = pool.arrived
+}
+
+func (pool *TxPool) untrack(tx *Transaction) {
+	delete(pool.all, string(tx.Hash()))
+	delete(pool.arrivals, string(tx.Hash()))
+}
+
+// Whether tx a is less useful than tx b. Queued txs are
+// less useful than pending ones, then by gas price
+func lessUseful(a *Transaction, aExecutable bool, b *Transaction, bExecutable bool) bool {
+	if aExecutable != bExecutable {
+		return bExecutable
+	}
+	return a.GasPrice.Cmp(b.GasPrice) < 0
+}
+
+// The least useful tx that can be evicted without leaving a gap
+// (any queued tx, or the last pending), skipping the given sender
+func (pool *TxPool) cheapest(skip string) (victim *Transaction, executable bool) {
+	for sender, l := range pool.queue {
+		for _, tx := range l {
+			if sender != skip && (victim == nil || lessUseful(tx, false, victim, executable)) {
+				victim, executable = tx, false
+			}
+		}
+	}
+	for sender, l := range pool.pending {
+		if len(l) == 0 || sender == skip {
+			continue
+		}
+		tx := l[len(l)-1]
+		if victim == nil || lessUseful(tx, true, victim, executable) {
+			victim, executable = tx, true
+		}
+	}
+	return
+}
+
+// Move a sender's queued txs to pending once there's no gap before them
+func (pool *TxPool) promote(sender string) {
+	pending, queued := pool.pending[sender], pool.queue[sender]
+	for len(queued) > 0 && len(pending) > 0 && queued[0].Nonce == pending[len(pending)-1].Nonce+1 {
+		pending = append(pending, queued[0])
+		queued = queued[1:]
+	}
+	pool.setLists(sender, pending, queued)
+}
+
+func (pool *TxPool) setLists(sender string, pending, queued txList) {
+	if len(pending) > 0 {
+		pool.pending[sender] = pending
+	} else {
+		delete(pool.pending, sender)
+	}
+	if len(queued) > 0 {
+		pool.queue[sender] = queued
+	} else {
+		delete(pool.queue, sender)
+	}
+}
+
+// Take a tx out of the pool. Txs after it in pending are queued
+func (pool *TxPool) removeTx(tx *Transaction) {
+	hash := tx.Hash()
+	if _, ok := pool.all[string(hash)]; !ok {
+		return
+	}
+	pool.untrack(tx)
+
+	sender := string(tx.Sender())
+	pending, queued := pool.pending[sender], pool.queue[sender]
+	if i := pending.find(tx.Nonce); i >= 0 && bytes.Compare(pending[i].Hash(), hash) == 0 {
+		for _, t := range pending[i+1:] {
+			queued = queued.insert(t)
+		}
+		pending = pending[:i]
+	} else if i := queued.find(tx.Nonce); i >= 0 {
+		queued = queued.remove(i)
+	}
+	pool.setLists(sender, pending, queued)
+}
+
 // TODO: will this panic on invalid signature? catch that
 //  does not execute evm, just simple checks for adding to pool
 func (pool *TxPool) ValidateTransaction(tx *Transaction) error {
@@ -99,8 +333,14 @@ func (pool *TxPool) ValidateTransaction(tx *Transaction) error {
 		return fmt.Errorf("[TXPL] Invalid recipient. len = %d", len(tx.Recipient))
 	}
 
-	if tx.GasPrice.Cmp(MinGasPrice) < 0 {
-		return fmt.Errorf("Gas price to low. Require %v > Got %v", MinGasPrice, tx.GasPrice)
+	// the price the next block will require
+	number := new(big.Int).Add(block.Number, monkutil.Big1)
+	if min := pool.Thelonious.ChainManager().GasRules(number, block.State()).MinPrice(); tx.GasPrice.Cmp(min) < 0 {
+		return GasPriceError(tx.GasPrice, min)
+	}
+
+	if diff := pool.Thelonious.ChainManager().TxDifficulty(number, block.State()); diff != nil && !tx.VerifyPow(diff) {
+		return fmt.Errorf("[TXPL] Invalid transaction proof of work (nonce = %x)", tx.PowNonce)
 	}
 
 	// Get the sender
@@ -115,12 +355,6 @@ func (pool *TxPool) ValidateTransaction(tx *Transaction) error {
 		return fmt.Errorf("[TXPL] Insufficient amount in sender's (%x) account", tx.Sender())
 	}
 
-	if tx.IsContract() {
-		if tx.GasPrice.Cmp(big.NewInt(minGasPrice)) < 0 {
-			return fmt.Errorf("[TXPL] Gasprice too low, %s given should be at least %d.", tx.GasPrice, minGasPrice)
-		}
-	}
-
 	return nil
 }
 
@@ -129,8 +363,9 @@ out:
 	for {
 		select {
 		case tx := <-pool.queueChan:
-			if pool.queueTransaction(tx) {
-				continue
+			pool.queueTransaction(tx)
+			if atomic.AddInt32(&pool.queued, -1) == 0 {
+				monkutil.NotifyIdle()
 			}
 		case <-pool.quit:
 			break out
@@ -142,21 +377,25 @@ func (pool *TxPool) queueTransaction(tx *Transaction) bool {
 	pool.mutex.Lock()
 	defer pool.mutex.Unlock()
 
-	hash := tx.Hash()
-	foundTx := FindTx(pool.pool, func(tx *Transaction, e *list.Element) bool {
-		return bytes.Compare(tx.Hash(), hash) == 0
-	})
-
-	if foundTx != nil {
+	if t, ok := pool.all[string(tx.Hash())]; ok {
+		pool.journalTx(t)
 		return true
 	}
 
 	// Validate the transaction
 	err := pool.ValidateTransaction(tx)
+	var dropped []*TxFail
+	if err == nil {
+		nonce := pool.Thelonious.BlockManager().CurrentState().GetNonce(tx.Sender())
+		dropped, err = pool.add(tx, nonce)
+	}
 	if err != nil {
 		txplogger.Debugln("Validating Tx failed", err)
+		delete(pool.locals, string(tx.Hash()))
 		pool.Thelonious.Reactor().Post("newTx:pre:fail", &TxFail{tx, err})
 	} else {
+		pool.journalTx(tx)
+
 		// Call blocking version.
 		pool.addTransaction(tx)
 
@@ -168,70 +407,151 @@ func (pool *TxPool) queueTransaction(tx *Transaction) bool {
 		// Notify the subscribers
 		pool.Thelonious.Reactor().Post("newTx:pre", tx)
 	}
+	for _, fail := range dropped {
+		if IsTxPoolErr(fail.Err) && fail.Err.(*TxPoolErr).Reason == TxReplaced {
+			pool.unjournalTx(fail.Tx)
+		}
+		txplogger.Debugf("Dropped tx %x: %v\n", fail.Tx.Hash(), fail.Err)
+		pool.Thelonious.Reactor().Post("newTx:pre:fail", fail)
+	}
 	return false
 }
 
 func (pool *TxPool) QueueTransaction(tx *Transaction) {
+	atomic.AddInt32(&pool.queued, 1)
 	pool.queueChan <- tx
 }
 
+// Whether there are queued txs the pool hasn't handled yet
+func (pool *TxPool) Busy() bool {
+	return atomic.LoadInt32(&pool.queued) > 0
+}
+
+// The pending txs in the order they arrived,
+// keeping each sender's in nonce order
 func (pool *TxPool) CurrentTransactions() []*Transaction {
 	pool.mutex.Lock()
 	defer pool.mutex.Unlock()
 
-	txList := make([]*Transaction, pool.pool.Len())
-	i := 0
-	for e := pool.pool.Front(); e != nil; e = e.Next() {
-		tx := e.Value.(*Transaction)
+	senders := make([]Transactions, 0, len(pool.pending))
+	for _, l := range pool.pending {
+		senders = append(senders, Transactions(l))
+	}
+	arrivals := make(map[*Transaction]uint64, len(pool.all))
+	for _, l := range senders {
+		for _, tx := range l {
+			arrivals[tx] = pool.arrivals[string(tx.Hash())]
+		}
+	}
 
-		txList[i] = tx
+	return takeTxs(senders, nil, func(a, b *Transaction) bool {
+		return arrivals[a] < arrivals[b]
+	})
+}
+
+// The txs waiting on lower nonces
+func (pool *TxPool) QueuedTransactions() []*Transaction {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
 
-		i++
+	var txs []*Transaction
+	for _, l := range pool.queue {
+		txs = append(txs, l...)
 	}
+	return txs
+}
+
+// Number of pending and queued txs
+func (pool *TxPool) Stats() (pending, queued int) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
 
-	return txList
+	for _, l := range pool.pending {
+		pending += len(l)
+	}
+	return pending, len(pool.all) - pending
 }
 
 func (pool *TxPool) RemoveInvalid(state *monkstate.State) {
-	for e := pool.pool.Front(); e != nil; e = e.Next() {
-		tx := e.Value.(*Transaction)
-		sender := state.GetAccount(tx.Sender())
-		err := pool.ValidateTransaction(tx)
-		if err != nil || sender.Nonce >= tx.Nonce {
-			pool.pool.Remove(e)
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for _, tx := range pool.all {
+		if err := pool.ValidateTransaction(tx); err != nil {
+			pool.removeTx(tx)
 		}
 	}
+	pool.reset(state)
 }
 
 func (self *TxPool) RemoveSet(txs Transactions) {
 	self.mutex.Lock()
 	defer self.mutex.Unlock()
 
+	// by hash, as txs from blocks aren't the pool's copies
 	for _, tx := range txs {
-		EachTx(self.pool, func(t *Transaction, element *list.Element) bool {
-			if t == tx {
-				self.pool.Remove(element)
-				return true // To stop the loop
+		if t, ok := self.all[string(tx.Hash())]; ok {
+			self.removeTx(t)
+		}
+	}
+}
+
+// Re-sort the pool on a new state (eg. a new head). Txs with nonces
+// already used are dropped, and each sender's txs are split into
+// pending and queued again from their new nonce
+func (pool *TxPool) Reset(state *monkstate.State) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.reset(state)
+}
+
+func (pool *TxPool) reset(state *monkstate.State) {
+	senders := make(map[string]bool)
+	for sender := range pool.pending {
+		senders[sender] = true
+	}
+	for sender := range pool.queue {
+		senders[sender] = true
+	}
+
+	for sender := range senders {
+		nonce := state.GetNonce([]byte(sender))
+		var pending, queued txList
+		for _, tx := range append(append(txList{}, pool.pending[sender]...), pool.queue[sender]...) {
+			switch {
+			case tx.Nonce < nonce:
+				pool.untrack(tx)
+				pool.unjournalTx(tx)
+			case tx.Nonce == nonce+uint64(len(pending)):
+				pending = append(pending, tx)
+			default:
+				queued = queued.insert(tx)
 			}
-			return false
-		})
+		}
+		pool.setLists(sender, pending, queued)
+		pool.promote(sender)
 	}
 }
 
+// Take the pending txs and empty the pool
 func (pool *TxPool) Flush() []*Transaction {
-	txList := pool.CurrentTransactions()
+	txs := pool.CurrentTransactions()
 
 	// Recreate a new list all together
 	// XXX Is this the fastest way?
 	pool.mutex.Lock()
 	defer pool.mutex.Unlock()
 
-	pool.pool = list.New()
+	pool.pending = make(map[string]txList)
+	pool.queue = make(map[string]txList)
+	pool.all = make(map[string]*Transaction)
+	pool.arrivals = make(map[string]uint64)
 
-	return txList
+	return txs
 }
 
 func (pool *TxPool) Start() {
+	pool.replayJournal()
 	go pool.queueHandler()
 }
 
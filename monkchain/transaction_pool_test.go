@@ -0,0 +1,129 @@
+package monkchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+)
+
+func poolTx(key *monkcrypto.KeyPair, nonce uint64, price int64) *Transaction {
+	tx := NewTransactionMessage(key.Address(), big.NewInt(1), big.NewInt(100), big.NewInt(price), nil)
+	tx.Nonce = nonce
+	tx.Sign(key.PrivateKey)
+	return tx
+}
+
+func checkPool(t *testing.T, pool *TxPool, pending, queued int) {
+	if p, q := pool.Stats(); p != pending || q != queued {
+		t.Errorf("Expected %d pending and %d queued, got %d and %d", pending, queued, p, q)
+	}
+}
+
+func TestTxPoolNonces(t *testing.T) {
+	pool := NewTxPool(nil)
+	key := monkcrypto.GenerateNewKeyPair()
+
+	if _, err := pool.add(poolTx(key, 1, 1), 2); !IsNonceErr(err) {
+		t.Errorf("Expected a nonce error for a used nonce, got %v", err)
+	}
+
+	// a gap, then filled
+	tx4 := poolTx(key, 4, 1)
+	for _, tx := range []*Transaction{poolTx(key, 2, 1), tx4, poolTx(key, 3, 1)} {
+		if _, err := pool.add(tx, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkPool(t, pool, 3, 0)
+	if _, err := pool.add(poolTx(key, 6, 1), 2); err != nil {
+		t.Fatal(err)
+	}
+	checkPool(t, pool, 3, 1)
+	for i, tx := range pool.CurrentTransactions() {
+		if tx.Nonce != uint64(i+2) {
+			t.Errorf("Pending tx %d has nonce %d", i, tx.Nonce)
+		}
+	}
+
+	// same price is no replacement, higher is
+	if _, err := pool.add(poolTx(key, 3, 1), 2); !IsTxPoolErr(err) || err.(*TxPoolErr).Reason != TxUnderpriced {
+		t.Errorf("Expected an underpriced replacement, got %v", err)
+	}
+	dropped, err := pool.add(poolTx(key, 3, 2), 2)
+	if err != nil || len(dropped) != 1 || dropped[0].Tx.GasPrice.Int64() != 1 || dropped[0].Err.(*TxPoolErr).Reason != TxReplaced {
+		t.Errorf("Expected the old tx to be replaced, got %v %v", dropped, err)
+	}
+	checkPool(t, pool, 3, 1)
+
+	// the txs after a removed one wait for it again,
+	// and those with used nonces go on a new state
+	pool.RemoveSet(Transactions{tx4})
+	checkPool(t, pool, 2, 1)
+	_, blocks := canonicalChain(1)
+	state := blocks[0].State()
+	state.GetOrNewStateObject(key.Address()).Nonce = 5
+	pool.Reset(state)
+	checkPool(t, pool, 0, 1)
+	if _, err := pool.add(poolTx(key, 5, 1), 5); err != nil {
+		t.Fatal(err)
+	}
+	checkPool(t, pool, 2, 0)
+}
+
+func TestTxPoolLimits(t *testing.T) {
+	pool := NewTxPool(nil)
+	pool.SetLimits(3, 2)
+	key, other := monkcrypto.GenerateNewKeyPair(), monkcrypto.GenerateNewKeyPair()
+
+	pool.add(poolTx(key, 0, 1), 0)
+	pool.add(poolTx(key, 2, 1), 0)
+	if _, err := pool.add(poolTx(key, 3, 1), 0); !IsTxPoolErr(err) || err.(*TxPoolErr).Reason != TxAccountFull {
+		t.Errorf("Expected the account to be full, got %v", err)
+	}
+	// a lower nonce pushes out the highest
+	dropped, err := pool.add(poolTx(key, 1, 1), 0)
+	if err != nil || len(dropped) != 1 || dropped[0].Tx.Nonce != 2 || dropped[0].Err.(*TxPoolErr).Reason != TxEvicted {
+		t.Errorf("Expected nonce 2 to be evicted, got %v %v", dropped, err)
+	}
+	checkPool(t, pool, 2, 0)
+
+	// the pool is full: a queued tx can't push out pending ones,
+	// but a pending one can push out a cheaper pending one
+	pool.add(poolTx(other, 0, 1), 0)
+	if _, err := pool.add(poolTx(monkcrypto.GenerateNewKeyPair(), 1, 5), 0); !IsTxPoolErr(err) || err.(*TxPoolErr).Reason != TxPoolFull {
+		t.Errorf("Expected the pool to be full, got %v", err)
+	}
+	if _, err := pool.add(poolTx(monkcrypto.GenerateNewKeyPair(), 0, 1), 0); !IsTxPoolErr(err) {
+		t.Errorf("Expected the pool to be full for the same price, got %v", err)
+	}
+	dropped, err = pool.add(poolTx(monkcrypto.GenerateNewKeyPair(), 0, 2), 0)
+	if err != nil || len(dropped) != 1 || dropped[0].Tx.GasPrice.Int64() != 1 {
+		t.Errorf("Expected a cheaper pending tx to be evicted, got %v %v", dropped, err)
+	}
+	checkPool(t, pool, 3, 0)
+}
+
+func TestTxPoolSenders(t *testing.T) {
+	pool := NewTxPool(nil)
+	to := make([]byte, 20)
+	var txs Transactions
+	for i := 0; i < 2; i++ {
+		tx := NewTransactionMessage(to, big.NewInt(1), big.NewInt(100), big.NewInt(1), nil)
+		tx.Sign(monkcrypto.GenerateNewKeyPair().PrivateKey)
+		txs = append(txs, tx)
+	}
+
+	// the same fields from two senders are two txs
+	for _, tx := range txs {
+		if _, err := pool.add(tx, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkPool(t, pool, 2, 0)
+	pool.RemoveSet(txs[:1])
+	checkPool(t, pool, 1, 0)
+	if l := pool.pending[string(txs[1].Sender())]; len(l) != 1 || l[0] != txs[1] {
+		t.Error("Removing one sender's tx took the other's")
+	}
+}
@@ -1 +1,64 @@
 package monkchain
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+func TestTxPow(t *testing.T) {
+	tx := NewTransactionMessage(make([]byte, 20), big.NewInt(1), big.NewInt(100), big.NewInt(1), nil)
+	tx.Sign(monkcrypto.GenerateNewKeyPair().PrivateKey)
+	hash := tx.Hash()
+
+	diff := monkutil.BigPow(2, 8)
+	if tx.VerifyPow(diff) {
+		t.Error("Tx without a pow nonce passed verification")
+	}
+	if err := tx.SolvePow(diff, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hash, tx.Hash()) {
+		t.Error("Solving the pow changed the tx hash")
+	}
+
+	tx = NewTransactionFromBytes(tx.RlpEncode())
+	if !tx.VerifyPow(diff) {
+		t.Error("Tx pow did not survive rlp")
+	}
+
+	// an impossible difficulty gives up
+	if err := tx.SolvePow(monkutil.BigPow(2, 256), 10*time.Millisecond); err == nil {
+		t.Error("Expected no tx pow at difficulty 2^256")
+	}
+}
+
+func TestTxHash(t *testing.T) {
+	keys := []*monkcrypto.KeyPair{monkcrypto.GenerateNewKeyPair(), monkcrypto.GenerateNewKeyPair()}
+	var txs []*Transaction
+	for _, key := range keys {
+		tx := NewTransactionMessage(make([]byte, 20), big.NewInt(1), big.NewInt(100), big.NewInt(1), nil)
+		tx.Sign(key.PrivateKey)
+		txs = append(txs, tx)
+	}
+
+	// the same fields from different senders are different txs
+	if !bytes.Equal(txs[0].SigHash(), txs[1].SigHash()) {
+		t.Error("Expected the same signing hash for the same fields")
+	}
+	if bytes.Equal(txs[0].Hash(), txs[1].Hash()) {
+		t.Error("Txs from different senders have the same hash")
+	}
+	for i, tx := range txs {
+		if !bytes.Equal(tx.Sender(), keys[i].Address()) {
+			t.Errorf("Tx %d has sender %x, expected %x", i, tx.Sender(), keys[i].Address())
+		}
+		if !bytes.Equal(NewTransactionFromBytes(tx.RlpEncode()).Hash(), tx.Hash()) {
+			t.Errorf("Tx %d hash did not survive rlp", i)
+		}
+	}
+}
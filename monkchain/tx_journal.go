@@ -0,0 +1,165 @@
+package monkchain
+
+import (
+	"encoding/binary"
+	"math/big"
+	"sort"
+
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+/*
+   The tx pool journals the txs submitted through this node (or all
+   of them) to the node's db, so they aren't lost on a restart. The
+   journal is replayed when the pool starts, and each tx is validated
+   again against the current state. Txs are dropped from the journal
+   once their nonce is used on the chain, or if they fail on replay
+*/
+
+var txJournalKey = []byte("txJournal")
+
+// A journaled tx, with its slot in the db and its sender
+// (which is slow to recover, so we only do it once)
+type journalEntry struct {
+	tx     *Transaction
+	sender string
+	slot   uint64
+}
+
+// Each tx has its own slot, so adding or dropping one is a single write.
+// The number of slots used is kept under the journal key
+func txJournalSlotKey(slot uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, slot)
+	return append(monkutil.CopyBytes(txJournalKey), key...)
+}
+
+// Journal the txs submitted through this node, and
+// optionally those from peers as well
+func (pool *TxPool) SetJournal(local, all bool) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.journalLocal, pool.journalAll = local, all
+}
+
+// Queue a tx submitted through this node
+func (pool *TxPool) QueueLocalTransaction(tx *Transaction) {
+	pool.mutex.Lock()
+	pool.locals[string(tx.Hash())] = true
+	pool.mutex.Unlock()
+
+	pool.QueueTransaction(tx)
+}
+
+// The journaled txs, by sender and in nonce order
+func (pool *TxPool) Journal() []*Transaction {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	entries := make([]*journalEntry, 0, len(pool.journal))
+	for _, entry := range pool.journal {
+		entries = append(entries, entry)
+	}
+	sort.Sort(entriesBySender(entries))
+	txs := make([]*Transaction, len(entries))
+	for i, entry := range entries {
+		txs[i] = entry.tx
+	}
+	return txs
+}
+
+// Empty the journal. The txs stay in the pool
+func (pool *TxPool) ClearJournal() {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.clearJournal()
+}
+
+type entriesBySender []*journalEntry
+
+func (s entriesBySender) Len() int      { return len(s) }
+func (s entriesBySender) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s entriesBySender) Less(i, j int) bool {
+	if s[i].sender != s[j].sender {
+		return s[i].sender < s[j].sender
+	}
+	return s[i].tx.Nonce < s[j].tx.Nonce
+}
+
+// Add a tx that made it into the pool to the journal, if it should be.
+// Caller should hold the lock!
+func (pool *TxPool) journalTx(tx *Transaction) {
+	hash := string(tx.Hash())
+	local := pool.locals[hash]
+	delete(pool.locals, hash)
+	if _, ok := pool.journal[hash]; ok {
+		return
+	}
+	if (local && pool.journalLocal) || pool.journalAll {
+		entry := &journalEntry{tx, string(tx.Sender()), pool.journalSlots}
+		pool.journal[hash] = entry
+		pool.journalSlots++
+
+		db := pool.Thelonious.Db()
+		db.Put(txJournalSlotKey(entry.slot), tx.RlpEncode())
+		db.Put(txJournalKey, new(big.Int).SetUint64(pool.journalSlots).Bytes())
+	}
+}
+
+// Caller should hold the lock!
+func (pool *TxPool) unjournalTx(tx *Transaction) {
+	if entry, ok := pool.journal[string(tx.Hash())]; ok {
+		delete(pool.journal, string(tx.Hash()))
+		pool.Thelonious.Db().Delete(txJournalSlotKey(entry.slot))
+	}
+}
+
+// Caller should hold the lock!
+func (pool *TxPool) clearJournal() {
+	db := pool.Thelonious.Db()
+	for slot := uint64(0); slot < pool.journalSlots; slot++ {
+		db.Delete(txJournalSlotKey(slot))
+	}
+	pool.journal = make(map[string]*journalEntry)
+	pool.journalSlots = 0
+	db.Put(txJournalKey, nil)
+}
+
+// The txs in the journal in the db, in the order they were added
+func (pool *TxPool) loadJournal() []*Transaction {
+	db := pool.Thelonious.Db()
+	data, _ := db.Get(txJournalKey)
+	slots := monkutil.BigD(data).Uint64()
+	var txs []*Transaction
+	for slot := uint64(0); slot < slots; slot++ {
+		// slots of txs dropped from the journal are empty
+		if data, _ := db.Get(txJournalSlotKey(slot)); len(data) > 0 {
+			txs = append(txs, NewTransactionFromBytes(data))
+		}
+	}
+	return txs
+}
+
+// Queue the journaled txs again. Those that aren't valid anymore
+// are dropped, and the rest are written back without the gaps
+func (pool *TxPool) replayJournal() {
+	txs := pool.loadJournal()
+	if len(txs) == 0 {
+		return
+	}
+
+	pool.mutex.Lock()
+	data, _ := pool.Thelonious.Db().Get(txJournalKey)
+	pool.journalSlots = monkutil.BigD(data).Uint64()
+	pool.clearJournal()
+	for _, tx := range txs {
+		pool.locals[string(tx.Hash())] = true
+	}
+	pool.mutex.Unlock()
+
+	for _, tx := range txs {
+		pool.queueTransaction(tx)
+	}
+
+	txplogger.Infof("Replayed %d journaled txs, %d still valid\n", len(txs), len(pool.Journal()))
+}
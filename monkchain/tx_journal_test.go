@@ -0,0 +1,87 @@
+package monkchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkdb"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+// Just enough of a node for the journal
+type journalNode struct {
+	NodeManager
+	db monkutil.Database
+}
+
+func (self *journalNode) Db() monkutil.Database {
+	return self.db
+}
+
+func TestTxJournal(t *testing.T) {
+	db, _ := monkdb.NewMemDatabase()
+	pool := NewTxPool(&journalNode{db: db})
+	if txs := pool.loadJournal(); len(txs) != 0 {
+		t.Fatalf("Expected an empty journal, got %d txs", len(txs))
+	}
+
+	key := monkcrypto.GenerateNewKeyPair()
+	local, remote := poolTx(key, 1, 1), poolTx(key, 0, 1)
+	pool.locals[string(local.Hash())] = true
+	for _, tx := range []*Transaction{local, remote} {
+		pool.add(tx, 0)
+		pool.journalTx(tx)
+	}
+	txs := NewTxPool(&journalNode{db: db}).loadJournal()
+	if len(txs) != 1 || !bytes.Equal(txs[0].Hash(), local.Hash()) {
+		t.Fatalf("Expected only the local tx to be journaled, got %v", txs)
+	}
+
+	// mined
+	_, blocks := canonicalChain(1)
+	state := blocks[0].State()
+	state.GetOrNewStateObject(key.Address()).Nonce = 2
+	pool.Reset(state)
+	if txs := pool.loadJournal(); len(txs) != 0 {
+		t.Errorf("Expected the mined tx to leave the journal, got %v", txs)
+	}
+
+	pool.SetJournal(false, true)
+	pool.add(remote, 0)
+	pool.journalTx(remote)
+	if txs := pool.Journal(); len(txs) != 1 {
+		t.Errorf("Expected all txs to be journaled, got %v", txs)
+	}
+	pool.ClearJournal()
+	if txs := pool.loadJournal(); len(txs) != 0 || len(pool.Journal()) != 0 {
+		t.Errorf("Expected the journal to be cleared, got %v", txs)
+	}
+}
+
+func TestTxJournalSlots(t *testing.T) {
+	db, _ := monkdb.NewMemDatabase()
+	pool := NewTxPool(&journalNode{db: db})
+	pool.SetJournal(false, true)
+
+	key, other := monkcrypto.GenerateNewKeyPair(), monkcrypto.GenerateNewKeyPair()
+	txs := []*Transaction{poolTx(key, 1, 1), poolTx(other, 0, 1), poolTx(key, 0, 1)}
+	for _, tx := range txs {
+		pool.journalTx(tx)
+	}
+
+	// dropping one leaves the others where they were
+	pool.unjournalTx(txs[1])
+	loaded := pool.loadJournal()
+	if len(loaded) != 2 || !bytes.Equal(loaded[0].Hash(), txs[0].Hash()) || !bytes.Equal(loaded[1].Hash(), txs[2].Hash()) {
+		t.Fatalf("Expected the first and last txs in the journal, got %v", loaded)
+	}
+	if txs := pool.Journal(); len(txs) != 2 || txs[0].Nonce != 0 || txs[1].Nonce != 1 {
+		t.Errorf("Expected the journal in nonce order, got %v", txs)
+	}
+	// and new ones go after them
+	pool.journalTx(txs[1])
+	if loaded := pool.loadJournal(); len(loaded) != 3 || !bytes.Equal(loaded[2].Hash(), txs[1].Hash()) {
+		t.Errorf("Expected the tx to be journaled again at the end, got %v", loaded)
+	}
+}
@@ -0,0 +1,219 @@
+package monkchain
+
+import (
+	"container/heap"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+/*
+   Strategies for picking a new block's txs from the pending ones.
+   Each sender's txs stay in nonce order, and together the txs
+   picked fit in the block's gas limit. A sender whose next tx
+   doesn't fit is passed over for the rest of the block.
+   The genesis.json picks one by name ("tx-selector"):
+       price - highest gas price first (the default)
+       fifo  - in the order they arrived
+       fair  - one from each sender in turn
+*/
+
+type TxSelector interface {
+	// Pick txs (given in the order they arrived) for a block.
+	// A nil gas limit is no limit
+	Select(txs Transactions, gasLimit *big.Int) Transactions
+}
+
+type TxSelectorFunc func(txs Transactions, gasLimit *big.Int) Transactions
+
+func (f TxSelectorFunc) Select(txs Transactions, gasLimit *big.Int) Transactions {
+	return f(txs, gasLimit)
+}
+
+var (
+	selectorsMut sync.Mutex
+	selectors    = make(map[string]TxSelector)
+)
+
+// Register a named tx selector.
+// Names must be unique
+func RegisterTxSelector(name string, selector TxSelector) error {
+	selectorsMut.Lock()
+	defer selectorsMut.Unlock()
+	if selector == nil {
+		return fmt.Errorf("Nil tx selector %s", name)
+	}
+	if _, ok := selectors[name]; ok {
+		return fmt.Errorf("Tx selector %s already registered", name)
+	}
+	selectors[name] = selector
+	return nil
+}
+
+func LookupTxSelector(name string) (TxSelector, bool) {
+	selectorsMut.Lock()
+	defer selectorsMut.Unlock()
+	selector, ok := selectors[name]
+	return selector, ok
+}
+
+// Names of all registered tx selectors, sorted
+func RegisteredTxSelectors() []string {
+	selectorsMut.Lock()
+	defer selectorsMut.Unlock()
+	names := []string{}
+	for name := range selectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Optional interface for protocols picking how blocks are filled
+type TxSelectorProtocol interface {
+	TxSelector() TxSelector
+}
+
+// How the chain's blocks are filled (by gas price by default)
+func (bc *ChainManager) TxSelector() TxSelector {
+	if p, ok := bc.protocol.(TxSelectorProtocol); ok {
+		if selector := p.TxSelector(); selector != nil {
+			return selector
+		}
+	}
+	selector, _ := LookupTxSelector("price")
+	return selector
+}
+
+// Where a tx came in a selector's input: its index,
+// the order of its sender's first tx, and its place
+// among the sender's txs
+type txPos struct {
+	index, sender, turn int
+}
+
+// Split the txs by sender, in the order of the senders' first
+// txs, sorting each sender's by nonce
+func bySender(txs Transactions) ([]Transactions, map[*Transaction]txPos) {
+	first := make(map[string]int)
+	pos := make(map[*Transaction]txPos, len(txs))
+	var senders []Transactions
+	for i, tx := range txs {
+		sender := string(tx.Sender())
+		j, ok := first[sender]
+		if !ok {
+			j = len(senders)
+			first[sender] = j
+			senders = append(senders, nil)
+		}
+		senders[j] = append(senders[j], tx)
+		pos[tx] = txPos{index: i, sender: j}
+	}
+
+	for _, l := range senders {
+		sort.Stable(TxByNonce{l})
+		for turn, tx := range l {
+			p := pos[tx]
+			p.turn = turn
+			pos[tx] = p
+		}
+	}
+	return senders, pos
+}
+
+// Senders' remaining txs, ordered by their next tx
+type txHeap struct {
+	lists []Transactions
+	less  func(a, b *Transaction) bool
+}
+
+func (h *txHeap) Len() int           { return len(h.lists) }
+func (h *txHeap) Less(i, j int) bool { return h.less(h.lists[i][0], h.lists[j][0]) }
+func (h *txHeap) Swap(i, j int)      { h.lists[i], h.lists[j] = h.lists[j], h.lists[i] }
+
+func (h *txHeap) Push(x interface{}) {
+	h.lists = append(h.lists, x.(Transactions))
+}
+
+func (h *txHeap) Pop() interface{} {
+	l := h.lists[len(h.lists)-1]
+	h.lists = h.lists[:len(h.lists)-1]
+	return l
+}
+
+// Take the least of the senders' next txs until none are left
+// or fit in the gas limit (nil for no limit)
+func takeTxs(senders []Transactions, gasLimit *big.Int, less func(a, b *Transaction) bool) Transactions {
+	h := &txHeap{less: less}
+	for _, l := range senders {
+		if len(l) > 0 {
+			h.lists = append(h.lists, l)
+		}
+	}
+	heap.Init(h)
+
+	var gas *big.Int
+	if gasLimit != nil {
+		gas = new(big.Int).Set(gasLimit)
+	}
+	var txs Transactions
+	for h.Len() > 0 {
+		l := h.lists[0]
+		if gas != nil {
+			if l[0].Gas.Cmp(gas) > 0 {
+				heap.Pop(h)
+				continue
+			}
+			gas.Sub(gas, l[0].Gas)
+		}
+		txs = append(txs, l[0])
+		if len(l) > 1 {
+			h.lists[0] = l[1:]
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return txs
+}
+
+func selectByPrice(txs Transactions, gasLimit *big.Int) Transactions {
+	senders, pos := bySender(txs)
+	return takeTxs(senders, gasLimit, func(a, b *Transaction) bool {
+		if c := a.GasPrice.Cmp(b.GasPrice); c != 0 {
+			return c > 0
+		}
+		return pos[a].index < pos[b].index
+	})
+}
+
+func selectFifo(txs Transactions, gasLimit *big.Int) Transactions {
+	senders, pos := bySender(txs)
+	return takeTxs(senders, gasLimit, func(a, b *Transaction) bool {
+		return pos[a].index < pos[b].index
+	})
+}
+
+func selectFair(txs Transactions, gasLimit *big.Int) Transactions {
+	senders, pos := bySender(txs)
+	return takeTxs(senders, gasLimit, func(a, b *Transaction) bool {
+		if pos[a].turn != pos[b].turn {
+			return pos[a].turn < pos[b].turn
+		}
+		return pos[a].sender < pos[b].sender
+	})
+}
+
+func mustRegisterTxSelector(name string, selector TxSelector) {
+	if err := RegisterTxSelector(name, selector); err != nil {
+		panic(err)
+	}
+}
+
+// The builtin tx selectors
+func init() {
+	mustRegisterTxSelector("price", TxSelectorFunc(selectByPrice))
+	mustRegisterTxSelector("fifo", TxSelectorFunc(selectFifo))
+	mustRegisterTxSelector("fair", TxSelectorFunc(selectFair))
+}
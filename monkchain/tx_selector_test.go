@@ -0,0 +1,64 @@
+package monkchain
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+)
+
+func checkSelected(t *testing.T, name string, got, expected Transactions) {
+	if len(got) != len(expected) {
+		t.Errorf("%s: expected %d txs, got %d", name, len(expected), len(got))
+		return
+	}
+	for i := range got {
+		if !bytes.Equal(got[i].Hash(), expected[i].Hash()) {
+			t.Errorf("%s: tx %d is %v, expected %v", name, i, got[i], expected[i])
+		}
+	}
+}
+
+func TestTxSelectors(t *testing.T) {
+	a, b := monkcrypto.GenerateNewKeyPair(), monkcrypto.GenerateNewKeyPair()
+	a0, a1, b0 := poolTx(a, 0, 1), poolTx(a, 1, 5), poolTx(b, 0, 3)
+	// arrived out of nonce order
+	txs := Transactions{a1, a0, b0}
+
+	for name, expected := range map[string]Transactions{
+		"price": {b0, a0, a1},
+		"fifo":  {a0, a1, b0},
+		"fair":  {a0, b0, a1},
+	} {
+		selector, ok := LookupTxSelector(name)
+		if !ok {
+			t.Fatalf("No builtin tx selector %s", name)
+		}
+		checkSelected(t, name, selector.Select(txs, nil), expected)
+	}
+
+	// each tx has 100 gas. A sender whose next tx
+	// doesn't fit is passed over
+	selector, _ := LookupTxSelector("price")
+	checkSelected(t, "price limited", selector.Select(txs, big.NewInt(250)), Transactions{b0, a0})
+	big0 := NewTransactionMessage(b.Address(), big.NewInt(1), big.NewInt(1000), big.NewInt(10), nil)
+	big0.Sign(b.PrivateKey)
+	checkSelected(t, "price big tx", selector.Select(Transactions{a0, a1, big0}, big.NewInt(250)), Transactions{a0, a1})
+
+	if err := RegisterTxSelector("fifo", TxSelectorFunc(selectFifo)); err == nil {
+		t.Error("Expected an error registering a tx selector twice")
+	}
+}
+
+func TestTxPoolArrivals(t *testing.T) {
+	pool := NewTxPool(nil)
+	a, b := monkcrypto.GenerateNewKeyPair(), monkcrypto.GenerateNewKeyPair()
+	b0, a1, a0 := poolTx(b, 0, 1), poolTx(a, 1, 1), poolTx(a, 0, 1)
+	for _, tx := range []*Transaction{b0, a1, a0} {
+		if _, err := pool.add(tx, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkSelected(t, "pool", pool.CurrentTransactions(), Transactions{b0, a0, a1})
+}
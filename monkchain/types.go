@@ -149,6 +149,13 @@ const (
 	SWAP15 = 0x9e
 	SWAP16 = 0x9f
 
+	// 0xa0 range - logging
+	LOG0 = 0xa0
+	LOG1 = 0xa1
+	LOG2 = 0xa2
+	LOG3 = 0xa3
+	LOG4 = 0xa4
+
 	// 0xf0 range - closures
 	CREATE        = 0xf0
 	CALL          = 0xf1
@@ -305,6 +312,13 @@ var opCodeToString = map[OpCode]string{
 	SWAP15: "SWAP15",
 	SWAP16: "SWAP16",
 
+	// 0xa0 range - logging
+	LOG0: "LOG0",
+	LOG1: "LOG1",
+	LOG2: "LOG2",
+	LOG3: "LOG3",
+	LOG4: "LOG4",
+
 	// 0xf0 range
 	CREATE:        "CREATE",
 	CALL:          "CALL",
@@ -0,0 +1,109 @@
+package monkchain
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+/*
+   Uncles are stale blocks whose parent is a recent ancestor
+   of the block including them. The miner of an uncle gets
+   a fraction of the block reward, and the miner including
+   it gets a smaller fraction on top of their own.
+*/
+
+// Rules for uncle inclusion and rewards.
+// Rewards are fractions of the block reward
+type UncleRules struct {
+	// How many generations back an uncle's parent may be
+	Depth int `json:"depth"`
+	// Max number of uncles in a block
+	Max int `json:"max"`
+	// Reward to the uncle's coinbase
+	RewardNum int64 `json:"reward-num"`
+	RewardDen int64 `json:"reward-den"`
+	// Reward to the including block's coinbase, per uncle
+	InclusionNum int64 `json:"inclusion-num"`
+	InclusionDen int64 `json:"inclusion-den"`
+}
+
+var DefaultUncleRules = &UncleRules{
+	Depth:        6,
+	Max:          2,
+	RewardNum:    15,
+	RewardDen:    16,
+	InclusionNum: 1,
+	InclusionDen: 32,
+}
+
+// Optional interface for protocols with their own uncle rules
+type UncleProtocol interface {
+	UncleRules() *UncleRules
+}
+
+func (self *UncleRules) UncleReward(reward *big.Int) *big.Int {
+	return fraction(reward, self.RewardNum, self.RewardDen)
+}
+
+func (self *UncleRules) InclusionReward(reward *big.Int) *big.Int {
+	return fraction(reward, self.InclusionNum, self.InclusionDen)
+}
+
+func fraction(x *big.Int, num, den int64) *big.Int {
+	if den == 0 {
+		return new(big.Int)
+	}
+	r := new(big.Int).Mul(x, big.NewInt(num))
+	return r.Div(r, big.NewInt(den))
+}
+
+// Uncle rules of the protocol, or the defaults
+func (bc *ChainManager) UncleRules() *UncleRules {
+	if p, ok := bc.protocol.(UncleProtocol); ok {
+		if rules := p.UncleRules(); rules != nil {
+			return rules
+		}
+	}
+	return DefaultUncleRules
+}
+
+// Walk back depth generations from parent (inclusive).
+// Returns the hashes of the ancestors and of the uncles they included
+func (bc *ChainManager) UncleAncestry(parent *Block, depth int) (ancestors, included map[string]bool) {
+	ancestors = make(map[string]bool)
+	included = make(map[string]bool)
+	for block := parent; block != nil && len(ancestors) <= depth; block = bc.GetBlock(block.PrevHash) {
+		ancestors[string(block.Hash())] = true
+		for _, uncle := range block.Uncles {
+			included[string(uncle.Hash())] = true
+		}
+		if block.Number.Cmp(big.NewInt(0)) == 0 {
+			break
+		}
+	}
+	return
+}
+
+// Check a candidate uncle for a block on top of parent
+// against the ancestry returned by UncleAncestry
+func ValidUncle(uncle, parent *Block, ancestors, included map[string]bool) error {
+	hash := string(uncle.Hash())
+	if ancestors[hash] {
+		return UncleError("Uncle is an ancestor")
+	}
+	if included[hash] {
+		return UncleError("Uncle in chain")
+	}
+	if bytes.Equal(uncle.PrevHash, parent.Hash()) || !ancestors[string(uncle.PrevHash)] {
+		return UncleError("Uncle's parent is not a recent ancestor")
+	}
+	return nil
+}
+
+// Whether the block's uncles match its UncleSha
+func (block *Block) ValidUncleSha() bool {
+	return bytes.Equal(block.UncleSha, monkcrypto.Sha3Bin(monkutil.Encode(block.rlpUncles())))
+}
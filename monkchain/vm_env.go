@@ -7,16 +7,18 @@ import (
 )
 
 type VMEnv struct {
-	state *monkstate.State
-	block *Block
-	tx    *Transaction
+	state    *monkstate.State
+	block    *Block
+	tx       *Transaction
+	protocol Protocol
 }
 
-func NewEnv(state *monkstate.State, tx *Transaction, block *Block) *VMEnv {
+func NewEnv(state *monkstate.State, tx *Transaction, block *Block, protocol Protocol) *VMEnv {
 	return &VMEnv{
-		state: state,
-		block: block,
-		tx:    tx,
+		state:    state,
+		block:    block,
+		tx:       tx,
+		protocol: protocol,
 	}
 }
 
@@ -29,7 +31,7 @@ func (self *VMEnv) Difficulty() *big.Int    { return self.block.Difficulty }
 func (self *VMEnv) BlockHash() []byte       { return self.block.Hash() }
 func (self *VMEnv) Value() *big.Int         { return self.tx.Value }
 func (self *VMEnv) State() *monkstate.State { return self.state }
-func (self *VMEnv) Doug() []byte            { return genDoug.Doug() }
+func (self *VMEnv) Doug() []byte            { return self.protocol.Doug() }
 func (self *VMEnv) DougValidate(addr []byte, role string, state *monkstate.State) error {
-	return genDoug.ValidatePerm(addr, role, state)
+	return protocolAt(self.protocol, self.block.Number).ValidatePerm(addr, role, state)
 }
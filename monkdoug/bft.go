@@ -0,0 +1,495 @@
+package monkdoug
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkreact"
+	"github.com/eris-ltd/thelonious/monkstate"
+	"github.com/eris-ltd/thelonious/monkutil"
+	"github.com/eris-ltd/thelonious/monkwire"
+)
+
+/*
+   The bft model gives blocks instant finality, tendermint style.
+   Validators are the miners listed in gendoug ("seq:name").
+   Each height has rounds of length blocktime, counted from the parent's
+   timestamp. The proposer for (height, round) is
+   validators[(height+round) % nValidators].
+   The proposer mines a block at the base difficulty and announces it.
+   Validators prevote for the announced block once they've processed it,
+   and precommit (and lock on it) once more than two thirds have prevoted
+   for it. A locked validator prevotes for its locked block in later rounds.
+   More than two thirds of precommits form a commit certificate, which is
+   stored with the block. The ChainManager reorgs to it if it's on a fork,
+   and never reorgs below it.
+   Votes for a height are checked against the validators in the state
+   of its parent.
+*/
+
+type BftModel struct {
+	*StdLibModel
+
+	mut  sync.Mutex
+	th   monkchain.NodeManager
+	quit chan bool
+
+	blockChan  chan monkreact.Event
+	voteChan   chan monkreact.Event
+	commitChan chan monkreact.Event
+
+	// validators in a state (the gendoug miners list)
+	validatorsOf func(state *monkstate.State) [][]byte
+
+	// proposed block hash by "height:round"
+	proposals map[string][]byte
+	// votes by "type:height:round:hash", then by signer
+	votes map[string]map[string]*monkchain.Vote
+	// rounds we have prevoted in ("height:round")
+	prevoted map[string]bool
+	// rounds we have precommitted in ("height:round")
+	precommitted map[string]bool
+	// block we are locked on at a height
+	locked map[uint64][]byte
+	// certificates waiting on their block
+	pending map[string]*monkchain.CommitCertificate
+	// votes for heights whose parent we don't have yet
+	early map[uint64][]*monkchain.Vote
+}
+
+func NewBftModel(g *GenesisConfig) monkchain.Consensus {
+	m := &BftModel{
+		StdLibModel:  NewStdLibModel(g).(*StdLibModel),
+		proposals:    make(map[string][]byte),
+		votes:        make(map[string]map[string]*monkchain.Vote),
+		prevoted:     make(map[string]bool),
+		precommitted: make(map[string]bool),
+		locked:       make(map[uint64][]byte),
+		pending:      make(map[string]*monkchain.CommitCertificate),
+		early:        make(map[uint64][]*monkchain.Vote),
+	}
+	m.validatorsOf = m.miners
+	return m
+}
+
+// Validators are the miners in gendoug
+func (m *BftModel) validators(state *monkstate.State) [][]byte {
+	return m.validatorsOf(state)
+}
+
+// State of the canonical parent of a height, whose validators
+// vote on it. Nil if we don't have the parent yet
+func (m *BftModel) parentState(height uint64) *monkstate.State {
+	if height == 0 {
+		return nil
+	}
+	parent := m.th.ChainManager().GetBlockByNumber(height - 1)
+	if parent == nil {
+		return nil
+	}
+	return parent.State()
+}
+
+func (m *BftModel) isValidator(addr []byte, state *monkstate.State) bool {
+	for _, v := range m.validators(state) {
+		if bytes.Equal(v, addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Number of votes needed for a two thirds majority
+func quorum(nValidators int) int {
+	return nValidators*2/3 + 1
+}
+
+// Rounds last one blocktime
+func (m *BftModel) roundTimeout(state *monkstate.State) int64 {
+	t := m.blocktime(state)
+	if t <= 0 {
+		t = 1
+	}
+	return t
+}
+
+// Round of a block with the given timestamp on top of parent
+func (m *BftModel) round(t int64, parent *monkchain.Block) uint64 {
+	if t <= parent.Time {
+		return 0
+	}
+	return uint64((t - parent.Time) / m.roundTimeout(parent.State()))
+}
+
+func (m *BftModel) proposer(height, round uint64, state *monkstate.State) []byte {
+	validators := m.validators(state)
+	if len(validators) == 0 {
+		return nil
+	}
+	return validators[(height+round)%uint64(len(validators))]
+}
+
+// Only the proposer of the current round mines.
+// We propose in the first round only once the parent is committed
+func (m *BftModel) Participate(coinbase []byte, parent *monkchain.Block) bool {
+	state := parent.State()
+	round := m.round(monkutil.Now().Unix(), parent)
+	height := parent.Number.Uint64() + 1
+	if !bytes.Equal(m.proposer(height, round, state), coinbase) {
+		return false
+	}
+	if round == 0 && parent.Number.Uint64() > 0 {
+		m.mut.Lock()
+		th := m.th
+		m.mut.Unlock()
+		if th != nil && th.ChainManager().CommittedHeight() < parent.Number.Uint64() {
+			return false
+		}
+	}
+	return true
+}
+
+// Blocks are chosen by votes, not work, so difficulty is constant
+func (m *BftModel) Difficulty(block, parent *monkchain.Block) *big.Int {
+	return m.baseDifficulty(parent.State())
+}
+
+func (m *BftModel) ValidateBlock(block *monkchain.Block, bc *monkchain.ChainManager) error {
+	if Adversary != 0 {
+		return nil
+	}
+
+	// we have to verify using the state of the previous block!
+	prevBlock := bc.GetBlock(block.PrevHash)
+
+	// check that signature of block matches miners coinbase
+	if !bytes.Equal(block.Signer(), block.Coinbase) {
+		return monkchain.InvalidSigError(block.Signer(), block.Coinbase)
+	}
+
+	// check that the coinbase was the proposer for the block's round
+	round := m.round(block.Time, prevBlock)
+	proposer := m.proposer(block.Number.Uint64(), round, prevBlock.State())
+	if !bytes.Equal(proposer, block.Coinbase) {
+		return monkchain.InvalidTurnError(block.Coinbase, proposer)
+	}
+
+	newdiff := m.Difficulty(block, prevBlock)
+	if block.Difficulty.Cmp(newdiff) != 0 {
+		return monkchain.InvalidDifficultyError(block.Difficulty, newdiff, block.Coinbase)
+	}
+
+	if !m.pow.Verify(block.HashNoNonce(), block.Difficulty, block.Nonce) {
+		return monkchain.ValidationError("Block's nonce is invalid (= %v)", monkutil.Bytes2Hex(block.Nonce))
+	}
+
+	// proposals from missed rounds may be included as uncles
+	if err := CheckUncles(prevBlock, block, bc, m.pow); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Accept a checkpoint if it has been committed
+func (m *BftModel) CheckPoint(proposed []byte, bc *monkchain.ChainManager) bool {
+	cert := bc.GetCommit(proposed)
+	block := bc.GetBlock(proposed)
+	if cert == nil || block == nil {
+		return false
+	}
+	parent := bc.GetBlock(block.PrevHash)
+	if parent == nil {
+		return false
+	}
+	return m.VerifyCommit(cert, parent.State()) == nil
+}
+
+// A commit is valid if more than two thirds of the validators signed it
+func (m *BftModel) VerifyCommit(cert *monkchain.CommitCertificate, state *monkstate.State) error {
+	validators := m.validators(state)
+	var n int
+	for _, signer := range cert.Signers() {
+		for _, v := range validators {
+			if bytes.Equal(v, signer) {
+				n += 1
+				break
+			}
+		}
+	}
+	if n < quorum(len(validators)) {
+		return monkchain.ValidationError("Commit for %x has %d valid precommits, needs %d", cert.BlockHash, n, quorum(len(validators)))
+	}
+	return nil
+}
+
+// Start exchanging votes with the network
+func (m *BftModel) Start(th monkchain.NodeManager) {
+	m.mut.Lock()
+	m.th = th
+	m.quit = make(chan bool)
+	m.blockChan = make(chan monkreact.Event, 10)
+	m.voteChan = make(chan monkreact.Event, 100)
+	m.commitChan = make(chan monkreact.Event, 10)
+	m.mut.Unlock()
+
+	reactor := th.Reactor()
+	reactor.Subscribe("newBlock", m.blockChan)
+	reactor.Subscribe("newUncle", m.blockChan)
+	reactor.Subscribe("chainHead", m.blockChan)
+	reactor.Subscribe("consensus:vote", m.voteChan)
+	reactor.Subscribe("consensus:commit", m.commitChan)
+
+	go m.update()
+}
+
+func (m *BftModel) Stop() {
+	if m.quit == nil {
+		return
+	}
+	reactor := m.th.Reactor()
+	reactor.Unsubscribe("newBlock", m.blockChan)
+	reactor.Unsubscribe("newUncle", m.blockChan)
+	reactor.Unsubscribe("chainHead", m.blockChan)
+	reactor.Unsubscribe("consensus:vote", m.voteChan)
+	reactor.Unsubscribe("consensus:commit", m.commitChan)
+	close(m.quit)
+}
+
+func (m *BftModel) update() {
+out:
+	for {
+		select {
+		case <-m.quit:
+			break out
+		case ev := <-m.blockChan:
+			if block, ok := ev.Resource.(*monkchain.Block); ok {
+				m.receiveBlock(block)
+			}
+		case ev := <-m.voteChan:
+			if vote, ok := ev.Resource.(*monkchain.Vote); ok {
+				m.receiveVote(vote)
+			}
+		case ev := <-m.commitChan:
+			if cert, ok := ev.Resource.(*monkchain.CommitCertificate); ok {
+				m.receiveCommit(cert, false)
+			}
+		}
+	}
+}
+
+func voteKey(typ byte, height, round uint64, hash []byte) string {
+	return fmt.Sprintf("%d:%d:%d:%x", typ, height, round, hash)
+}
+
+func roundKey(height, round uint64) string {
+	return fmt.Sprintf("%d:%d", height, round)
+}
+
+// If we mined the block, propose it.
+// Then prevote if it was proposed, and retry the votes and
+// commits that were waiting on blocks
+func (m *BftModel) receiveBlock(block *monkchain.Block) {
+	bc := m.th.ChainManager()
+	parent := bc.GetBlock(block.PrevHash)
+	if parent == nil {
+		return
+	}
+	height := block.Number.Uint64()
+	round := m.round(block.Time, parent)
+
+	if km := m.th.KeyManager(); km != nil && bytes.Equal(block.Coinbase, km.Address()) {
+		if _, ok := m.proposals[roundKey(height, round)]; !ok {
+			m.castVote(monkchain.VoteProposal, height, round, block.Hash(), monkwire.MsgProposalTy)
+		}
+	}
+
+	m.tryPrevote(height, round)
+	m.retry()
+}
+
+// Count the votes whose parent we now have, and
+// store the commits whose block is now canonical
+func (m *BftModel) retry() {
+	for height, votes := range m.early {
+		if m.parentState(height) == nil {
+			continue
+		}
+		delete(m.early, height)
+		for _, vote := range votes {
+			m.receiveVote(vote)
+		}
+	}
+	pending := m.pending
+	m.pending = make(map[string]*monkchain.CommitCertificate)
+	for _, cert := range pending {
+		m.receiveCommit(cert, false)
+	}
+}
+
+func (m *BftModel) receiveVote(vote *monkchain.Vote) {
+	bc := m.th.ChainManager()
+	if vote.Height <= bc.CommittedHeight() {
+		return
+	}
+	state := m.parentState(vote.Height)
+	if state == nil {
+		m.early[vote.Height] = append(m.early[vote.Height], vote)
+		return
+	}
+	signer := vote.Signer()
+	if signer == nil || !m.isValidator(signer, state) {
+		douglogger.Debugln("Ignoring vote from non-validator", vote)
+		return
+	}
+
+	switch vote.Type {
+	case monkchain.VoteProposal:
+		if !bytes.Equal(signer, m.proposer(vote.Height, vote.Round, state)) {
+			douglogger.Debugln("Ignoring proposal from wrong proposer", vote)
+			return
+		}
+		key := roundKey(vote.Height, vote.Round)
+		if _, ok := m.proposals[key]; !ok {
+			m.proposals[key] = vote.BlockHash
+		}
+		m.tryPrevote(vote.Height, vote.Round)
+	case monkchain.VotePrevote, monkchain.VotePrecommit:
+		key := voteKey(vote.Type, vote.Height, vote.Round, vote.BlockHash)
+		if m.votes[key] == nil {
+			m.votes[key] = make(map[string]*monkchain.Vote)
+		}
+		if _, ok := m.votes[key][string(signer)]; ok {
+			return
+		}
+		m.votes[key][string(signer)] = vote
+
+		if len(m.votes[key]) < quorum(len(m.validators(state))) {
+			return
+		}
+		if vote.Type == monkchain.VotePrevote {
+			rkey := roundKey(vote.Height, vote.Round)
+			if !m.precommitted[rkey] {
+				m.precommitted[rkey] = true
+				m.locked[vote.Height] = vote.BlockHash
+				m.castVote(monkchain.VotePrecommit, vote.Height, vote.Round, vote.BlockHash, monkwire.MsgPrecommitTy)
+			}
+		} else {
+			precommits := []*monkchain.Vote{}
+			for _, v := range m.votes[key] {
+				precommits = append(precommits, v)
+			}
+			cert := monkchain.NewCommitCertificate(vote.Height, vote.Round, vote.BlockHash, precommits)
+			m.receiveCommit(cert, true)
+		}
+	}
+}
+
+// Prevote for the proposal of this round once we've processed the block.
+// If we're locked on a block at this height, prevote for that instead
+func (m *BftModel) tryPrevote(height, round uint64) {
+	key := roundKey(height, round)
+	hash, ok := m.proposals[key]
+	if !ok || m.prevoted[key] {
+		return
+	}
+	if lock, ok := m.locked[height]; ok {
+		hash = lock
+	}
+	if m.th.ChainManager().GetBlock(hash) == nil {
+		return
+	}
+	m.prevoted[key] = true
+	m.castVote(monkchain.VotePrevote, height, round, hash, monkwire.MsgPrevoteTy)
+}
+
+// Sign a vote, broadcast it, and count it ourselves
+func (m *BftModel) castVote(typ byte, height, round uint64, hash []byte, msgTy monkwire.MsgType) {
+	km := m.th.KeyManager()
+	state := m.parentState(height)
+	if km == nil || state == nil || !m.isValidator(km.Address(), state) {
+		return
+	}
+	vote := monkchain.NewVote(typ, height, round, hash)
+	vote.Sign(km.PrivateKey())
+	m.th.Broadcast(msgTy, []interface{}{vote.RlpData()})
+	m.receiveVote(vote)
+}
+
+// Verify and store a commit, reorging to its block.
+// Broadcast it if we assembled it. If we don't have the
+// block (or its parent), fetch it and try again once we do
+func (m *BftModel) receiveCommit(cert *monkchain.CommitCertificate, ours bool) {
+	bc := m.th.ChainManager()
+	if cert.Height <= bc.CommittedHeight() && bc.CommittedHash() != nil {
+		return
+	}
+	block := bc.GetBlock(cert.BlockHash)
+	if block == nil {
+		if _, ok := m.pending[string(cert.BlockHash)]; !ok {
+			m.th.Broadcast(monkwire.MsgGetBlocksTy, []interface{}{cert.BlockHash})
+		}
+		m.pending[string(cert.BlockHash)] = cert
+		return
+	}
+	parent := bc.GetBlock(block.PrevHash)
+	if parent == nil {
+		m.pending[string(cert.BlockHash)] = cert
+		return
+	}
+	if err := m.VerifyCommit(cert, parent.State()); err != nil {
+		douglogger.Infoln(err)
+		return
+	}
+	if ours {
+		m.th.Broadcast(monkwire.MsgCommitTy, []interface{}{cert.RlpData()})
+	}
+	if err := bc.Commit(cert); err != nil {
+		// the block is still being processed
+		douglogger.Debugln(err)
+		m.pending[string(cert.BlockHash)] = cert
+		return
+	}
+	m.prune(cert.Height)
+}
+
+// Forget votes at or below a committed height
+func (m *BftModel) prune(height uint64) {
+	for key, votes := range m.votes {
+		for _, v := range votes {
+			if v.Height <= height {
+				delete(m.votes, key)
+			}
+			break
+		}
+	}
+	for _, rounds := range []map[string]bool{m.prevoted, m.precommitted} {
+		for key := range rounds {
+			var h, r uint64
+			fmt.Sscanf(key, "%d:%d", &h, &r)
+			if h <= height {
+				delete(rounds, key)
+			}
+		}
+	}
+	for key := range m.proposals {
+		var h, r uint64
+		fmt.Sscanf(key, "%d:%d", &h, &r)
+		if h <= height {
+			delete(m.proposals, key)
+		}
+	}
+	for h := range m.locked {
+		if h <= height {
+			delete(m.locked, h)
+		}
+	}
+	for h := range m.early {
+		if h <= height {
+			delete(m.early, h)
+		}
+	}
+}
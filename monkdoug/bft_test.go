@@ -0,0 +1,307 @@
+package monkdoug
+
+import (
+	"bytes"
+	"container/list"
+	"math/big"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkdb"
+	"github.com/eris-ltd/thelonious/monkreact"
+	"github.com/eris-ltd/thelonious/monkstate"
+	"github.com/eris-ltd/thelonious/monkutil"
+	"github.com/eris-ltd/thelonious/monkwire"
+)
+
+// A node with a chain and keys, that keeps what it broadcasts
+type bftNode struct {
+	bc      *monkchain.ChainManager
+	keys    *monkcrypto.KeyManager
+	reactor *monkreact.ReactorEngine
+	db      monkutil.Database
+	sent    []*monkwire.Msg
+}
+
+func (n *bftNode) BlockManager() *monkchain.BlockManager   { return nil }
+func (n *bftNode) ChainManager() *monkchain.ChainManager   { return n.bc }
+func (n *bftNode) TxPool() *monkchain.TxPool               { return nil }
+func (n *bftNode) Reactor() *monkreact.ReactorEngine       { return n.reactor }
+func (n *bftNode) PeerCount() int                          { return 0 }
+func (n *bftNode) IsMining() bool                          { return false }
+func (n *bftNode) IsListening() bool                       { return false }
+func (n *bftNode) Peers() *list.List                       { return list.New() }
+func (n *bftNode) KeyManager() *monkcrypto.KeyManager      { return n.keys }
+func (n *bftNode) ClientIdentity() monkwire.ClientIdentity { return nil }
+func (n *bftNode) Db() monkutil.Database                   { return n.db }
+func (n *bftNode) Protocol() monkchain.Protocol            { return nil }
+
+func (n *bftNode) Broadcast(msgType monkwire.MsgType, data []interface{}) {
+	n.sent = append(n.sent, monkwire.NewMessage(msgType, data))
+}
+
+// The votes we broadcast of a type
+func (n *bftNode) votes(msgType monkwire.MsgType) []*monkchain.Vote {
+	var votes []*monkchain.Vote
+	for _, msg := range n.sent {
+		if msg.Type == msgType {
+			val := monkutil.NewValueFromBytes(monkutil.Encode(msg.Data.Get(0).Val))
+			votes = append(votes, monkchain.NewVoteFromValue(val))
+		}
+	}
+	return votes
+}
+
+func (n *bftNode) count(msgType monkwire.MsgType) int {
+	var c int
+	for _, msg := range n.sent {
+		if msg.Type == msgType {
+			c += 1
+		}
+	}
+	return c
+}
+
+// Blocks are valid, and weigh their difficulty
+type bftProcessor struct {
+	bc *monkchain.ChainManager
+}
+
+func (p bftProcessor) ProcessWithParent(block, parent *monkchain.Block) (*big.Int, error) {
+	td := p.bc.BlockInfo(parent).TD
+	if td == nil {
+		td = new(big.Int)
+	}
+	return new(big.Int).Add(td, block.Difficulty), nil
+}
+
+// A bft model for validator i of n, on a fresh chain
+func newBftTest(t *testing.T, n, i int) (*BftModel, *bftNode, []*monkcrypto.KeyPair) {
+	var keys []*monkcrypto.KeyPair
+	var validators [][]byte
+	for j := 0; j < n; j++ {
+		k := monkcrypto.GenerateNewKeyPair()
+		keys = append(keys, k)
+		validators = append(validators, k.Address())
+	}
+
+	g := &GenesisConfig{
+		Address:    "0000000000THISISDOUG",
+		NoGenDoug:  true,
+		Difficulty: 4,
+	}
+	g.Init()
+
+	db, _ := monkdb.NewMemDatabase()
+	if monkutil.Config == nil {
+		monkutil.Config = &monkutil.ConfigManager{}
+	}
+	monkutil.Config.Db = db
+	bc := monkchain.NewChainManagerWithDb(g.Model(), db)
+	bc.SetProcessor(bftProcessor{bc})
+
+	km := monkcrypto.NewDBKeyManager(db)
+	if err := km.InitFromString("bft", 0, monkutil.Bytes2Hex(keys[i].PrivateKey)); err != nil {
+		t.Fatal(err)
+	}
+	node := &bftNode{bc: bc, keys: km, reactor: monkreact.New(), db: db}
+
+	m := NewBftModel(g).(*BftModel)
+	m.validatorsOf = func(*monkstate.State) [][]byte { return validators }
+	m.th = node
+	return m, node, keys
+}
+
+// Process a block on top of parent. It goes on a fork
+// if it doesn't beat the head
+func addBlock(t *testing.T, bc *monkchain.ChainManager, block *monkchain.Block) {
+	chain := monkchain.NewChain(monkchain.Blocks{block})
+	if _, err := bc.TestChain(chain); err != nil && !monkchain.IsTDError(err) {
+		t.Fatal(err)
+	}
+	bc.InsertChain(chain)
+}
+
+func signedVote(typ byte, height, round uint64, hash []byte, key *monkcrypto.KeyPair) *monkchain.Vote {
+	vote := monkchain.NewVote(typ, height, round, hash)
+	vote.Sign(key.PrivateKey)
+	return vote
+}
+
+func TestBftRound(t *testing.T) {
+	m, node, keys := newBftTest(t, 4, 0)
+	genesis := node.bc.Genesis()
+
+	// without a blocktime, rounds last a second
+	for dt, round := range map[int64]uint64{-1: 0, 0: 0, 1: 1, 5: 5} {
+		if r := m.round(genesis.Time+dt, genesis); r != round {
+			t.Errorf("Round %d seconds after the parent is %d, expected %d", dt, r, round)
+		}
+	}
+
+	state := genesis.State()
+	for _, c := range []struct {
+		height, round uint64
+		proposer      int
+	}{{1, 0, 1}, {1, 1, 2}, {1, 3, 0}, {2, 0, 2}, {6, 1, 3}} {
+		if p := m.proposer(c.height, c.round, state); !bytes.Equal(p, keys[c.proposer].Address()) {
+			t.Errorf("Proposer for #%d round %d is %x, expected validator %d", c.height, c.round, p, c.proposer)
+		}
+	}
+
+	for n, q := range map[int]int{1: 1, 3: 3, 4: 3, 7: 5, 10: 7} {
+		if quorum(n) != q {
+			t.Errorf("Quorum of %d is %d, expected %d", n, quorum(n), q)
+		}
+	}
+}
+
+func TestBftPrevote(t *testing.T) {
+	m, node, keys := newBftTest(t, 4, 0)
+	genesis := node.bc.Genesis()
+
+	// the proposal for #1, round 0, and another in round 1
+	b := childBlock(genesis, keys[1].Address(), genesis.Time)
+	addBlock(t, node.bc, b)
+	c := childBlock(genesis, keys[2].Address(), genesis.Time+1)
+	addBlock(t, node.bc, c)
+
+	// only the round's proposer can propose
+	m.receiveVote(signedVote(monkchain.VoteProposal, 1, 0, b.Hash(), keys[2]))
+	if n := node.count(monkwire.MsgPrevoteTy); n != 0 {
+		t.Fatalf("Prevoted %d times for a proposal from the wrong proposer", n)
+	}
+
+	m.receiveVote(signedVote(monkchain.VoteProposal, 1, 0, b.Hash(), keys[1]))
+	prevotes := node.votes(monkwire.MsgPrevoteTy)
+	if len(prevotes) != 1 || !bytes.Equal(prevotes[0].BlockHash, b.Hash()) || prevotes[0].Round != 0 {
+		t.Fatalf("Expected a prevote for the proposal, got %v", prevotes)
+	}
+	// once per round
+	m.tryPrevote(1, 0)
+	if n := node.count(monkwire.MsgPrevoteTy); n != 1 {
+		t.Fatalf("Prevoted %d times in one round", n)
+	}
+
+	// non-validators don't count towards the quorum
+	m.receiveVote(signedVote(monkchain.VotePrevote, 1, 0, b.Hash(), monkcrypto.GenerateNewKeyPair()))
+	m.receiveVote(signedVote(monkchain.VotePrevote, 1, 0, b.Hash(), keys[1]))
+	m.receiveVote(signedVote(monkchain.VotePrevote, 1, 0, b.Hash(), keys[1]))
+	if n := node.count(monkwire.MsgPrecommitTy); n != 0 {
+		t.Fatalf("Precommitted with %d prevotes", 2)
+	}
+	m.receiveVote(signedVote(monkchain.VotePrevote, 1, 0, b.Hash(), keys[2]))
+	precommits := node.votes(monkwire.MsgPrecommitTy)
+	if len(precommits) != 1 || !bytes.Equal(precommits[0].BlockHash, b.Hash()) {
+		t.Fatalf("Expected a precommit with a quorum of prevotes, got %v", precommits)
+	}
+	if !bytes.Equal(m.locked[1], b.Hash()) {
+		t.Fatal("Not locked on the precommitted block")
+	}
+
+	// locked, we prevote for b in later rounds
+	m.receiveVote(signedVote(monkchain.VoteProposal, 1, 1, c.Hash(), keys[2]))
+	prevotes = node.votes(monkwire.MsgPrevoteTy)
+	if len(prevotes) != 2 || !bytes.Equal(prevotes[1].BlockHash, b.Hash()) || prevotes[1].Round != 1 {
+		t.Fatalf("Expected a prevote for the locked block in round 1, got %v", prevotes)
+	}
+
+	// votes wait for their parent
+	m.receiveVote(signedVote(monkchain.VotePrevote, 3, 0, c.Hash(), keys[1]))
+	if len(m.early[3]) != 1 {
+		t.Fatal("Expected the vote to wait for block #2")
+	}
+}
+
+func TestBftCommit(t *testing.T) {
+	m, node, keys := newBftTest(t, 4, 0)
+	genesis := node.bc.Genesis()
+
+	b := childBlock(genesis, keys[1].Address(), genesis.Time)
+	addBlock(t, node.bc, b)
+	fork := childBlock(genesis, keys[2].Address(), genesis.Time+1)
+	addBlock(t, node.bc, fork)
+	if !bytes.Equal(node.bc.GetBlockHashByNumber(1), b.Hash()) {
+		t.Fatal("Expected the first block to be canonical")
+	}
+
+	// not enough precommits
+	weak := monkchain.NewCommitCertificate(1, 1, fork.Hash(), []*monkchain.Vote{
+		signedVote(monkchain.VotePrecommit, 1, 1, fork.Hash(), keys[1]),
+		signedVote(monkchain.VotePrecommit, 1, 1, fork.Hash(), keys[2]),
+	})
+	m.receiveCommit(weak, false)
+	if node.bc.CommittedHash() != nil {
+		t.Fatal("Committed without a quorum")
+	}
+
+	// the fork is committed, and becomes canonical
+	for _, k := range keys[1:] {
+		m.receiveVote(signedVote(monkchain.VotePrecommit, 1, 1, fork.Hash(), k))
+	}
+	if node.count(monkwire.MsgCommitTy) != 1 {
+		t.Fatal("Expected our commit to be broadcast")
+	}
+	if node.bc.CommittedHeight() != 1 || !bytes.Equal(node.bc.CommittedHash(), fork.Hash()) {
+		t.Fatalf("Expected the fork to be committed, got #%d %x", node.bc.CommittedHeight(), node.bc.CommittedHash())
+	}
+	if !bytes.Equal(node.bc.GetBlockHashByNumber(1), fork.Hash()) || !bytes.Equal(node.bc.CurrentBlockHash(), fork.Hash()) {
+		t.Fatal("Committed block is not canonical")
+	}
+
+	// we don't have the next committed block yet
+	next := childBlock(fork, keys[2].Address(), fork.Time)
+	var precommits []*monkchain.Vote
+	for _, k := range keys[1:] {
+		precommits = append(precommits, signedVote(monkchain.VotePrecommit, 2, 0, next.Hash(), k))
+	}
+	m.receiveCommit(monkchain.NewCommitCertificate(2, 0, next.Hash(), precommits), false)
+	if node.count(monkwire.MsgGetBlocksTy) != 1 {
+		t.Fatal("Expected a request for the committed block")
+	}
+	if node.bc.CommittedHeight() != 1 {
+		t.Fatal("Committed a block we don't have")
+	}
+
+	addBlock(t, node.bc, next)
+	m.receiveBlock(next)
+	if node.bc.CommittedHeight() != 2 || !bytes.Equal(node.bc.CommittedHash(), next.Hash()) {
+		t.Fatalf("Expected the commit once we had the block, got #%d", node.bc.CommittedHeight())
+	}
+	if len(m.pending) != 0 {
+		t.Error("Commit is still pending")
+	}
+}
+
+func TestCommitBelowCommitted(t *testing.T) {
+	_, node, keys := newBftTest(t, 4, 0)
+	genesis := node.bc.Genesis()
+
+	// a heavy canonical chain, and a light fork off genesis
+	b := childBlock(genesis, keys[1].Address(), genesis.Time)
+	b.Difficulty = big.NewInt(100)
+	addBlock(t, node.bc, b)
+	fork := childBlock(genesis, keys[2].Address(), genesis.Time+1)
+	addBlock(t, node.bc, fork)
+	forkChild := childBlock(fork, keys[2].Address(), fork.Time)
+	addBlock(t, node.bc, forkChild)
+	if !bytes.Equal(node.bc.CurrentBlockHash(), b.Hash()) {
+		t.Fatal("Expected the heavy block to be the head")
+	}
+
+	if err := node.bc.Commit(monkchain.NewCommitCertificate(1, 0, b.Hash(), nil)); err != nil {
+		t.Fatal(err)
+	}
+	// the fork would revert the committed block
+	if err := node.bc.Commit(monkchain.NewCommitCertificate(2, 0, forkChild.Hash(), nil)); err == nil {
+		t.Fatal("Expected a commit below the committed height to fail")
+	}
+	if !bytes.Equal(node.bc.CurrentBlockHash(), b.Hash()) || node.bc.CommittedHeight() != 1 {
+		t.Error("Refused commit moved the head")
+	}
+	// and the fork is still there
+	if node.bc.GetBlock(fork.Hash()) == nil || node.bc.GetBlock(forkChild.Hash()) == nil {
+		t.Error("Refused reorg dropped the fork")
+	}
+}
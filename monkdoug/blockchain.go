@@ -2,8 +2,10 @@ package monkdoug
 
 import (
 	"bytes"
+	"fmt"
 	vars "github.com/eris-ltd/eris-std-lib/go-tests"
 	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkcrypto"
 	"github.com/eris-ltd/thelonious/monkstate"
 	"github.com/eris-ltd/thelonious/monkutil"
 	"math/big"
@@ -21,54 +23,323 @@ func (m *StdLibModel) blocktime(state *monkstate.State) int64 {
 	return blockTime
 }
 
-// Who should the next block be mined by?
-// TODO: Accomodate for a dynamic number of miners!
-func (m *StdLibModel) nextCoinbase(prevblock *monkchain.Block) []byte {
-	var nextcoinbase []byte
-	// if its not the genesis block, get coinbase of last block
-	// if it is genesis block, find first entry in linked list
-	if !bytes.Equal(prevblock.PrevHash, monkchain.ZeroHash256) {
-		// i = blockN % nMiners
-		nblocks := prevblock.Number
-		nMiners := vars.GetLinkedListLength(m.doug, "seq:name", prevblock.State())
-		nB := m.base.Mod(nblocks, big.NewInt(int64(nMiners)))
-		n := int(nB.Int64())
-		next, _ := vars.GetLinkedListHead(m.doug, "seq:name", prevblock.State())
-		for i := 0; i < n; i++ {
-			next, _ = vars.GetNextLinkedListElement(m.doug, "seq:name", string(next), prevblock.State())
+// Difficulty of the work required on txs (nil for none)
+func (m *StdLibModel) TxDifficulty(state *monkstate.State) *big.Int {
+	return txDifficulty(m.doug, state)
+}
+
+// The tapow var in gendoug, set from the genesis.json
+func txDifficulty(doug []byte, state *monkstate.State) *big.Int {
+	tapow := monkutil.BigD(vars.GetSingle(doug, "tapow", state)).Int64()
+	if tapow <= 0 {
+		return nil
+	}
+	return monkutil.BigPow(2, int(tapow))
+}
+
+// Blocks between certified checkpoints (0 for none)
+func (m *StdLibModel) CheckpointInterval(state *monkstate.State) uint64 {
+	return monkutil.BigD(vars.GetSingle(m.doug, "checkpointinterval", state)).Uint64()
+}
+
+// Signatures needed to certify a checkpoint (0 for none)
+func (m *StdLibModel) CheckpointQuorum(state *monkstate.State) int {
+	return int(monkutil.BigD(vars.GetSingle(m.doug, "checkpointquorum", state)).Int64())
+}
+
+// Rewards from gendoug, on top of those from the genesis.json.
+// The schedule is only in the genesis.json
+func (m *StdLibModel) RewardRules(state *monkstate.State) *monkchain.RewardRules {
+	return rewardRules(m.g, func(name string) []byte { return vars.GetSingle(m.doug, name, state) })
+}
+
+// Gas rules from gendoug, on top of those from the genesis.json
+func (m *StdLibModel) GasRules(state *monkstate.State) *monkchain.GasRules {
+	return gasRules(m.g, func(name string) []byte { return vars.GetSingle(m.doug, name, state) })
+}
+
+// Rewards from the gendoug vars got by get. Zero vars read as
+// unset, so chains deployed before the "rewards:set" flag keep
+// the genesis values for them. With it, they're zero.
+// Treasury shares above 1 are ignored
+func rewardRules(g *GenesisConfig, get func(name string) []byte) *monkchain.RewardRules {
+	rules := *g.RewardRules()
+	set := len(get("rewards:set")) > 0
+	if reward := get("blockreward"); len(reward) > 0 || set {
+		rules.Reward = monkutil.BigD(reward)
+	}
+	if treasury := get("treasury"); len(treasury) > 0 {
+		addr := monkutil.LeftPadBytes(treasury, 20)
+		rules.Treasury = monkutil.Bytes2Hex(addr[len(addr)-20:])
+	} else if set {
+		rules.Treasury = ""
+	}
+	for name, share := range map[string]*int64{
+		"rewardsharenum": &rules.RewardNum,
+		"rewardshareden": &rules.RewardDen,
+		"feesharenum":    &rules.FeeNum,
+		"feeshareden":    &rules.FeeDen,
+	} {
+		if v := get(name); len(v) > 0 || set {
+			*share = monkutil.BigD(v).Int64()
+		}
+	}
+	if err := rules.Check(); err != nil {
+		douglogger.Errorln("Ignoring treasury shares in gendoug:", err)
+		rules.RewardNum, rules.FeeNum = 0, 0
+	}
+	return &rules
+}
+
+// Gas rules from the gendoug vars got by get. As with the rewards,
+// zero vars only read as zero with the "gas:set" flag
+func gasRules(g *GenesisConfig, get func(name string) []byte) *monkchain.GasRules {
+	rules := *g.GasRules()
+	set := len(get("gas:set")) > 0
+	for name, v := range map[string]**big.Int{
+		"mingasprice": &rules.MinGasPrice,
+		"gaslimit":    &rules.InitialLimit,
+		"gaslimitmin": &rules.Min,
+	} {
+		if b := get(name); len(b) > 0 || set {
+			*v = monkutil.BigD(b)
+		}
+	}
+	for name, v := range map[string]*int64{
+		"gaslimitdivisor":   &rules.Divisor,
+		"gaslimitfactornum": &rules.FactorNum,
+		"gaslimitfactorden": &rules.FactorDen,
+	} {
+		if b := get(name); len(b) > 0 || set {
+			*v = monkutil.BigD(b).Int64()
 		}
-		nextcoinbase = next
-	} else {
-		nextcoinbase, _ = vars.GetLinkedListHead(m.doug, "seq:name", prevblock.State())
-	}
-	return nextcoinbase
-}
-
-// TODO !
-func (m *StdLibModel) CheckUncles(prevBlock, block *monkchain.Block) error {
-	// Check each uncle's previous hash. In order for it to be valid
-	// is if it has the same block hash as the current
-	/*
-		for _, uncle := range block.Uncles {
-			if bytes.Compare(uncle.PrevHash,prevBlock.PrevHash) != 0 {
-				return ValidationError("Mismatch uncle's previous hash. Expected %x, got %x",prevBlock.PrevHash, uncle.PrevHash)
-			}
+	}
+	return &rules
+}
+
+// Number of blocks in an epoch (0 for no epochs)
+func (m *StdLibModel) epoch(state *monkstate.State) uint64 {
+	epochBytes := vars.GetSingle(m.doug, "epoch", state)
+	return monkutil.BigD(epochBytes).Uint64()
+}
+
+// Seconds before a miner's turn passes to the next in line (0 for never)
+func (m *StdLibModel) turnTimeout(state *monkstate.State) int64 {
+	timeoutBytes := vars.GetSingle(m.doug, "turntimeout", state)
+	return monkutil.BigD(timeoutBytes).Int64()
+}
+
+// The miners list from gendoug, in order
+func (m *StdLibModel) miners(state *monkstate.State) [][]byte {
+	nMiners := vars.GetLinkedListLength(m.doug, "seq:name", state)
+	miners := make([][]byte, nMiners)
+	next, _ := vars.GetLinkedListHead(m.doug, "seq:name", state)
+	for i := 0; i < nMiners; i++ {
+		miners[i] = next
+		next, _ = vars.GetNextLinkedListElement(m.doug, "seq:name", string(next), state)
+	}
+	return miners
+}
+
+// First block of the epoch containing the given height.
+// Its state holds the miners for the whole epoch
+func epochStart(height, epoch uint64) uint64 {
+	if epoch == 0 || height == 0 {
+		return 0
+	}
+	return ((height - 1) / epoch) * epoch
+}
+
+// Most epoch blocks we remember, by the hash of the block before
+// the one they're for
+const epochCacheSize = 1024
+
+// The block whose state fixes the miners for the block after prevblock.
+// Without epochs (or a chain to walk back on), that's prevblock itself.
+// We only walk back until we reach a block whose epoch block we know
+func (m *StdLibModel) epochBlock(prevblock *monkchain.Block) *monkchain.Block {
+	epoch := m.epoch(prevblock.State())
+	bc := m.g.ChainManager()
+	if epoch == 0 || bc == nil {
+		return prevblock
+	}
+	start := epochStart(prevblock.Number.Uint64()+1, epoch)
+
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	block := prevblock
+	for block != nil && block.Number.Uint64() > start {
+		if cached, ok := m.epochBlocks[string(block.Hash())]; ok && cached.Number.Uint64() == start {
+			block = cached
+			break
 		}
-	*/
+		block = bc.GetBlock(block.PrevHash)
+	}
+	if block == nil {
+		return prevblock
+	}
+	if len(m.epochBlocks) >= epochCacheSize {
+		m.epochBlocks = make(map[string]*monkchain.Block)
+	}
+	m.epochBlocks[string(prevblock.Hash())] = block
+	return block
+}
+
+// Whose turn is it to mine on top of a parent with the given number and time?
+// Turns go in order of the miners list, one per block.
+// If the turn timeout passes without a block, the turn passes to the
+// next in line (and again each timeout after that)
+func robinTurn(miners [][]byte, parentNumber uint64, parentTime, t, turnTimeout int64) []byte {
+	n := uint64(len(miners))
+	if n == 0 {
+		return nil
+	}
+	i := parentNumber % n
+	if turnTimeout > 0 && t > parentTime {
+		i += uint64((t - parentTime) / turnTimeout)
+	}
+	return miners[i%n]
+}
+
+// Distance from the miner whose turn it is to coinbase, along the miners list.
+// Returns the number of miners if coinbase isn't one
+func robinDistance(miners [][]byte, next, coinbase []byte) int {
+	var start, i int
+	for start = 0; start < len(miners); start++ {
+		if bytes.Equal(miners[start], next) {
+			break
+		}
+	}
+	for i = 0; i < len(miners); i++ {
+		if bytes.Equal(miners[(start+i)%len(miners)], coinbase) {
+			break
+		}
+	}
+	return i
+}
+
+// Who should the block after prevblock, with time t, be mined by?
+// The miners are taken from the start of the epoch, so changes
+// to the list only take effect at epoch boundaries
+func (m *StdLibModel) nextCoinbase(prevblock *monkchain.Block, t int64) []byte {
+	miners := m.miners(m.epochBlock(prevblock).State())
+	timeout := m.turnTimeout(prevblock.State())
+	return robinTurn(miners, prevblock.Number.Uint64(), prevblock.Time, t, timeout)
+}
+
+// Stake of an address, as recorded in gendoug
+func (m *StdLibModel) stake(addr []byte, state *monkstate.State) *big.Int {
+	stakeBytes := vars.GetKeyedArrayElement(m.doug, "stake", monkutil.Bytes2Hex(addr), 0, state)
+	return monkutil.BigD(stakeBytes)
+}
+
+// Everyone in the miners list, with their stakes
+func (m *StdLibModel) stakes(state *monkstate.State) ([][]byte, []*big.Int) {
+	nMiners := vars.GetLinkedListLength(m.doug, "seq:name", state)
+	miners := make([][]byte, nMiners)
+	stakes := make([]*big.Int, nMiners)
+	next, _ := vars.GetLinkedListHead(m.doug, "seq:name", state)
+	for i := 0; i < nMiners; i++ {
+		miners[i] = next
+		stakes[i] = m.stake(next, state)
+		next, _ = vars.GetNextLinkedListElement(m.doug, "seq:name", string(next), state)
+	}
+	return miners, stakes
+}
+
+// Sum of the stake of everyone in the miners list
+func (m *StdLibModel) totalStake(state *monkstate.State) *big.Int {
+	_, stakes := m.stakes(state)
+	return sumStakes(stakes)
+}
+
+// Who should lead the next block in a stake-weighted chain?
+func (m *StdLibModel) stakeLeader(prevblock *monkchain.Block) []byte {
+	miners, stakes := m.stakes(prevblock.State())
+	return pickStakeLeader(prevblock.Hash(), miners, stakes)
+}
+
+func sumStakes(stakes []*big.Int) *big.Int {
+	total := new(big.Int)
+	for _, s := range stakes {
+		total.Add(total, s)
+	}
+	return total
+}
+
+// The sha3 of the parent hash picks a point in [0, total stake),
+// and we walk the miners until the cumulative stake passes it.
+// Anyone can verify the choice from the parent block alone
+func pickStakeLeader(prevHash []byte, miners [][]byte, stakes []*big.Int) []byte {
+	total := sumStakes(stakes)
+	if total.Sign() <= 0 {
+		return nil
+	}
+	seed := monkutil.BigD(monkcrypto.Sha3Bin(prevHash))
+	seed.Mod(seed, total)
+
+	cumulative := new(big.Int)
+	for i, s := range stakes {
+		cumulative.Add(cumulative, s)
+		if cumulative.Cmp(seed) > 0 {
+			return miners[i]
+		}
+	}
 	return nil
 }
 
-func CheckBlockTimes(prevBlock, block *monkchain.Block) error {
-	diff := block.Time - prevBlock.Time
-	if diff < 0 {
-		return monkchain.ValidationError("Block timestamp less then prev block %v (%v - %v)", diff, block.Time, prevBlock.Time)
+// Check a block's uncles against the uncle rules.
+// Each uncle must be unique, have a recent ancestor of the block
+// as its parent, and not already be included in the chain.
+// It must also be a valid header in its own right: signed by its
+// coinbase, with the difficulty the protocol at its height gives it,
+// a valid time, and a valid proof of work
+func CheckUncles(prevBlock, block *monkchain.Block, bc *monkchain.ChainManager, pow monkchain.PoW) error {
+	if len(block.Uncles) == 0 {
+		return nil
+	}
+
+	rules := bc.UncleRules()
+	if len(block.Uncles) > rules.Max {
+		return monkchain.UncleError(fmt.Sprintf("Too many uncles (%d > %d)", len(block.Uncles), rules.Max))
+	}
+	if !block.ValidUncleSha() {
+		return monkchain.UncleError("Uncles do not match the uncle sha")
 	}
 
-	/* XXX
-	// New blocks must be within the 15 minute range of the last block.
-	if diff > int64(15*time.Minute) {
-		return ValidationError("Block is too far in the future of last block (> 15 minutes)")
+	ancestors, included := bc.UncleAncestry(prevBlock, rules.Depth)
+	for _, uncle := range block.Uncles {
+		if err := monkchain.ValidUncle(uncle, prevBlock, ancestors, included); err != nil {
+			return err
+		}
+		uncleParent := bc.GetBlock(uncle.PrevHash)
+		if uncleParent == nil {
+			return monkchain.UncleError(fmt.Sprintf("Uncle's parent is unknown (= %x)", uncle.PrevHash))
+		}
+		if uncle.Number.Cmp(new(big.Int).Add(uncleParent.Number, big.NewInt(1))) != 0 {
+			return monkchain.UncleError(fmt.Sprintf("Uncle number %v does not follow its parent", uncle.Number))
+		}
+		if !bytes.Equal(uncle.Signer(), uncle.Coinbase) {
+			return monkchain.UncleError(fmt.Sprintf("Uncle signed by %x, not its coinbase %x", uncle.Signer(), uncle.Coinbase))
+		}
+		diff := bc.ProtocolAt(uncle.Number).Difficulty(uncle, uncleParent)
+		if diff == nil || uncle.Difficulty.Cmp(diff) != 0 {
+			return monkchain.UncleError(fmt.Sprintf("Uncle difficulty is %v, expected %v", uncle.Difficulty, diff))
+		}
+		if err := CheckBlockTimes(uncleParent, uncle, bc); err != nil {
+			return monkchain.UncleError(fmt.Sprintf("Uncle time is invalid: %v", err))
+		}
+		if !pow.Verify(uncle.HashNoNonce(), uncle.Difficulty, uncle.Nonce) {
+			return monkchain.UncleError(fmt.Sprintf("Uncle's nonce is invalid (= %x)", uncle.Nonce))
+		}
+		included[string(uncle.Hash())] = true
 	}
-	*/
 	return nil
 }
+
+// Check a block's time against the blocks before it
+// and network time, by the time rules in genesis.
+// Blocks a little in the future give a FutureBlockErr
+func CheckBlockTimes(prevBlock, block *monkchain.Block, bc *monkchain.ChainManager) error {
+	return bc.CheckBlockTime(prevBlock, block)
+}
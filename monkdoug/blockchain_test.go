@@ -0,0 +1,134 @@
+package monkdoug
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkdb"
+	"github.com/eris-ltd/thelonious/monkreact"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+// A yes model on a fresh chain
+func newYesTest(t *testing.T) (*YesModel, *monkchain.ChainManager) {
+	g := &GenesisConfig{
+		Address:    "0000000000THISISDOUG",
+		NoGenDoug:  true,
+		Difficulty: 4,
+	}
+	g.Init()
+
+	db, _ := monkdb.NewMemDatabase()
+	if monkutil.Config == nil {
+		monkutil.Config = &monkutil.ConfigManager{}
+	}
+	monkutil.Config.Db = db
+	bc := monkchain.NewChainManagerWithDb(g.Model(), db)
+	bc.SetProcessor(bftProcessor{bc})
+	return NewYesModel(g).(*YesModel), bc
+}
+
+// Find a nonce for the block and sign it
+func sealBlock(block *monkchain.Block, key *monkcrypto.KeyPair, pow monkchain.PoW) {
+	block.Nonce = pow.Search(block, make(chan monkreact.Event))
+	block.Sign(key.PrivateKey)
+}
+
+func TestCheckUncles(t *testing.T) {
+	m, bc := newYesTest(t)
+	genesis := bc.Genesis()
+	key := monkcrypto.GenerateNewKeyPair()
+
+	b1 := childBlock(genesis, key.Address(), genesis.Time)
+	b1.Difficulty = big.NewInt(16)
+	sealBlock(b1, key, m.pow)
+	addBlock(t, bc, b1)
+	b2 := childBlock(b1, key.Address(), genesis.Time)
+	b2.Difficulty = big.NewInt(16)
+	sealBlock(b2, key, m.pow)
+	addBlock(t, bc, b2)
+
+	for name, c := range map[string]struct {
+		mutate func(uncle *monkchain.Block)
+		seal   func(uncle *monkchain.Block)
+		valid  bool
+	}{
+		"valid": {valid: true},
+		"signed by another": {seal: func(uncle *monkchain.Block) {
+			sealBlock(uncle, monkcrypto.GenerateNewKeyPair(), m.pow)
+		}},
+		"wrong difficulty": {mutate: func(uncle *monkchain.Block) {
+			uncle.Difficulty = big.NewInt(32)
+		}},
+		"in the future": {mutate: func(uncle *monkchain.Block) {
+			uncle.Time = monkutil.Now().Unix() + 3600
+		}},
+		"bad nonce": {seal: func(uncle *monkchain.Block) {
+			for i := 0; ; i++ {
+				uncle.Nonce = monkutil.LeftPadBytes(big.NewInt(int64(i)).Bytes(), 32)
+				if !m.pow.Verify(uncle.HashNoNonce(), uncle.Difficulty, uncle.Nonce) {
+					break
+				}
+			}
+			uncle.Sign(key.PrivateKey)
+		}},
+	} {
+		// a sibling of b1
+		uncle := childBlock(genesis, key.Address(), genesis.Time+1)
+		uncle.Difficulty = big.NewInt(16)
+		if c.mutate != nil {
+			c.mutate(uncle)
+		}
+		if c.seal != nil {
+			c.seal(uncle)
+		} else {
+			sealBlock(uncle, key, m.pow)
+		}
+
+		block := childBlock(b2, key.Address(), genesis.Time)
+		block.SetUncles([]*monkchain.Block{uncle})
+		err := m.ValidateBlock(block, bc)
+		if c.valid && err != nil {
+			t.Errorf("%s: expected the uncle to be valid, got %v", name, err)
+		} else if !c.valid && !monkchain.IsUncleErr(err) {
+			t.Errorf("%s: expected an uncle error, got %v", name, err)
+		}
+	}
+}
+
+func TestGendougRules(t *testing.T) {
+	g := &GenesisConfig{
+		Rewards: &monkchain.RewardRules{Reward: big.NewInt(1000), Treasury: "01", RewardNum: 1, RewardDen: 2},
+		Gas:     &monkchain.GasRules{MinGasPrice: big.NewInt(10), InitialLimit: big.NewInt(100000)},
+	}
+	zeroed := map[string][]byte{"rewards:set": {1}, "gas:set": {1}}
+
+	// zeroed vars keep the genesis values without the flags...
+	rewards := rewardRules(g, func(name string) []byte { return nil })
+	if rewards.Reward.Int64() != 1000 || rewards.Treasury != "01" || rewards.RewardNum != 1 {
+		t.Errorf("Expected the genesis rewards, got %+v", rewards)
+	}
+	gas := gasRules(g, func(name string) []byte { return nil })
+	if gas.MinGasPrice.Int64() != 10 || gas.InitialLimit.Int64() != 100000 {
+		t.Errorf("Expected the genesis gas rules, got %+v", gas)
+	}
+
+	// ...and are zero with them
+	rewards = rewardRules(g, func(name string) []byte { return zeroed[name] })
+	if rewards.Reward.Sign() != 0 || rewards.Treasury != "" || rewards.RewardNum != 0 || rewards.RewardDen != 0 {
+		t.Errorf("Expected zeroed rewards, got %+v", rewards)
+	}
+	gas = gasRules(g, func(name string) []byte { return zeroed[name] })
+	if gas.MinGasPrice.Sign() != 0 || gas.InitialLimit.Sign() != 0 {
+		t.Errorf("Expected zeroed gas rules, got %+v", gas)
+	}
+
+	// shares above 1 are dropped
+	bad := map[string][]byte{"rewardsharenum": {3}, "feesharenum": {2}, "feeshareden": {1}}
+	rewards = rewardRules(g, func(name string) []byte { return bad[name] })
+	if rewards.RewardNum != 0 || rewards.FeeNum != 0 {
+		t.Errorf("Expected no treasury shares, got %d/%d and %d/%d", rewards.RewardNum, rewards.RewardDen, rewards.FeeNum, rewards.FeeDen)
+	}
+}
@@ -0,0 +1,170 @@
+package monkdoug
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkutil"
+	"github.com/obscuren/secp256k1-go"
+)
+
+/*
+   Genesis ceremony for chains founded by several parties.
+   Each founder contributes accounts to the genesis.json.
+   Once everyone has contributed, the genesis block is deployed
+   (deterministically, so everyone gets the same block) and each
+   founder signs its hash. The chain id commits to the genesis hash,
+   the founders, and the number of signatures required, and is only
+   valid if at least that many founders have signed.
+
+   The ceremony lives in the genesis.json, but isn't part of the
+   genesis block, so signatures can be added after the fact.
+*/
+
+type Ceremony struct {
+	// Number of founder signatures required
+	Threshold int `json:"threshold"`
+	// Founder addresses (hex)
+	Founders []string `json:"founders"`
+	// Founder signatures of the genesis hash (hex)
+	Signatures []string `json:"signatures"`
+}
+
+// Add a founder and their accounts to the genesis.
+// Any signatures are dropped, since the genesis has changed
+func (g *GenesisConfig) Contribute(founder []byte, accounts []*Account) error {
+	if g.Ceremony == nil {
+		g.Ceremony = &Ceremony{}
+	}
+	if g.Ceremony.isFounder(founder) {
+		return fmt.Errorf("Founder %x has already contributed", founder)
+	}
+
+	known := make(map[string]bool)
+	for _, acc := range g.Accounts {
+		known[string(acc.byteAddr)] = true
+	}
+	for _, acc := range accounts {
+		acc.byteAddr = monkutil.UserHex2Bytes(acc.Address)
+		if known[string(acc.byteAddr)] {
+			return fmt.Errorf("Account %s is already in the genesis", acc.Address)
+		}
+		known[string(acc.byteAddr)] = true
+	}
+
+	g.Accounts = append(g.Accounts, accounts...)
+	g.Ceremony.Founders = append(g.Ceremony.Founders, monkutil.Bytes2Hex(founder))
+	g.Ceremony.Signatures = nil
+	return nil
+}
+
+// Deploy a fresh genesis block from the config.
+// Requires a deterministic deploy so every founder
+// gets the same block
+func (g *GenesisConfig) GenesisBlock() (*monkchain.Block, error) {
+	if g.Unique && g.PrivateKey == "" {
+		return nil, fmt.Errorf("A unique genesis needs a private-key to be reproducible")
+	}
+	block := monkchain.NewBlockFromBytes(monkutil.Encode(monkchain.Genesis))
+	if _, err := g.Deployer(block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Write the config back out (ie. after contributing or signing)
+func (g *GenesisConfig) Save(file string) error {
+	b, err := json.MarshalIndent(g, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0600)
+}
+
+func (c *Ceremony) isFounder(addr []byte) bool {
+	for _, f := range c.Founders {
+		if bytes.Equal(monkutil.UserHex2Bytes(f), addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Chain id committing to the genesis hash, founders, and threshold
+func (c *Ceremony) ChainID(genesisHash []byte) []byte {
+	founders := make([]interface{}, len(c.Founders))
+	for i, f := range c.Founders {
+		founders[i] = monkutil.UserHex2Bytes(f)
+	}
+	data := []interface{}{genesisHash, uint64(c.Threshold), founders}
+	return monkcrypto.Sha3Bin(monkutil.Encode(data))[:20]
+}
+
+// Sign the genesis hash as a founder
+func (c *Ceremony) Sign(genesisHash []byte, keys *monkcrypto.KeyPair) error {
+	sig, err := secp256k1.Sign(genesisHash, keys.PrivateKey)
+	if err != nil {
+		return err
+	}
+	return c.AddSignature(genesisHash, sig)
+}
+
+// Add a founder's signature of the genesis hash
+func (c *Ceremony) AddSignature(genesisHash, sig []byte) error {
+	signer := genesisSigner(genesisHash, sig)
+	if signer == nil || !c.isFounder(signer) {
+		return fmt.Errorf("Signature is not from a founder (%x)", signer)
+	}
+	for _, s := range c.Signatures {
+		if bytes.Equal(genesisSigner(genesisHash, monkutil.Hex2Bytes(s)), signer) {
+			return fmt.Errorf("Founder %x has already signed", signer)
+		}
+	}
+	c.Signatures = append(c.Signatures, monkutil.Bytes2Hex(sig))
+	return nil
+}
+
+// Addresses of the distinct founders who signed the genesis hash
+func (c *Ceremony) Signers(genesisHash []byte) [][]byte {
+	seen := make(map[string]bool)
+	signers := [][]byte{}
+	for _, s := range c.Signatures {
+		signer := genesisSigner(genesisHash, monkutil.Hex2Bytes(s))
+		if signer == nil || seen[string(signer)] || !c.isFounder(signer) {
+			continue
+		}
+		seen[string(signer)] = true
+		signers = append(signers, signer)
+	}
+	return signers
+}
+
+// Check the chain id commits to this ceremony and genesis,
+// and that enough founders signed
+func (c *Ceremony) Validate(chainId, genesisHash []byte) error {
+	if c.Threshold < 1 || c.Threshold > len(c.Founders) {
+		return fmt.Errorf("Invalid ceremony threshold %d of %d founders", c.Threshold, len(c.Founders))
+	}
+	if id := c.ChainID(genesisHash); !bytes.Equal(id, chainId) {
+		return fmt.Errorf("ChainID %x does not match the genesis ceremony (%x)", chainId, id)
+	}
+	if n := len(c.Signers(genesisHash)); n < c.Threshold {
+		return fmt.Errorf("Genesis signed by %d founders, %d required", n, c.Threshold)
+	}
+	return nil
+}
+
+func genesisSigner(hash, sig []byte) []byte {
+	if len(sig) != 65 {
+		return nil
+	}
+	pubkey, _ := secp256k1.RecoverPubkey(hash, sig)
+	if len(pubkey) == 0 || pubkey[0] != 4 {
+		return nil
+	}
+	return monkcrypto.Sha3Bin(pubkey[1:])[12:]
+}
@@ -0,0 +1,49 @@
+package monkdoug
+
+import (
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+func TestCeremony(t *testing.T) {
+	g := &GenesisConfig{}
+	founders := []*monkcrypto.KeyPair{monkcrypto.GenerateNewKeyPair(), monkcrypto.GenerateNewKeyPair(), monkcrypto.GenerateNewKeyPair()}
+	for _, f := range founders {
+		acc := &Account{Address: monkutil.Bytes2Hex(f.Address()), Balance: "1000"}
+		if err := g.Contribute(f.Address(), []*Account{acc}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Contribute(founders[0].Address(), nil); err == nil {
+		t.Error("Expected an error for a founder contributing twice")
+	}
+	g.Ceremony.Threshold = 2
+
+	hash := monkcrypto.Sha3Bin([]byte("genesis"))
+	chainId := g.Ceremony.ChainID(hash)
+
+	if err := g.Ceremony.Sign(hash, founders[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Ceremony.Sign(hash, founders[0]); err == nil {
+		t.Error("Expected an error for a founder signing twice")
+	}
+	if err := g.Ceremony.Sign(hash, monkcrypto.GenerateNewKeyPair()); err == nil {
+		t.Error("Expected an error for a signature from a non-founder")
+	}
+	if err := g.Ceremony.Validate(chainId, hash); err == nil {
+		t.Error("Expected an error below the threshold")
+	}
+
+	if err := g.Ceremony.Sign(hash, founders[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Ceremony.Validate(chainId, hash); err != nil {
+		t.Error(err)
+	}
+	if err := g.Ceremony.Validate(chainId, monkcrypto.Sha3Bin([]byte("other"))); err == nil {
+		t.Error("Expected an error for a different genesis")
+	}
+}
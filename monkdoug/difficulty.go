@@ -40,25 +40,42 @@ func (m *StdLibModel) RoundRobinDifficulty(block, parent *monkchain.Block) *big.
 	blockTime := monkutil.BigD(blockTimeBytes).Int64()
 	// adjust difficulty in pursuit of holy target block time
 	newdiff = adjustDifficulty(newdiff, parent.Time, block.Time, blockTime)
-	// find relative position of coinbase in the linked list (i)
+	// find relative position of coinbase in the miners list (i)
 	// difficulty should be (base difficulty)*2^i
-	var i int
-	nMiners := vars.GetLinkedListLength(m.doug, "seq:name", state)
+	miners := m.miners(m.epochBlock(parent).State())
 	// this is the proper next coinbase
-	next := m.nextCoinbase(parent)
-	for i = 0; i < nMiners; i++ {
-		if bytes.Equal(next, block.Coinbase) {
-			break
-		}
-		next, _ = vars.GetNextLinkedListElement(m.doug, "seq:name", string(next), state)
-	}
+	next := m.nextCoinbase(parent, block.Time)
+	i := robinDistance(miners, next, block.Coinbase)
 	newdiff = big.NewInt(0).Mul(monkutil.BigPow(2, i), newdiff)
 	return newdiff
 }
 
+// Difficulty for miners weighted by stake.
+// Returns nil if the coinbase has no stake (see stakeDifficulty)
 func (m *StdLibModel) StakeDifficulty(block, parent *monkchain.Block) *big.Int {
-	//TODO
-	return nil
+	state := parent.State()
+	// get base difficulty
+	newdiff := m.baseDifficulty(state)
+	// adjust difficulty in pursuit of holy target block time
+	newdiff = adjustDifficulty(newdiff, parent.Time, block.Time, m.blocktime(state))
+
+	leader := bytes.Equal(m.stakeLeader(parent), block.Coinbase)
+	return stakeDifficulty(newdiff, m.stake(block.Coinbase, state), m.totalStake(state), leader)
+}
+
+// The leader for the block (see stakeLeader) mines at the base difficulty.
+// Everyone else mines at (base difficulty)*(total stake)/(their stake),
+// so the chain keeps moving if the leader is offline, but those with
+// more stake get there first. Returns nil without stake
+func stakeDifficulty(base, stake, total *big.Int, leader bool) *big.Int {
+	if stake.Sign() <= 0 {
+		return nil
+	}
+	if leader {
+		return base
+	}
+	newdiff := new(big.Int).Mul(base, total)
+	return newdiff.Div(newdiff, stake)
 }
 
 // difficulty targets a specific block time
@@ -0,0 +1,218 @@
+package monkdoug
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkreact"
+	"github.com/eris-ltd/thelonious/monkvm"
+)
+
+/*
+   Protocol upgrades scheduled in the genesis.json.
+   At a fork's height the chain may switch consensus model,
+   change gas costs, or disable vm opcodes. Anything not set
+   in a fork is carried over from the one before it, eg.
+
+   "forks":[
+       {"height":1000, "model":"bft"},
+       {"height":5000, "gas":{"sstore":200}, "disabled-opcodes":["CALLSTATELESS"]},
+       {"height":8000, "vm-block-env":true}
+   ]
+
+   Forks are only scheduled in the genesis.json, not through gendoug:
+   every node has to know the protocol at a height without first
+   trusting a state that the protocol itself is meant to validate.
+*/
+
+type Fork struct {
+	// Block number the fork takes effect
+	Height uint64 `json:"height"`
+	// New consensus model (empty to keep the current one)
+	ModelName string `json:"model"`
+	// Options for the new model
+	ModelOptions json.RawMessage `json:"model-options,omitempty"`
+	// Gas costs to change, by name (eg. "sstore")
+	Gas map[string]int64 `json:"gas,omitempty"`
+	// Opcodes that may no longer be run
+	DisabledOps []string `json:"disabled-opcodes,omitempty"`
+	// Show the vm contracts the block's values from here on
+	VmBlockEnv bool `json:"vm-block-env,omitempty"`
+}
+
+type forksByHeight []*Fork
+
+func (f forksByHeight) Len() int           { return len(f) }
+func (f forksByHeight) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f forksByHeight) Less(i, j int) bool { return f[i].Height < f[j].Height }
+
+func sortForks(forks []*Fork) forksByHeight {
+	sorted := make(forksByHeight, len(forks))
+	copy(sorted, forks)
+	sort.Sort(sorted)
+	return sorted
+}
+
+// Forks must be at distinct heights above 0 and name
+// only known models, gas costs and opcodes
+func checkForks(forks []*Fork) error {
+	var height uint64
+	for _, fork := range sortForks(forks) {
+		if fork.Height == 0 || fork.Height == height {
+			return fmt.Errorf("Fork at height %d: forks must be at distinct heights above 0", fork.Height)
+		}
+		height = fork.Height
+
+		if fork.ModelName != "" {
+			if _, ok := LookupModel(fork.ModelName); !ok {
+				return fmt.Errorf("Fork at height %d: unknown model %s", fork.Height, fork.ModelName)
+			}
+		}
+		rules := monkvm.DefaultRules()
+		for name, cost := range fork.Gas {
+			if err := rules.Gas.Set(name, big.NewInt(cost)); err != nil {
+				return fmt.Errorf("Fork at height %d: %v", fork.Height, err)
+			}
+		}
+		for _, name := range fork.DisabledOps {
+			if err := rules.Disable(name); err != nil {
+				return fmt.Errorf("Fork at height %d: %v", fork.Height, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Build the protocols pinned at each fork height.
+// The first is the protocol from genesis
+func newForks(g *GenesisConfig, consensus monkchain.Consensus) ([]*Protocol, error) {
+	if err := checkForks(g.Forks); err != nil {
+		return nil, err
+	}
+	forks := []*Protocol{&Protocol{g: g, consensus: consensus, blockEnv: g.VmBlockEnv}}
+
+	for _, fork := range sortForks(g.Forks) {
+		prev := forks[len(forks)-1]
+		p := &Protocol{g: prev.g, consensus: prev.consensus, vmRules: prev.vmRules, blockEnv: prev.blockEnv || fork.VmBlockEnv, height: fork.Height}
+
+		if fork.ModelName != "" {
+			// models read their name and options from the config
+			fg := *g
+			fg.ModelName = fork.ModelName
+			fg.ModelOptions = fork.ModelOptions
+			fg.NoGenDoug = false
+			g.forkConfigs = append(g.forkConfigs, &fg)
+			p.g = &fg
+			model, err := NewPermModel(&fg)
+			if err != nil {
+				return nil, fmt.Errorf("Fork at height %d: %v", fork.Height, err)
+			}
+			p.consensus = model
+		}
+
+		if len(fork.Gas) > 0 || len(fork.DisabledOps) > 0 {
+			rules := monkvm.DefaultRules()
+			if prev.vmRules != nil {
+				rules = prev.vmRules.Copy()
+			}
+			for name, cost := range fork.Gas {
+				rules.Gas.Set(name, big.NewInt(cost))
+			}
+			for _, name := range fork.DisabledOps {
+				rules.Disable(name)
+			}
+			p.vmRules = rules
+		}
+		forks = append(forks, p)
+	}
+	return forks, nil
+}
+
+// The protocol pinned at the height of a block number
+func (p *Protocol) at(number *big.Int) *Protocol {
+	if len(p.forks) == 0 || number == nil {
+		return p
+	}
+	for i := len(p.forks) - 1; i > 0; i-- {
+		if number.Uint64() >= p.forks[i].height {
+			return p.forks[i]
+		}
+	}
+	return p.forks[0]
+}
+
+// The protocol for the next block on the canonical chain
+func (p *Protocol) next() *Protocol {
+	bc := p.g.ChainManager()
+	if len(p.forks) == 0 || bc == nil {
+		return p
+	}
+	return p.at(new(big.Int).SetUint64(bc.CurrentBlockNumber() + 1))
+}
+
+func (p *Protocol) ProtocolAt(number *big.Int) monkchain.Protocol {
+	return p.at(number)
+}
+
+// Gas costs and disabled opcodes (nil for defaults)
+func (p *Protocol) VmRules() *monkvm.Rules {
+	return p.vmRules
+}
+
+// Run the engine of the model for the next block,
+// switching engines when a new head crosses a fork
+func (p *Protocol) engineLoop() {
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-p.events:
+			p.switchEngine()
+		}
+	}
+}
+
+func (p *Protocol) switchEngine() {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	select {
+	case <-p.quit:
+		return
+	default:
+	}
+
+	engine, _ := p.next().consensus.(monkchain.ConsensusEngine)
+	if engine == p.engine {
+		return
+	}
+	if p.engine != nil {
+		p.engine.Stop()
+	}
+	if engine != nil {
+		engine.Start(p.th)
+	}
+	p.engine = engine
+}
+
+func (p *Protocol) startForks(th monkchain.NodeManager) {
+	p.th = th
+	p.quit = make(chan bool)
+	p.events = make(chan monkreact.Event, 1)
+	th.Reactor().Subscribe("newBlock", p.events)
+	p.switchEngine()
+	go p.engineLoop()
+}
+
+func (p *Protocol) stopForks() {
+	p.th.Reactor().Unsubscribe("newBlock", p.events)
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	close(p.quit)
+	if p.engine != nil {
+		p.engine.Stop()
+		p.engine = nil
+	}
+}
@@ -0,0 +1,175 @@
+package monkdoug
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkdb"
+	"github.com/eris-ltd/thelonious/monkstate"
+	"github.com/eris-ltd/thelonious/monktrie"
+	"github.com/eris-ltd/thelonious/monkutil"
+	"github.com/eris-ltd/thelonious/monkvm"
+)
+
+func TestForkSchedule(t *testing.T) {
+	g := &GenesisConfig{
+		ModelName: "yes",
+		Forks: []*Fork{
+			{Height: 20, Gas: map[string]int64{"tx": 1000}},
+			{Height: 10, ModelName: "no", Gas: map[string]int64{"sstore": 200}, DisabledOps: []string{"create"}},
+		},
+	}
+	protocol, err := NewProtocol(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := protocol.(*Protocol)
+
+	if _, ok := p.at(big.NewInt(9)).consensus.(*YesModel); !ok {
+		t.Error("Expected the yes model before the first fork")
+	}
+	if p.at(big.NewInt(9)).vmRules != nil {
+		t.Error("Expected default vm rules before the first fork")
+	}
+
+	for _, n := range []int64{10, 25} {
+		fork := p.at(big.NewInt(n))
+		if _, ok := fork.consensus.(*NoModel); !ok {
+			t.Errorf("Expected the no model at block %d", n)
+		}
+		rules := fork.vmRules
+		if rules.Gas.SStore.Int64() != 200 || !rules.Disabled[monkvm.CREATE] {
+			t.Errorf("Fork rules missing at block %d", n)
+		}
+	}
+
+	rules := p.at(big.NewInt(20)).vmRules
+	if rules.Gas.Tx.Int64() != 1000 {
+		t.Error("Expected tx gas of 1000, got", rules.Gas.Tx)
+	}
+	if p.at(big.NewInt(19)).vmRules.Gas.Tx.Cmp(monkvm.GasTx) != 0 {
+		t.Error("Second fork changed the rules of the first")
+	}
+}
+
+func TestForkPerms(t *testing.T) {
+	g := &GenesisConfig{
+		ModelName: "yes",
+		Forks:     []*Fork{{Height: 10, ModelName: "no"}},
+	}
+	protocol, err := NewProtocol(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := protocol.(*Protocol)
+
+	db, _ := monkdb.NewMemDatabase()
+	if monkutil.Config == nil {
+		monkutil.Config = &monkutil.ConfigManager{}
+	}
+	monkutil.Config.Db = db
+
+	// checked by the model at the block's height, wherever the head is
+	for n, allowed := range map[int64]bool{1: true, 9: true, 10: false, 25: false} {
+		block := monkchain.CreateBlock(nil, nil, nil, big.NewInt(1), nil, "")
+		block.Number = big.NewInt(n)
+		errs := []error{
+			p.ProtocolAt(block.Number).ValidatePerm(nil, "mine", nil),
+			p.ProtocolAt(block.Number).ValidateTx(nil, nil),
+			NewEnv(nil, nil, block, p).DougValidate(nil, "mine", nil),
+		}
+		for i, err := range errs {
+			if (err == nil) != allowed {
+				t.Errorf("Check %d at block %d gave %v", i, n, err)
+			}
+		}
+	}
+	if err := p.ValidatePerm(nil, "mine", nil); err != nil {
+		t.Error("Expected the genesis model without a height, got", err)
+	}
+}
+
+func TestCheckForks(t *testing.T) {
+	for i, forks := range [][]*Fork{
+		{{Height: 0, ModelName: "no"}},
+		{{Height: 10, ModelName: "no"}, {Height: 10, Gas: map[string]int64{"tx": 1000}}},
+		{{Height: 10, ModelName: "nonsense"}},
+		{{Height: 10, Gas: map[string]int64{"nonsense": 1}}},
+		{{Height: 10, DisabledOps: []string{"nonsense"}}},
+	} {
+		g := &GenesisConfig{ModelName: "yes", Forks: forks}
+		if err := g.Check(); err == nil {
+			t.Errorf("Expected forks %d to fail the check", i)
+		}
+		if _, err := NewProtocol(g); err == nil {
+			t.Errorf("Expected forks %d to fail building the protocol", i)
+		}
+	}
+
+	g := &GenesisConfig{ModelName: "yes", Forks: []*Fork{{Height: 10, ModelName: "no", Gas: map[string]int64{"sstore": 200}, DisabledOps: []string{"create"}}}}
+	if err := g.Check(); err != nil {
+		t.Error("Expected a valid fork to pass, got", err)
+	}
+}
+
+func TestForkBlockEnv(t *testing.T) {
+	db, _ := monkdb.NewMemDatabase()
+	if monkutil.Config == nil {
+		monkutil.Config = &monkutil.ConfigManager{}
+	}
+	monkutil.Config.Db = db
+
+	g := &GenesisConfig{
+		Address:   "0000000000THISISDOUG",
+		ModelName: "vm",
+		Forks:     []*Fork{{Height: 10, VmBlockEnv: true}},
+	}
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	m := NewVmModel(g).(*VmModel)
+
+	// a contract returning the coinbase plus the time
+	code := []byte{
+		byte(monkvm.COINBASE), byte(monkvm.TIMESTAMP), byte(monkvm.ADD),
+		byte(monkvm.PUSH1), 0, byte(monkvm.MSTORE),
+		byte(monkvm.PUSH1), 32, byte(monkvm.PUSH1), 0, byte(monkvm.RETURN),
+	}
+	state := monkstate.New(monktrie.New(db, ""))
+	obj := state.GetOrNewStateObject([]byte("0000000000000CONTRACT"))
+	obj.Code = code
+
+	for n, zeros := range map[int64]bool{1: true, 9: true, 10: false, 25: false} {
+		block := monkchain.CreateBlock(nil, nil, []byte{7}, big.NewInt(1), nil, "")
+		block.Number = big.NewInt(n)
+		block.Time = 100
+		ret, err := m.evmCall(code, nil, obj, state, nil, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// old contracts see no block before the fork
+		expected := int64(107)
+		if zeros {
+			expected = 0
+		}
+		if got := monkutil.BigD(ret).Int64(); got != expected {
+			t.Errorf("Contract at block %d returned %d, expected %d", n, got, expected)
+		}
+		env := NewEnv(state, nil, block, g.protocol)
+		if (env.BlockNumber() == nil) != zeros || (env.Difficulty() == nil) != zeros {
+			t.Errorf("Block values at block %d, expected zeros %v", n, zeros)
+		}
+	}
+
+	// a new chain can have them from genesis
+	g = &GenesisConfig{Address: "0000000000THISISDOUG", ModelName: "vm", VmBlockEnv: true}
+	if err := g.Init(); err != nil {
+		t.Fatal(err)
+	}
+	block := monkchain.CreateBlock(nil, nil, nil, big.NewInt(1), nil, "")
+	block.Number = big.NewInt(1)
+	if NewEnv(state, nil, block, g.protocol).BlockNumber() == nil {
+		t.Error("Expected block values from genesis")
+	}
+}
@@ -44,10 +44,35 @@ type GenesisConfig struct {
 	Unique bool `json:"unique"`
 	// A private key to seed uniqueness (otherwise is random)
 	PrivateKey string `json:"private-key"`
-	// Name of the gendoug access model (yes, no, std, vm, eth)
+	// Name of the gendoug access model (yes, no, std, vm, eth, bft,
+	// or any other registered model)
 	ModelName string `json:"model"`
+	// Options for the model (json, unmarshalled by the model)
+	ModelOptions json.RawMessage `json:"model-options,omitempty"`
 	// Turn off gendoug
 	NoGenDoug bool `json:"no-gendoug"`
+	// Proof of work for blocks (easy, dagger, none, or any other
+	// registered pow) and its options (json, eg. {"levels":3} for dagger)
+	PoWName    string          `json:"pow"`
+	PoWOptions json.RawMessage `json:"pow-options,omitempty"`
+	// How miners fill blocks (price, fifo, fair, or any
+	// other registered tx selector)
+	TxSelectorName string `json:"tx-selector"`
+	// Uncle depth, max per block, and rewards (defaults if not set)
+	Uncles *monkchain.UncleRules `json:"uncles,omitempty"`
+	// Block reward, its schedule by height, and the treasury's
+	// shares of rewards and fees (defaults if not set)
+	Rewards *monkchain.RewardRules `json:"rewards,omitempty"`
+	// Min gas price, and the block gas limit and how
+	// it adjusts (defaults if not set)
+	Gas *monkchain.GasRules `json:"gas,omitempty"`
+	// Future drift, median-time-past span and peer offset
+	// bounds for block timestamps (defaults if not set)
+	Times *monkchain.TimeRules `json:"times,omitempty"`
+	// Protocol upgrades scheduled by height
+	Forks []*Fork `json:"forks,omitempty"`
+	// Founders and their signatures of the genesis (multi-party chains)
+	Ceremony *Ceremony `json:"ceremony,omitempty"`
 
 	/*
 	   Global GenDoug Singles
@@ -64,13 +89,26 @@ type GenesisConfig struct {
 	PublicTx int `json:"public:tx"`
 	// Max gas per tx
 	MaxGasTx string `json:"maxgastx"`
-	// Proof of work difficulty for transactions/
+	// Proof of work difficulty for transactions (2^tapow, 0 for none)
 	TaPoW int `json:"tapow"`
 	// Target block time (shaky...)
 	BlockTime int `json:"blocktime"`
+	// Number of blocks between changes to the miners list (robin)
+	Epoch int `json:"epoch"`
+	// Seconds before a miner's turn passes to the next (robin)
+	TurnTimeout int `json:"turntimeout"`
+	// Blocks between certified checkpoints (0 for none)
+	CheckpointInterval int `json:"checkpointinterval"`
+	// Signatures from accounts with the checkpoint permission
+	// needed to certify a checkpoint
+	CheckpointQuorum int `json:"checkpointquorum"`
 
 	// Paths to lll consensus contracts (if ModelName = vm)
 	Vm *VmConsensus `json:"vm"`
+	// Show the vm contracts the block's number, prevhash, coinbase,
+	// time and difficulty. Off, they see zeros, as they always
+	// did before (turn it on for a running chain with a fork)
+	VmBlockEnv bool `json:"vm-block-env"`
 
 	// Accounts (permissions and stake)
 	Accounts []*Account `json:"accounts"`
@@ -88,8 +126,14 @@ type GenesisConfig struct {
 	// Signed genesis block (hex)
 	chainId string
 
+	// configs for models introduced by forks
+	forkConfigs []*GenesisConfig
+
 	// so we can register a deployer function (which might import monkdoug)
 	deployer func(block *monkchain.Block) ([]byte, error)
+
+	// the chain, for models that look back past the parent
+	chainManager *monkchain.ChainManager
 }
 
 // A protocol level call executed through the vm
@@ -133,8 +177,21 @@ func (g *GenesisConfig) Model() monkchain.Protocol {
 	return g.protocol
 }
 
-func (g *GenesisConfig) SetModel() {
-	g.protocol = NewProtocol(g)
+func (g *GenesisConfig) SetModel() error {
+	p, err := NewProtocol(g)
+	if err != nil {
+		return err
+	}
+	g.setProtocol(p)
+	return nil
+}
+
+// Fork configs share the protocol and chain manager
+func (g *GenesisConfig) setProtocol(p monkchain.Protocol) {
+	g.protocol = p
+	for _, fg := range g.forkConfigs {
+		fg.protocol = p
+	}
 }
 
 func (g *GenesisConfig) Deployer(block *monkchain.Block) ([]byte, error) {
@@ -145,6 +202,18 @@ func (g *GenesisConfig) SetDeployer(f func(block *monkchain.Block) ([]byte, erro
 	g.deployer = f
 }
 
+func (g *GenesisConfig) ChainManager() *monkchain.ChainManager {
+	return g.chainManager
+}
+
+// Give the models access to the chain (for epochs)
+func (g *GenesisConfig) SetChainManager(bc *monkchain.ChainManager) {
+	g.chainManager = bc
+	for _, fg := range g.forkConfigs {
+		fg.chainManager = bc
+	}
+}
+
 // Load the genesis block info from genesis.json
 func LoadGenesis(file string) *GenesisConfig {
 	douglogger.Infoln("Loading genesis config:", file)
@@ -166,23 +235,63 @@ func LoadGenesis(file string) *GenesisConfig {
 		acc.byteAddr = monkutil.UserHex2Bytes(acc.Address)
 	}
 
-	g.byteAddr = []byte(g.Address)
-	g.hexAddr = monkutil.Bytes2Hex(g.byteAddr)
-	g.contractPath = path.Join(utils.ErisLtd, "eris-std-lib")
+	if err := g.Check(); err != nil {
+		fmt.Println("bad genesis.json", err)
+		os.Exit(0)
+	}
 
-	g.Init()
+	if err := g.Init(); err != nil {
+		fmt.Println("bad genesis.json", err)
+		os.Exit(0)
+	}
 
 	return g
 }
 
+// Report settings that can't be used, rather than
+// finding out once the chain is running
+func (g *GenesisConfig) Check() error {
+	if _, ok := LookupModel(g.modelName()); !ok {
+		return fmt.Errorf("Unknown model %s", g.ModelName)
+	}
+	if _, err := g.newPoW(); err != nil {
+		return err
+	}
+	if _, err := g.txSelector(); err != nil {
+		return err
+	}
+	if err := g.RewardRules().Check(); err != nil {
+		return err
+	}
+	if err := checkForks(g.Forks); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Add an account to a GenesisConfig built in code
+func (g *GenesisConfig) AddAccount(acc *Account) {
+	acc.byteAddr = monkutil.UserHex2Bytes(acc.Address)
+	g.Accounts = append(g.Accounts, acc)
+}
+
 // Initialize the Protocol and Deployer for a populated GenesisConfig
-func (g *GenesisConfig) Init() {
+func (g *GenesisConfig) Init() error {
+	g.byteAddr = []byte(g.Address)
+	g.hexAddr = monkutil.Bytes2Hex(g.byteAddr)
+	if g.contractPath == "" {
+		g.contractPath = path.Join(utils.ErisLtd, "eris-std-lib")
+	}
+
 	// set doug model
-	g.protocol = NewProtocol(g)
+	if err := g.SetModel(); err != nil {
+		return err
+	}
 
 	// set default deploy function
 	douglogger.Debugf("Setting Doug Deployer: NoGenDoug=%v, ModelName=%s, Path=%s", g.NoGenDoug, g.ModelName, g.DougPath)
 	g.SetDeployer(g.Deploy)
+	return nil
 }
 
 // Deploy the genesis block
@@ -243,6 +352,12 @@ func (g *GenesisConfig) chainIdFromBlock(block *monkchain.Block, keys *monkcrypt
 	douglogger.Debugf("Using signing address %x for deploy\n", keys.Address())
 	sig := block.Sign(keys.PrivateKey)
 	chainId := monkcrypto.Sha3Bin(sig)[:20]
+	// multi-party chains commit to the founders instead
+	// (and the final state root)
+	if g.Ceremony != nil {
+		block.State().Update()
+		chainId = g.Ceremony.ChainID(block.Hash())
+	}
 	g.chainId = monkutil.Bytes2Hex(chainId)
 	return chainId
 }
@@ -269,7 +384,7 @@ func (g *GenesisConfig) bankRollAndPerms(keys *monkcrypto.KeyPair, block *monkch
 			// issue txs to set perms according to the model
 			SetPermissions(g.byteAddr, account.byteAddr, account.Permissions, block, keys)
 			if account.Permissions["mine"] != 0 {
-				SetValue(g.byteAddr, []string{"addminer", account.Name, "0x" + account.Address, "0x" + strconv.Itoa(account.Stake)}, keys, block)
+				SetValue(g.byteAddr, []string{"addminer", account.Name, "0x" + account.Address, hexNum(big.NewInt(int64(account.Stake)))}, keys, block)
 			}
 			douglogger.Debugln("Setting permissions for ", account.Address)
 		}
@@ -309,7 +424,53 @@ func (g *GenesisConfig) setValues(keys *monkcrypto.KeyPair, block *monkchain.Blo
 	SetValue(g.byteAddr, []string{"initvar", "public:create", "single", "0x" + strconv.Itoa(g.PublicCreate)}, keys, block)
 	SetValue(g.byteAddr, []string{"initvar", "public:tx", "single", "0x" + strconv.Itoa(g.PublicTx)}, keys, block)
 	SetValue(g.byteAddr, []string{"initvar", "maxgastx", "single", g.MaxGasTx}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "tapow", "single", hexNum(big.NewInt(int64(g.TaPoW)))}, keys, block)
 	SetValue(g.byteAddr, []string{"initvar", "blocktime", "single", "0x" + strconv.Itoa(g.BlockTime)}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "epoch", "single", hexNum(big.NewInt(int64(g.Epoch)))}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "turntimeout", "single", hexNum(big.NewInt(int64(g.TurnTimeout)))}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "checkpointinterval", "single", hexNum(big.NewInt(int64(g.CheckpointInterval)))}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "checkpointquorum", "single", hexNum(big.NewInt(int64(g.CheckpointQuorum)))}, keys, block)
+
+	rewards := g.RewardRules()
+	SetValue(g.byteAddr, []string{"initvar", "blockreward", "single", hexNum(rewards.BlockReward(big.NewInt(0)))}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "treasury", "single", hexNum(monkutil.BigD(rewards.TreasuryAddress()))}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "rewardsharenum", "single", hexNum(big.NewInt(rewards.RewardNum))}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "rewardshareden", "single", hexNum(big.NewInt(rewards.RewardDen))}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "feesharenum", "single", hexNum(big.NewInt(rewards.FeeNum))}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "feeshareden", "single", hexNum(big.NewInt(rewards.FeeDen))}, keys, block)
+	// the rules are all in gendoug, even those that are zero
+	SetValue(g.byteAddr, []string{"initvar", "rewards:set", "single", "0x01"}, keys, block)
+
+	gas, limit, min := g.GasRules(), new(big.Int), new(big.Int)
+	if gas.InitialLimit != nil {
+		limit = gas.InitialLimit
+	}
+	if gas.Min != nil {
+		min = gas.Min
+	}
+	SetValue(g.byteAddr, []string{"initvar", "mingasprice", "single", hexNum(gas.MinPrice())}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "gaslimit", "single", hexNum(limit)}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "gaslimitdivisor", "single", hexNum(big.NewInt(gas.Divisor))}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "gaslimitfactornum", "single", hexNum(big.NewInt(gas.FactorNum))}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "gaslimitfactorden", "single", hexNum(big.NewInt(gas.FactorDen))}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "gaslimitmin", "single", hexNum(min)}, keys, block)
+	SetValue(g.byteAddr, []string{"initvar", "gas:set", "single", "0x01"}, keys, block)
+}
+
+// The gas rules from the genesis.json, or the defaults
+func (g *GenesisConfig) GasRules() *monkchain.GasRules {
+	if g.Gas == nil {
+		return monkchain.DefaultGasRules
+	}
+	return g.Gas
+}
+
+// The rewards from the genesis.json, or the defaults
+func (g *GenesisConfig) RewardRules() *monkchain.RewardRules {
+	if g.Rewards == nil {
+		return monkchain.DefaultRewardRules
+	}
+	return g.Rewards
 }
 
 // Options for hooking consensus to the vm
@@ -407,44 +568,90 @@ func AddAccount(addr []byte, balance string, block *monkchain.Block) {
 }
 
 //
-func NewProtocol(g *GenesisConfig) monkchain.Protocol {
-	consensus := NewPermModel(g)
-	p := &Protocol{g: g, consensus: consensus}
-	return p
+func NewProtocol(g *GenesisConfig) (monkchain.Protocol, error) {
+	consensus, err := NewPermModel(g)
+	if err != nil {
+		return nil, err
+	}
+	p := &Protocol{g: g, consensus: consensus, blockEnv: g.VmBlockEnv}
+	if len(g.Forks) > 0 {
+		if p.forks, err = newForks(g, consensus); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
 }
 
-// Return a new permissions model
-// Only "std" and "vm" care about gendoug
-// NoGendoug defaults to the "yes" model
-func NewPermModel(g *GenesisConfig) (model monkchain.Consensus) {
-	modelName := g.ModelName
-	if g.NoGenDoug {
-		modelName = "yes"
-	}
-	switch modelName {
-	case "std":
-		// gendoug-v2
-		// uses eris-std-lib/gotests/vars for reading
-		// from gendoug
-		model = NewStdLibModel(g)
-	case "vm":
-		// run processing through the vm
-		model = NewVmModel(g)
-	case "yes":
-		// everyone allowed everything
-		model = NewYesModel(g)
-	case "no":
-		// noone allowed anything
-		model = NewNoModel(g)
-	case "eth":
-		// ethereum
+// Return a new permissions model from the registry
+// Only "std", "vm", and "bft" care about gendoug
+// NoGendoug and an unset model are the "yes" model.
+// An unknown model is an error
+func NewPermModel(g *GenesisConfig) (monkchain.Consensus, error) {
+	modelName := g.modelName()
+	if modelName == "eth" {
+		// no gendoug for ethereum
 		g.NoGenDoug = true
-		model = NewEthModel(g)
-	default:
-		// default to yes
-		model = NewYesModel(g)
 	}
-	return
+	constructor, ok := LookupModel(modelName)
+	if !ok {
+		return nil, fmt.Errorf("Unknown model %s", modelName)
+	}
+	return constructor(g)
+}
+
+// The registered model to run
+func (g *GenesisConfig) modelName() string {
+	if g.NoGenDoug || g.ModelName == "" {
+		return "yes"
+	}
+	return g.ModelName
+}
+
+// A new instance of the proof of work from the registry ("easy" if
+// unset), or nil if it's unknown or its options are bad (see Check)
+func (g *GenesisConfig) NewPoW() monkchain.PoW {
+	pow, _ := g.newPoW()
+	return pow
+}
+
+func (g *GenesisConfig) newPoW() (monkchain.PoW, error) {
+	name := g.PoWName
+	if name == "" {
+		name = "easy"
+	}
+	pow, err := monkchain.NewPoW(name, g.PoWOptions)
+	if err != nil {
+		return nil, fmt.Errorf("Bad pow %s: %v", name, err)
+	}
+	return pow, nil
+}
+
+// The tx selector from the registry ("price" if unset),
+// or nil if it's unknown (see Check)
+func (g *GenesisConfig) TxSelector() monkchain.TxSelector {
+	selector, _ := g.txSelector()
+	return selector
+}
+
+func (g *GenesisConfig) txSelector() (monkchain.TxSelector, error) {
+	name := g.TxSelectorName
+	if name == "" {
+		name = "price"
+	}
+	selector, ok := monkchain.LookupTxSelector(name)
+	if !ok {
+		return nil, fmt.Errorf("Unknown tx selector %s", name)
+	}
+	return selector, nil
+}
+
+// Unmarshal the model specific options from genesis.json into v.
+// Does nothing if there are none
+func (g *GenesisConfig) UnmarshalModelOptions(v interface{}) error {
+	if len(g.ModelOptions) == 0 {
+		return nil
+	}
+	return json.Unmarshal(g.ModelOptions, v)
 }
 
 // A default genesis.json
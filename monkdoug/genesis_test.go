@@ -0,0 +1,227 @@
+package monkdoug
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+
+	vars "github.com/eris-ltd/eris-std-lib/go-tests"
+	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkdb"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+// A genesis with gendoug, the given consensus, and a miner
+// with each of the stakes
+func stdGenesis(consensus string, stakes ...int) (*GenesisConfig, []*monkcrypto.KeyPair) {
+	g := &GenesisConfig{
+		Address:    "0000000000THISISDOUG",
+		DougPath:   "Genesis DOUG/gendoug-v2.lll",
+		ModelName:  "std",
+		Consensus:  consensus,
+		Difficulty: 4,
+		BlockTime:  60,
+		MaxGasTx:   "100000",
+	}
+	var keys []*monkcrypto.KeyPair
+	for i, stake := range stakes {
+		k := monkcrypto.GenerateNewKeyPair()
+		keys = append(keys, k)
+		g.AddAccount(&Account{
+			Address:     monkutil.Bytes2Hex(k.Address()),
+			Name:        string(rune('a' + i)),
+			Balance:     "1000000000000000000000",
+			Permissions: map[string]int{"mine": 1, "transact": 1},
+			Stake:       stake,
+		})
+	}
+	return g, keys
+}
+
+// Deploy the genesis into a fresh chain. Skips the test if
+// the gendoug contract isn't installed
+func deployGenesis(t *testing.T, g *GenesisConfig) *monkchain.ChainManager {
+	g.Init()
+	if _, err := os.Stat(path.Join(g.contractPath, g.DougPath)); err != nil {
+		t.Skip("No gendoug contract:", err)
+	}
+
+	db, _ := monkdb.NewMemDatabase()
+	if monkutil.Config == nil {
+		monkutil.Config = &monkutil.ConfigManager{}
+	}
+	monkutil.Config.Db = db
+	bc := monkchain.NewChainManagerWithDb(g.Model(), db)
+	g.SetChainManager(bc)
+	return bc
+}
+
+// A block on top of parent, with parent's state
+func childBlock(parent *monkchain.Block, coinbase []byte, t int64) *monkchain.Block {
+	block := monkchain.CreateBlock(parent.State().Trie.Root, parent.Hash(), coinbase, big.NewInt(1), nil, "")
+	block.Number = new(big.Int).Add(parent.Number, big.NewInt(1))
+	block.Time = t
+	return block
+}
+
+func TestStake(t *testing.T) {
+	g, keys := stdGenesis("stake", 1, 2, 12)
+	bc := deployGenesis(t, g)
+	m := NewStdLibModel(g).(*StdLibModel)
+	state := bc.Genesis().State()
+
+	// 12 reads back as 12, not 0x12
+	for i, stake := range []int64{1, 2, 12} {
+		if s := m.stake(keys[i].Address(), state); s.Int64() != stake {
+			t.Errorf("Miner %d has stake %v, expected %d", i, s, stake)
+		}
+	}
+	if s := m.stake(monkcrypto.GenerateNewKeyPair().Address(), state); s.Sign() != 0 {
+		t.Errorf("Expected no stake for a stranger, got %v", s)
+	}
+	if total := m.totalStake(state); total.Int64() != 15 {
+		t.Errorf("Total stake is %v, expected 15", total)
+	}
+}
+
+func TestStakeLeader(t *testing.T) {
+	g, keys := stdGenesis("stake", 1, 2, 5)
+	bc := deployGenesis(t, g)
+	m := NewStdLibModel(g).(*StdLibModel)
+
+	// leaders over many parents follow the stakes
+	counts := make(map[string]int)
+	n := 800
+	for i := 0; i < n; i++ {
+		parent := childBlock(bc.Genesis(), nil, int64(i))
+		leader := m.stakeLeader(parent)
+		if leader == nil {
+			t.Fatal("No leader")
+		}
+		if again := m.stakeLeader(parent); string(again) != string(leader) {
+			t.Fatal("Leader changed for the same parent")
+		}
+		counts[string(leader)]++
+	}
+	for i, stake := range []int{1, 2, 5} {
+		expected := n * stake / 8
+		if c := counts[string(keys[i].Address())]; c < expected/2 || c > expected*3/2 {
+			t.Errorf("Miner %d with stake %d led %d of %d blocks, expected about %d", i, stake, c, n, expected)
+		}
+	}
+}
+
+func TestStakeDifficulty(t *testing.T) {
+	g, keys := stdGenesis("stake", 1, 2, 5)
+	bc := deployGenesis(t, g)
+	m := NewStdLibModel(g).(*StdLibModel)
+
+	parent := bc.Genesis()
+	// on time, so the base isn't adjusted down
+	now := parent.Time + 1
+	base := adjustDifficulty(m.baseDifficulty(parent.State()), parent.Time, now, 60)
+	leader := m.stakeLeader(parent)
+	for i, stake := range []int64{1, 2, 5} {
+		addr := keys[i].Address()
+		diff := m.StakeDifficulty(childBlock(parent, addr, now), parent)
+		expected := new(big.Int).Div(new(big.Int).Mul(base, big.NewInt(8)), big.NewInt(stake))
+		if string(addr) == string(leader) {
+			expected = base
+		}
+		if diff == nil || diff.Cmp(expected) != 0 {
+			t.Errorf("Miner %d has difficulty %v, expected %v", i, diff, expected)
+		}
+	}
+	if diff := m.StakeDifficulty(childBlock(parent, monkcrypto.GenerateNewKeyPair().Address(), now), parent); diff != nil {
+		t.Errorf("Expected no difficulty without stake, got %v", diff)
+	}
+}
+
+func TestHexNum(t *testing.T) {
+	for n, hex := range map[int64]string{0: "0x0", 1: "0x01", 60: "0x3c", 256: "0x0100"} {
+		if h := hexNum(big.NewInt(n)); h != hex {
+			t.Errorf("%d is %s, expected %s", n, h, hex)
+		}
+	}
+	// zero still takes up an arg
+	data := monkutil.PackTxDataArgs2("initvar", "epoch", "single", hexNum(new(big.Int)))
+	if len(data) != 4*32 || monkutil.BigD(data[3*32:]).Sign() != 0 {
+		t.Errorf("Zero packed to %x", data)
+	}
+}
+
+func TestInitVars(t *testing.T) {
+	g, _ := stdGenesis("robin", 1)
+	g.Epoch = 0
+	g.TurnTimeout = 30
+	bc := deployGenesis(t, g)
+	m := NewStdLibModel(g).(*StdLibModel)
+	state := bc.Genesis().State()
+
+	// zero round-trips, and doesn't shift the vars after it
+	for name, v := range map[string]int64{"epoch": 0, "tapow": 0, "turntimeout": 30, "difficulty": 4, "checkpointinterval": 0} {
+		if got := monkutil.BigD(vars.GetSingle(m.doug, name, state)); got.Int64() != v {
+			t.Errorf("%s is %v, expected %d", name, got, v)
+		}
+	}
+}
+
+func TestVmTxPow(t *testing.T) {
+	g, keys := stdGenesis("robin", 1)
+	g.ModelName = "vm"
+	g.TaPoW = 8
+	bc := deployGenesis(t, g)
+	m := NewVmModel(g).(*VmModel)
+	state := bc.Genesis().State()
+
+	diff := m.TxDifficulty(state)
+	if diff == nil || diff.Cmp(monkutil.BigPow(2, 8)) != 0 {
+		t.Fatalf("Tx difficulty is %v, expected 2^8", diff)
+	}
+	tx := monkchain.NewTransactionMessage(make([]byte, 20), big.NewInt(1), big.NewInt(100), big.NewInt(1), nil)
+	tx.Sign(keys[0].PrivateKey)
+	if err := m.ValidateTx(tx, state); err == nil {
+		t.Error("Expected a tx without work to fail")
+	}
+}
+
+func TestCheckGenesis(t *testing.T) {
+	g, _ := stdGenesis("robin", 1)
+	if err := g.Check(); err != nil {
+		t.Fatal("Expected the default pow to pass:", err)
+	}
+
+	for _, bad := range []struct {
+		name    string
+		options string
+	}{
+		{"nothing", ""},
+		{"dagger", `{"levels":40}`},
+	} {
+		g.PoWName, g.PoWOptions = bad.name, json.RawMessage(bad.options)
+		if err := g.Check(); err == nil {
+			t.Errorf("Expected pow %s with options %s to fail", bad.name, bad.options)
+		}
+		if pow := g.NewPoW(); pow != nil {
+			t.Errorf("Expected no pow for %s, got %T", bad.name, pow)
+		}
+	}
+	g.PoWName, g.PoWOptions = "", nil
+
+	g.TxSelectorName = "nothing"
+	if err := g.Check(); err == nil {
+		t.Error("Expected an unknown tx selector to fail")
+	}
+	if selector := g.TxSelector(); selector != nil {
+		t.Errorf("Expected no tx selector, got %T", selector)
+	}
+	g.TxSelectorName = ""
+
+	g.Rewards = &monkchain.RewardRules{Treasury: "01", RewardNum: 1, RewardDen: 2, FeeNum: 2, FeeDen: 1}
+	if err := g.Check(); err == nil {
+		t.Error("Expected a treasury share above 1 to fail")
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"math/big"
-	"time"
+	"sync"
 	//"log"
 	vars "github.com/eris-ltd/eris-std-lib/go-tests"
 	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkreact"
 	"github.com/eris-ltd/thelonious/monkstate"
 	"github.com/eris-ltd/thelonious/monkutil"
+	"github.com/eris-ltd/thelonious/monkvm"
 )
 
 var Adversary = 0
@@ -17,6 +19,22 @@ var Adversary = 0
 type Protocol struct {
 	g         *GenesisConfig
 	consensus monkchain.Consensus
+	// gas costs and disabled opcodes (nil for defaults)
+	vmRules *monkvm.Rules
+	// whether vm contracts see the block's values
+	blockEnv bool
+
+	// protocols pinned at each scheduled fork (see forks.go).
+	// empty for a pinned protocol
+	forks  []*Protocol
+	height uint64
+
+	// running consensus engine when there are forks
+	mut    sync.Mutex
+	th     monkchain.NodeManager
+	engine monkchain.ConsensusEngine
+	events chan monkreact.Event
+	quit   chan bool
 }
 
 func (p *Protocol) Doug() []byte {
@@ -28,25 +46,33 @@ func (p *Protocol) Deploy(block *monkchain.Block) ([]byte, error) {
 	return p.g.Deployer(block)
 }
 
+// Chains from a genesis ceremony need enough founder signatures
 func (p *Protocol) ValidateChainID(chainId []byte, genesisBlock *monkchain.Block) error {
-	return nil
+	if p.g.Ceremony == nil {
+		return nil
+	}
+	return p.g.Ceremony.Validate(chainId, genesisBlock.Hash())
 }
 
 // Determine whether to accept a new checkpoint
 func (p *Protocol) Participate(coinbase []byte, parent *monkchain.Block) bool {
-	return p.consensus.Participate(coinbase, parent)
+	number := new(big.Int).Add(parent.Number, monkutil.Big1)
+	return p.at(number).consensus.Participate(coinbase, parent)
 }
 
 func (p *Protocol) Difficulty(block, parent *monkchain.Block) *big.Int {
-	return p.consensus.Difficulty(block, parent)
+	return p.at(block.Number).consensus.Difficulty(block, parent)
 }
 
+// Permissions, txs and tx work are checked by the model of the protocol
+// itself. Callers that know the height should go through ProtocolAt,
+// or they get the model from genesis
 func (p *Protocol) ValidatePerm(addr []byte, role string, state *monkstate.State) error {
 	return p.consensus.ValidatePerm(addr, role, state)
 }
 
 func (p *Protocol) ValidateBlock(block *monkchain.Block, bc *monkchain.ChainManager) error {
-	return p.consensus.ValidateBlock(block, bc)
+	return p.at(block.Number).consensus.ValidateBlock(block, bc)
 }
 
 func (p *Protocol) ValidateTx(tx *monkchain.Transaction, state *monkstate.State) error {
@@ -54,16 +80,116 @@ func (p *Protocol) ValidateTx(tx *monkchain.Transaction, state *monkstate.State)
 }
 
 func (p *Protocol) CheckPoint(proposed []byte, bc *monkchain.ChainManager) bool {
-	return p.consensus.CheckPoint(proposed, bc)
+	return p.next().consensus.CheckPoint(proposed, bc)
+}
+
+// Uncle rules from the genesis.json (nil for defaults)
+func (p *Protocol) UncleRules() *monkchain.UncleRules {
+	return p.g.Uncles
+}
+
+// Time rules from the genesis.json (nil for defaults)
+func (p *Protocol) TimeRules() *monkchain.TimeRules {
+	return p.g.Times
+}
+
+// A new instance of the proof of work from the genesis.json
+func (p *Protocol) NewPoW() monkchain.PoW {
+	return p.g.NewPoW()
+}
+
+// Rewards from the genesis.json, or from gendoug
+// for models that keep them there
+func (p *Protocol) RewardRules(state *monkstate.State) *monkchain.RewardRules {
+	if m, ok := p.consensus.(monkchain.RewardProtocol); ok {
+		return m.RewardRules(state)
+	}
+	return p.g.RewardRules()
+}
+
+// Gas rules from the genesis.json, or from gendoug
+// for models that keep them there
+func (p *Protocol) GasRules(state *monkstate.State) *monkchain.GasRules {
+	if m, ok := p.consensus.(monkchain.GasProtocol); ok {
+		return m.GasRules(state)
+	}
+	return p.g.GasRules()
+}
+
+// How miners fill blocks, from the genesis.json
+func (p *Protocol) TxSelector() monkchain.TxSelector {
+	return p.g.TxSelector()
+}
+
+// Difficulty of the work required on txs (nil for none)
+func (p *Protocol) TxDifficulty(state *monkstate.State) *big.Int {
+	if m, ok := p.consensus.(monkchain.TxPowProtocol); ok {
+		return m.TxDifficulty(state)
+	}
+	return nil
+}
+
+// Blocks between certified checkpoints (0 for none)
+func (p *Protocol) CheckpointInterval(state *monkstate.State) uint64 {
+	if m, ok := p.consensus.(monkchain.CheckpointProtocol); ok {
+		return m.CheckpointInterval(state)
+	}
+	return 0
+}
+
+// Signatures needed to certify a checkpoint (0 for none)
+func (p *Protocol) CheckpointQuorum(state *monkstate.State) int {
+	if m, ok := p.consensus.(monkchain.CheckpointProtocol); ok {
+		return m.CheckpointQuorum(state)
+	}
+	return 0
+}
+
+// Start the consensus engine, if the model has one
+func (p *Protocol) Start(th monkchain.NodeManager) {
+	if len(p.forks) > 0 {
+		p.startForks(th)
+		return
+	}
+	if engine, ok := p.consensus.(monkchain.ConsensusEngine); ok {
+		engine.Start(th)
+	}
+}
+
+// Run the block start contracts, if the model has them
+func (p *Protocol) PreCall(block, parent *monkchain.Block, state *monkstate.State) error {
+	if m, ok := p.at(block.Number).consensus.(monkchain.BlockCallProtocol); ok {
+		return m.PreCall(block, parent, state)
+	}
+	return nil
+}
+
+// Run the block end contracts, if the model has them
+func (p *Protocol) PostCall(block, parent *monkchain.Block, state *monkstate.State) error {
+	if m, ok := p.at(block.Number).consensus.(monkchain.BlockCallProtocol); ok {
+		return m.PostCall(block, parent, state)
+	}
+	return nil
+}
+
+func (p *Protocol) Stop() {
+	if len(p.forks) > 0 {
+		p.stopForks()
+		return
+	}
+	if engine, ok := p.consensus.(monkchain.ConsensusEngine); ok {
+		engine.Stop()
+	}
 }
 
 // The yes model grants all permissions
 type YesModel struct {
-	g *GenesisConfig
+	g   *GenesisConfig
+	pow monkchain.PoW
 }
 
 func NewYesModel(g *GenesisConfig) monkchain.Consensus {
-	return &YesModel{g}
+	return &YesModel{g, g.NewPoW()}
 }
 
 func (m *YesModel) Participate(coinbase []byte, parent *monkchain.Block) bool {
@@ -78,8 +204,13 @@ func (m *YesModel) ValidatePerm(addr []byte, role string, state *monkstate.State
 	return nil
 }
 
+// Any block will do, but its uncles must still be valid
 func (m *YesModel) ValidateBlock(block *monkchain.Block, bc *monkchain.ChainManager) error {
-	return nil
+	prevBlock := bc.GetBlock(block.PrevHash)
+	if prevBlock == nil {
+		return monkchain.ParentError(block.PrevHash)
+	}
+	return CheckUncles(prevBlock, block, bc, m.pow)
 }
 
 func (m *YesModel) ValidateTx(tx *monkchain.Transaction, state *monkstate.State) error {
@@ -129,6 +260,7 @@ func (m *NoModel) CheckPoint(proposed []byte, bc *monkchain.ChainManager) bool {
 type VmModel struct {
 	g    *GenesisConfig
 	doug []byte
+	pow  monkchain.PoW
 
 	// map of contract names to syscalls
 	// names are json tags, addresses are
@@ -138,7 +270,7 @@ type VmModel struct {
 
 func NewVmModel(g *GenesisConfig) monkchain.Consensus {
 	contract := make(map[string]SysCall)
-	return &VmModel{g, g.byteAddr, contract}
+	return &VmModel{g, g.byteAddr, g.NewPoW(), contract}
 }
 
 // TODO:
@@ -237,7 +369,10 @@ func (m *VmModel) ValidatePerm(addr []byte, role string, state *monkstate.State)
 }
 
 func (m *VmModel) ValidateBlock(block *monkchain.Block, bc *monkchain.ChainManager) error {
-	parent := bc.CurrentBlock()
+	parent := bc.GetBlock(block.PrevHash)
+	if parent == nil {
+		return monkchain.ParentError(block.PrevHash)
+	}
 	state := parent.State()
 
 	if scall, ok := m.getSysCall("block-verify", state); ok {
@@ -257,15 +392,20 @@ func (m *VmModel) ValidateBlock(block *monkchain.Block, bc *monkchain.ChainManag
 
 		douglogger.Infoln("Calling block verify contract")
 		ret := m.EvmCall(code, data, obj, state, nil, block, true)
-		if monkutil.BigD(ret).Uint64() > 0 {
-			return nil
+		if monkutil.BigD(ret).Uint64() == 0 {
+			return fmt.Errorf("Permission error")
 		}
-		return fmt.Errorf("Permission error")
+	} else if err := m.ValidatePerm(block.Coinbase, "mine", block.State()); err != nil {
+		return err
 	}
-	return m.ValidatePerm(block.Coinbase, "mine", block.State())
+	return CheckUncles(parent, block, bc, m.pow)
 }
 
 func (m *VmModel) ValidateTx(tx *monkchain.Transaction, state *monkstate.State) error {
+	// the work is checked before any contract runs for the tx
+	if diff := m.TxDifficulty(state); diff != nil && !tx.VerifyPow(diff) {
+		return monkchain.ValidationError("Invalid tx proof of work (nonce = %x)", tx.PowNonce)
+	}
 	if scall, ok := m.getSysCall("tx-verify", state); ok {
 		addr := scall.byteAddr
 		obj, code := m.pickCallObjAndCode(addr, state)
@@ -289,11 +429,43 @@ func (m *VmModel) ValidateTx(tx *monkchain.Transaction, state *monkstate.State)
 	return m.ValidatePerm(tx.Sender(), perm, state)
 }
 
+// Difficulty of the work required on txs (nil for none)
+func (m *VmModel) TxDifficulty(state *monkstate.State) *big.Int {
+	return txDifficulty(m.doug, state)
+}
+
 func (m *VmModel) CheckPoint(proposed []byte, bc *monkchain.ChainManager) bool {
 	// TODO: checkpoint validation contract
 	return true
 }
 
+// Run the precall contract at the beginning of a block
+func (m *VmModel) PreCall(block, parent *monkchain.Block, state *monkstate.State) error {
+	return m.blockCall("precall", block, parent, state)
+}
+
+// Run the postcall contract at the end of a block
+func (m *VmModel) PostCall(block, parent *monkchain.Block, state *monkstate.State) error {
+	return m.blockCall("postcall", block, parent, state)
+}
+
+// Call a system contract with the block and its parent.
+// Changes are made directly to the block's state
+func (m *VmModel) blockCall(name string, block, parent *monkchain.Block, state *monkstate.State) error {
+	scall, ok := m.getSysCall(name, state)
+	if !ok {
+		return nil
+	}
+	obj, code := m.pickCallObjAndCode(scall.byteAddr, state)
+	data := packBlockParent(block, parent)
+	douglogger.Debugln("Calling", name, "contract")
+	if _, err := m.evmCall(code, data, obj, state, nil, block); err != nil {
+		return err
+	}
+	state.UpdateStateObject(obj)
+	return nil
+}
+
 // The stdlib model grants permissions based on the state of the gendoug
 // It depends on the eris-std-lib for its storage model
 type StdLibModel struct {
@@ -301,14 +473,19 @@ type StdLibModel struct {
 	doug []byte
 	g    *GenesisConfig
 	pow  monkchain.PoW
+
+	// epoch blocks by the hash of the block before the one they're for
+	mut         sync.Mutex
+	epochBlocks map[string]*monkchain.Block
 }
 
 func NewStdLibModel(g *GenesisConfig) monkchain.Consensus {
 	return &StdLibModel{
-		base: new(big.Int),
-		doug: g.byteAddr,
-		g:    g,
-		pow:  &monkchain.EasyPow{},
+		base:        new(big.Int),
+		doug:        g.byteAddr,
+		g:           g,
+		pow:         g.NewPoW(),
+		epochBlocks: make(map[string]*monkchain.Block),
 	}
 }
 
@@ -338,20 +515,19 @@ func (m *StdLibModel) Participate(coinbase []byte, parent *monkchain.Block) bool
 	}
 
 	consensus := m.consensus(parent.State())
-	// if we're not in a round robin, always mine
-	if consensus != "robin" {
+	switch consensus {
+	case "robin":
+	case "stake", "stake-weight":
+		return m.participateStake(coinbase, parent)
+	default:
+		// if we're not in a round robin, always mine
 		return true
 	}
 	// find out our distance from the current next miner
-	next := m.nextCoinbase(parent)
-	nMiners := vars.GetLinkedListLength(m.doug, "seq:name", parent.State())
-	var i int
-	for i = 0; i < nMiners; i++ {
-		next, _ = vars.GetNextLinkedListElement(m.doug, "seq:name", string(next), parent.State())
-		if bytes.Equal(next, coinbase) {
-			break
-		}
-	}
+	miners := m.miners(m.epochBlock(parent).State())
+	nMiners := len(miners)
+	next := m.nextCoinbase(parent, monkutil.Now().Unix())
+	i := robinDistance(miners, next, coinbase)
 	// if we're less than halfway from the current miner, we should mine
 	if i <= int(nMiners/2) {
 		return true
@@ -359,7 +535,7 @@ func (m *StdLibModel) Participate(coinbase []byte, parent *monkchain.Block) bool
 	// if we're more than halfway, but enough time has gone by, we should mine
 	mDiff := i - int(nMiners/2)
 	t := parent.Time
-	cur := time.Now().Unix()
+	cur := monkutil.Now().Unix()
 	blocktime := m.blocktime(parent.State())
 	tDiff := (cur - t) / blocktime
 	if tDiff > int64(mDiff) {
@@ -369,6 +545,19 @@ func (m *StdLibModel) Participate(coinbase []byte, parent *monkchain.Block) bool
 	return false
 }
 
+// In a stake-weighted chain, the leader always mines.
+// Everyone else with stake waits out a block time first
+func (m *StdLibModel) participateStake(coinbase []byte, parent *monkchain.Block) bool {
+	state := parent.State()
+	if m.stake(coinbase, state).Sign() <= 0 {
+		return false
+	}
+	if bytes.Equal(m.stakeLeader(parent), coinbase) {
+		return true
+	}
+	return monkutil.Now().Unix()-parent.Time > m.blocktime(state)
+}
+
 // Difficulty of the current block for a given coinbase
 func (m *StdLibModel) Difficulty(block, parent *monkchain.Block) *big.Int {
 	var b *big.Int
@@ -379,7 +568,7 @@ func (m *StdLibModel) Difficulty(block, parent *monkchain.Block) *big.Int {
 	switch consensus {
 	case "robin":
 		b = m.RoundRobinDifficulty(block, parent)
-	case "stake-weight":
+	case "stake", "stake-weight":
 		b = m.StakeDifficulty(block, parent)
 	case "constant":
 		b = m.baseDifficulty(parent.State())
@@ -421,6 +610,9 @@ func (m *StdLibModel) ValidateBlock(block *monkchain.Block, bc *monkchain.ChainM
 	// check if the block difficulty is correct
 	// it must be specified exactly
 	newdiff := m.Difficulty(block, prevBlock)
+	if newdiff == nil {
+		return monkchain.ValidationError("Coinbase %x has no stake", block.Coinbase)
+	}
 	if block.Difficulty.Cmp(newdiff) != 0 {
 		return monkchain.InvalidDifficultyError(block.Difficulty, newdiff, block.Coinbase)
 	}
@@ -430,11 +622,6 @@ func (m *StdLibModel) ValidateBlock(block *monkchain.Block, bc *monkchain.ChainM
 	// Do we even budget for lists of signers/forgers and all
 	// that nutty PoS stuff?
 
-	// check block times
-	if err := CheckBlockTimes(prevBlock, block); err != nil {
-		return err
-	}
-
 	// Verify the nonce of the block. Return an error if it's not valid
 	// TODO: for now we leave pow on everything
 	// soon we will want to generalize/relieve
@@ -443,6 +630,11 @@ func (m *StdLibModel) ValidateBlock(block *monkchain.Block, bc *monkchain.ChainM
 		return monkchain.ValidationError("Block's nonce is invalid (= %v)", monkutil.Bytes2Hex(block.Nonce))
 	}
 
+	// check the uncles
+	if err := CheckUncles(prevBlock, block, bc, m.pow); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -461,6 +653,10 @@ func (m *StdLibModel) ValidateTx(tx *monkchain.Transaction, state *monkstate.Sta
 	if !m.HasPermission(tx.Sender(), perm, state) {
 		return monkchain.InvalidPermError(tx.Sender(), perm)
 	}
+	// check the tx proof of work
+	if diff := m.TxDifficulty(state); diff != nil && !tx.VerifyPow(diff) {
+		return monkchain.ValidationError("Invalid tx proof of work (nonce = %x)", tx.PowNonce)
+	}
 	// check that tx uses less than maxgas
 	gas := tx.GasValue()
 	max := vars.GetSingle(m.doug, "maxgastx", state)
@@ -487,7 +683,7 @@ type EthModel struct {
 }
 
 func NewEthModel(g *GenesisConfig) monkchain.Consensus {
-	return &EthModel{&monkchain.EasyPow{}, g}
+	return &EthModel{g.NewPoW(), g}
 }
 
 func (m *EthModel) Participate(coinbase []byte, parent *monkchain.Block) bool {
@@ -518,16 +714,16 @@ func (m *EthModel) ValidateBlock(block *monkchain.Block, bc *monkchain.ChainMana
 		return monkchain.InvalidDifficultyError(block.Difficulty, newdiff, block.Coinbase)
 	}
 
-	// check block times
-	if err := CheckBlockTimes(prevBlock, block); err != nil {
-		return err
-	}
-
 	// Verify the nonce of the block. Return an error if it's not valid
 	if !m.pow.Verify(block.HashNoNonce(), block.Difficulty, block.Nonce) {
 		return monkchain.ValidationError("Block's nonce is invalid (= %v)", monkutil.Bytes2Hex(block.Nonce))
 	}
 
+	// check the uncles
+	if err := CheckUncles(prevBlock, block, bc, m.pow); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -0,0 +1,101 @@
+package monkdoug
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/eris-ltd/thelonious/monkchain"
+)
+
+/*
+   Registry of consensus models by name.
+   GenesisConfig.ModelName ("model" in genesis.json) picks the model,
+   and GenesisConfig.ModelOptions ("model-options") holds any
+   model specific json for the constructor to unmarshal.
+   Packages outside monkdoug can add their own models with
+   RegisterModel, typically from an init function.
+*/
+
+// Construct a consensus model from a genesis config
+// (eg. an error for bad model options)
+type ModelConstructor func(g *GenesisConfig) (monkchain.Consensus, error)
+
+var (
+	modelsMut sync.Mutex
+	models    = make(map[string]ModelConstructor)
+)
+
+// Register a named consensus model.
+// Names must be unique
+func RegisterModel(name string, constructor ModelConstructor) error {
+	modelsMut.Lock()
+	defer modelsMut.Unlock()
+	if constructor == nil {
+		return fmt.Errorf("Nil constructor for model %s", name)
+	}
+	if _, ok := models[name]; ok {
+		return fmt.Errorf("Model %s already registered", name)
+	}
+	models[name] = constructor
+	return nil
+}
+
+// Remove a named consensus model, if it's registered
+func UnregisterModel(name string) {
+	modelsMut.Lock()
+	defer modelsMut.Unlock()
+	delete(models, name)
+}
+
+// Return the constructor for a named model
+func LookupModel(name string) (ModelConstructor, bool) {
+	modelsMut.Lock()
+	defer modelsMut.Unlock()
+	constructor, ok := models[name]
+	return constructor, ok
+}
+
+// Names of all registered models, sorted
+func RegisteredModels() []string {
+	modelsMut.Lock()
+	defer modelsMut.Unlock()
+	names := []string{}
+	for name := range models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func mustRegisterModel(name string, constructor ModelConstructor) {
+	if err := RegisterModel(name, constructor); err != nil {
+		panic(err)
+	}
+}
+
+// The builtin models can't fail
+func builtinModel(constructor func(g *GenesisConfig) monkchain.Consensus) ModelConstructor {
+	return func(g *GenesisConfig) (monkchain.Consensus, error) {
+		return constructor(g), nil
+	}
+}
+
+// The builtin models
+func init() {
+	// gendoug-v2
+	// uses eris-std-lib/gotests/vars for reading
+	// from gendoug
+	mustRegisterModel("std", builtinModel(NewStdLibModel))
+	// run processing through the vm
+	mustRegisterModel("vm", builtinModel(NewVmModel))
+	// everyone allowed everything
+	mustRegisterModel("yes", builtinModel(NewYesModel))
+	// noone allowed anything
+	mustRegisterModel("no", builtinModel(NewNoModel))
+	// ethereum
+	mustRegisterModel("eth", builtinModel(NewEthModel))
+	// gendoug validators vote
+	// on blocks for finality
+	mustRegisterModel("bft", builtinModel(NewBftModel))
+}
@@ -0,0 +1,88 @@
+package monkdoug
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/eris-ltd/thelonious/monkchain"
+)
+
+type optionsModel struct {
+	*YesModel
+	Turns int `json:"turns"`
+}
+
+func TestRegisterModel(t *testing.T) {
+	err := RegisterModel("test-options", func(g *GenesisConfig) (monkchain.Consensus, error) {
+		m := &optionsModel{YesModel: NewYesModel(g).(*YesModel)}
+		if err := g.UnmarshalModelOptions(m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer UnregisterModel("test-options")
+
+	g := &GenesisConfig{}
+	if err := json.Unmarshal([]byte(`{"model":"test-options", "model-options":{"turns":7}}`), g); err != nil {
+		t.Fatal(err)
+	}
+	model, err := NewPermModel(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := model.(*optionsModel)
+	if !ok {
+		t.Fatal("Expected the registered model")
+	}
+	if m.Turns != 7 {
+		t.Error("Expected model options to be passed through, got", m.Turns)
+	}
+
+	g = &GenesisConfig{}
+	if err := json.Unmarshal([]byte(`{"model":"test-options", "model-options":{"turns":"seven"}}`), g); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPermModel(g); err == nil {
+		t.Error("Expected an error for bad model options")
+	}
+
+	if err := RegisterModel("test-options", builtinModel(NewYesModel)); err == nil {
+		t.Error("Expected error registering a model twice")
+	}
+}
+
+func TestUnknownModel(t *testing.T) {
+	g := &GenesisConfig{ModelName: "nonsense"}
+	if _, err := NewPermModel(g); err == nil {
+		t.Error("Expected an error for an unknown model")
+	}
+	if err := g.Check(); err == nil {
+		t.Error("Expected Check to reject an unknown model")
+	}
+	if _, err := NewProtocol(&GenesisConfig{ModelName: "yes", Forks: []*Fork{{Height: 10, ModelName: "nonsense"}}}); err == nil {
+		t.Error("Expected an error for an unknown fork model")
+	}
+
+	// no model, or no gendoug, is the yes model
+	for _, g := range []*GenesisConfig{{}, {ModelName: "nonsense", NoGenDoug: true}} {
+		if err := g.Check(); err != nil {
+			t.Error(err)
+		}
+		if model, err := NewPermModel(g); err != nil {
+			t.Error(err)
+		} else if _, ok := model.(*YesModel); !ok {
+			t.Errorf("Expected the yes model, got %T", model)
+		}
+	}
+
+	g = &GenesisConfig{ModelName: "eth"}
+	if _, err := NewPermModel(g); err != nil {
+		t.Fatal(err)
+	}
+	if !g.NoGenDoug {
+		t.Error("Expected no gendoug for eth")
+	}
+}
@@ -0,0 +1,123 @@
+package monkdoug
+
+import (
+	"bytes"
+	"testing"
+)
+
+/*
+   Simulate a few miners taking turns in a round robin.
+   Each node only mines when robinTurn says it's their turn,
+   so the chain only moves if the schedule hands the turn
+   to someone online.
+*/
+
+type robinNode struct {
+	addr   []byte
+	online bool
+}
+
+type robinBlock struct {
+	number   uint64
+	time     int64
+	coinbase []byte
+}
+
+// Mine n blocks on top of genesis. minersAt returns the miners list
+// as of the state of a given block. Returns the chain (without genesis)
+func simulateRobin(t *testing.T, nodes []*robinNode, minersAt func(uint64) [][]byte, epoch uint64, timeout int64, n int) []robinBlock {
+	chain := []robinBlock{{0, 0, nil}}
+	for len(chain) <= n {
+		parent := chain[len(chain)-1]
+		miners := minersAt(epochStart(parent.number+1, epoch))
+		var mined bool
+		// each second, every online node checks if it's their turn
+		for now := parent.time + 1; now < parent.time+100*timeout && !mined; now++ {
+			next := robinTurn(miners, parent.number, parent.time, now, timeout)
+			for _, node := range nodes {
+				if node.online && bytes.Equal(node.addr, next) {
+					chain = append(chain, robinBlock{parent.number + 1, now, node.addr})
+					mined = true
+					break
+				}
+			}
+		}
+		if !mined {
+			t.Fatalf("Chain stalled at block %d", parent.number)
+		}
+	}
+	return chain[1:]
+}
+
+func TestRobinMissedTurn(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	nodes := []*robinNode{{a, true}, {b, false}, {c, true}}
+	miners := func(uint64) [][]byte { return [][]byte{a, b, c} }
+
+	var timeout int64 = 10
+	chain := simulateRobin(t, nodes, miners, 0, timeout, 9)
+
+	for i, block := range chain {
+		if bytes.Equal(block.coinbase, b) {
+			t.Fatalf("Offline node mined block %d", block.number)
+		}
+		var parentTime int64
+		if i > 0 {
+			parentTime = chain[i-1].time
+		}
+		// b's turns are taken by c after the timeout
+		if block.number%3 == 2 {
+			if !bytes.Equal(block.coinbase, c) {
+				t.Errorf("Block %d mined by %s, expected c", block.number, block.coinbase)
+			}
+			if block.time-parentTime < timeout {
+				t.Errorf("Block %d mined before the turn timeout", block.number)
+			}
+		} else if block.time-parentTime >= timeout {
+			t.Errorf("Block %d waited for a timeout it shouldn't have", block.number)
+		}
+	}
+}
+
+func TestRobinEpochs(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	nodes := []*robinNode{{a, true}, {b, true}, {c, true}}
+	// c is added to the miners list in block 2
+	miners := func(number uint64) [][]byte {
+		if number >= 2 {
+			return [][]byte{a, b, c}
+		}
+		return [][]byte{a, b}
+	}
+
+	chain := simulateRobin(t, nodes, miners, 4, 10, 12)
+
+	for _, block := range chain {
+		if bytes.Equal(block.coinbase, c) {
+			// first epoch is blocks 1-4, so c starts in the second
+			if block.number <= 4 {
+				t.Fatalf("New miner mined block %d before the epoch boundary", block.number)
+			}
+			if block.number != 6 {
+				t.Errorf("New miner's first block was %d, expected 6", block.number)
+			}
+			return
+		}
+	}
+	t.Error("New miner never got a turn")
+}
+
+func TestRobinDistance(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+	miners := [][]byte{a, b, c}
+
+	if d := robinDistance(miners, b, b); d != 0 {
+		t.Error("Expected distance 0, got", d)
+	}
+	if d := robinDistance(miners, b, a); d != 2 {
+		t.Error("Expected distance 2, got", d)
+	}
+	if d := robinDistance(miners, b, []byte("d")); d != 3 {
+		t.Error("Expected distance 3 for non-miner, got", d)
+	}
+}
@@ -0,0 +1,77 @@
+package monkdoug
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func stakeMiners(stakes ...int64) ([][]byte, []*big.Int) {
+	var miners [][]byte
+	var bigStakes []*big.Int
+	for i, s := range stakes {
+		miners = append(miners, []byte{byte('a' + i)})
+		bigStakes = append(bigStakes, big.NewInt(s))
+	}
+	return miners, bigStakes
+}
+
+func TestPickStakeLeader(t *testing.T) {
+	miners, stakes := stakeMiners(1, 2, 0, 5)
+
+	// leaders over many parents follow the stakes
+	counts := make(map[string]int)
+	n := 800
+	for i := 0; i < n; i++ {
+		prevHash := big.NewInt(int64(i)).Bytes()
+		leader := pickStakeLeader(prevHash, miners, stakes)
+		if leader == nil {
+			t.Fatal("No leader")
+		}
+		if again := pickStakeLeader(prevHash, miners, stakes); !bytes.Equal(again, leader) {
+			t.Fatal("Leader changed for the same parent")
+		}
+		counts[string(leader)]++
+	}
+	for i, stake := range stakes {
+		expected := n * int(stake.Int64()) / 8
+		if c := counts[string(miners[i])]; c < expected/2 || c > expected*3/2 {
+			t.Errorf("Miner %d with stake %v led %d of %d blocks, expected about %d", i, stake, c, n, expected)
+		}
+	}
+
+	for i := range stakes {
+		stakes[i].SetInt64(0)
+	}
+	if leader := pickStakeLeader([]byte("parent"), miners, stakes); leader != nil {
+		t.Errorf("Expected no leader without stake, got %x", leader)
+	}
+	stakes[2].SetInt64(3)
+	if leader := pickStakeLeader([]byte("parent"), miners, stakes); !bytes.Equal(leader, miners[2]) {
+		t.Errorf("Expected the only miner with stake to lead, got %x", leader)
+	}
+}
+
+func TestStakeDifficultyWeights(t *testing.T) {
+	base := big.NewInt(1000)
+	total := big.NewInt(8)
+	for _, c := range []struct {
+		stake    int64
+		leader   bool
+		expected int64
+	}{
+		{5, true, 1000},
+		{1, true, 1000},
+		{5, false, 1600},
+		{2, false, 4000},
+		{1, false, 8000},
+	} {
+		diff := stakeDifficulty(base, big.NewInt(c.stake), total, c.leader)
+		if diff == nil || diff.Int64() != c.expected {
+			t.Errorf("Stake %d (leader %v) has difficulty %v, expected %d", c.stake, c.leader, diff, c.expected)
+		}
+	}
+	if diff := stakeDifficulty(base, new(big.Int), total, true); diff != nil {
+		t.Errorf("Expected no difficulty without stake, got %v", diff)
+	}
+}
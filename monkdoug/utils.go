@@ -93,7 +93,7 @@ func SimpleTransitionState(addr []byte, block *monkchain.Block, tx *monkchain.Tr
 		root = []byte(r)
 	}
 
-	receipt := &monkchain.Receipt{tx, monkutil.CopyBytes(root), new(big.Int)}
+	receipt := &monkchain.Receipt{tx, monkutil.CopyBytes(root), new(big.Int), nil}
 
 	sender.Nonce += 1
 	// remove stateobject used to deploy gen doug
@@ -151,6 +151,15 @@ func PrintHelp(m map[string]interface{}, obj *monkstate.StateObject) {
 	})
 }
 
+// Hex for a number in SetValue's args. Zero is "0x0", not a bare "0x",
+// which packs to nothing and shifts every arg after it
+func hexNum(n *big.Int) string {
+	if n.Sign() == 0 {
+		return "0x0"
+	}
+	return "0x" + monkutil.Bytes2Hex(n.Bytes())
+}
+
 func SetValue(addr []byte, args []string, keys *monkcrypto.KeyPair, block *monkchain.Block) (*monkchain.Transaction, *monkchain.Receipt) {
 	data := monkutil.PackTxDataArgs2(args...)
 	tx, rec, _ := MakeApplyTx("", addr, data, keys, block)
@@ -177,17 +186,7 @@ func SetPermissions(genAddr, addr []byte, permissions map[string]int, block *mon
 
 // Run data through evm code and return value
 func (m *VmModel) EvmCall(code, data []byte, stateObject *monkstate.StateObject, state *monkstate.State, tx *monkchain.Transaction, block *monkchain.Block, dump bool) []byte {
-	gas := "10000000000000000000000"
-	price := "10000000"
-
-	msg := &monkstate.Message{}
-
-	closure := monkvm.NewClosure(msg, stateObject, stateObject, code, monkutil.Big(gas), monkutil.Big(price))
-
-	env := NewEnv(state, tx, block, m.g.protocol)
-	vm := monkvm.New(env)
-	vm.Verbose = true
-	ret, _, e := closure.Call(vm, data)
+	ret, e := m.evmCall(code, data, stateObject, state, tx, block)
 
 	if e != nil {
 		fmt.Println("vm error!", e)
@@ -200,6 +199,27 @@ func (m *VmModel) EvmCall(code, data []byte, stateObject *monkstate.StateObject,
 	return ret
 }
 
+func (m *VmModel) evmCall(code, data []byte, stateObject *monkstate.StateObject, state *monkstate.State, tx *monkchain.Transaction, block *monkchain.Block) ([]byte, error) {
+	gas := "10000000000000000000000"
+	price := "10000000"
+
+	msg := &monkstate.Message{}
+
+	closure := monkvm.NewClosure(msg, stateObject, stateObject, code, monkutil.Big(gas), monkutil.Big(price))
+
+	env := NewEnv(state, tx, block, m.g.protocol)
+	vm := monkvm.New(env)
+	// use the vm rules active at the block
+	if p, ok := m.g.protocol.(*Protocol); ok && block != nil {
+		if rules := p.at(block.Number).vmRules; rules != nil {
+			vm.Rules = rules
+		}
+	}
+	vm.Verbose = true
+	ret, _, err := closure.Call(vm, data)
+	return ret, err
+}
+
 type VMEnv struct {
 	protocol monkchain.Protocol
 	state    *monkstate.State
@@ -217,16 +237,59 @@ func NewEnv(state *monkstate.State, tx *monkchain.Transaction, block *monkchain.
 	}
 }
 
-func (self *VMEnv) Origin() []byte          { return []byte("000000000000000LOCAL") } //self.tx.Sender() }
-func (self *VMEnv) BlockNumber() *big.Int   { return nil }                            //self.block.Number }
-func (self *VMEnv) PrevHash() []byte        { return nil }                            //self.block.PrevHash }
-func (self *VMEnv) Coinbase() []byte        { return nil }                            //self.block.Coinbase }
-func (self *VMEnv) Time() int64             { return 0 }                              //self.block.Time }
-func (self *VMEnv) Difficulty() *big.Int    { return nil }                            //self.block.Difficulty }
-func (self *VMEnv) BlockHash() []byte       { return nil }                            //self.block.Hash() }
+func (self *VMEnv) Origin() []byte { return []byte("000000000000000LOCAL") } //self.tx.Sender() }
+
+// Block values are available to system contracts run
+// with a block (ie. precall/postcall) once the protocol at
+// the block turns them on (vm-block-env). Before that the
+// contracts get zeros, as they always have, so old chains
+// replay the same. The block hash is never available,
+// since the miner doesn't know it while running them
+func (self *VMEnv) withBlock() bool {
+	if self.block == nil {
+		return false
+	}
+	p, ok := self.protocol.(*Protocol)
+	return ok && p.at(self.block.Number).blockEnv
+}
+func (self *VMEnv) BlockNumber() *big.Int {
+	if !self.withBlock() {
+		return nil
+	}
+	return self.block.Number
+}
+func (self *VMEnv) PrevHash() []byte {
+	if !self.withBlock() {
+		return nil
+	}
+	return self.block.PrevHash
+}
+func (self *VMEnv) Coinbase() []byte {
+	if !self.withBlock() {
+		return nil
+	}
+	return self.block.Coinbase
+}
+func (self *VMEnv) Time() int64 {
+	if !self.withBlock() {
+		return 0
+	}
+	return self.block.Time
+}
+func (self *VMEnv) Difficulty() *big.Int {
+	if !self.withBlock() {
+		return nil
+	}
+	return self.block.Difficulty
+}
+func (self *VMEnv) BlockHash() []byte       { return nil } //self.block.Hash() }
 func (self *VMEnv) Value() *big.Int         { return big.NewInt(0) }
 func (self *VMEnv) State() *monkstate.State { return self.state }
 func (self *VMEnv) Doug() []byte            { return self.protocol.Doug() }
 func (self *VMEnv) DougValidate(addr []byte, role string, state *monkstate.State) error {
-	return self.protocol.ValidatePerm(addr, role, state)
+	protocol := self.protocol
+	if p, ok := protocol.(monkchain.ForkProtocol); ok && self.block != nil {
+		protocol = p.ProtocolAt(self.block.Number)
+	}
+	return protocol.ValidatePerm(addr, role, state)
 }
@@ -2,11 +2,16 @@ package monkminer
 
 import (
 	"bytes"
-	"sort"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/eris-ltd/thelonious/monkchain"
 	"github.com/eris-ltd/thelonious/monklog"
 	"github.com/eris-ltd/thelonious/monkreact"
+	"github.com/eris-ltd/thelonious/monkutil"
 	"github.com/eris-ltd/thelonious/monkwire"
 )
 
@@ -26,28 +31,180 @@ type Miner struct {
 	startChan   chan monkreact.Event
 
 	turbo bool
+
+	// Search workers, and the hashes tried by each
+	mut     sync.Mutex
+	workers int
+	hashes  []int64
+	// Hashrate sampled each second, and when
+	// and at how many hashes it last was
+	hashrate      *Hashrate
+	hashrateQuit  chan bool
+	sampled       time.Time
+	sampledHashes []int64
+}
+
+// Hashes per second of each search worker and in total,
+// with the last minute of totals, oldest first
+type Hashrate struct {
+	Workers []int64
+	Total   int64
+	History []int64
 }
 
+const hashrateHistory = 60
+
 func (self *Miner) GetPow() monkchain.PoW {
 	return self.pow
 }
 
+// Search with pow instead of the chain's. Set it before mining
+func (self *Miner) SetPow(pow monkchain.PoW) {
+	self.pow = pow
+}
+
 func NewDefaultMiner(coinbase []byte, thelonious monkchain.NodeManager) *Miner {
 	miner := Miner{
-		pow:        &monkchain.EasyPow{},
+		pow:        thelonious.ChainManager().NewPoW(),
 		thelonious: thelonious,
 		coinbase:   coinbase,
+		workers:    1,
+		hashrate:   &Hashrate{},
 	}
 
 	return &miner
 }
 
 func (self *Miner) ToggleTurbo() {
-	self.turbo = !self.turbo
+	self.SetTurbo(!self.Turbo())
+}
+
+// In turbo the search workers don't rest between hashes
+func (self *Miner) SetTurbo(on bool) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+	self.turbo = on
 
 	self.pow.Turbo(self.turbo)
 }
 
+func (self *Miner) Turbo() bool {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+	return self.turbo
+}
+
+// Set the number of search workers (one per cpu if n < 1).
+// Takes effect on the next block
+func (self *Miner) SetWorkers(n int) {
+	if n < 1 {
+		n = runtime.NumCPU()
+	}
+	self.mut.Lock()
+	defer self.mut.Unlock()
+	self.workers = n
+}
+
+func (self *Miner) Workers() int {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+	return self.workers
+}
+
+// The last sampled hashrate
+func (self *Miner) Hashrate() *Hashrate {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+	return &Hashrate{
+		Workers: append([]int64{}, self.hashrate.Workers...),
+		Total:   self.hashrate.Total,
+		History: append([]int64{}, self.hashrate.History...),
+	}
+}
+
+// Sample the hashes tried by each worker every second
+// (by the monkutil clock) until quit is closed
+func (self *Miner) startHashrate(quit chan bool) {
+	self.mut.Lock()
+	self.sampled = monkutil.Now()
+	self.mut.Unlock()
+	monkutil.Every(time.Second, quit, func() {
+		self.sampleHashrate(monkutil.Now())
+	})
+}
+
+// Work out the hashrate from the hashes tried since the last sample
+func (self *Miner) sampleHashrate(now time.Time) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+	elapsed := now.Sub(self.sampled).Seconds()
+	self.sampled = now
+
+	rate := &Hashrate{History: self.hashrate.History}
+	if _, ok := self.pow.(monkchain.ParallelPoW); !ok {
+		rate.Total = self.pow.GetHashrate()
+		rate.Workers = []int64{rate.Total}
+	} else {
+		if len(self.sampledHashes) != len(self.hashes) {
+			self.sampledHashes = make([]int64, len(self.hashes))
+		}
+		for i := range self.hashes {
+			n := atomic.LoadInt64(&self.hashes[i])
+			r := int64(float64(n-self.sampledHashes[i]) / elapsed)
+			self.sampledHashes[i] = n
+			rate.Workers = append(rate.Workers, r)
+			rate.Total += r
+		}
+	}
+	rate.History = append(rate.History, rate.Total)
+	if len(rate.History) > hashrateHistory {
+		rate.History = rate.History[len(rate.History)-hashrateHistory:]
+	}
+	self.hashrate = rate
+}
+
+// Find a nonce for the block, or nil if mining it was interrupted.
+// Proofs of work that can are searched by all the workers at once
+func (self *Miner) search(block *monkchain.Block) []byte {
+	pow, ok := self.pow.(monkchain.ParallelPoW)
+	if !ok {
+		return self.pow.Search(block, self.powQuitChan)
+	}
+
+	self.mut.Lock()
+	workers := self.workers
+	if len(self.hashes) != workers {
+		self.hashes = make([]int64, workers)
+	}
+	hashes := self.hashes
+	self.mut.Unlock()
+
+	stop := make(chan struct{})
+	found := make(chan []byte, workers)
+	start := uint64(rand.Int63())
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := monkchain.NonceRange{Start: start, Worker: uint64(i), Workers: uint64(workers)}
+			if nonce := pow.SearchRange(block, r, stop, &hashes[i]); nonce != nil {
+				found <- nonce
+			}
+		}(i)
+	}
+
+	var nonce []byte
+	select {
+	case nonce = <-found:
+	case <-self.powQuitChan:
+		logger.Infoln("Breaking from mining")
+	}
+	close(stop)
+	wg.Wait()
+	return nonce
+}
+
 func (miner *Miner) Start() {
 	miner.reactChan = make(chan monkreact.Event, 1)   // This is the channel that receives 'updates' when ever a new transaction or block comes in
 	miner.powChan = make(chan []byte, 1)              // This is the channel that receives valid sha hashes for a given block
@@ -65,6 +222,7 @@ func (miner *Miner) Start() {
 
 	reactor := miner.thelonious.Reactor()
 	reactor.Subscribe("newBlock", miner.reactChan)
+	reactor.Subscribe("newUncle", miner.reactChan)
 	reactor.Subscribe("newTx:pre", miner.reactChan)
 	reactor.Subscribe("chainReady", miner.startChan)
 
@@ -76,6 +234,9 @@ func (miner *Miner) Start() {
 	reactor.Subscribe("newBlock", miner.powQuitChan)
 	reactor.Subscribe("newTx:pre", miner.powQuitChan)
 
+	miner.hashrateQuit = make(chan bool)
+	miner.startHashrate(miner.hashrateQuit)
+
 	reactor.Post("miner:start", miner)
 }
 
@@ -94,7 +255,11 @@ func (miner *Miner) listener() {
 			return
 		case chanMessage := <-miner.reactChan:
 			if block, ok := chanMessage.Resource.(*monkchain.Block); ok {
-				miner.receiveBlock(block)
+				if chanMessage.Name == "newUncle" {
+					miner.receiveUncle(block)
+				} else {
+					miner.receiveBlock(block)
+				}
 			}
 			if tx, ok := chanMessage.Resource.(*monkchain.Transaction); ok {
 				miner.receiveTx(tx)
@@ -145,11 +310,45 @@ func (miner *Miner) receiveBlock(block *monkchain.Block) {
 		//miner.block = miner.thelonious.ChainManager().NewBlock(miner.coinbase, miner.txs)
 
 	} else {
-		if bytes.Compare(block.PrevHash, miner.thelonious.ChainManager().CurrentBlockPrevHash()) == 0 {
-			logger.Infoln("Adding uncle block")
-			miner.uncles = append(miner.uncles, block)
+		miner.receiveUncle(block)
+	}
+}
+
+// A valid block that didn't make it onto the canonical chain.
+// Kept as a candidate uncle until it's included or too old
+func (miner *Miner) receiveUncle(block *monkchain.Block) {
+	for _, uncle := range miner.uncles {
+		if bytes.Compare(uncle.Hash(), block.Hash()) == 0 {
+			return
 		}
 	}
+	logger.Infoln("Adding uncle block")
+	miner.uncles = append(miner.uncles, block)
+}
+
+// Drop candidate uncles that are too old or already in the chain
+// and return as many of the rest as the uncle rules allow
+func (miner *Miner) selectUncles(parent *monkchain.Block) []*monkchain.Block {
+	chainMan := miner.thelonious.ChainManager()
+	rules := chainMan.UncleRules()
+	ancestors, included := chainMan.UncleAncestry(parent, rules.Depth)
+
+	var candidates, uncles []*monkchain.Block
+	for _, uncle := range miner.uncles {
+		if err := monkchain.ValidUncle(uncle, parent, ancestors, included); err != nil {
+			// siblings of the parent become valid on the next block
+			if bytes.Compare(uncle.PrevHash, parent.Hash()) == 0 {
+				candidates = append(candidates, uncle)
+			}
+			continue
+		}
+		candidates = append(candidates, uncle)
+		if len(uncles) < rules.Max {
+			uncles = append(uncles, uncle)
+		}
+	}
+	miner.uncles = candidates
+	return uncles
 }
 
 func (miner *Miner) Stop() {
@@ -160,96 +359,160 @@ func (miner *Miner) Stop() {
 	status := make(chan error)
 	miner.quitChan <- status
 	<-status
+	close(miner.hashrateQuit)
 
 	reactor := miner.thelonious.Reactor()
 	reactor.Unsubscribe("newBlock", miner.powQuitChan)
 	reactor.Unsubscribe("newTx:pre", miner.powQuitChan)
 	reactor.Unsubscribe("newBlock", miner.reactChan)
+	reactor.Unsubscribe("newUncle", miner.reactChan)
 	reactor.Unsubscribe("newTx:pre", miner.reactChan)
 
 	reactor.Post("miner:stop", miner)
 }
 
-func (self *Miner) mineNewBlock() {
-	stateManager := self.thelonious.BlockManager()
-	chainMan := self.thelonious.ChainManager()
-	self.block = chainMan.NewBlock(self.coinbase)
+// Mine one block on the head with the txs in the pool, without
+// starting the miner. Whether a block was mined and added.
+// Only a started miner collects uncles
+func (self *Miner) MineBlock() bool {
+	self.txs = self.thelonious.TxPool().CurrentTransactions()
+	return self.mineNewBlock()
+}
+
+// Whether a block was mined and added
+func (self *Miner) mineNewBlock() bool {
+	block, parent := newBlock(self.thelonious, self.coinbase)
+	if block == nil {
+		return false
+	}
+	self.block = block
+
+	// Apply uncles
+	if uncles := self.selectUncles(parent); len(uncles) > 0 {
+		self.block.SetUncles(uncles)
+	}
+
+	txs, err := fillBlock(self.thelonious, self.block, parent, self.txs)
+	if err != nil {
+		logger.Infoln(err)
+		return false
+	}
+	self.txs = txs
+
+	logger.Infof("Mining on block %d. Includes %v transactions", self.block.Number, len(self.txs))
+
+	// Find a valid nonce
+	self.block.Nonce = self.search(self.block)
+	if self.block.Nonce == nil {
+		return false
+	}
+	if err := sealBlock(self.thelonious, self.block); err != nil {
+		logger.Infoln(err)
+		return false
+	}
+	self.txs = self.thelonious.TxPool().CurrentTransactions()
+	return true
+}
+
+// A new block on top of the chain for the coinbase, and its parent.
+// Nil if the parent isn't built yet or the coinbase may not mine on it
+func newBlock(thelonious monkchain.NodeManager, coinbase []byte) (*monkchain.Block, *monkchain.Block) {
+	chainMan := thelonious.ChainManager()
+	block := chainMan.NewBlock(coinbase)
 
-	parent := self.thelonious.ChainManager().GetBlock(self.block.PrevHash)
+	parent := chainMan.GetBlock(block.PrevHash)
 
 	// if parent is not built yet, return
 	if parent == nil {
-		return
+		return nil, nil
 	}
 
 	// check if we should even bother mining (potential energy savings)
-	if !self.thelonious.Protocol().Participate(self.coinbase, parent) {
-		return
+	if !thelonious.Protocol().Participate(coinbase, parent) {
+		return nil, nil
 	}
+	return block, parent
+}
 
-	// Apply uncles
-	if len(self.uncles) > 0 {
-		self.block.SetUncles(self.uncles)
-	}
+// Run the block's system contracts and the txs the chain's selector
+// picks, leaving it ready for a nonce. Returns the txs still worth
+// mining (all but those that failed), in their order
+func fillBlock(thelonious monkchain.NodeManager, block, parent *monkchain.Block, txs monkchain.Transactions) (monkchain.Transactions, error) {
+	stateManager := thelonious.BlockManager()
 
-	// Sort the transactions by nonce in case of odd network propagation
-	sort.Sort(monkchain.TxByNonce{self.txs})
+	// Run the block start system contracts
+	if err := stateManager.PreCall(block, parent, block.State()); err != nil {
+		return txs, err
+	}
 
-	// Accumulate all valid transactions and apply them to the new state
+	// Pick the txs that fit and apply them to the new state
 	// Error may be ignored. It's not important during mining
-	coinbase := self.block.State().GetOrNewStateObject(self.block.Coinbase)
-	coinbase.SetGasPool(self.block.CalcGasLimit(parent))
-	receipts, txs, unhandledTxs, err := stateManager.ProcessTransactions(coinbase, self.block.State(), self.block, self.block, self.txs)
+	gasPool := thelonious.ChainManager().GasPool(parent)
+	selected := thelonious.ChainManager().TxSelector().Select(txs, gasPool)
+	coinbase := block.State().GetOrNewStateObject(block.Coinbase)
+	coinbase.SetGasPool(gasPool)
+	receipts, handled, unhandled, err := stateManager.ProcessTransactions(coinbase, block.State(), block, block, selected)
 	if err != nil {
 		logger.Debugln(err)
 	}
-	self.txs = append(txs, unhandledTxs...)
-	self.block.SetTxHash(receipts)
+
+	// keep all but the txs that failed
+	failed := make(map[*monkchain.Transaction]bool)
+	for _, tx := range selected {
+		failed[tx] = true
+	}
+	for _, tx := range append(handled, unhandled...) {
+		delete(failed, tx)
+	}
+	var left monkchain.Transactions
+	for _, tx := range txs {
+		if !failed[tx] {
+			left = append(left, tx)
+		}
+	}
+	block.SetTxHash(receipts)
+	if len(receipts) > 0 {
+		block.GasUsed = receipts[len(receipts)-1].CumulativeGasUsed
+	}
 
 	// Set the transactions to the block so the new SHA3 can be calculated
-	self.block.SetReceipts(receipts, txs)
+	block.SetReceipts(receipts, handled)
 
 	// Accumulate the rewards included for this block
-	stateManager.AccumelateRewards(self.block.State(), self.block, parent)
-
-	self.block.State().Update()
+	if err := stateManager.AccumelateRewards(block.State(), block, parent, receipts); err != nil {
+		return left, err
+	}
 
-	logger.Infof("Mining on block %d. Includes %v transactions", self.block.Number, len(self.txs))
+	// Run the block end system contracts
+	if err := stateManager.PostCall(block, parent, block.State()); err != nil {
+		return left, err
+	}
 
-	// Find a valid nonce
-	self.block.Nonce = self.pow.Search(self.block, self.powQuitChan)
-	if self.block.Nonce != nil {
-		// sign the block
-		keypair := self.thelonious.KeyManager().KeyPair()
-		self.block.Sign(keypair.PrivateKey)
-		// process the completed block
-		lchain := monkchain.NewChain(monkchain.Blocks{self.block})
-		_, err := chainMan.TestChain(lchain)
-		if err != nil {
-			logger.Infoln(err)
-		} else {
-			chainMan.InsertChain(lchain)
-			//	self.thelonious.EventMux().Post(chain.NewBlockEvent{block})
-			logger.Infoln("posting new block!")
-			self.thelonious.Reactor().Post("newBlock", self.block)
-			self.thelonious.Broadcast(monkwire.MsgBlockTy, []interface{}{self.block.Value().Val})
-
-			logger.Infof("🔨  Mined block %x\n", self.block.Hash())
-			logger.Infoln(self.block)
-			self.txs = self.thelonious.TxPool().CurrentTransactions()
-		}
+	block.State().Update()
+	return left, nil
+}
 
-		// go self.mineNewBlock()
-		/*
-			err := self.thelonious.BlockManager().Process(self.block, false)
-			if err != nil {
-				logger.Infoln(err)
-			} else {
-				self.thelonious.Broadcast(monkwire.MsgBlockTy, []interface{}{self.block.Value().Val})
-				logger.Infof("🔨  Mined block %x\n", self.block.Hash())
-				logger.Infoln(self.block)
-				// Gather the new batch of transactions currently in the tx pool
-				self.txs = self.thelonious.TxPool().CurrentTransactions()
-			}*/
+// Sign a block with a valid nonce, add it to the chain
+// and tell the peers
+func sealBlock(thelonious monkchain.NodeManager, block *monkchain.Block) error {
+	chainMan := thelonious.ChainManager()
+
+	// sign the block
+	keypair := thelonious.KeyManager().KeyPair()
+	block.Sign(keypair.PrivateKey)
+	// process the completed block
+	lchain := monkchain.NewChain(monkchain.Blocks{block})
+	if _, err := chainMan.TestChain(lchain); err != nil {
+		return err
 	}
+	if err := chainMan.InsertChain(lchain); err != nil {
+		return err
+	}
+	logger.Infoln("posting new block!")
+	thelonious.Reactor().Post("newBlock", block)
+	thelonious.Broadcast(monkwire.MsgBlockTy, []interface{}{block.Value().Val})
+
+	logger.Infof("🔨  Mined block %x\n", block.Hash())
+	logger.Infoln(block)
+	return nil
 }
@@ -0,0 +1,189 @@
+package monkminer
+
+import (
+	"container/list"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkdb"
+	"github.com/eris-ltd/thelonious/monkdoug"
+	"github.com/eris-ltd/thelonious/monkreact"
+	"github.com/eris-ltd/thelonious/monkstate"
+	"github.com/eris-ltd/thelonious/monkutil"
+	"github.com/eris-ltd/thelonious/monkwire"
+)
+
+func searchMiner(workers int) *Miner {
+	db, _ := monkdb.NewMemDatabase()
+	if monkutil.Config == nil {
+		monkutil.Config = &monkutil.ConfigManager{}
+	}
+	monkutil.Config.Db = db
+	return &Miner{
+		pow:         &monkchain.EasyPow{},
+		workers:     workers,
+		hashrate:    &Hashrate{},
+		powQuitChan: make(chan monkreact.Event, 1),
+	}
+}
+
+func TestSearchWorkers(t *testing.T) {
+	miner := searchMiner(4)
+	miner.SetTurbo(true)
+	block := monkchain.CreateBlock(nil, monkchain.ZeroHash256, nil, big.NewInt(1000), nil, "")
+
+	nonce := miner.search(block)
+	if nonce == nil || !miner.pow.Verify(block.HashNoNonce(), block.Difficulty, nonce) {
+		t.Fatalf("Found nonce %x doesn't verify", nonce)
+	}
+	if len(miner.hashes) != 4 {
+		t.Fatalf("Expected hashes for 4 workers, got %d", len(miner.hashes))
+	}
+	var total int64
+	for _, n := range miner.hashes {
+		total += n
+	}
+	if total == 0 {
+		t.Error("No hashes counted")
+	}
+}
+
+func TestSearchInterrupt(t *testing.T) {
+	miner := searchMiner(2)
+	block := monkchain.CreateBlock(nil, monkchain.ZeroHash256, nil, monkutil.BigPow(2, 250), nil, "")
+
+	done := make(chan []byte)
+	go func() { done <- miner.search(block) }()
+	// turbo can be switched while the workers search
+	miner.SetTurbo(true)
+	miner.powQuitChan <- monkreact.Event{Name: "newTx:pre"}
+
+	select {
+	case nonce := <-done:
+		if nonce != nil {
+			t.Errorf("Expected no nonce after an interrupt, got %x", nonce)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Search wasn't interrupted")
+	}
+}
+
+func TestHashrate(t *testing.T) {
+	miner := searchMiner(2)
+	miner.hashes = []int64{0, 0}
+	start := time.Unix(1000, 0)
+	miner.sampled = start
+
+	atomic.StoreInt64(&miner.hashes[0], 1000)
+	atomic.StoreInt64(&miner.hashes[1], 3000)
+	miner.sampleHashrate(start.Add(2 * time.Second))
+	rate := miner.Hashrate()
+	if len(rate.Workers) != 2 || rate.Workers[0] != 500 || rate.Workers[1] != 1500 || rate.Total != 2000 {
+		t.Errorf("Unexpected hashrate %v", rate)
+	}
+	if len(rate.History) != 1 || rate.History[0] != rate.Total {
+		t.Errorf("Expected the total in the history, got %v", rate.History)
+	}
+
+	// only the hashes since the last sample count
+	atomic.StoreInt64(&miner.hashes[0], 1100)
+	miner.sampleHashrate(start.Add(3 * time.Second))
+	if rate := miner.Hashrate(); rate.Total != 100 || len(rate.History) != 2 || rate.History[0] != 2000 {
+		t.Errorf("Unexpected second hashrate %v", rate)
+	}
+}
+
+// A node with just a chain and a block manager
+type callNode struct {
+	bc      *monkchain.ChainManager
+	bm      *monkchain.BlockManager
+	reactor *monkreact.ReactorEngine
+	db      monkutil.Database
+}
+
+func (n *callNode) BlockManager() *monkchain.BlockManager                  { return n.bm }
+func (n *callNode) ChainManager() *monkchain.ChainManager                  { return n.bc }
+func (n *callNode) TxPool() *monkchain.TxPool                              { return nil }
+func (n *callNode) Broadcast(msgType monkwire.MsgType, data []interface{}) {}
+func (n *callNode) Reactor() *monkreact.ReactorEngine                      { return n.reactor }
+func (n *callNode) PeerCount() int                                         { return 0 }
+func (n *callNode) IsMining() bool                                         { return true }
+func (n *callNode) IsListening() bool                                      { return false }
+func (n *callNode) Peers() *list.List                                      { return list.New() }
+func (n *callNode) KeyManager() *monkcrypto.KeyManager                     { return nil }
+func (n *callNode) ClientIdentity() monkwire.ClientIdentity                { return nil }
+func (n *callNode) Db() monkutil.Database                                  { return n.db }
+func (n *callNode) Protocol() monkchain.Protocol                           { return nil }
+
+// A protocol whose system contracts fail as asked
+type callProtocol struct {
+	monkchain.Protocol
+	pre, post error
+}
+
+func (p *callProtocol) ProtocolAt(number *big.Int) monkchain.Protocol { return p }
+
+func (p *callProtocol) PreCall(block, parent *monkchain.Block, state *monkstate.State) error {
+	return p.pre
+}
+
+func (p *callProtocol) PostCall(block, parent *monkchain.Block, state *monkstate.State) error {
+	return p.post
+}
+
+func TestFillBlockCalls(t *testing.T) {
+	for call, p := range map[string]*callProtocol{
+		"":          {},
+		"pre-call":  {pre: fmt.Errorf("no")},
+		"post-call": {post: fmt.Errorf("no")},
+	} {
+		g := &monkdoug.GenesisConfig{Address: "0000000000THISISDOUG", NoGenDoug: true, Difficulty: 4}
+		g.Init()
+		db, _ := monkdb.NewMemDatabase()
+		if monkutil.Config == nil {
+			monkutil.Config = &monkutil.ConfigManager{}
+		}
+		monkutil.Config.Db = db
+		p.Protocol = g.Model()
+		node := &callNode{bc: monkchain.NewChainManagerWithDb(p, db), reactor: monkreact.New(), db: db}
+		node.bm = monkchain.NewBlockManager(node)
+
+		// the miner gives up on the block, as validators would reject it
+		parent := node.bc.CurrentBlock()
+		_, err := fillBlock(node, node.bc.NewBlock(nil), parent, nil)
+		if call == "" && err != nil {
+			t.Errorf("Expected the block to be filled, got %v", err)
+		} else if call != "" && (!monkchain.IsValidationErr(err) || !strings.Contains(err.Error(), call)) {
+			t.Errorf("Expected a failed %s to stop the block, got %v", call, err)
+		}
+	}
+}
+
+func TestFillBlockRewards(t *testing.T) {
+	g := &monkdoug.GenesisConfig{Address: "0000000000THISISDOUG", NoGenDoug: true, Difficulty: 4}
+	g.Init()
+	db, _ := monkdb.NewMemDatabase()
+	if monkutil.Config == nil {
+		monkutil.Config = &monkutil.ConfigManager{}
+	}
+	monkutil.Config.Db = db
+	p := &callProtocol{Protocol: g.Model()}
+	node := &callNode{bc: monkchain.NewChainManagerWithDb(p, db), reactor: monkreact.New(), db: db}
+	node.bm = monkchain.NewBlockManager(node)
+
+	// a block the rewards can't be paid for is given up
+	parent := node.bc.CurrentBlock()
+	block := node.bc.NewBlock(nil)
+	for i := 0; i <= node.bc.UncleRules().Max; i++ {
+		block.Uncles = append(block.Uncles, parent)
+	}
+	if _, err := fillBlock(node, block, parent, nil); !monkchain.IsUncleErr(err) {
+		t.Errorf("Expected an uncle error from the rewards, got %v", err)
+	}
+}
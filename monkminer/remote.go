@@ -0,0 +1,125 @@
+package monkminer
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/eris-ltd/thelonious/monkchain"
+)
+
+/*
+   Sealing work for miners outside the node. Blocks are built
+   like the miner builds them, from the pool's pending txs, and
+   handed out by the hash of their header without the nonce.
+   A nonce that comes back for one of them is verified with the
+   chain's proof of work, and the block is signed with the node's
+   key, added to the chain and broadcast.
+   Work on an old head is dropped once the head moves
+*/
+
+// What an external miner needs to search for a nonce
+type Work struct {
+	Hash       []byte
+	Difficulty *big.Int
+	Number     *big.Int
+}
+
+type RemoteWork struct {
+	thelonious monkchain.NodeManager
+
+	mut sync.Mutex
+	// Blocks handed out, by their hash without the nonce
+	work map[string]*monkchain.Block
+	// The latest block, and the pending txs it was built from
+	current *monkchain.Block
+	txs     monkchain.Transactions
+}
+
+func NewRemoteWork(thelonious monkchain.NodeManager) *RemoteWork {
+	return &RemoteWork{
+		thelonious: thelonious,
+		work:       make(map[string]*monkchain.Block),
+	}
+}
+
+// Work on a block at the head of the chain. The same work is
+// handed out until the head or the pending txs change
+func (self *RemoteWork) GetWork() (*Work, error) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	head := self.thelonious.ChainManager().CurrentBlockHash()
+	txs := self.thelonious.TxPool().CurrentTransactions()
+	if self.current != nil && bytes.Compare(self.current.PrevHash, head) == 0 && sameTxs(self.txs, txs) {
+		return newWork(self.current), nil
+	}
+
+	coinbase := self.thelonious.KeyManager().Address()
+	block, parent := newBlock(self.thelonious, coinbase)
+	if block == nil {
+		return nil, fmt.Errorf("No work for %x on the head %x", coinbase, head)
+	}
+
+	if _, err := fillBlock(self.thelonious, block, parent, txs); err != nil {
+		return nil, err
+	}
+
+	for hash, b := range self.work {
+		if bytes.Compare(b.PrevHash, block.PrevHash) != 0 {
+			delete(self.work, hash)
+		}
+	}
+	self.work[string(block.HashNoNonce())] = block
+	self.current, self.txs = block, txs
+
+	logger.Infof("Handing out work on block %d. Includes %v transactions", block.Number, len(block.Transactions()))
+	return newWork(block), nil
+}
+
+// Seal the block handed out for the hash with the nonce
+func (self *RemoteWork) SubmitWork(hash, nonce []byte) error {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+
+	block, ok := self.work[string(hash)]
+	if !ok {
+		return fmt.Errorf("Unknown or stale work %x", hash)
+	}
+	pow := self.thelonious.ChainManager().NewPoW()
+	if !pow.Verify(hash, block.Difficulty, nonce) {
+		return fmt.Errorf("Invalid nonce %x for work %x", nonce, hash)
+	}
+
+	block.Nonce = nonce
+	if err := sealBlock(self.thelonious, block); err != nil {
+		block.Nonce = nil
+		return err
+	}
+	delete(self.work, string(hash))
+	if self.current == block {
+		self.current = nil
+	}
+	return nil
+}
+
+func newWork(block *monkchain.Block) *Work {
+	return &Work{
+		Hash:       block.HashNoNonce(),
+		Difficulty: new(big.Int).Set(block.Difficulty),
+		Number:     new(big.Int).Set(block.Number),
+	}
+}
+
+func sameTxs(a, b monkchain.Transactions) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if bytes.Compare(a[i].Hash(), b[i].Hash()) != 0 {
+			return false
+		}
+	}
+	return true
+}
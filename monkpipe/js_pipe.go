@@ -3,10 +3,12 @@ package monkpipe
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"sync/atomic"
 
 	"github.com/eris-ltd/thelonious/monkchain"
 	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkminer"
 	"github.com/eris-ltd/thelonious/monkstate"
 	"github.com/eris-ltd/thelonious/monkutil"
 )
@@ -34,6 +36,25 @@ func (self *JSPipe) BlockByNumber(num int32) *JSBlock {
 	return NewJSBlock(self.obj.ChainManager().GetBlockByNumber(uint64(num)))
 }
 
+// Canonical blocks from from to to (inclusive), newest
+// first if from is above to. -1 is the current block
+func (self *JSPipe) BlocksInRange(from, to int32) []*JSBlock {
+	chainManager := self.obj.ChainManager()
+	number := func(n int32) uint64 {
+		if n < 0 {
+			return chainManager.CurrentBlockNumber()
+		}
+		return uint64(n)
+	}
+
+	var blocks []*JSBlock
+	chainManager.IterateBlocks(number(from), number(to), func(block *monkchain.Block) bool {
+		blocks = append(blocks, NewJSBlock(block))
+		return true
+	})
+	return blocks
+}
+
 func (self *JSPipe) Block(v interface{}) *JSBlock {
 	if n, ok := v.(int32); ok {
 		return self.BlockByNumber(n)
@@ -46,6 +67,74 @@ func (self *JSPipe) Block(v interface{}) *JSBlock {
 	return nil
 }
 
+// A transaction on the canonical chain by hash (nil if none)
+func (self *JSPipe) Transaction(strHash string) *JSTransaction {
+	chainManager := self.obj.ChainManager()
+	block, index := chainManager.GetTransactionBlock(monkutil.Hex2Bytes(strHash))
+	if block == nil {
+		return nil
+	}
+
+	tx := NewJSTx(block.Receipts()[index].Tx)
+	tx.BlockHash = monkutil.Bytes2Hex(block.Hash())
+	tx.BlockNumber = int(block.Number.Uint64())
+	tx.Index = int(index)
+	tx.Confirmations = int(chainManager.CurrentBlockNumber()-block.Number.Uint64()) + 1
+	return tx
+}
+
+// The receipt of a transaction on the canonical chain by hash (nil if none)
+func (self *JSPipe) Receipt(strHash string) *JSTxReceipt {
+	chainManager := self.obj.ChainManager()
+	block, index := chainManager.GetTransactionBlock(monkutil.Hex2Bytes(strHash))
+	if block == nil {
+		return nil
+	}
+
+	confirmations := int(chainManager.CurrentBlockNumber()-block.Number.Uint64()) + 1
+	return NewJSTxReceipt(block, int(index), confirmations)
+}
+
+// A page of the canonical txs touching an address, newest first
+// (nil if the address index is off)
+func (self *JSPipe) AddressTxs(addr string, offset, limit int) *JSAddressTxs {
+	chainManager := self.obj.ChainManager()
+	if !chainManager.AddressIndex() {
+		return nil
+	}
+
+	refs, total := chainManager.AddressTxs(monkutil.UserHex2Bytes(addr), uint64(offset), uint64(limit))
+	txs := &JSAddressTxs{Address: addr, Total: int(total), Txs: []*JSTransaction{}}
+	for _, ref := range refs {
+		block := chainManager.GetBlockCanonical(ref.BlockHash)
+		if block == nil || ref.Index >= uint64(len(block.Receipts())) {
+			continue
+		}
+
+		tx := NewJSTx(block.Receipts()[ref.Index].Tx)
+		tx.BlockHash = monkutil.Bytes2Hex(ref.BlockHash)
+		tx.BlockNumber = int(ref.Number)
+		tx.Index = int(ref.Index)
+		tx.Internal = ref.Internal
+		tx.Confirmations = int(chainManager.CurrentBlockNumber()-ref.Number) + 1
+		txs.Txs = append(txs.Txs, tx)
+	}
+	return txs
+}
+
+// The txs journaled by the tx pool, kept across restarts
+func (self *JSPipe) TxJournal() []*JSTransaction {
+	txs := []*JSTransaction{}
+	for _, tx := range self.obj.TxPool().Journal() {
+		txs = append(txs, NewJSTx(tx))
+	}
+	return txs
+}
+
+func (self *JSPipe) ClearTxJournal() {
+	self.obj.TxPool().ClearJournal()
+}
+
 func (self *JSPipe) Key() *JSKey {
 	return NewJSKey(self.obj.KeyManager().KeyPair())
 }
@@ -77,6 +166,73 @@ func (self *JSPipe) IsMining() bool {
 	return self.obj.IsMining()
 }
 
+// Optional interface for nodes that keep a handle on their miner
+type minerNode interface {
+	Miner() *monkminer.Miner
+}
+
+func (self *JSPipe) miner() *monkminer.Miner {
+	if node, ok := self.obj.(minerNode); ok {
+		return node.Miner()
+	}
+	return nil
+}
+
+// The miner's hashrate per worker, in total, and over the
+// last minute (nil if there's no miner)
+func (self *JSPipe) Hashrate() *JSHashrate {
+	miner := self.miner()
+	if miner == nil {
+		return nil
+	}
+	return NewJSHashrate(miner.Hashrate(), miner.Turbo())
+}
+
+// Turn the miner's turbo on or off. False if there's no miner
+func (self *JSPipe) SetTurbo(on bool) bool {
+	miner := self.miner()
+	if miner == nil {
+		return false
+	}
+	miner.SetTurbo(on)
+	return true
+}
+
+// Optional interface for nodes that hand out sealing work
+type remoteWorkNode interface {
+	RemoteWork() *monkminer.RemoteWork
+}
+
+func (self *JSPipe) remoteWork() *monkminer.RemoteWork {
+	if node, ok := self.obj.(remoteWorkNode); ok {
+		return node.RemoteWork()
+	}
+	return nil
+}
+
+// Work for an external miner: the hash of the
+// next block without its nonce, its difficulty and number
+func (self *JSPipe) GetWork() (*JSWork, error) {
+	remote := self.remoteWork()
+	if remote == nil {
+		return nil, fmt.Errorf("No remote work")
+	}
+	work, err := remote.GetWork()
+	if err != nil {
+		return nil, err
+	}
+	return NewJSWork(work), nil
+}
+
+// Seal the block for the work's hash with an external miner's nonce
+func (self *JSPipe) SubmitWork(hash, nonce string) error {
+	remote := self.remoteWork()
+	if remote == nil {
+		return fmt.Errorf("No remote work")
+	}
+	return remote.SubmitWork(monkutil.Hex2Bytes(hash), monkutil.Hex2Bytes(nonce))
+}
+
 func (self *JSPipe) IsListening() bool {
 	return self.obj.IsListening()
 }
@@ -231,7 +387,7 @@ func (self *JSPipe) Transact(key, toStr, valueStr, gasStr, gasPriceStr, codeStr
 	self.obj.BlockManager().TransState().UpdateStateObject(acc)
 
 	tx.Sign(keyPair.PrivateKey)
-	self.obj.TxPool().QueueTransaction(tx)
+	self.obj.TxPool().QueueLocalTransaction(tx)
 
 	if contractCreation {
 		logger.Infof("Contract addr %x", tx.CreationAddress())
@@ -242,7 +398,7 @@ func (self *JSPipe) Transact(key, toStr, valueStr, gasStr, gasPriceStr, codeStr
 
 func (self *JSPipe) PushTx(txStr string) (*JSReceipt, error) {
 	tx := monkchain.NewTransactionFromBytes(monkutil.Hex2Bytes(txStr))
-	self.obj.TxPool().QueueTransaction(tx)
+	self.obj.TxPool().QueueLocalTransaction(tx)
 	return NewJSReciept(tx.CreatesContract(), tx.CreationAddress(), tx.Hash(), tx.Sender()), nil
 }
 
@@ -2,11 +2,13 @@ package monkpipe
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
 	"strings"
 
 	"github.com/eris-ltd/thelonious/monkchain"
 	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkminer"
 	"github.com/eris-ltd/thelonious/monkstate"
 	"github.com/eris-ltd/thelonious/monkutil"
 )
@@ -81,6 +83,12 @@ type JSTransaction struct {
 	Contract        bool   `json:"isContract"`
 	CreatesContract bool   `json:"createsContract"`
 	Confirmations   int    `json:"confirmations"`
+	// set for txs looked up by hash
+	BlockHash   string `json:"blockHash,omitempty"`
+	BlockNumber int    `json:"blockNumber,omitempty"`
+	Index       int    `json:"index,omitempty"`
+	// set for txs looked up by address
+	Internal bool `json:"internal,omitempty"`
 }
 
 func NewJSTx(tx *monkchain.Transaction) *JSTransaction {
@@ -191,6 +199,88 @@ func NewJSReciept(contractCreation bool, creationAddress, hash, address []byte)
 	}
 }
 
+// Receipt of a transaction on the canonical chain
+type JSTxReceipt struct {
+	Hash              string   `json:"hash"`
+	BlockHash         string   `json:"blockHash"`
+	BlockNumber       int      `json:"blockNumber"`
+	Index             int      `json:"index"`
+	PostState         string   `json:"postState"`
+	GasUsed           string   `json:"gasUsed"`
+	CumulativeGasUsed string   `json:"cumulativeGasUsed"`
+	Logs              []*JSLog `json:"logs"`
+	Confirmations     int      `json:"confirmations"`
+}
+
+func NewJSTxReceipt(block *monkchain.Block, index int, confirmations int) *JSTxReceipt {
+	receipts := block.Receipts()
+	receipt := receipts[index]
+	gasUsed := new(big.Int).Set(receipt.CumulativeGasUsed)
+	if index > 0 {
+		gasUsed.Sub(gasUsed, receipts[index-1].CumulativeGasUsed)
+	}
+
+	return &JSTxReceipt{
+		Hash:              monkutil.Bytes2Hex(receipt.Tx.Hash()),
+		BlockHash:         monkutil.Bytes2Hex(block.Hash()),
+		BlockNumber:       int(block.Number.Uint64()),
+		Index:             index,
+		PostState:         monkutil.Bytes2Hex(receipt.PostState),
+		GasUsed:           gasUsed.String(),
+		CumulativeGasUsed: receipt.CumulativeGasUsed.String(),
+		Logs:              NewJSLogs(receipt.Logs),
+		Confirmations:     confirmations,
+	}
+}
+
+type JSLog struct {
+	Address string   `json:"address"`
+	Topics  []string `json:"topics"`
+	Data    string   `json:"data"`
+}
+
+func NewJSLogs(logs monkstate.Logs) []*JSLog {
+	jsLogs := []*JSLog{}
+	for _, log := range logs {
+		jsLog := &JSLog{Address: monkutil.Bytes2Hex(log.Address), Topics: []string{}, Data: monkutil.Bytes2Hex(log.Data)}
+		for _, topic := range log.Topics {
+			jsLog.Topics = append(jsLog.Topics, monkutil.Bytes2Hex(topic))
+		}
+		jsLogs = append(jsLogs, jsLog)
+	}
+	return jsLogs
+}
+
+// A page of the txs touching an address, newest first
+type JSAddressTxs struct {
+	Address string           `json:"address"`
+	Total   int              `json:"total"`
+	Txs     []*JSTransaction `json:"txs"`
+}
+
+// A miner's hashes per second
+type JSHashrate struct {
+	Workers []int64 `json:"workers"`
+	Total   int64   `json:"total"`
+	History []int64 `json:"history"`
+	Turbo   bool    `json:"turbo"`
+}
+
+func NewJSHashrate(rate *monkminer.Hashrate, turbo bool) *JSHashrate {
+	return &JSHashrate{Workers: rate.Workers, Total: rate.Total, History: rate.History, Turbo: turbo}
+}
+
+// Sealing work for an external miner
+type JSWork struct {
+	Hash       string `json:"hash"`
+	Difficulty string `json:"difficulty"`
+	Number     int    `json:"number"`
+}
+
+func NewJSWork(work *monkminer.Work) *JSWork {
+	return &JSWork{Hash: monkutil.Bytes2Hex(work.Hash), Difficulty: work.Difficulty.String(), Number: int(work.Number.Uint64())}
+}
+
 type JSMessage struct {
 	To        string `json:"to"`
 	From      string `json:"from"`
@@ -2,6 +2,8 @@ package monkpipe
 
 import (
 	//"strings"
+	"math/big"
+	"time"
 
 	"github.com/eris-ltd/thelonious/monkchain"
 	"github.com/eris-ltd/thelonious/monkcrypto"
@@ -13,6 +15,9 @@ import (
 
 var logger = monklog.NewLogger("PIPE")
 
+// How long Transact searches for a tx proof of work
+var TxPowLimit = time.Minute
+
 type VmVars struct {
 	State *monkstate.State
 }
@@ -157,10 +162,17 @@ func (self *Pipe) Transact(key *monkcrypto.KeyPair, rec []byte, value, gas, pric
 
 	acc := self.stateManager.TransState().GetOrNewStateObject(key.Address())
 	tx.Nonce = acc.Nonce
+	tx.Sign(key.PrivateKey)
+	// some chains require work on transactions
+	number := new(big.Int).SetUint64(self.obj.ChainManager().CurrentBlockNumber() + 1)
+	if diff := self.obj.ChainManager().TxDifficulty(number, self.stateManager.CurrentState()); diff != nil {
+		if err := tx.SolvePow(diff, TxPowLimit); err != nil {
+			return nil, err
+		}
+	}
 	acc.Nonce += 1
 	self.stateManager.TransState().UpdateStateObject(acc)
-	tx.Sign(key.PrivateKey)
-	self.obj.TxPool().QueueTransaction(tx)
+	self.obj.TxPool().QueueLocalTransaction(tx)
 
 	if contractCreation {
 		logger.Infof("Contract addr %x", tx.CreationAddress())
@@ -172,7 +184,7 @@ func (self *Pipe) Transact(key *monkcrypto.KeyPair, rec []byte, value, gas, pric
 }
 
 func (self *Pipe) PushTx(tx *monkchain.Transaction) ([]byte, error) {
-	self.obj.TxPool().QueueTransaction(tx)
+	self.obj.TxPool().QueueLocalTransaction(tx)
 	if tx.Recipient == nil {
 		logger.Infof("Contract addr %x", tx.CreationAddress())
 		return tx.CreationAddress(), nil
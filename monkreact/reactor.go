@@ -74,6 +74,8 @@ type ReactorEngine struct {
 	quit          chan chan error
 	running       bool
 	drained       chan bool
+	// closed when the dispatch loop stops
+	stopped chan struct{}
 }
 
 func New() *ReactorEngine {
@@ -89,6 +91,7 @@ func (reactor *ReactorEngine) Start() {
 	reactor.lock.Lock()
 	defer reactor.lock.Unlock()
 	if !reactor.running {
+		reactor.stopped = make(chan struct{})
 		go func() {
 			for {
 				select {
@@ -96,6 +99,7 @@ func (reactor *ReactorEngine) Start() {
 					reactor.lock.Lock()
 					defer reactor.lock.Unlock()
 					reactor.running = false
+					close(reactor.stopped)
 					logger.Infoln("stopped")
 					status <- nil
 					return
@@ -159,11 +163,19 @@ func (reactor *ReactorEngine) Unsubscribe(event string, eventChannel chan Event)
 }
 
 func (reactor *ReactorEngine) Post(event string, resource interface{}) {
-	reactor.lock.Lock()
-	defer reactor.lock.Unlock()
-
-	if reactor.running {
-		reactor.eventChannel <- Event{Resource: resource, Name: event}
+	// don't hold the lock while sending: dispatch needs it
+	// to drain the channel if it's full. Once stopped,
+	// nothing drains it, so give up on the event
+	reactor.lock.RLock()
+	running, stopped := reactor.running, reactor.stopped
+	reactor.lock.RUnlock()
+
+	if running {
+		select {
+		case reactor.eventChannel <- Event{Resource: resource, Name: event}:
+		case <-stopped:
+			return
+		}
 		select {
 		case <-reactor.drained:
 		default:
@@ -3,6 +3,7 @@ package monkreact
 import (
 	"fmt"
 	"testing"
+	"time"
 )
 
 func TestReactorAdd(t *testing.T) {
@@ -61,3 +62,24 @@ func TestReactorEvent(t *testing.T) {
 	}
 	reactor.Stop()
 }
+
+func TestReactorPostAfterStop(t *testing.T) {
+	reactor := New()
+	reactor.Start()
+	reactor.Stop()
+
+	// a post that saw the reactor running, after the loop stopped
+	reactor.running = true
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 2*eventBufferSize; i++ {
+			reactor.Post("test", i)
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Post blocked on a stopped reactor")
+	}
+}
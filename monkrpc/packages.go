@@ -75,11 +75,151 @@ func (p *TheloniousApi) GetBlock(args *GetBlockArgs, reply *string) error {
 		return err
 	}
 
-	block := p.pipe.BlockByHash(args.Hash)
+	var block *monkpipe.JSBlock
+	if args.Hash != "" {
+		block = p.pipe.BlockByHash(args.Hash)
+	} else {
+		block = p.pipe.BlockByNumber(int32(args.BlockNumber))
+	}
 	*reply = NewSuccessRes(block)
 	return nil
 }
 
+// Most blocks returned by one GetBlocksInRange call
+const maxBlocksInRange = 256
+
+type GetBlocksInRangeArgs struct {
+	From int
+	To   int
+}
+
+func (a *GetBlocksInRangeArgs) requirements() error {
+	if a.From < -1 || a.To < -1 {
+		return NewErrorResponse("GetBlocksInRange requires 'from' and 'to' block numbers (-1 for the latest)")
+	}
+	return nil
+}
+
+// Blocks from 'from' to 'to', newest first if 'from' is above 'to'.
+// Long ranges are cut off after maxBlocksInRange blocks
+func (p *TheloniousApi) GetBlocksInRange(args *GetBlocksInRangeArgs, reply *string) error {
+	err := args.requirements()
+	if err != nil {
+		return err
+	}
+
+	from, to := args.From, args.To
+	head := p.pipe.BlockByNumber(-1).Number
+	if from < 0 {
+		from = head
+	}
+	if to < 0 {
+		to = head
+	}
+	if to-from >= maxBlocksInRange {
+		to = from + maxBlocksInRange - 1
+	} else if from-to >= maxBlocksInRange {
+		to = from - maxBlocksInRange + 1
+	}
+
+	blocks := p.pipe.BlocksInRange(int32(from), int32(to))
+	*reply = NewSuccessRes(blocks)
+	return nil
+}
+
+type GetTxArgs struct {
+	Hash string
+}
+
+func (a *GetTxArgs) requirements() error {
+	if a.Hash == "" {
+		return NewErrorResponse("GetTransaction and GetReceipt require a tx 'hash' as argument")
+	}
+	return nil
+}
+
+// A transaction on the canonical chain, with its block and confirmations
+func (p *TheloniousApi) GetTransaction(args *GetTxArgs, reply *string) error {
+	err := args.requirements()
+	if err != nil {
+		return err
+	}
+
+	tx := p.pipe.Transaction(args.Hash)
+	if tx == nil {
+		return NewErrorResponse(fmt.Sprintf("Transaction %s not found", args.Hash))
+	}
+	*reply = NewSuccessRes(tx)
+	return nil
+}
+
+// The receipt of a transaction on the canonical chain.
+// Poll with the hash from Transact until it's confirmed
+func (p *TheloniousApi) GetReceipt(args *GetTxArgs, reply *string) error {
+	err := args.requirements()
+	if err != nil {
+		return err
+	}
+
+	receipt := p.pipe.Receipt(args.Hash)
+	if receipt == nil {
+		return NewErrorResponse(fmt.Sprintf("Receipt for %s not found", args.Hash))
+	}
+	*reply = NewSuccessRes(receipt)
+	return nil
+}
+
+// Most txs returned by one call to GetAddressTxs
+const maxAddressTxs = 256
+
+type GetAddressTxsArgs struct {
+	Address string
+	Offset  int
+	Limit   int
+}
+
+func (a *GetAddressTxsArgs) requirements() error {
+	if a.Address == "" {
+		return NewErrorResponse("GetAddressTxs requires an 'address' as argument")
+	}
+	if a.Offset < 0 || a.Limit < 0 {
+		return NewErrorResponse("GetAddressTxs requires a non-negative 'offset' and 'limit'")
+	}
+	return nil
+}
+
+// A page of the txs sent from or to an address (including by contracts),
+// newest first, and the total number of them. Needs the address index on
+func (p *TheloniousApi) GetAddressTxs(args *GetAddressTxsArgs, reply *string) error {
+	err := args.requirements()
+	if err != nil {
+		return err
+	}
+
+	limit := args.Limit
+	if limit == 0 || limit > maxAddressTxs {
+		limit = maxAddressTxs
+	}
+	txs := p.pipe.AddressTxs(args.Address, args.Offset, limit)
+	if txs == nil {
+		return NewErrorResponse("The address index is off")
+	}
+	*reply = NewSuccessRes(txs)
+	return nil
+}
+
+// The txs the tx pool journals to keep across restarts
+func (p *TheloniousApi) GetTxJournal(args *interface{}, reply *string) error {
+	*reply = NewSuccessRes(p.pipe.TxJournal())
+	return nil
+}
+
+func (p *TheloniousApi) ClearTxJournal(args *interface{}, reply *string) error {
+	p.pipe.ClearTxJournal()
+	*reply = NewSuccessRes(nil)
+	return nil
+}
+
 type NewTxArgs struct {
 	Sec       string
 	Recipient string
@@ -251,6 +391,68 @@ func (p *TheloniousApi) GetIsMining(args *interface{}, reply *string) error {
 	return nil
 }
 
+// The miner's hashes per second, per worker, in total and over the last minute
+func (p *TheloniousApi) GetHashrate(args *interface{}, reply *string) error {
+	rate := p.pipe.Hashrate()
+	if rate == nil {
+		return NewErrorResponse("Not mining")
+	}
+	*reply = NewSuccessRes(rate)
+	return nil
+}
+
+type SetTurboArgs struct {
+	Turbo bool
+}
+
+func (p *TheloniousApi) SetTurbo(args *SetTurboArgs, reply *string) error {
+	if !p.pipe.SetTurbo(args.Turbo) {
+		return NewErrorResponse("Not mining")
+	}
+	*reply = NewSuccessRes(GetMiningRes{IsMining: true})
+	return nil
+}
+
+// Work for an external miner: the next block's hash
+// without its nonce, its difficulty and number
+func (p *TheloniousApi) GetWork(args *interface{}, reply *string) error {
+	work, err := p.pipe.GetWork()
+	if err != nil {
+		return NewErrorResponse(err.Error())
+	}
+	*reply = NewSuccessRes(work)
+	return nil
+}
+
+type SubmitWorkArgs struct {
+	Hash  string
+	Nonce string
+}
+
+func (a *SubmitWorkArgs) requirements() error {
+	if a.Hash == "" || a.Nonce == "" {
+		return NewErrorResponse("SubmitWork requires a 'hash' and a 'nonce' as arguments")
+	}
+	return nil
+}
+
+type SubmitWorkRes struct {
+	Accepted bool `json:"accepted"`
+}
+
+// Seal the block for a hash from GetWork with the nonce found for it
+func (p *TheloniousApi) SubmitWork(args *SubmitWorkArgs, reply *string) error {
+	err := args.requirements()
+	if err != nil {
+		return err
+	}
+	if err := p.pipe.SubmitWork(args.Hash, args.Nonce); err != nil {
+		return NewErrorResponse(err.Error())
+	}
+	*reply = NewSuccessRes(SubmitWorkRes{Accepted: true})
+	return nil
+}
+
 type GetTxCountArgs struct {
 	Address string `json:"address"`
 }
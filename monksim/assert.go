@@ -0,0 +1,79 @@
+package monksim
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Nodes by the hash of their head block
+func (s *Simulator) Heads() map[string][]int {
+	heads := make(map[string][]int)
+	for _, node := range s.Nodes {
+		hash := fmt.Sprintf("%x", node.ChainManager().CurrentBlockHash())
+		heads[hash] = append(heads[hash], node.Index)
+	}
+	return heads
+}
+
+// Whether the nodes disagree on their head
+func (s *Simulator) Forked() bool {
+	return len(s.Heads()) > 1
+}
+
+// Error unless every node has the same head,
+// or the run failed
+func (s *Simulator) Converged() error {
+	if s.err != nil {
+		return s.err
+	}
+	heads := s.Heads()
+	if len(heads) <= 1 {
+		return nil
+	}
+	var views []string
+	for hash, nodes := range heads {
+		views = append(views, fmt.Sprintf("%v at %s", nodes, hash[:8]))
+	}
+	sort.Strings(views)
+	return fmt.Errorf("Nodes have %d heads: %v", len(heads), views)
+}
+
+// Highest block number every node has
+func (s *Simulator) CommonHeight() uint64 {
+	var height uint64
+	for i, node := range s.Nodes {
+		n := node.ChainManager().CurrentBlockNumber()
+		if i == 0 || n < height {
+			height = n
+		}
+	}
+	return height
+}
+
+// Error unless every node has the same block at height
+func (s *Simulator) Agree(height uint64) error {
+	var hash []byte
+	for _, node := range s.Nodes {
+		block := node.ChainManager().GetBlockByNumber(height)
+		if block == nil {
+			return fmt.Errorf("Node %d has no block #%d", node.Index, height)
+		}
+		if hash == nil {
+			hash = block.Hash()
+		} else if !bytes.Equal(hash, block.Hash()) {
+			return fmt.Errorf("Node %d has block #%d %x, node 0 has %x", node.Index, height, block.Hash()[:4], hash[:4])
+		}
+	}
+	return nil
+}
+
+// Error unless every node has committed the same block at height
+func (s *Simulator) Finalized(height uint64) error {
+	for _, node := range s.Nodes {
+		if h := node.ChainManager().CommittedHeight(); h < height {
+			return fmt.Errorf("Node %d has only committed up to #%d", node.Index, h)
+		}
+	}
+	return s.Agree(height)
+}
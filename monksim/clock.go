@@ -0,0 +1,111 @@
+package monksim
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// A virtual clock. Time only moves when the simulation
+// advances it, running anything scheduled along the way
+// in order (by time, then by when it was scheduled)
+type Clock struct {
+	mut    sync.Mutex
+	now    time.Time
+	seq    uint64
+	events eventQueue
+
+	// Called before each timer set with Every runs,
+	// so the nodes can finish with what arrived before it
+	Quiesce func()
+}
+
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.now
+}
+
+// Run f once the clock has advanced by d
+func (c *Clock) AfterFunc(d time.Duration, f func()) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.seq += 1
+	heap.Push(&c.events, &event{at: c.now.Add(d), seq: c.seq, f: f})
+}
+
+// Run f every d until quit is closed. f runs while
+// the clock advances, so it shouldn't wait on the clock
+func (c *Clock) Every(d time.Duration, quit chan bool, f func()) {
+	var tick func()
+	tick = func() {
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		if c.Quiesce != nil {
+			c.Quiesce()
+		}
+		f()
+		c.AfterFunc(d, tick)
+	}
+	c.AfterFunc(d, tick)
+}
+
+// Move the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mut.Lock()
+	until := c.now.Add(d)
+	for len(c.events) > 0 && !c.events[0].at.After(until) {
+		ev := heap.Pop(&c.events).(*event)
+		if ev.at.After(c.now) {
+			c.now = ev.at
+		}
+		// events may schedule more events
+		c.mut.Unlock()
+		ev.f()
+		c.mut.Lock()
+	}
+	c.now = until
+	c.mut.Unlock()
+}
+
+// Number of events waiting to run
+func (c *Clock) Pending() int {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return len(c.events)
+}
+
+type event struct {
+	at  time.Time
+	seq uint64
+	f   func()
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x interface{}) {
+	*q = append(*q, x.(*event))
+}
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	ev := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return ev
+}
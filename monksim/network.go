@@ -0,0 +1,432 @@
+package monksim
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+/*
+   An in-memory network between simulated nodes.
+   Every write on a connection arrives whole at the other end
+   after the network's latency (in virtual time), unless it is
+   lost or the two hosts are partitioned. Writes on a connection
+   arrive in order, like tcp. Losses are drawn from a random
+   source per pair of hosts, seeded from the network's seed,
+   so the same traffic sees the same losses on every run.
+   Read deadlines are kept by the virtual clock too.
+*/
+
+type Network struct {
+	clock *Clock
+	seed  int64
+
+	mut       sync.Mutex
+	latency   time.Duration
+	jitter    time.Duration
+	loss      float64
+	groups    map[string]int
+	listeners map[string]*listener
+	links     map[string]*link
+	conns     map[*conn]bool
+	dials     int
+	nextPort  int
+}
+
+// Per pair of hosts
+type link struct {
+	rand *rand.Rand
+	// latest delivery, so writes arrive in order
+	last time.Time
+}
+
+func NewNetwork(clock *Clock, seed int64) *Network {
+	return &Network{
+		clock:     clock,
+		seed:      seed,
+		listeners: make(map[string]*listener),
+		links:     make(map[string]*link),
+		conns:     make(map[*conn]bool),
+		nextPort:  40000,
+	}
+}
+
+// Every write takes latency plus up to jitter to arrive
+func (n *Network) SetLatency(latency, jitter time.Duration) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	n.latency = latency
+	n.jitter = jitter
+}
+
+// Fraction of writes that are lost
+func (n *Network) SetLoss(loss float64) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	n.loss = loss
+}
+
+// Split the network. Hosts can only reach hosts in the same group.
+// Hosts not in any group form a group of their own
+func (n *Network) Partition(groups ...[]string) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	n.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, host := range group {
+			n.groups[host] = i + 1
+		}
+	}
+}
+
+// Undo any partition
+func (n *Network) Heal() {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	n.groups = nil
+}
+
+// Whether from can reach to under the current partition
+func (n *Network) Reachable(from, to string) bool {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	return n.reachable(from, to)
+}
+
+func (n *Network) reachable(from, to string) bool {
+	return n.groups == nil || n.groups[from] == n.groups[to]
+}
+
+func (n *Network) link(from, to string) *link {
+	key := from + ">" + to
+	l, ok := n.links[key]
+	if !ok {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		l = &link{rand: rand.New(rand.NewSource(n.seed ^ int64(h.Sum64())))}
+		n.links[key] = l
+	}
+	return l
+}
+
+// Schedule a write for delivery to the other end of c
+func (n *Network) send(c *conn, data []byte) {
+	n.mut.Lock()
+	from, to := c.local.IP.String(), c.remote.IP.String()
+	l := n.link(from, to)
+	if !n.reachable(from, to) || l.rand.Float64() < n.loss {
+		n.mut.Unlock()
+		return
+	}
+	delay := n.latency
+	if n.jitter > 0 {
+		delay += time.Duration(l.rand.Int63n(int64(n.jitter)))
+	}
+	now := n.clock.Now()
+	at := now.Add(delay)
+	if at.Before(l.last) {
+		at = l.last
+	}
+	l.last = at
+	n.mut.Unlock()
+
+	peer := c.peer
+	n.clock.AfterFunc(at.Sub(now), func() {
+		peer.deliver(data)
+	})
+}
+
+// Number of dials so far, whether they connected or not
+func (n *Network) Dials() int {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	return n.dials
+}
+
+// Whether there's an open connection between the hosts
+func (n *Network) Linked(a, b string) bool {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	for c := range n.conns {
+		if c.local.IP.String() == a && c.remote.IP.String() == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether every open connection has a reader that's read
+// it to the end and is done with what it read
+func (n *Network) Idle() bool {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	for c := range n.conns {
+		if !c.idle() {
+			return false
+		}
+	}
+	return true
+}
+
+// A transport for the node at host
+func (n *Network) Transport(host string) *Transport {
+	return &Transport{net: n, host: host}
+}
+
+type Transport struct {
+	net  *Network
+	host string
+}
+
+// Listen on a port (addr is ":port")
+func (t *Transport) Listen(addr string) (net.Listener, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	laddr := t.host + ":" + port
+
+	n := t.net
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	if _, ok := n.listeners[laddr]; ok {
+		return nil, fmt.Errorf("Address %s in use", laddr)
+	}
+	l := &listener{
+		net:    n,
+		addr:   tcpAddr(laddr),
+		accept: make(chan *conn, 16),
+		closed: make(chan struct{}),
+	}
+	n.listeners[laddr] = l
+	return l, nil
+}
+
+func (t *Transport) Dial(addr string) (net.Conn, error) {
+	// the simulation waits for dials
+	defer monkutil.NotifyIdle()
+	n := t.net
+	n.mut.Lock()
+	n.dials += 1
+	l, ok := n.listeners[addr]
+	remote := tcpAddr(addr)
+	if !ok || !n.reachable(t.host, remote.IP.String()) {
+		n.mut.Unlock()
+		return nil, fmt.Errorf("Could not reach %s", addr)
+	}
+	local := tcpAddr(t.host + ":" + strconv.Itoa(n.nextPort))
+	n.nextPort += 1
+
+	client, server := newConn(n, local, remote), newConn(n, remote, local)
+	client.peer, server.peer = server, client
+	n.conns[client], n.conns[server] = true, true
+	n.mut.Unlock()
+
+	select {
+	case l.accept <- server:
+		return client, nil
+	case <-l.closed:
+		client.Close()
+		return nil, fmt.Errorf("Could not reach %s", addr)
+	}
+}
+
+func tcpAddr(addr string) *net.TCPAddr {
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: p}
+}
+
+type listener struct {
+	net    *Network
+	addr   *net.TCPAddr
+	accept chan *conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("Listener on %v closed", l.addr)
+	}
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() {
+		l.net.mut.Lock()
+		delete(l.net.listeners, l.addr.String())
+		l.net.mut.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+// One end of a connection. Reads return at most
+// one write from the other end at a time
+type conn struct {
+	net           *Network
+	local, remote *net.TCPAddr
+	peer          *conn
+
+	mut     sync.Mutex
+	writes  [][]byte
+	partial []byte
+	// by the virtual clock
+	deadline time.Time
+	alarm    time.Time
+	// the reader has started, and whether it has
+	// data it hasn't come back from
+	reading  bool
+	handling bool
+	closed   bool
+	notify   chan struct{}
+}
+
+func newConn(n *Network, local, remote *net.TCPAddr) *conn {
+	return &conn{net: n, local: local, remote: remote, notify: make(chan struct{}, 1)}
+}
+
+func (c *conn) deliver(data []byte) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.closed {
+		return
+	}
+	c.writes = append(c.writes, data)
+	c.wake()
+}
+
+// must hold the lock
+func (c *conn) wake() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *conn) idle() bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.closed || (c.reading && len(c.writes) == 0 && len(c.partial) == 0 && !c.handling)
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	for {
+		c.mut.Lock()
+		c.reading, c.handling = true, false
+		if len(c.partial) == 0 && len(c.writes) > 0 {
+			c.partial, c.writes = c.writes[0], c.writes[1:]
+		}
+		if len(c.partial) > 0 {
+			n := copy(b, c.partial)
+			c.partial = c.partial[n:]
+			c.handling = true
+			c.mut.Unlock()
+			return n, nil
+		}
+		if c.closed {
+			c.mut.Unlock()
+			return 0, io.EOF
+		}
+		if !c.deadline.IsZero() {
+			now := c.net.clock.Now()
+			if !now.Before(c.deadline) {
+				c.mut.Unlock()
+				return 0, timeoutError{}
+			}
+			// wake up when the deadline passes
+			if !c.alarm.Equal(c.deadline) {
+				c.alarm = c.deadline
+				c.net.clock.AfterFunc(c.deadline.Sub(now), c.timeout)
+			}
+		}
+		c.mut.Unlock()
+
+		// done with what we read
+		monkutil.NotifyIdle()
+		<-c.notify
+	}
+}
+
+func (c *conn) timeout() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.wake()
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	c.mut.Lock()
+	closed := c.closed
+	c.mut.Unlock()
+	if closed {
+		return 0, fmt.Errorf("Connection to %v closed", c.remote)
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	c.net.send(c, data)
+	return len(b), nil
+}
+
+// Closes both ends
+func (c *conn) Close() error {
+	c.close()
+	c.peer.close()
+	return nil
+}
+
+func (c *conn) close() {
+	c.mut.Lock()
+	c.closed = true
+	c.wake()
+	c.mut.Unlock()
+
+	c.net.mut.Lock()
+	delete(c.net.conns, c)
+	c.net.mut.Unlock()
+	monkutil.NotifyIdle()
+}
+
+func (c *conn) LocalAddr() net.Addr  { return c.local }
+func (c *conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// Deadlines are set by the real clock. They're moved onto
+// the virtual one, so a read times out after the same
+// virtual time however long the nodes take
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if t.IsZero() {
+		c.deadline = t
+	} else {
+		c.deadline = c.net.clock.Now().Add(t.Sub(time.Now()))
+	}
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
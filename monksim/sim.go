@@ -0,0 +1,416 @@
+package monksim
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"time"
+
+	"github.com/eris-ltd/thelonious"
+	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkdb"
+	"github.com/eris-ltd/thelonious/monkdoug"
+	"github.com/eris-ltd/thelonious/monklog"
+	"github.com/eris-ltd/thelonious/monkminer"
+	"github.com/eris-ltd/thelonious/monkreact"
+	"github.com/eris-ltd/thelonious/monkutil"
+	"github.com/eris-ltd/thelonious/monkwire"
+)
+
+/*
+   Run a network of thelonious nodes in one process.
+   Nodes talk over an in-memory network with controllable
+   latency, loss, and partitions, and stamp and judge blocks
+   by a virtual clock. The simulation advances the clock a tick
+   at a time, and waits after each tick for the nodes to handle
+   whatever arrived. Block pools run on the clock too.
+
+       sim, err := NewSimulator(4, 1, genesis)
+       err = sim.Start()
+       sim.StartMining(0)
+       err = sim.Run(time.Minute)
+       err = sim.Converged()
+       sim.Stop()
+
+   Nodes tell the simulation when they may have gone idle
+   (monkutil.NotifyIdle). If they don't go quiet for
+   quiesceLimit of real time, the run fails with an error.
+
+   Each node keeps its own blocks, but state is shared, since
+   it's keyed by hash. Only one simulation may run at a time.
+*/
+
+var simlogger = monklog.NewLogger("SIM")
+
+const simPort = "30303"
+
+// Real time to wait for the nodes to go quiet
+// before failing the run
+var quiesceLimit = 5 * time.Second
+
+// Build the genesis for a simulated chain, given every
+// node's keys. Called once per node, since nodes can't
+// share a genesis config
+type GenesisFunc func(keys []*monkcrypto.KeyPair) *monkdoug.GenesisConfig
+
+type Node struct {
+	*thelonious.Thelonious
+
+	Index int
+	Host  string
+	Keys  *monkcrypto.KeyPair
+
+	mining    bool
+	miner     *monkminer.Miner
+	minedFrom uint64
+	nextBlock time.Time
+}
+
+type Simulator struct {
+	Clock *Clock
+	Net   *Network
+	Nodes []*Node
+
+	// Virtual time per tick
+	Tick time.Duration
+	// Virtual time a node waits after a new block before it mines again
+	BlockTime time.Duration
+
+	dir      string
+	execPath string
+	stateDb  monkutil.Database
+
+	// signalled when a node may have gone idle
+	changed chan struct{}
+	// why the run failed, if it did
+	err error
+}
+
+// Create n nodes with deterministic keys from the seed
+func NewSimulator(n int, seed int64, genesis GenesisFunc) (*Simulator, error) {
+	dir, err := ioutil.TempDir("", "monksim")
+	if err != nil {
+		return nil, err
+	}
+	if monkutil.Config == nil {
+		monkutil.Config = &monkutil.ConfigManager{}
+	}
+	stateDb, err := monkdb.NewMemDatabase()
+	if err != nil {
+		return nil, err
+	}
+
+	clock := NewClock(time.Unix(1420070400, 0))
+	s := &Simulator{
+		Clock:     clock,
+		Net:       NewNetwork(clock, seed),
+		Tick:      time.Second,
+		BlockTime: 5 * time.Second,
+		dir:       dir,
+		execPath:  monkutil.Config.ExecPath,
+		stateDb:   stateDb,
+		changed:   make(chan struct{}, 1),
+	}
+	monkutil.Config.ExecPath = dir
+	clock.Quiesce = s.quiesce
+	monkutil.SetClock(clock)
+	monkutil.SetIdleHook(s.notify)
+
+	keys := make([]*monkcrypto.KeyPair, n)
+	for i := range keys {
+		sec := monkcrypto.Sha3Bin([]byte(fmt.Sprintf("monksim:%d:%d", seed, i)))
+		if keys[i], err = monkcrypto.NewKeyPairFromSec(sec); err != nil {
+			s.cleanup()
+			return nil, err
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		node, err := s.newNode(i, keys, genesis(keys))
+		if err != nil {
+			s.cleanup()
+			return nil, err
+		}
+		s.Nodes = append(s.Nodes, node)
+	}
+	return s, nil
+}
+
+func (s *Simulator) newNode(i int, keys []*monkcrypto.KeyPair, g *monkdoug.GenesisConfig) (*Node, error) {
+	db, err := monkdb.NewMemDatabase()
+	if err != nil {
+		return nil, err
+	}
+	keyManager := monkcrypto.NewDBKeyManager(db)
+	if err := keyManager.InitFromString("sim", 0, monkutil.Bytes2Hex(keys[i].PrivateKey)); err != nil {
+		return nil, err
+	}
+	clientIdentity := monkwire.NewSimpleClientIdentity("monksim", "0.1", fmt.Sprintf("node%d", i))
+
+	th, err := thelonious.NewWithStateDb(db, s.stateDb, clientIdentity, keyManager, thelonious.CapDefault, false, nil, g)
+	if err != nil {
+		return nil, err
+	}
+	host := fmt.Sprintf("10.0.%d.%d", (i+1)/256, (i+1)%256)
+	th.Port = simPort
+	th.MaxPeers = len(keys)
+	th.SetTransport(s.Net.Transport(host))
+
+	return &Node{Thelonious: th, Index: i, Host: host, Keys: keys[i]}, nil
+}
+
+// Start the nodes and connect every pair. Blocks aren't
+// relayed to a peer until it's sent its status, so wait
+// for every handshake before letting anyone mine
+func (s *Simulator) Start() error {
+	for _, node := range s.Nodes {
+		node.Start(true, "")
+	}
+	for i := range s.Nodes {
+		for j := i + 1; j < len(s.Nodes); j++ {
+			s.Connect(i, j)
+		}
+	}
+	if !s.RunUntil(time.Minute, s.Connected) {
+		if s.err != nil {
+			return s.err
+		}
+		return fmt.Errorf("Nodes did not finish connecting")
+	}
+	return nil
+}
+
+// Whether every node has exchanged status with every other node
+func (s *Simulator) Connected() bool {
+	for _, node := range s.Nodes {
+		known := 0
+		for _, p := range node.InOutPeers() {
+			if p.StatusKnown() {
+				known += 1
+			}
+		}
+		if known < len(s.Nodes)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Connect node i to node j, unless they're connected
+func (s *Simulator) Connect(i, j int) {
+	from, to := s.Nodes[i], s.Nodes[j]
+	if s.Net.Linked(from.Host, to.Host) {
+		return
+	}
+	dials := s.Net.Dials()
+	from.ConnectToPeer(to.Host + ":" + simPort)
+	// the node dials in the background
+	if err := s.wait(func() bool { return s.Net.Dials() > dials }); err != nil {
+		s.fail(fmt.Errorf("Node %d did not dial node %d: %v", i, j, err))
+	}
+}
+
+// Mine on node i. Blocks are mined by the simulation between
+// ticks, trying nonces in order, so they take no virtual time
+// and come out the same on every run. A mining node mines a
+// block as soon as it can, then rests for BlockTime whenever
+// its chain grows (by its own block or a peer's)
+func (s *Simulator) StartMining(i int) {
+	node := s.Nodes[i]
+	if node.miner == nil {
+		node.miner = monkminer.NewDefaultMiner(node.Keys.Address(), node.Thelonious)
+		node.miner.SetPow(orderedPow{node.ChainManager().NewPoW()})
+	}
+	node.mining, node.Mining = true, true
+	node.minedFrom = node.ChainManager().CurrentBlockNumber()
+}
+
+func (s *Simulator) StopMining(i int) {
+	node := s.Nodes[i]
+	node.mining, node.Mining = false, false
+}
+
+func (s *Simulator) pace() {
+	now := s.Clock.Now()
+	for _, node := range s.Nodes {
+		if !node.mining {
+			continue
+		}
+		if height := node.ChainManager().CurrentBlockNumber(); height > node.minedFrom {
+			node.minedFrom = height
+			node.nextBlock = now.Add(s.BlockTime)
+		}
+		if !now.Before(node.nextBlock) && s.seal(node) {
+			node.minedFrom = node.ChainManager().CurrentBlockNumber()
+			node.nextBlock = now.Add(s.BlockTime)
+		}
+	}
+}
+
+// Mine a block on the node's head.
+// Whether the node could mine, and its block was added
+func (s *Simulator) seal(node *Node) bool {
+	return node.miner.MineBlock()
+}
+
+// Tries nonces in order, so a block always gets the same one
+type orderedPow struct {
+	monkchain.PoW
+}
+
+func (pow orderedPow) Search(block *monkchain.Block, stop chan monkreact.Event) []byte {
+	hash := block.HashNoNonce()
+	for i := int64(0); ; i++ {
+		nonce := monkcrypto.Sha3Bin(big.NewInt(i).Bytes())
+		if pow.Verify(hash, block.Difficulty, nonce) {
+			return nonce
+		}
+	}
+}
+
+// Split the nodes into groups that can't reach each other.
+// Nodes not in any group form a group of their own.
+// Connections across the partition are dropped, as if
+// they had timed out
+func (s *Simulator) Partition(groups ...[]int) {
+	hosts := make([][]string, len(groups))
+	for i, group := range groups {
+		for _, j := range group {
+			hosts[i] = append(hosts[i], s.Nodes[j].Host)
+		}
+	}
+	s.Net.Partition(hosts...)
+
+	for _, node := range s.Nodes {
+		for _, p := range node.InOutPeers() {
+			addr, ok := p.RemoteAddr().(*net.TCPAddr)
+			if ok && !s.Net.Reachable(node.Host, addr.IP.String()) {
+				p.Stop()
+			}
+		}
+	}
+}
+
+// Undo any partition and reconnect every pair.
+// Nodes catch up on each other's chains when they
+// exchange status
+func (s *Simulator) Heal() {
+	s.Net.Heal()
+	for i := range s.Nodes {
+		for j := i + 1; j < len(s.Nodes); j++ {
+			s.Connect(i, j)
+		}
+	}
+}
+
+// Advance the virtual clock by d, a tick at a time.
+// Stops with an error if the run fails
+func (s *Simulator) Run(d time.Duration) error {
+	for t := time.Duration(0); t < d && s.err == nil; t += s.Tick {
+		s.step()
+	}
+	return s.err
+}
+
+// Run until cond holds, for at most max (virtual time).
+// Returns whether cond held. False if the run fails (see Err)
+func (s *Simulator) RunUntil(max time.Duration, cond func() bool) bool {
+	for t := time.Duration(0); t < max && s.err == nil; t += s.Tick {
+		if cond() {
+			return true
+		}
+		s.step()
+	}
+	return s.err == nil && cond()
+}
+
+// Why the run failed, or nil. A failed run doesn't advance
+func (s *Simulator) Err() error {
+	return s.err
+}
+
+// Fail the run, keeping the first error
+func (s *Simulator) fail(err error) {
+	if s.err == nil {
+		simlogger.Errorln(err)
+		s.err = err
+	}
+}
+
+func (s *Simulator) step() {
+	s.Clock.Advance(s.Tick)
+	s.quiesce()
+	s.pace()
+	s.quiesce()
+}
+
+// Wait for the nodes to finish connecting, and to read, handle
+// and answer everything delivered to them
+func (s *Simulator) quiesce() {
+	if err := s.wait(s.quiet); err != nil {
+		s.fail(err)
+	}
+}
+
+// Called by the nodes whenever they may have gone idle
+func (s *Simulator) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Wait until cond holds, checking it each time a node may have
+// gone idle. An error if it doesn't within quiesceLimit (eg. a
+// node waiting on something that needs the clock to move)
+func (s *Simulator) wait(cond func() bool) error {
+	if s.err != nil {
+		return s.err
+	}
+	limit := time.NewTimer(quiesceLimit)
+	defer limit.Stop()
+	for !cond() {
+		select {
+		case <-s.changed:
+		case <-limit.C:
+			return fmt.Errorf("Nodes did not go quiet in %v", quiesceLimit)
+		}
+	}
+	return nil
+}
+
+func (s *Simulator) quiet() bool {
+	for _, node := range s.Nodes {
+		if node.TxPool().Busy() {
+			return false
+		}
+		for _, p := range node.InOutPeers() {
+			if p.Busy() {
+				return false
+			}
+		}
+	}
+	return s.Net.Idle()
+}
+
+func (s *Simulator) Stop() {
+	for i := range s.Nodes {
+		s.StopMining(i)
+	}
+	for _, node := range s.Nodes {
+		node.Thelonious.Stop()
+	}
+	s.cleanup()
+}
+
+func (s *Simulator) cleanup() {
+	monkutil.SetIdleHook(nil)
+	monkutil.SetClock(nil)
+	monkutil.Config.ExecPath = s.execPath
+	if err := os.RemoveAll(s.dir); err != nil {
+		simlogger.Errorln(err)
+	}
+}
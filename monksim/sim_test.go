@@ -0,0 +1,470 @@
+package monksim
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/eris-ltd/epm-go/utils"
+	"github.com/eris-ltd/thelonious/monkchain"
+	"github.com/eris-ltd/thelonious/monkcrypto"
+	"github.com/eris-ltd/thelonious/monkdoug"
+	"github.com/eris-ltd/thelonious/monkreact"
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+func simGenesis(keys []*monkcrypto.KeyPair) *monkdoug.GenesisConfig {
+	g := &monkdoug.GenesisConfig{
+		Address:    "0000000000THISISDOUG",
+		NoGenDoug:  true,
+		Difficulty: 4,
+	}
+	for _, k := range keys {
+		g.AddAccount(&monkdoug.Account{
+			Address: monkutil.Bytes2Hex(k.Address()),
+			Balance: "1000000000000000000000",
+		})
+	}
+	g.Init()
+	return g
+}
+
+// A genesis with gendoug, the model and consensus, and every node
+// a miner with a stake of 1. Skips the test if the gendoug
+// contract isn't installed
+func stdGenesis(t *testing.T, model, consensus string, configure func(g *monkdoug.GenesisConfig)) GenesisFunc {
+	doug := "Genesis DOUG/gendoug-v2.lll"
+	if _, err := os.Stat(path.Join(utils.ErisLtd, "eris-std-lib", doug)); err != nil {
+		t.Skip("No gendoug contract:", err)
+	}
+	return func(keys []*monkcrypto.KeyPair) *monkdoug.GenesisConfig {
+		g := &monkdoug.GenesisConfig{
+			Address:    "0000000000THISISDOUG",
+			DougPath:   doug,
+			ModelName:  model,
+			Consensus:  consensus,
+			Difficulty: 4,
+			BlockTime:  5,
+			MaxGasTx:   "100000",
+		}
+		for i, k := range keys {
+			g.AddAccount(&monkdoug.Account{
+				Address:     monkutil.Bytes2Hex(k.Address()),
+				Name:        fmt.Sprintf("node%d", i),
+				Balance:     "1000000000000000000000",
+				Permissions: map[string]int{"mine": 1, "transact": 1, "create": 1},
+				Stake:       1,
+			})
+		}
+		if configure != nil {
+			configure(g)
+		}
+		g.Init()
+		return g
+	}
+}
+
+func newSim(t *testing.T, n int) *Simulator {
+	sim, err := NewSimulator(n, 1, simGenesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Start(); err != nil {
+		sim.Stop()
+		t.Fatal(err)
+	}
+	return sim
+}
+
+func TestSimConverge(t *testing.T) {
+	sim := newSim(t, 3)
+	defer sim.Stop()
+	sim.Net.SetLatency(50*time.Millisecond, 50*time.Millisecond)
+
+	sim.StartMining(0)
+	if !sim.RunUntil(time.Minute, func() bool { return sim.CommonHeight() >= 3 }) {
+		t.Fatalf("Nodes did not reach #3. Heads: %v", sim.Heads())
+	}
+	sim.StopMining(0)
+
+	if !sim.RunUntil(time.Minute, func() bool { return !sim.Forked() }) {
+		t.Fatal(sim.Converged())
+	}
+	if err := sim.Agree(3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimPartition(t *testing.T) {
+	sim := newSim(t, 4)
+	defer sim.Stop()
+
+	// only one side makes progress
+	sim.Partition([]int{0, 1}, []int{2, 3})
+	sim.StartMining(0)
+	if !sim.RunUntil(time.Minute, func() bool {
+		return sim.Nodes[1].ChainManager().CurrentBlockNumber() >= 3
+	}) {
+		t.Fatalf("Node 1 did not follow node 0. Heads: %v", sim.Heads())
+	}
+	sim.StopMining(0)
+
+	if !sim.Forked() {
+		t.Fatal("Expected the partitioned nodes to disagree")
+	}
+	if n := sim.Nodes[2].ChainManager().CurrentBlockNumber(); n != 0 {
+		t.Fatalf("Node 2 got block #%d across the partition", n)
+	}
+
+	sim.Heal()
+	if !sim.RunUntil(time.Minute, func() bool {
+		return sim.CommonHeight() >= 3 && !sim.Forked()
+	}) {
+		t.Fatal(sim.Converged())
+	}
+	if err := sim.Agree(3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimReorg(t *testing.T) {
+	sim := newSim(t, 4)
+	defer sim.Stop()
+
+	sim.Partition([]int{0, 1}, []int{2, 3})
+
+	// a tx only the losing side mines
+	node := sim.Nodes[0]
+	tx := monkchain.NewTransactionMessage(sim.Nodes[3].Keys.Address(), big.NewInt(1), big.NewInt(1000), big.NewInt(1), nil)
+	tx.Sign(node.Keys.PrivateKey)
+	node.TxPool().QueueTransaction(tx)
+
+	sim.StartMining(0)
+	if !sim.RunUntil(time.Minute, func() bool { return node.ChainManager().GetTransaction(tx.Hash()) != nil }) {
+		t.Fatal("Node 0 did not mine the tx")
+	}
+	sim.StopMining(0)
+
+	sim.StartMining(2)
+	height := node.ChainManager().CurrentBlockNumber()
+	if !sim.RunUntil(time.Minute, func() bool {
+		return sim.Nodes[2].ChainManager().CurrentBlockNumber() >= height+2
+	}) {
+		t.Fatalf("Node 2 did not pass node 0. Heads: %v", sim.Heads())
+	}
+	sim.StopMining(2)
+
+	reorgs := make(chan monkreact.Event, 1)
+	node.Reactor().Subscribe("chainReorg", reorgs)
+	sim.Heal()
+	if !sim.RunUntil(time.Minute, func() bool { return !sim.Forked() }) {
+		t.Fatal(sim.Converged())
+	}
+
+	if node.ChainManager().GetTransaction(tx.Hash()) != nil {
+		t.Fatal("Tx is still on canonical after the reorg")
+	}
+	var found bool
+	for _, pending := range node.TxPool().CurrentTransactions() {
+		found = found || bytes.Equal(pending.Hash(), tx.Hash())
+	}
+	if !found {
+		t.Error("Tx was not put back in the pool")
+	}
+
+	select {
+	case ev := <-reorgs:
+		reorg := ev.Resource.(*monkchain.ChainReorg)
+		if len(reorg.Dropped) != 1 || !bytes.Equal(reorg.Dropped[0], tx.Hash()) {
+			t.Errorf("Expected the tx to be dropped, got %x", reorg.Dropped)
+		}
+	default:
+		t.Error("No chainReorg event")
+	}
+}
+
+func TestSimRemoteWork(t *testing.T) {
+	sim := newSim(t, 2)
+	defer sim.Stop()
+
+	node := sim.Nodes[0]
+	tx := monkchain.NewTransactionMessage(sim.Nodes[1].Keys.Address(), big.NewInt(1), big.NewInt(1000), big.NewInt(1), nil)
+	tx.Sign(node.Keys.PrivateKey)
+	node.TxPool().QueueTransaction(tx)
+	if !sim.RunUntil(time.Minute, func() bool { return len(node.TxPool().CurrentTransactions()) == 1 }) {
+		t.Fatal("Tx never made it to the pool")
+	}
+
+	work, err := node.RemoteWork().GetWork()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if work.Number.Uint64() != 1 {
+		t.Fatalf("Expected work on block 1, got %v", work.Number)
+	}
+	if again, _ := node.RemoteWork().GetWork(); !bytes.Equal(again.Hash, work.Hash) {
+		t.Error("Expected the same work while nothing changed")
+	}
+	if err := node.RemoteWork().SubmitWork(monkutil.Hex2Bytes("beef"), nil); err == nil {
+		t.Error("Expected an error for unknown work")
+	}
+
+	// search for a nonce outside the node
+	pow := &monkchain.EasyPow{}
+	var nonce []byte
+	for i := int64(0); nonce == nil; i++ {
+		sha := monkcrypto.Sha3Bin(big.NewInt(i).Bytes())
+		if pow.Verify(work.Hash, work.Difficulty, sha) {
+			nonce = sha
+		}
+	}
+	if err := node.RemoteWork().SubmitWork(work.Hash, nonce); err != nil {
+		t.Fatal(err)
+	}
+	if node.ChainManager().GetTransaction(tx.Hash()) == nil {
+		t.Error("Sealed block is missing the tx")
+	}
+	if !sim.RunUntil(time.Minute, func() bool { return sim.CommonHeight() >= 1 }) {
+		t.Fatalf("Sealed block was not relayed. Heads: %v", sim.Heads())
+	}
+
+	// the head moved, so the work is stale
+	if err := node.RemoteWork().SubmitWork(work.Hash, nonce); err == nil {
+		t.Error("Expected an error for stale work")
+	}
+}
+
+func TestSimGasRules(t *testing.T) {
+	gas := &monkchain.GasRules{
+		MinGasPrice:  big.NewInt(2),
+		InitialLimit: big.NewInt(1000000),
+		Divisor:      1024,
+		FactorNum:    6,
+		FactorDen:    5,
+		Min:          big.NewInt(125000),
+	}
+	sim, err := NewSimulator(2, 1, func(keys []*monkcrypto.KeyPair) *monkdoug.GenesisConfig {
+		g := simGenesis(keys)
+		g.Gas = gas
+		return g
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Start(); err != nil {
+		sim.Stop()
+		t.Fatal(err)
+	}
+	defer sim.Stop()
+
+	node := sim.Nodes[0]
+	cheap := monkchain.NewTransactionMessage(sim.Nodes[1].Keys.Address(), big.NewInt(1), big.NewInt(1000), big.NewInt(1), nil)
+	cheap.Sign(node.Keys.PrivateKey)
+	if err := node.TxPool().ValidateTransaction(cheap); !monkchain.IsGasPriceErr(err) {
+		t.Errorf("Expected a gas price error, got %v", err)
+	}
+
+	sim.StartMining(0)
+	if !sim.RunUntil(time.Minute, func() bool { return sim.CommonHeight() >= 2 }) {
+		t.Fatalf("Nodes did not reach #2. Heads: %v", sim.Heads())
+	}
+	sim.StopMining(0)
+
+	// blocks are checked against the same rules by the other node
+	chain := sim.Nodes[1].ChainManager()
+	first := chain.GetBlockByNumber(1)
+	if first.GasLimit.Int64() != 1000000 || first.MinGasPrice.Int64() != 2 {
+		t.Errorf("Block #1 has gas limit %v and min price %v", first.GasLimit, first.MinGasPrice)
+	}
+	if second := chain.GetBlockByNumber(2); second.GasLimit.Cmp(gas.GasLimit(first)) != 0 {
+		t.Errorf("Block #2 has gas limit %v, expected %v", second.GasLimit, gas.GasLimit(first))
+	}
+}
+
+func TestSimRobin(t *testing.T) {
+	timeout := 10
+	sim, err := NewSimulator(3, 1, stdGenesis(t, "std", "robin", func(g *monkdoug.GenesisConfig) {
+		g.Epoch = 2
+		g.TurnTimeout = timeout
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Start(); err != nil {
+		sim.Stop()
+		t.Fatal(err)
+	}
+	defer sim.Stop()
+
+	// node 1 never takes its turn, so the chain
+	// only moves on when its turn times out
+	sim.StartMining(0)
+	sim.StartMining(2)
+	mined := minedBy(t, sim, 6)
+	if mined[1] != 0 {
+		t.Errorf("Offline node 1 mined %d blocks", mined[1])
+	}
+	if mined[0] == 0 || mined[2] == 0 {
+		t.Errorf("Expected nodes 0 and 2 to take turns, got %v", mined)
+	}
+}
+
+// Run the simulation until every node agrees on the first n blocks,
+// and count how many of them each node mined
+func minedBy(t *testing.T, sim *Simulator, n uint64) map[int]int {
+	if !sim.RunUntil(5*time.Minute, func() bool { return sim.CommonHeight() >= n }) {
+		t.Fatalf("Nodes did not reach #%d. Heads: %v", n, sim.Heads())
+	}
+	for i := range sim.Nodes {
+		sim.StopMining(i)
+	}
+	if !sim.RunUntil(time.Minute, func() bool { return !sim.Forked() }) {
+		t.Fatal(sim.Converged())
+	}
+
+	chain := sim.Nodes[0].ChainManager()
+	mined := make(map[int]int)
+	for h := uint64(1); h <= n; h++ {
+		if err := sim.Agree(h); err != nil {
+			t.Fatal(err)
+		}
+		coinbase := chain.GetBlockByNumber(h).Coinbase
+		for _, node := range sim.Nodes {
+			if bytes.Equal(node.Keys.Address(), coinbase) {
+				mined[node.Index] += 1
+			}
+		}
+	}
+	return mined
+}
+
+func TestSimStd(t *testing.T) {
+	sim, err := NewSimulator(3, 1, stdGenesis(t, "std", "eth", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Start(); err != nil {
+		sim.Stop()
+		t.Fatal(err)
+	}
+	defer sim.Stop()
+	sim.Net.SetLatency(50*time.Millisecond, 50*time.Millisecond)
+
+	// everyone may mine, and races for each block
+	for i := range sim.Nodes {
+		sim.StartMining(i)
+	}
+	mined := minedBy(t, sim, 6)
+	if total := mined[0] + mined[1] + mined[2]; total != 6 {
+		t.Errorf("Expected the nodes to mine every block, got %v", mined)
+	}
+}
+
+func TestSimStake(t *testing.T) {
+	sim, err := NewSimulator(3, 1, stdGenesis(t, "std", "stake", func(g *monkdoug.GenesisConfig) {
+		g.Accounts[0].Stake = 6
+		g.Accounts[2].Stake = 0
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Start(); err != nil {
+		sim.Stop()
+		t.Fatal(err)
+	}
+	defer sim.Stop()
+
+	// node 2 has no stake, so it can't mine however long it waits
+	for i := range sim.Nodes {
+		sim.StartMining(i)
+	}
+	mined := minedBy(t, sim, 6)
+	if mined[2] != 0 {
+		t.Errorf("Node 2 mined %d blocks without stake", mined[2])
+	}
+	if mined[0] == 0 {
+		t.Errorf("Expected the biggest stake to mine, got %v", mined)
+	}
+}
+
+func TestSimBft(t *testing.T) {
+	sim, err := NewSimulator(4, 1, stdGenesis(t, "bft", "", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Start(); err != nil {
+		sim.Stop()
+		t.Fatal(err)
+	}
+	defer sim.Stop()
+
+	for i := range sim.Nodes {
+		sim.StartMining(i)
+	}
+	if !sim.RunUntil(5*time.Minute, func() bool { return sim.Finalized(3) == nil }) {
+		t.Fatal(sim.Finalized(3))
+	}
+	var committed [][]byte
+	for h := uint64(1); h <= 3; h++ {
+		committed = append(committed, sim.Nodes[0].ChainManager().GetBlockByNumber(h).Hash())
+	}
+
+	// three of four are still a quorum. The one cut off
+	// can't commit on its own
+	sim.Partition([]int{0, 1, 2}, []int{3})
+	lone := sim.Nodes[3].ChainManager()
+	height := lone.CommittedHeight()
+	if !sim.RunUntil(5*time.Minute, func() bool {
+		return sim.Nodes[0].ChainManager().CommittedHeight() >= 5
+	}) {
+		t.Fatalf("The majority did not commit past #5. Heads: %v", sim.Heads())
+	}
+	if h := lone.CommittedHeight(); h != height {
+		t.Fatalf("Node 3 committed #%d on its own", h)
+	}
+
+	sim.Heal()
+	if !sim.RunUntil(5*time.Minute, func() bool { return sim.Finalized(5) == nil }) {
+		t.Fatal(sim.Finalized(5))
+	}
+	// committed blocks are final
+	for _, node := range sim.Nodes {
+		for i, hash := range committed {
+			if block := node.ChainManager().GetBlockByNumber(uint64(i + 1)); !bytes.Equal(block.Hash(), hash) {
+				t.Errorf("Node %d reverted committed block #%d", node.Index, i+1)
+			}
+		}
+	}
+}
+
+func TestSimFailsWhenNotQuiet(t *testing.T) {
+	sim := newSim(t, 2)
+	defer sim.Stop()
+	if err := sim.Run(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	limit := quiesceLimit
+	quiesceLimit = 10 * time.Millisecond
+	defer func() { quiesceLimit = limit }()
+
+	// a node that never goes quiet fails the run
+	if err := sim.wait(func() bool { return false }); err == nil {
+		t.Fatal("Expected an error waiting on a busy node")
+	} else {
+		sim.fail(err)
+	}
+	if err := sim.Run(time.Minute); err == nil {
+		t.Error("Expected the failed run to stay failed")
+	}
+	if sim.RunUntil(time.Minute, func() bool { return true }) {
+		t.Error("Expected RunUntil to report the failed run")
+	}
+	if err := sim.Converged(); err != sim.Err() || err == nil {
+		t.Errorf("Expected Converged to report the failure, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package monkstate
+
+import (
+	"fmt"
+
+	"github.com/eris-ltd/thelonious/monkutil"
+)
+
+// A log (event) made by a contract with the LOG opcodes.
+// Topics are 32 byte words to filter on, data is up to the contract
+type Log struct {
+	Address []byte
+	Topics  [][]byte
+	Data    []byte
+}
+
+func NewLogFromValue(val *monkutil.Value) *Log {
+	log := &Log{
+		Address: val.Get(0).Bytes(),
+		Data:    val.Get(2).Bytes(),
+	}
+	topics := val.Get(1)
+	for i := 0; i < topics.Len(); i++ {
+		log.Topics = append(log.Topics, topics.Get(i).Bytes())
+	}
+	return log
+}
+
+func (self *Log) RlpData() interface{} {
+	topics := make([]interface{}, len(self.Topics))
+	for i, topic := range self.Topics {
+		topics[i] = topic
+	}
+	return []interface{}{self.Address, topics, self.Data}
+}
+
+func (self *Log) String() string {
+	return fmt.Sprintf("Log{address: %x topics: %x data: %x}", self.Address, self.Topics, self.Data)
+}
+
+type Logs []*Log
+
+func NewLogsFromValue(val *monkutil.Value) Logs {
+	var logs Logs
+	for i := 0; i < val.Len(); i++ {
+		logs = append(logs, NewLogFromValue(val.Get(i)))
+	}
+	return logs
+}
+
+func (self Logs) RlpData() interface{} {
+	logs := make([]interface{}, len(self))
+	for i, log := range self {
+		logs[i] = log.RlpData()
+	}
+	return logs
+}
@@ -42,6 +42,8 @@ type Message struct {
 	Block     []byte
 	Number    *big.Int
 	Value     *big.Int
+	// Hash of the tx the message is part of
+	Tx []byte
 
 	ChangedAddresses [][]byte
 }
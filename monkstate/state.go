@@ -24,6 +24,9 @@ type State struct {
 
 	manifest *Manifest
 
+	// logs made by the current tx
+	logs Logs
+
 	mut sync.Mutex // for locking the cache
 }
 
@@ -151,6 +154,7 @@ func (self *State) Copy() *State {
 		for k, stateObject := range self.stateObjects {
 			state.stateObjects[k] = stateObject.Copy()
 		}
+		state.logs = append(Logs(nil), self.logs...)
 
 		return state
 	}
@@ -166,6 +170,7 @@ func (self *State) Set(state *State) {
 	defer self.mut.Unlock()
 	self.Trie = state.Trie
 	self.stateObjects = state.stateObjects
+	self.logs = state.logs
 }
 
 func (s *State) Root() interface{} {
@@ -241,6 +246,26 @@ func (self *State) Manifest() *Manifest {
 	return self.manifest
 }
 
+// Logs are kept with the state so they're
+// reverted with it when a call fails
+func (self *State) AddLog(log *Log) {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+	self.logs = append(self.logs, log)
+}
+
+func (self *State) Logs() Logs {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+	return self.logs
+}
+
+func (self *State) EmptyLogs() {
+	self.mut.Lock()
+	defer self.mut.Unlock()
+	self.logs = nil
+}
+
 // Debug stuff
 func (self *State) CreateOutputForDiff() {
 	self.mut.Lock()
@@ -0,0 +1,66 @@
+package monkutil
+
+import (
+	"sync"
+	"time"
+)
+
+// Source of the current time for anything that stamps
+// or judges blocks by time, so simulations can swap
+// in a virtual clock
+type Clock interface {
+	Now() time.Time
+	// Run f every d until quit is closed
+	Every(d time.Duration, quit chan bool, f func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Every(d time.Duration, quit chan bool, f func()) {
+	go func() {
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f()
+			case <-quit:
+				return
+			}
+		}
+	}()
+}
+
+var (
+	clockMut sync.RWMutex
+	clock    Clock = realClock{}
+)
+
+// Set the clock (nil for the real one)
+func SetClock(c Clock) {
+	clockMut.Lock()
+	defer clockMut.Unlock()
+	if c == nil {
+		c = realClock{}
+	}
+	clock = c
+}
+
+// The current time according to the clock
+func Now() time.Time {
+	clockMut.RLock()
+	defer clockMut.RUnlock()
+	return clock.Now()
+}
+
+// Run f every d by the clock until quit is closed
+func Every(d time.Duration, quit chan bool, f func()) {
+	clockMut.RLock()
+	c := clock
+	clockMut.RUnlock()
+	c.Every(d, quit, f)
+}
@@ -0,0 +1,28 @@
+package monkutil
+
+import "sync"
+
+var (
+	idleMut  sync.RWMutex
+	idleHook func()
+)
+
+// Set a func to call whenever part of a node may have gone
+// idle (a queue drained, a peer connected or left), so a
+// simulation can wait on the nodes instead of polling them.
+// nil for none
+func SetIdleHook(f func()) {
+	idleMut.Lock()
+	defer idleMut.Unlock()
+	idleHook = f
+}
+
+// Tell the idle hook (if any) that something may have gone idle
+func NotifyIdle() {
+	idleMut.RLock()
+	f := idleHook
+	idleMut.RUnlock()
+	if f != nil {
+		f()
+	}
+}
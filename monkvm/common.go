@@ -20,6 +20,10 @@ var (
 	GasMemory  = big.NewInt(1)
 	GasData    = big.NewInt(5)
 	GasTx      = big.NewInt(500)
+	// per log, per topic, and per byte of data
+	GasLog      = big.NewInt(10)
+	GasLogTopic = big.NewInt(10)
+	GasLogData  = big.NewInt(1)
 
 	Pow256 = monkutil.BigPow(2, 256)
 
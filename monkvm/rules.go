@@ -0,0 +1,118 @@
+package monkvm
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Gas costs of the vm
+type GasSchedule struct {
+	Step    *big.Int
+	Sha     *big.Int
+	SLoad   *big.Int
+	SStore  *big.Int
+	Balance *big.Int
+	Nonce   *big.Int
+	Create  *big.Int
+	Call    *big.Int
+	Memory  *big.Int
+	Data    *big.Int
+	Tx      *big.Int
+	// per log, per topic, and per byte of data
+	Log      *big.Int
+	LogTopic *big.Int
+	LogData  *big.Int
+}
+
+// The schedule given by the Gas* globals
+func DefaultGasSchedule() *GasSchedule {
+	return &GasSchedule{
+		Step:    GasStep,
+		Sha:     GasSha,
+		SLoad:   GasSLoad,
+		SStore:  GasSStore,
+		Balance: GasBalance,
+		Nonce:   GasNonce,
+		Create:  GasCreate,
+		Call:    GasCall,
+		Memory:  GasMemory,
+		Data:    GasData,
+		Tx:      GasTx,
+
+		Log:      GasLog,
+		LogTopic: GasLogTopic,
+		LogData:  GasLogData,
+	}
+}
+
+func (self *GasSchedule) Copy() *GasSchedule {
+	s := *self
+	return &s
+}
+
+// Set a cost by name (ie. "sstore")
+func (self *GasSchedule) Set(name string, cost *big.Int) error {
+	switch strings.ToLower(name) {
+	case "step":
+		self.Step = cost
+	case "sha":
+		self.Sha = cost
+	case "sload":
+		self.SLoad = cost
+	case "sstore":
+		self.SStore = cost
+	case "balance":
+		self.Balance = cost
+	case "nonce":
+		self.Nonce = cost
+	case "create":
+		self.Create = cost
+	case "call":
+		self.Call = cost
+	case "memory":
+		self.Memory = cost
+	case "data":
+		self.Data = cost
+	case "tx":
+		self.Tx = cost
+	case "log":
+		self.Log = cost
+	case "logtopic":
+		self.LogTopic = cost
+	case "logdata":
+		self.LogData = cost
+	default:
+		return fmt.Errorf("Unknown gas cost %s", name)
+	}
+	return nil
+}
+
+// Gas costs and the opcodes that may not be run.
+// Lets a chain change its vm at a given height
+type Rules struct {
+	Gas      *GasSchedule
+	Disabled map[OpCode]bool
+}
+
+func DefaultRules() *Rules {
+	return &Rules{Gas: DefaultGasSchedule(), Disabled: make(map[OpCode]bool)}
+}
+
+func (self *Rules) Copy() *Rules {
+	disabled := make(map[OpCode]bool)
+	for op, d := range self.Disabled {
+		disabled[op] = d
+	}
+	return &Rules{Gas: self.Gas.Copy(), Disabled: disabled}
+}
+
+// Disable an opcode by name (ie. "CREATE")
+func (self *Rules) Disable(name string) error {
+	op, ok := OpCodes[strings.ToUpper(name)]
+	if !ok {
+		return fmt.Errorf("Unknown opcode %s", name)
+	}
+	self.Disabled[OpCode(op)] = true
+	return nil
+}
@@ -47,6 +47,9 @@ type Vm struct {
 	queue *list.List
 
 	callStack *[][]byte // list of addrs
+
+	// Gas costs and disabled opcodes
+	Rules *Rules
 }
 
 type Environment interface {
@@ -75,7 +78,7 @@ func New(env Environment) *Vm {
 		lt = LogTyDiff
 	}
 
-	return &Vm{env: env, logTy: lt, Recoverable: true, queue: list.New(), callStack: new([][]byte)}
+	return &Vm{env: env, logTy: lt, Recoverable: true, queue: list.New(), callStack: new([][]byte), Rules: DefaultRules()}
 }
 
 func calcMemSize(off, l *big.Int) *big.Int {
@@ -121,6 +124,7 @@ func (self *Vm) RunClosure(closure *Closure) (ret []byte, err error) {
 	var (
 		op OpCode
 
+		sched    = self.Rules.Gas
 		mem      = &Memory{}
 		stack    = NewStack()
 		pc       = big.NewInt(0)
@@ -194,7 +198,11 @@ func (self *Vm) RunClosure(closure *Closure) (ret []byte, err error) {
 			}
 		}
 
-		addStepGasUsage(GasStep)
+		if self.Rules.Disabled[op] {
+			return closure.Return(nil), fmt.Errorf("Disabled opcode %v", op)
+		}
+
+		addStepGasUsage(sched.Step)
 		var newMemSize *big.Int = monkutil.Big0
 		switch op {
 		case STOP:
@@ -202,7 +210,7 @@ func (self *Vm) RunClosure(closure *Closure) (ret []byte, err error) {
 		case SUICIDE:
 			gas.Set(monkutil.Big0)
 		case SLOAD:
-			gas.Set(GasSLoad)
+			gas.Set(sched.SLoad)
 		case SSTORE:
 			var mult *big.Int
 			y, x := stack.Peekn()
@@ -214,11 +222,11 @@ func (self *Vm) RunClosure(closure *Closure) (ret []byte, err error) {
 			} else {
 				mult = monkutil.Big1
 			}
-			gas = new(big.Int).Mul(mult, GasSStore)
+			gas = new(big.Int).Mul(mult, sched.SStore)
 		case BALANCE:
-			gas.Set(GasBalance)
+			gas.Set(sched.Balance)
 		case NONCE:
-			gas.Set(GasNonce)
+			gas.Set(sched.Nonce)
 		case MSTORE:
 			require(2)
 			newMemSize = calcMemSize(stack.Peek(), u256(32))
@@ -233,10 +241,19 @@ func (self *Vm) RunClosure(closure *Closure) (ret []byte, err error) {
 			require(2)
 
 			newMemSize = calcMemSize(stack.Peek(), stack.data[stack.Len()-2])
+		case LOG0, LOG1, LOG2, LOG3, LOG4:
+			n := int(op - LOG0)
+			require(n + 2)
+
+			size := stack.data[stack.Len()-2]
+			gas.Add(sched.Log, new(big.Int).Mul(big.NewInt(int64(n)), sched.LogTopic))
+			gas.Add(gas, new(big.Int).Mul(size, sched.LogData))
+
+			newMemSize = calcMemSize(stack.Peek(), size)
 		case SHA3:
 			require(2)
 
-			gas.Set(GasSha)
+			gas.Set(sched.Sha)
 
 			newMemSize = calcMemSize(stack.Peek(), stack.data[stack.Len()-2])
 		case CALLDATACOPY:
@@ -253,7 +270,7 @@ func (self *Vm) RunClosure(closure *Closure) (ret []byte, err error) {
 			newMemSize = calcMemSize(stack.data[stack.Len()-2], stack.data[stack.Len()-4])
 		case CALL, CALLSTATELESS:
 			require(7)
-			gas.Set(GasCall)
+			gas.Set(sched.Call)
 			addStepGasUsage(stack.data[stack.Len()-1])
 
 			x := calcMemSize(stack.data[stack.Len()-6], stack.data[stack.Len()-7])
@@ -269,7 +286,7 @@ func (self *Vm) RunClosure(closure *Closure) (ret []byte, err error) {
 				}
 			}
 			require(3)
-			gas.Set(GasCreate)
+			gas.Set(sched.Create)
 
 			newMemSize = calcMemSize(stack.data[stack.Len()-2], stack.data[stack.Len()-3])
 
@@ -300,7 +317,7 @@ func (self *Vm) RunClosure(closure *Closure) (ret []byte, err error) {
 
 			if newMemSize.Cmp(u256(int64(mem.Len()))) > 0 {
 				memGasUsage := new(big.Int).Sub(newMemSize, u256(int64(mem.Len())))
-				memGasUsage.Mul(GasMemory, memGasUsage)
+				memGasUsage.Mul(sched.Memory, memGasUsage)
 				memGasUsage.Div(memGasUsage, u256(32))
 
 				addStepGasUsage(memGasUsage)
@@ -325,6 +342,18 @@ func (self *Vm) RunClosure(closure *Closure) (ret []byte, err error) {
 			stack.Print()
 		case LOGMEM:
 			mem.Print()
+			// 0xa0 range
+		case LOG0, LOG1, LOG2, LOG3, LOG4:
+			n := int(op - LOG0)
+			size, offset := stack.Popn()
+			topics := make([][]byte, n)
+			for i := 0; i < n; i++ {
+				topics[i] = monkutil.LeftPadBytes(stack.Pop().Bytes(), 32)
+			}
+			data := monkutil.CopyBytes(mem.Get(offset.Int64(), size.Int64()))
+			self.env.State().AddLog(&monkstate.Log{Address: closure.Address(), Topics: topics, Data: data})
+
+			self.Printf(" => %x %x", topics, data)
 			// 0x20 range
 		case ADD:
 			require(2)
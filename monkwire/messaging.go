@@ -41,8 +41,15 @@ const (
 	MsgGetBlocksTy      = 0x15
 	MsgBlockTy          = 0x16
 
-	MsgGetStateTy = 0x20
-	MsgStateTy    = 0x21
+	MsgGetStateTy      = 0x20
+	MsgStateTy         = 0x21
+	MsgGetCheckpointTy = 0x22
+	MsgCheckpointTy    = 0x23
+
+	MsgProposalTy  = 0x30
+	MsgPrevoteTy   = 0x31
+	MsgPrecommitTy = 0x32
+	MsgCommitTy    = 0x33
 )
 
 var msgTypeToString = map[MsgType]string{
@@ -61,6 +68,12 @@ var msgTypeToString = map[MsgType]string{
 	MsgGetBlocksTy:      "Get blocks",
 	MsgGetStateTy:       "Get state",
 	MsgStateTy:          "State",
+	MsgGetCheckpointTy:  "Get checkpoint",
+	MsgCheckpointTy:     "Checkpoint",
+	MsgProposalTy:       "Proposal",
+	MsgPrevoteTy:        "Prevote",
+	MsgPrecommitTy:      "Precommit",
+	MsgCommitTy:         "Commit",
 }
 
 func (mt MsgType) String() string {
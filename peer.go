@@ -123,6 +123,8 @@ type Peer struct {
 	// Flag for checking the peer's connectivity state
 	connected  int32
 	disconnect int32
+	// Messages queued but not yet written
+	queued int32
 	// Last known message send
 	lastSend time.Time
 	// Indicated whether a verack has been send or not
@@ -216,6 +218,7 @@ func NewOutboundPeer(addr string, th *Thelonious, caps Caps) *Peer {
 		// Atomically set the connection state
 		atomic.StoreInt32(&p.connected, 1)
 		atomic.StoreInt32(&p.disconnect, 0)
+		monkutil.NotifyIdle()
 
 		p.Start()
 	}()
@@ -226,7 +229,7 @@ func NewOutboundPeer(addr string, th *Thelonious, caps Caps) *Peer {
 func (self *Peer) Connect(addr string) (conn net.Conn, err error) {
 	const maxTries = 3
 	for attempts := 0; attempts < maxTries; attempts++ {
-		conn, err = net.DialTimeout("tcp", addr, 10*time.Second)
+		conn, err = self.thelonious.transport.Dial(addr)
 		if err != nil {
 			time.Sleep(time.Duration(attempts*20) * time.Second)
 			continue
@@ -258,6 +261,12 @@ func (p *Peer) Host() []byte {
 func (p *Peer) Port() uint16 {
 	return p.port
 }
+func (p *Peer) RemoteAddr() net.Addr {
+	if p.conn == nil {
+		return nil
+	}
+	return p.conn.RemoteAddr()
+}
 func (p *Peer) Version() string {
 	return p.version
 }
@@ -280,9 +289,26 @@ func (p *Peer) QueueMessage(msg *monkwire.Msg) {
 	if atomic.LoadInt32(&p.connected) != 1 {
 		return
 	}
+	atomic.AddInt32(&p.queued, 1)
 	p.outputQueue <- msg
 }
 
+// Whether the peer is still connecting, or has messages
+// it hasn't written yet
+func (p *Peer) Busy() bool {
+	if atomic.LoadInt32(&p.disconnect) != 0 {
+		return false
+	}
+	return atomic.LoadInt32(&p.connected) == 0 || atomic.LoadInt32(&p.queued) > 0
+}
+
+// A queued message was written or dropped
+func (p *Peer) dequeued() {
+	if atomic.AddInt32(&p.queued, -1) == 0 {
+		monkutil.NotifyIdle()
+	}
+}
+
 func (p *Peer) writeMessage(msg *monkwire.Msg) {
 	// Ignore the write if we're not connected
 	if atomic.LoadInt32(&p.connected) != 1 {
@@ -332,13 +358,17 @@ out:
 		case msg := <-p.outputQueue:
 			if !p.StatusKnown() {
 				switch msg.Type {
-				case monkwire.MsgGetTxsTy, monkwire.MsgTxTy, monkwire.MsgGetBlockHashesTy, monkwire.MsgBlockHashesTy, monkwire.MsgGetBlocksTy, monkwire.MsgBlockTy:
+				case monkwire.MsgGetTxsTy, monkwire.MsgTxTy, monkwire.MsgGetBlockHashesTy, monkwire.MsgBlockHashesTy, monkwire.MsgGetBlocksTy, monkwire.MsgBlockTy,
+					monkwire.MsgProposalTy, monkwire.MsgPrevoteTy, monkwire.MsgPrecommitTy, monkwire.MsgCommitTy,
+					monkwire.MsgGetCheckpointTy, monkwire.MsgCheckpointTy:
+					p.dequeued()
 					break skip
 				}
 			}
 
 			p.writeMessage(msg)
 			p.setLastSend()
+			p.dequeued()
 
 		// Ping timer
 		case <-pingTimer.C:
@@ -370,6 +400,7 @@ clean:
 		select {
 		case <-p.outputQueue:
 			// TODO
+			p.dequeued()
 		default:
 			break clean
 		}
@@ -568,6 +599,32 @@ func (p *Peer) HandleInbound() {
 					}
 					newTrie.Sync()
 					p.thelonious.Reactor().Post("chainReady", nil)
+
+				case monkwire.MsgGetCheckpointTy:
+					certs := []interface{}{}
+					if cert := p.thelonious.ChainManager().LatestCheckpointCertificate(); cert != nil {
+						certs = append(certs, cert.RlpData())
+					}
+					p.QueueMessage(monkwire.NewMessage(monkwire.MsgCheckpointTy, certs))
+
+				case monkwire.MsgCheckpointTy:
+					for i := 0; i < msg.Data.Len(); i++ {
+						cert := monkchain.NewCheckpointCertificateFromValue(msg.Data.Get(i))
+						p.thelonious.receiveCheckpoint(cert)
+					}
+
+				case monkwire.MsgProposalTy, monkwire.MsgPrevoteTy, monkwire.MsgPrecommitTy:
+					// Votes are handled by the consensus engine (if any)
+					for i := 0; i < msg.Data.Len(); i++ {
+						vote := monkchain.NewVoteFromValue(msg.Data.Get(i))
+						p.thelonious.Reactor().Post("consensus:vote", vote)
+					}
+
+				case monkwire.MsgCommitTy:
+					for i := 0; i < msg.Data.Len(); i++ {
+						cert := monkchain.NewCommitCertificateFromValue(msg.Data.Get(i))
+						p.thelonious.Reactor().Post("consensus:commit", cert)
+					}
 				}
 
 			}
@@ -718,6 +775,13 @@ func (p *Peer) Stop() {
 
 	// Pre-emptively remove the peer; don't wait for reaping. We already know it's dead if we are here
 	p.thelonious.RemovePeer(p)
+	p.thelonious.ChainManager().RemoveTimeOffset(p.timeKey())
+	monkutil.NotifyIdle()
+}
+
+// Key for the peer's clock offset
+func (p *Peer) timeKey() string {
+	return fmt.Sprintf("%p", p)
 }
 
 func (p *Peer) peersMessage() *monkwire.Msg {
@@ -745,7 +809,9 @@ func (self *Peer) pushStatus() {
 		uint32(NetVersion),
 		self.thelonious.ChainManager().TD,
 		self.thelonious.ChainManager().CurrentBlock().Hash(),
-		self.thelonious.ChainManager().Genesis().Hash(),
+		// checked against the chain id by handleStatus
+		self.thelonious.ChainManager().ChainID(),
+		uint64(monkutil.Now().Unix()),
 	})
 
 	self.QueueMessage(msg)
@@ -760,6 +826,7 @@ func (self *Peer) handleStatus(msg *monkwire.Msg) {
 		td           = c.Get(2).BigInt()
 		bestHash     = c.Get(3).Bytes()
 		chainId      = c.Get(4).Bytes()
+		peerTime     = int64(c.Get(5).Uint())
 	)
 
 	if bytes.Compare(self.thelonious.ChainManager().ChainID(), chainId) != 0 {
@@ -786,6 +853,16 @@ func (self *Peer) handleStatus(msg *monkwire.Msg) {
 	self.statusKnown = true
 	self.mut.Unlock()
 
+	// Track how far the peer's clock is from ours,
+	// for judging block times by network time
+	if peerTime > 0 {
+		offset := peerTime - monkutil.Now().Unix()
+		self.thelonious.ChainManager().AddTimeOffset(self.timeKey(), offset)
+		if drift := self.thelonious.ChainManager().TimeRules().MaxDrift; offset > drift || offset < -drift {
+			monklogger.Warnf("Peer's clock is off from ours by %ds\n", offset)
+		}
+	}
+
 	// Compare the total TD with the blockchain TD. If remote is higher
 	// fetch hashes from highest TD node.
 	if self.td.Cmp(self.thelonious.ChainManager().TD) > 0 {
@@ -793,6 +870,11 @@ func (self *Peer) handleStatus(msg *monkwire.Msg) {
 		self.FetchHashes()
 	}
 
+	// Ask for the latest checkpoint if we're syncing from one
+	if self.thelonious.ChainManager().WaitingForCertificate() {
+		self.thelonious.requestCheckpoint(self)
+	}
+
 	monklogger.Infof("Peer is [eth] capable. (TD = %v ~ %x) %d / %d", self.td, self.bestHash, protoVersion, netVersion)
 
 }
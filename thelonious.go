@@ -18,6 +18,7 @@ import (
 	"github.com/eris-ltd/thelonious/monkcrypto"
 	"github.com/eris-ltd/thelonious/monkdoug"
 	"github.com/eris-ltd/thelonious/monklog"
+	"github.com/eris-ltd/thelonious/monkminer"
 	"github.com/eris-ltd/thelonious/monkreact"
 	"github.com/eris-ltd/thelonious/monkrpc"
 	"github.com/eris-ltd/thelonious/monkstate"
@@ -64,6 +65,10 @@ type Thelonious struct {
 	blockChain *monkchain.ChainManager
 	// The block pool
 	blockPool *BlockPool
+	// The miner, if there is one
+	miner *monkminer.Miner
+	// Sealing work for external miners
+	remoteWork *monkminer.RemoteWork
 	// Peers (NYI)
 	peers *list.List
 	// Nonce
@@ -95,6 +100,13 @@ type Thelonious struct {
 
 	filters map[int]*monkchain.Filter
 
+	// how we reach peers
+	transport Transport
+
+	// when we first asked peers for a checkpoint certificate
+	checkpointRequestedAt time.Time
+	checkpointMut         sync.Mutex
+
 	// json based config object
 	genConfig *monkdoug.GenesisConfig
 	// model interface for validating actions
@@ -102,9 +114,20 @@ type Thelonious struct {
 }
 
 func New(db monkutil.Database, clientIdentity monkwire.ClientIdentity, keyManager *monkcrypto.KeyManager, caps Caps, usePnp bool, checkPoint []byte, genConfig *monkdoug.GenesisConfig) (*Thelonious, error) {
+	return NewWithStateDb(db, db, clientIdentity, keyManager, caps, usePnp, checkPoint, genConfig)
+}
+
+// A node keeping its blocks in db and its state in stateDb.
+// State is keyed by hash, so nodes in the same process
+// (ie. in a simulation) can share a stateDb, but not a db
+func NewWithStateDb(db, stateDb monkutil.Database, clientIdentity monkwire.ClientIdentity, keyManager *monkcrypto.KeyManager, caps Caps, usePnp bool, checkPoint []byte, genConfig *monkdoug.GenesisConfig) (*Thelonious, error) {
 	var err error
 	var nat NAT
 
+	if err = genConfig.Check(); err != nil {
+		return nil, err
+	}
+
 	if usePnp {
 		nat, err = Discover()
 		if err != nil {
@@ -114,7 +137,7 @@ func New(db monkutil.Database, clientIdentity monkwire.ClientIdentity, keyManage
 
 	bootstrapDb(db)
 
-	monkutil.Config.Db = db
+	monkutil.Config.Db = stateDb
 
 	nonce, _ := monkutil.RandomUint64()
 	th := &Thelonious{
@@ -130,17 +153,23 @@ func New(db monkutil.Database, clientIdentity monkwire.ClientIdentity, keyManage
 		clientIdentity: clientIdentity,
 		isUpToDate:     true,
 		filters:        make(map[int]*monkchain.Filter),
+		transport:      tcpTransport{},
 	}
 
-	protocol := th.setGenesis(genConfig)
+	protocol, err := th.setGenesis(genConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	th.reactor = monkreact.New()
 
 	th.blockPool = NewBlockPool(th)
 	th.txPool = monkchain.NewTxPool(th)
-	th.blockChain = monkchain.NewChainManager(protocol)
+	th.blockChain = monkchain.NewChainManagerWithDb(protocol, db)
+	th.genConfig.SetChainManager(th.blockChain)
 	th.blockManager = monkchain.NewBlockManager(th)
 	th.blockChain.SetProcessor(th.blockManager)
+	th.remoteWork = monkminer.NewRemoteWork(th)
 
 	// Set chain's checkpoint
 	if len(checkPoint) > 0 {
@@ -159,17 +188,19 @@ func (s *Thelonious) Protocol() monkchain.Protocol {
 
 // Loaded from genesis.json, possibly modified
 // Sets the config object and the access model
-func (s *Thelonious) setGenesis(genConfig *monkdoug.GenesisConfig) monkchain.Protocol {
+func (s *Thelonious) setGenesis(genConfig *monkdoug.GenesisConfig) (monkchain.Protocol, error) {
 	if s.genConfig != nil {
 		fmt.Println("GenesisConfig already set")
-		return nil
+		return nil, nil
 	}
 	if genConfig.Model() == nil {
-		genConfig.SetModel()
+		if err := genConfig.SetModel(); err != nil {
+			return nil, err
+		}
 	}
 	s.genConfig = genConfig
 	s.protocol = genConfig.Model()
-	return s.protocol
+	return s.protocol, nil
 }
 
 func (s *Thelonious) Reactor() *monkreact.ReactorEngine {
@@ -209,6 +240,21 @@ func (s *Thelonious) IsMining() bool {
 	return s.Mining
 }
 
+// Keep a handle on the miner, for its hashrate and turbo
+func (s *Thelonious) SetMiner(miner *monkminer.Miner) {
+	s.miner = miner
+}
+
+// The miner, or nil if there isn't one
+func (s *Thelonious) Miner() *monkminer.Miner {
+	return s.miner
+}
+
+// Sealing work for external miners
+func (s *Thelonious) RemoteWork() *monkminer.RemoteWork {
+	return s.remoteWork
+}
+
 func (s *Thelonious) PeerCount() int {
 	s.peerMut.Lock()
 	defer s.peerMut.Unlock()
@@ -447,12 +493,18 @@ func (s *Thelonious) Start(listen bool, seed string) {
 	go s.ReapDeadPeerHandler()
 	go s.update()
 	go s.filterLoop()
+	go s.checkpointLoop()
 
 	if seed != "" {
 		s.Seed(seed)
 	}
 	monklogger.Infoln("Peer handling started")
 
+	// Start the consensus engine (if any)
+	if engine, ok := s.protocol.(monkchain.ConsensusEngine); ok {
+		engine.Start(s)
+	}
+
 	if !s.ChainManager().WaitingForCheckpoint() {
 		s.Reactor().Post("chainReady", "Chain is ready!")
 	}
@@ -514,7 +566,7 @@ func (s *Thelonious) Seed(seed string) {
 }
 
 func (s *Thelonious) StartListening() {
-	ln, err := net.Listen("tcp", ":"+s.Port)
+	ln, err := s.transport.Listen(":" + s.Port)
 	if err != nil {
 		monklogger.Warnf("Port %s in use. Connection listening disabled. Acting as client", s.Port)
 		s.listening = false
@@ -588,6 +640,9 @@ func (s *Thelonious) Stop() {
 	if s.RpcServer != nil {
 		s.RpcServer.Stop()
 	}
+	if engine, ok := s.protocol.(monkchain.ConsensusEngine); ok {
+		engine.Stop()
+	}
 	s.txPool.Stop()
 	s.blockManager.Stop()
 	s.reactor.Flush()
@@ -684,10 +739,12 @@ func (self *Thelonious) GetFilter(id int) *monkchain.Filter {
 func (self *Thelonious) filterLoop() {
 	blockChan := make(chan monkreact.Event, 5)
 	messageChan := make(chan monkreact.Event, 5)
+	logsChan := make(chan monkreact.Event, 5)
 	// Subscribe to events
 	reactor := self.Reactor()
 	reactor.Subscribe("newBlock", blockChan)
 	reactor.Subscribe("messages", messageChan)
+	reactor.Subscribe("logs", logsChan)
 out:
 	for {
 		select {
@@ -712,6 +769,17 @@ out:
 					}
 				}
 			}
+		case ev := <-logsChan:
+			if logs, ok := ev.Resource.(monkstate.Logs); ok {
+				for _, filter := range self.filters {
+					if filter.LogsCallback != nil {
+						logs := filter.FilterLogs(logs)
+						if len(logs) > 0 {
+							filter.LogsCallback(logs)
+						}
+					}
+				}
+			}
 		}
 	}
 }
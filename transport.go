@@ -0,0 +1,28 @@
+package thelonious
+
+import (
+	"net"
+	"time"
+)
+
+// How a node listens for and connects to peers.
+// Simulations swap in an in-memory network
+type Transport interface {
+	Listen(addr string) (net.Listener, error)
+	Dial(addr string) (net.Conn, error)
+}
+
+type tcpTransport struct{}
+
+func (tcpTransport) Listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func (tcpTransport) Dial(addr string) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, 10*time.Second)
+}
+
+// Set the transport. Must be called before Start
+func (s *Thelonious) SetTransport(transport Transport) {
+	s.transport = transport
+}